@@ -0,0 +1,260 @@
+// bench.go
+//
+// This file implements the bench mode of the client subcommand, a soak
+// test for Envoy connection pooling, HTTP/2 max-concurrent-streams and
+// circuit breaker limits. It keeps a number of StreamTime streams open,
+// reopening those that end, and optionally calls GetTime at a fixed rate,
+// spread over several connections. At the end it prints the message gaps,
+// the lifetimes of the streams that ended early, the unary latencies, and
+// the errors by status code:
+//
+//	envoy_hck client -addr localhost:8080 bench -streams 500 -duration 5m
+//	envoy_hck client bench -streams 0 -qps 200 -connections 4
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/dethi/envoy_hck/protos"
+)
+
+// benchOptions are the settings of a bench run.
+type benchOptions struct {
+	streams      int     // concurrent StreamTime streams
+	qps          float64 // GetTime calls per second, 0 for none
+	connections  int
+	duration     time.Duration
+	maxErrorRate float64
+}
+
+// benchStats aggregates the results of a bench run.
+type benchStats struct {
+	mu            sync.Mutex
+	streamsOpened int
+	messages      int
+	gaps          []time.Duration // between consecutive messages on a stream
+	lifetimes     []time.Duration // of streams that ended before the run
+	calls         int
+	latencies     []time.Duration
+	errors        map[string]map[codes.Code]int // by RPC kind (stream, unary)
+}
+
+func (st *benchStats) fail(kind string, err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.errors[kind] == nil {
+		st.errors[kind] = make(map[codes.Code]int)
+	}
+	st.errors[kind][status.Code(err)]++
+}
+
+// runBench runs the bench with req until opts.duration elapsed or ctx is done and
+// returns the process exit code.
+func runBench(ctx context.Context, w io.Writer, dial func() (*grpc.ClientConn, error), req *pb.TimeRequest, opts benchOptions) int {
+	if opts.streams < 0 || opts.qps < 0 || opts.connections < 1 || opts.duration <= 0 {
+		fmt.Fprintln(w, "client: bench needs -streams >= 0, -qps >= 0, -connections >= 1 and a positive -duration")
+		return 2
+	}
+	if opts.streams == 0 && opts.qps == 0 {
+		fmt.Fprintln(w, "client: bench needs -streams or -qps")
+		return 2
+	}
+	var clients []pb.TimeServiceClient
+	for range opts.connections {
+		conn, err := dial()
+		if err != nil {
+			fmt.Fprintln(w, "client:", err)
+			return 1
+		}
+		defer conn.Close()
+		clients = append(clients, pb.NewTimeServiceClient(conn))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.duration)
+	defer cancel()
+	stats := &benchStats{errors: make(map[string]map[codes.Code]int)}
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := range opts.streams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			benchStream(ctx, clients[i%len(clients)], req, stats)
+		}()
+	}
+	if opts.qps > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			benchUnary(ctx, clients, req, opts.qps, stats)
+		}()
+	}
+	wg.Wait()
+	return stats.report(w, opts, time.Since(start))
+}
+
+// benchRetryDelay is how long a stream waits before reopening after an
+// error, so a server rejecting streams is not hammered.
+const benchRetryDelay = 10 * time.Millisecond
+
+// benchStream keeps one stream open until ctx is done, reopening it
+// whenever it ends.
+func benchStream(ctx context.Context, client pb.TimeServiceClient, req *pb.TimeRequest, stats *benchStats) {
+	for ctx.Err() == nil {
+		opened := time.Now()
+		stats.mu.Lock()
+		stats.streamsOpened++
+		stats.mu.Unlock()
+		stream, err := client.StreamTime(ctx, req)
+		if err != nil {
+			if ctx.Err() == nil {
+				stats.fail("stream", err)
+				time.Sleep(benchRetryDelay)
+			}
+			continue
+		}
+		last := time.Time{}
+		for {
+			_, err := stream.Recv()
+			if err != nil {
+				if ctx.Err() != nil {
+					break
+				}
+				stats.mu.Lock()
+				stats.lifetimes = append(stats.lifetimes, time.Since(opened))
+				stats.mu.Unlock()
+				if err != io.EOF {
+					stats.fail("stream", err)
+					time.Sleep(benchRetryDelay)
+				}
+				break
+			}
+			now := time.Now()
+			stats.mu.Lock()
+			stats.messages++
+			if !last.IsZero() {
+				stats.gaps = append(stats.gaps, now.Sub(last))
+			}
+			stats.mu.Unlock()
+			last = now
+		}
+	}
+}
+
+// benchUnary calls GetTime qps times per second, round robin over clients,
+// until ctx is done.
+func benchUnary(ctx context.Context, clients []pb.TimeServiceClient, req *pb.TimeRequest, qps float64, stats *benchStats) {
+	limiter := rate.NewLimiter(rate.Limit(qps), 1)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for i := 0; ; i++ {
+		if limiter.Wait(ctx) != nil {
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			_, err := clients[i%len(clients)].GetTime(ctx, req)
+			elapsed := time.Since(start)
+			if err != nil && ctx.Err() != nil {
+				return
+			}
+			stats.mu.Lock()
+			stats.calls++
+			if err == nil {
+				stats.latencies = append(stats.latencies, elapsed)
+			}
+			stats.mu.Unlock()
+			if err != nil {
+				stats.fail("unary", err)
+			}
+		}()
+	}
+}
+
+// report prints a summary and returns the process exit code.
+func (st *benchStats) report(w io.Writer, opts benchOptions, elapsed time.Duration) int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	failed := 0
+	for _, byCode := range st.errors {
+		for _, n := range byCode {
+			failed += n
+		}
+	}
+	errorRate := 0.0
+	if total := st.streamsOpened + st.calls; total > 0 {
+		errorRate = float64(failed) / float64(total)
+	}
+
+	fmt.Fprintf(w, "duration:        %s over %d connection(s)\n", elapsed.Round(time.Millisecond), opts.connections)
+	if opts.streams > 0 {
+		fmt.Fprintf(w, "streams:         %d concurrent (%d opened)\n", opts.streams, st.streamsOpened)
+		fmt.Fprintf(w, "messages:        %d (%.1f/s)\n", st.messages, float64(st.messages)/elapsed.Seconds())
+		printDistribution(w, "message gap", st.gaps)
+		fmt.Fprintf(w, "ended early:     %d stream(s)\n", len(st.lifetimes))
+		printDistribution(w, "stream lifetime", st.lifetimes)
+	}
+	if opts.qps > 0 {
+		fmt.Fprintf(w, "unary calls:     %d (%.1f/s, target %g/s)\n", st.calls, float64(st.calls)/elapsed.Seconds(), opts.qps)
+		printDistribution(w, "unary latency", st.latencies)
+	}
+	fmt.Fprintf(w, "errors:          %d (%.2f%%)\n", failed, 100*errorRate)
+	for _, kind := range slices.Sorted(maps.Keys(st.errors)) {
+		for _, code := range slices.Sorted(maps.Keys(st.errors[kind])) {
+			fmt.Fprintf(w, "  %-6s %-18s %d\n", kind, code, st.errors[kind][code])
+		}
+	}
+
+	if errorRate > opts.maxErrorRate {
+		fmt.Fprintf(w, "FAIL: error rate %.2f%% exceeds %.2f%%\n", 100*errorRate, 100*opts.maxErrorRate)
+		return 1
+	}
+	return 0
+}
+
+// histogramWidth is the length of the longest histogram bar.
+const histogramWidth = 40
+
+// printDistribution prints the percentiles of samples and a histogram with
+// power-of-two buckets from 1ms.
+func printDistribution(w io.Writer, name string, samples []time.Duration) {
+	if len(samples) == 0 {
+		return
+	}
+	slices.Sort(samples)
+	at := func(p float64) time.Duration { return percentile(samples, p).Round(time.Microsecond) }
+	fmt.Fprintf(w, "%-16s p50=%s p90=%s p99=%s max=%s\n", name+":", at(0.50), at(0.90), at(0.99), at(1))
+
+	var bounds []time.Duration
+	var counts []int
+	i := 0
+	for bound := time.Millisecond; i < len(samples); bound *= 2 {
+		n := 0
+		for ; i < len(samples) && samples[i] <= bound; i++ {
+			n++
+		}
+		bounds, counts = append(bounds, bound), append(counts, n)
+	}
+	first := slices.IndexFunc(counts, func(n int) bool { return n > 0 })
+	most := slices.Max(counts)
+	for j := first; j < len(counts); j++ {
+		bar := strings.Repeat("#", (counts[j]*histogramWidth+most-1)/most)
+		fmt.Fprintf(w, "  <= %-10s %8d %s\n", bounds[j], counts[j], bar)
+	}
+}
@@ -0,0 +1,210 @@
+// certscmd.go
+//
+// This file implements the certs subcommand, which generates a CA and the
+// server and client certificates it signs into the layout the server and
+// the client subcommand expect, so the harness works out of the box:
+//
+//	envoy_hck certs
+//	envoy_hck certs -server-san localhost,envoy-hck.svc -client-san spiffe://example.org/envoy
+//	envoy_hck certs -reuse-ca -client-cn other-client    # new leaves, same CA
+
+package main
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dethi/envoy_hck/pkg/server"
+	"github.com/dethi/envoy_hck/pkg/tlsutil"
+)
+
+func runCerts(args []string) int {
+	fset := flag.NewFlagSet("certs", flag.ExitOnError)
+	dir := fset.String("dir", "certs", "directory to write ca.crt, ca.key, server.crt, server.key, client.crt and client.key to")
+	caCN := fset.String("ca-cn", "envoy-hck CA", "common name of the CA")
+	serverCN := fset.String("server-cn", "localhost", "common name of the server certificate")
+	clientCN := fset.String("client-cn", "envoy", "common name of the client certificate")
+	var serverSANs server.ListFlag
+	fset.Var(&serverSANs, "server-san", "comma-separated SANs of the server certificate: DNS names, IP addresses or URIs (default localhost,127.0.0.1,::1)")
+	var clientSANs server.ListFlag
+	fset.Var(&clientSANs, "client-san", "comma-separated SANs of the client certificate, e.g. spiffe://example.org/ns/default/sa/envoy")
+	caValidity := fset.Duration("ca-validity", 10*365*24*time.Hour, "validity period of the CA")
+	validity := fset.Duration("validity", 365*24*time.Hour, "validity period of the server and client certificates")
+	keyType := fset.String("key-type", "ecdsa-p256", "key type: "+strings.Join(tlsutil.KeyTypes, ", "))
+	reuseCA := fset.Bool("reuse-ca", false, "issue the certificates from the existing ca.crt and ca.key in -dir instead of a new CA")
+	force := fset.Bool("force", false, "overwrite existing files")
+	fset.Parse(args)
+	if fset.NArg() != 0 {
+		fset.Usage()
+		return 2
+	}
+	if len(serverSANs) == 0 {
+		serverSANs = server.ListFlag{"localhost", "127.0.0.1", "::1"}
+	}
+
+	if err := generateCertsDir(certsOptions{
+		dir:        *dir,
+		caCN:       *caCN,
+		serverCN:   *serverCN,
+		clientCN:   *clientCN,
+		serverSANs: serverSANs,
+		clientSANs: clientSANs,
+		caValidity: *caValidity,
+		validity:   *validity,
+		keyType:    *keyType,
+		reuseCA:    *reuseCA,
+		force:      *force,
+	}); err != nil {
+		fmt.Fprintln(os.Stderr, "certs:", err)
+		return 1
+	}
+	return 0
+}
+
+// certsOptions are the settings of the certs subcommand.
+type certsOptions struct {
+	dir                    string
+	caCN, serverCN         string
+	clientCN               string
+	serverSANs, clientSANs []string
+	caValidity, validity   time.Duration
+	keyType                string
+	reuseCA, force         bool
+}
+
+// generateCertsDir writes the CA, unless it is reused, and the server and
+// client certificates with their keys to opts.dir.
+func generateCertsDir(opts certsOptions) error {
+	if !slices.Contains(tlsutil.KeyTypes, opts.keyType) {
+		return fmt.Errorf("unknown -key-type %q, want one of %s", opts.keyType, strings.Join(tlsutil.KeyTypes, ", "))
+	}
+	if opts.caValidity <= 0 || opts.validity <= 0 {
+		return errors.New("-ca-validity and -validity must be positive")
+	}
+	for _, san := range slices.Concat(opts.serverSANs, opts.clientSANs) {
+		if strings.Contains(san, "://") {
+			if _, err := url.Parse(san); err != nil {
+				return fmt.Errorf("invalid URI SAN: %v", err)
+			}
+		}
+	}
+	files := []string{"server.crt", "server.key", "client.crt", "client.key"}
+	if !opts.reuseCA {
+		files = append(files, "ca.crt", "ca.key")
+	}
+	if !opts.force {
+		for _, name := range files {
+			if _, err := os.Stat(filepath.Join(opts.dir, name)); err == nil {
+				return fmt.Errorf("%s already exists; use -force to overwrite it", filepath.Join(opts.dir, name))
+			}
+		}
+	}
+	if err := os.MkdirAll(opts.dir, 0o755); err != nil {
+		return err
+	}
+
+	var ca *tlsutil.IssuedCert
+	var err error
+	if opts.reuseCA {
+		if ca, err = loadIssuedCert(filepath.Join(opts.dir, "ca.crt"), filepath.Join(opts.dir, "ca.key")); err != nil {
+			return fmt.Errorf("failed to load the CA: %w", err)
+		}
+		if !ca.Cert.IsCA {
+			return errors.New("ca.crt is not a CA certificate")
+		}
+	} else {
+		if ca, err = generateKeyedCert(tlsutil.CATemplate(opts.caCN, opts.caValidity), nil, opts.keyType); err != nil {
+			return fmt.Errorf("failed to generate the CA: %w", err)
+		}
+		if err := writeIssuedCert(opts.dir, "ca", ca); err != nil {
+			return err
+		}
+	}
+	leaves := []struct {
+		name, cn string
+		sans     []string
+		usage    x509.ExtKeyUsage
+	}{
+		{"server", opts.serverCN, opts.serverSANs, x509.ExtKeyUsageServerAuth},
+		{"client", opts.clientCN, opts.clientSANs, x509.ExtKeyUsageClientAuth},
+	}
+	for _, leaf := range leaves {
+		template := tlsutil.LeafTemplate(leaf.cn, leaf.sans, leaf.usage, opts.validity)
+		if template.NotAfter.After(ca.Cert.NotAfter) {
+			template.NotAfter = ca.Cert.NotAfter
+		}
+		if strings.HasPrefix(opts.keyType, "rsa-") {
+			template.KeyUsage |= x509.KeyUsageKeyEncipherment
+		}
+		cert, err := generateKeyedCert(template, ca, opts.keyType)
+		if err != nil {
+			return fmt.Errorf("failed to generate the %s certificate: %w", leaf.name, err)
+		}
+		if err := writeIssuedCert(opts.dir, leaf.name, cert); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// generateKeyedCert is tlsutil.GenerateCert with a key of keyType.
+func generateKeyedCert(template *x509.Certificate, parent *tlsutil.IssuedCert, keyType string) (*tlsutil.IssuedCert, error) {
+	key, err := tlsutil.GenerateKey(keyType)
+	if err != nil {
+		return nil, err
+	}
+	return tlsutil.IssueCert(template, parent, key)
+}
+
+// writeIssuedCert writes c to name.crt and its key, readable only by the
+// owner, to name.key in dir, and prints what it wrote.
+func writeIssuedCert(dir, name string, c *tlsutil.IssuedCert) error {
+	certPath, keyPath := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certPath, c.CertPEM, 0o644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyPath, c.KeyPEM, 0o600); err != nil {
+		return err
+	}
+	sans := slices.Clone(c.Cert.DNSNames)
+	for _, ip := range c.Cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, u := range c.Cert.URIs {
+		sans = append(sans, u.String())
+	}
+	fmt.Printf("Wrote %s and %s: %s, SANs [%s], expires %s\n", certPath, keyPath,
+		c.Cert.Subject, strings.Join(sans, ", "), c.Cert.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+// loadIssuedCert reads a PEM certificate and its PEM private key.
+func loadIssuedCert(certPath, keyPath string) (*tlsutil.IssuedCert, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s: unsupported private key", keyPath)
+	}
+	return &tlsutil.IssuedCert{Cert: pair.Leaf, Key: key, CertPEM: certPEM, KeyPEM: keyPEM}, nil
+}
@@ -0,0 +1,293 @@
+// client.go
+//
+// This file implements the client subcommand, which calls GetTime,
+// StreamTime or GetBlob over mTLS, directly or through Envoy, and prints each event of
+// the call with its latency, so mTLS setups can be debugged without
+// assembling grpcurl invocations.
+//
+//	envoy_hck client -addr localhost:8080 get
+//	envoy_hck client -addr localhost:8080 -count 5 stream
+//	envoy_hck client -addr localhost:8080 -size 8388608 blob
+//
+// The bench mode, a load test, is in bench.go.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/dethi/envoy_hck/pkg/server"
+	"github.com/dethi/envoy_hck/pkg/tlsutil"
+	pb "github.com/dethi/envoy_hck/protos"
+)
+
+func runClient(args []string) int {
+	fs := flag.NewFlagSet("client", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:50051", "address of the server or of Envoy in front of it")
+	caFile := fs.String("ca", "certs/ca.crt", "CA certificate used to verify the server")
+	certFile := fs.String("cert", "certs/client.crt", "client certificate presented to the server")
+	keyFile := fs.String("key", "certs/client.key", "private key of the client certificate")
+	serverName := fs.String("server-name", "", "expected server name, if it differs from the host in -addr")
+	timezone := fs.String("timezone", "", "IANA time zone to report local times in")
+	format := fs.String("format", "", "time format: rfc3339, rfc3339nano, rfc1123 or datetime (default: the server's)")
+	count := fs.Int("count", 0, "number of StreamTime messages to receive before ending the stream (0 = until interrupted)")
+	interval := fs.Duration("interval", 0, "interval between StreamTime messages (default: the server's)")
+	messageCount := fs.Int("message-count", 0, "number of StreamTime messages after which the server ends the stream (0 = unlimited)")
+	padBytes := fs.Int("pad-bytes", 0, "filler bytes the server adds to every time response")
+	timeout := fs.Duration("timeout", 0, "deadline of the call (0 = none)")
+	size := fs.Int64("size", 1<<20, "blob: size in bytes of the payload to request")
+	compressible := fs.Bool("compressible", false, "blob: request zeros instead of pseudo-random bytes")
+	maxRecvMsgSize := fs.Int("max-recv-msg-size", 4<<20, "maximum size in bytes of a response message")
+	compression := fs.String("compression", "", "compressor of the requests, gzip or zstd, which the server answers with by default (default: none)")
+	var bench benchOptions
+	fs.IntVar(&bench.streams, "streams", 100, "bench: number of concurrent StreamTime streams")
+	fs.Float64Var(&bench.qps, "qps", 0, "bench: GetTime calls per second (0 = none)")
+	fs.IntVar(&bench.connections, "connections", 1, "bench: number of connections to spread the calls over")
+	fs.DurationVar(&bench.duration, "duration", 30*time.Second, "bench: how long to run")
+	fs.Float64Var(&bench.maxErrorRate, "max-error-rate", 0.01, "bench: fraction of failed calls above which the command exits nonzero")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: envoy_hck client [flags] get|stream|blob|bench [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		// Flags may also follow the mode.
+		mode := fs.Arg(0)
+		fs.Parse(fs.Args()[1:])
+		args = append([]string{mode}, fs.Args()...)
+	} else {
+		args = nil
+	}
+	if len(args) != 1 || !slices.Contains([]string{"get", "stream", "blob", "bench"}, args[0]) {
+		fs.Usage()
+		return 2
+	}
+
+	server.RegisterZstd()
+	callOptions := []grpc.CallOption{grpc.MaxCallRecvMsgSize(*maxRecvMsgSize)}
+	switch *compression {
+	case "":
+	case "gzip", "zstd":
+		callOptions = append(callOptions, grpc.UseCompressor(*compression))
+	default:
+		fmt.Fprintf(os.Stderr, "client: unknown -compression %q, want gzip or zstd\n", *compression)
+		return 2
+	}
+
+	tlsConfig, err := tlsutil.ClientConfig(*caFile, *certFile, *keyFile, *serverName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "client:", err)
+		return 1
+	}
+	dial := func() (*grpc.ClientConn, error) {
+		return grpc.NewClient(*addr, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)), grpc.WithDefaultCallOptions(callOptions...))
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	req := &pb.TimeRequest{
+		Timezone:     *timezone,
+		Format:       *format,
+		IntervalMs:   interval.Milliseconds(),
+		MessageCount: int32(*messageCount),
+		PadBytes:     int32(*padBytes),
+	}
+	if args[0] == "bench" {
+		return runBench(ctx, os.Stdout, dial, req, bench)
+	}
+
+	conn, err := dial()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "client:", err)
+		return 1
+	}
+	defer conn.Close()
+	client := pb.NewTimeServiceClient(conn)
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	switch args[0] {
+	case "get":
+		err = clientGet(ctx, client, req)
+	case "stream":
+		err = clientStream(ctx, client, req, *count)
+	case "blob":
+		err = clientBlob(ctx, pb.NewDiagnosticsClient(conn), &pb.BlobRequest{Size: *size, Compressible: *compressible})
+	}
+	if err != nil {
+		return 1
+	}
+	return 0
+}
+
+// clientGet calls GetTime once and prints the response and round trip.
+func clientGet(ctx context.Context, client pb.TimeServiceClient, req *pb.TimeRequest) error {
+	var (
+		p               peer.Peer
+		header, trailer metadata.MD
+		start           = time.Now()
+	)
+	resp, err := client.GetTime(ctx, req, grpc.Peer(&p), grpc.Header(&header), grpc.Trailer(&trailer))
+	elapsed := time.Since(start)
+	printPeer(&p)
+	printMetadata("header", header)
+	if err == nil {
+		fmt.Printf("[%s] %s\n", elapsed.Round(time.Microsecond), describeTime(resp))
+	}
+	printMetadata("trailer", trailer)
+	if err != nil {
+		printStatus(err, elapsed)
+		return err
+	}
+	printStatus(nil, elapsed)
+	return nil
+}
+
+// clientBlob calls GetBlob once and prints the size of the payload and the
+// round trip.
+func clientBlob(ctx context.Context, client pb.DiagnosticsClient, req *pb.BlobRequest) error {
+	var (
+		p               peer.Peer
+		header, trailer metadata.MD
+		start           = time.Now()
+	)
+	resp, err := client.GetBlob(ctx, req, grpc.Peer(&p), grpc.Header(&header), grpc.Trailer(&trailer))
+	elapsed := time.Since(start)
+	printPeer(&p)
+	printMetadata("header", header)
+	if err == nil {
+		fmt.Printf("[%s] received %d bytes\n", elapsed.Round(time.Microsecond), len(resp.GetData()))
+	}
+	printMetadata("trailer", trailer)
+	printStatus(err, elapsed)
+	return err
+}
+
+// clientStream calls StreamTime and prints every message with the time
+// since the previous one, until the stream ends or count messages arrived.
+func clientStream(ctx context.Context, client pb.TimeServiceClient, req *pb.TimeRequest, count int) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var p peer.Peer
+	start := time.Now()
+	stream, err := client.StreamTime(ctx, req, grpc.Peer(&p))
+	if err != nil {
+		printStatus(err, time.Since(start))
+		return err
+	}
+	header, err := stream.Header()
+	if err == nil {
+		fmt.Printf("headers received after %s\n", time.Since(start).Round(time.Microsecond))
+		printMetadata("header", header)
+	}
+
+	received := 0
+	last := start
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			// The peer is only known once the stream is done.
+			printPeer(&p)
+			printMetadata("trailer", stream.Trailer())
+			fmt.Printf("stream ended after %d message(s)\n", received)
+			if err == io.EOF {
+				err = nil
+			}
+			printStatus(err, time.Since(start))
+			if ctx.Err() == context.Canceled {
+				// Ended by -count or the user, which is how streams usually end.
+				return nil
+			}
+			return err
+		}
+		now := time.Now()
+		if resp.GetIsHeartbeat() {
+			fmt.Printf("[+%s] heartbeat\n", now.Sub(last).Round(time.Microsecond))
+		} else {
+			received++
+			fmt.Printf("[+%s] #%d %s\n", now.Sub(last).Round(time.Microsecond), resp.GetSequence(), describeTime(resp))
+		}
+		last = now
+		if count > 0 && received == count {
+			cancel()
+		}
+	}
+}
+
+// describeTime formats the times of a response.
+func describeTime(resp *pb.TimeResponse) string {
+	if resp.GetLocalTime() != "" {
+		return resp.GetCurrentTime() + " (local " + resp.GetLocalTime() + ")"
+	}
+	return resp.GetCurrentTime()
+}
+
+// printPeer prints the address and TLS session of the connection the call
+// used, if known.
+func printPeer(p *peer.Peer) {
+	if p.Addr == nil {
+		return
+	}
+	fmt.Printf("connected to %s", p.Addr)
+	if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+		st := info.State
+		fmt.Printf(" with %s %s", tls.VersionName(st.Version), tls.CipherSuiteName(st.CipherSuite))
+		if len(st.PeerCertificates) > 0 {
+			fmt.Printf(", server certificate %q", st.PeerCertificates[0].Subject.CommonName)
+		}
+	}
+	fmt.Println()
+}
+
+// printMetadata prints md one key per line, in sorted order, with binary
+// (-bin) values base64 encoded.
+func printMetadata(kind string, md metadata.MD) {
+	for _, key := range slices.Sorted(maps.Keys(md)) {
+		values := md[key]
+		if strings.HasSuffix(key, "-bin") {
+			values = make([]string, len(md[key]))
+			for i, v := range md[key] {
+				values[i] = base64.StdEncoding.EncodeToString([]byte(v))
+			}
+		}
+		fmt.Printf("%s %s: %s\n", kind, key, strings.Join(values, ", "))
+	}
+}
+
+// printStatus prints the final status of a call, its duration and the
+// details it carries.
+func printStatus(err error, elapsed time.Duration) {
+	st := status.Convert(err)
+	if st.Message() != "" {
+		fmt.Printf("status %s: %s (%s)\n", st.Code(), st.Message(), elapsed.Round(time.Microsecond))
+	} else {
+		fmt.Printf("status %s (%s)\n", st.Code(), elapsed.Round(time.Microsecond))
+	}
+	for _, detail := range st.Proto().GetDetails() {
+		if b, err := protojson.Marshal(detail); err == nil {
+			fmt.Printf("status detail: %s\n", b)
+		} else {
+			fmt.Printf("status detail: %s\n", detail.GetTypeUrl())
+		}
+	}
+}
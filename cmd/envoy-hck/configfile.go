@@ -0,0 +1,104 @@
+// configfile.go
+//
+// This file layers a configuration file and environment variables under
+// the command-line flags, so the same binary can be deployed in different
+// environments without long command lines. Every flag can be set in three
+// places, the later overriding the earlier:
+//
+//   - the file named by -config, YAML or JSON, keyed by flag name;
+//   - the environment variable ENVOY_HCK_<NAME>, the flag name in upper
+//     case with dashes replaced by underscores (e.g. ENVOY_HCK_GRPC_ADDR);
+//   - the command line.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configEnvPrefix prefixes the environment variables that set flags.
+const configEnvPrefix = "ENVOY_HCK_"
+
+// flagEnvVar returns the environment variable that sets the flag name.
+func flagEnvVar(name string) string {
+	return configEnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// loadConfigFile reads the flag values in path. YAML is a superset of
+// JSON, so one decoder handles both; list values are joined with commas
+// as on the command line.
+func loadConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	values := make(map[string]string, len(raw))
+	for name, v := range raw {
+		switch v := v.(type) {
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			values[name] = strings.Join(items, ",")
+		case map[string]any, nil:
+			return nil, fmt.Errorf("%s: %s must be a scalar or a list", path, name)
+		default:
+			values[name] = fmt.Sprint(v)
+		}
+	}
+	return values, nil
+}
+
+// applyConfigSources sets the flags of fs not given on the command line
+// from the configuration file at path, if any, and then from the
+// environment.
+func applyConfigSources(fs *flag.FlagSet, path string) error {
+	onCommandLine := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { onCommandLine[f.Name] = true })
+
+	var fileValues map[string]string
+	if path != "" {
+		var err error
+		if fileValues, err = loadConfigFile(path); err != nil {
+			return err
+		}
+		for name := range fileValues {
+			if fs.Lookup(name) == nil {
+				return fmt.Errorf("%s: unknown setting %q", path, name)
+			}
+		}
+	}
+
+	var errs []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if onCommandLine[f.Name] {
+			return
+		}
+		source, value, ok := flagEnvVar(f.Name), "", false
+		if value, ok = os.LookupEnv(source); !ok {
+			source = path
+			value, ok = fileValues[f.Name]
+		}
+		if !ok {
+			return
+		}
+		if err := fs.Set(f.Name, value); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: invalid value %q for %s: %v", source, value, f.Name, err))
+		}
+	})
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
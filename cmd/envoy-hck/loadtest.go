@@ -0,0 +1,172 @@
+// loadtest.go
+//
+// This file implements the loadtest subcommand, which opens many concurrent
+// StreamTime streams over mTLS and reports throughput, message timing and
+// error rates.
+//
+//	envoy_hck loadtest -addr localhost:8080 -streams 500 -duration 1m
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+
+	"github.com/dethi/envoy_hck/pkg/tlsutil"
+	pb "github.com/dethi/envoy_hck/protos"
+)
+
+// loadStats aggregates the results of all load test streams.
+type loadStats struct {
+	mu       sync.Mutex
+	attempts int
+	errors   map[codes.Code]int
+	messages int
+	gaps     []time.Duration // time between consecutive messages on a stream
+}
+
+func (st *loadStats) attempt() {
+	st.mu.Lock()
+	st.attempts++
+	st.mu.Unlock()
+}
+
+func (st *loadStats) message(gap time.Duration) {
+	st.mu.Lock()
+	st.messages++
+	if gap > 0 {
+		st.gaps = append(st.gaps, gap)
+	}
+	st.mu.Unlock()
+}
+
+func (st *loadStats) fail(err error) {
+	st.mu.Lock()
+	st.errors[status.Code(err)]++
+	st.mu.Unlock()
+}
+
+func runLoadtest(args []string) int {
+	fs := flag.NewFlagSet("loadtest", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:50051", "address of the server or of Envoy in front of it")
+	caFile := fs.String("ca", "certs/ca.crt", "CA certificate used to verify the server")
+	certFile := fs.String("cert", "certs/client.crt", "client certificate presented to the server")
+	keyFile := fs.String("key", "certs/client.key", "private key of the client certificate")
+	serverName := fs.String("server-name", "", "expected server name, if it differs from the host in -addr")
+	streams := fs.Int("streams", 100, "number of concurrent StreamTime streams")
+	duration := fs.Duration("duration", 30*time.Second, "how long to run the test")
+	maxErrorRate := fs.Float64("max-error-rate", 0.01, "fraction of failed streams above which the command exits nonzero")
+	fs.Parse(args)
+
+	tlsConfig, err := tlsutil.ClientConfig(*caFile, *certFile, *keyFile, *serverName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "loadtest:", err)
+		return 1
+	}
+	conn, err := grpc.NewClient(*addr, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "loadtest:", err)
+		return 1
+	}
+	defer conn.Close()
+	client := pb.NewTimeServiceClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+
+	stats := &loadStats{errors: make(map[codes.Code]int)}
+	var wg sync.WaitGroup
+	for range *streams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runLoadStream(ctx, client, stats)
+		}()
+	}
+	wg.Wait()
+
+	return stats.report(os.Stdout, *streams, *duration, *maxErrorRate)
+}
+
+// runLoadStream keeps one stream open until ctx is done, reopening it
+// whenever it fails.
+func runLoadStream(ctx context.Context, client pb.TimeServiceClient, stats *loadStats) {
+	for ctx.Err() == nil {
+		stats.attempt()
+		stream, err := client.StreamTime(ctx, &pb.TimeRequest{})
+		if err != nil {
+			if ctx.Err() == nil {
+				stats.fail(err)
+			}
+			continue
+		}
+		last := time.Time{}
+		for {
+			_, err := stream.Recv()
+			if err != nil {
+				if ctx.Err() == nil {
+					stats.fail(err)
+				}
+				break
+			}
+			now := time.Now()
+			var gap time.Duration
+			if !last.IsZero() {
+				gap = now.Sub(last)
+			}
+			stats.message(gap)
+			last = now
+		}
+	}
+}
+
+// report prints a summary and returns the process exit code.
+func (st *loadStats) report(w io.Writer, streams int, duration time.Duration, maxErrorRate float64) int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	failed := 0
+	for _, n := range st.errors {
+		failed += n
+	}
+	errorRate := 0.0
+	if st.attempts > 0 {
+		errorRate = float64(failed) / float64(st.attempts)
+	}
+
+	fmt.Fprintf(w, "streams:     %d concurrent for %s (%d opened)\n", streams, duration, st.attempts)
+	fmt.Fprintf(w, "messages:    %d (%.1f/s)\n", st.messages, float64(st.messages)/duration.Seconds())
+	if len(st.gaps) > 0 {
+		slices.Sort(st.gaps)
+		fmt.Fprintf(w, "message gap: p50=%s p90=%s p99=%s max=%s\n",
+			percentile(st.gaps, 0.50), percentile(st.gaps, 0.90), percentile(st.gaps, 0.99), st.gaps[len(st.gaps)-1])
+	}
+	fmt.Fprintf(w, "errors:      %d (%.2f%%)\n", failed, 100*errorRate)
+	for _, code := range slices.Sorted(maps.Keys(st.errors)) {
+		fmt.Fprintf(w, "  %-18s %d\n", code, st.errors[code])
+	}
+
+	if errorRate > maxErrorRate {
+		fmt.Fprintf(w, "FAIL: error rate %.2f%% exceeds %.2f%%\n", 100*errorRate, 100*maxErrorRate)
+		return 1
+	}
+	return 0
+}
+
+// percentile returns the p-th percentile of sorted.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(p * float64(len(sorted)-1))
+	return sorted[i]
+}
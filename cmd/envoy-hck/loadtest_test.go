@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLoadReport(t *testing.T) {
+	st := &loadStats{errors: make(map[codes.Code]int)}
+	for i := range 10 {
+		st.attempt()
+		st.message(time.Duration(i+1) * time.Millisecond)
+	}
+	st.fail(status.Error(codes.Unavailable, "gone"))
+	st.fail(errors.New("not a status"))
+
+	var out strings.Builder
+	if code := st.report(&out, 10, time.Second, 0.5); code != 0 {
+		t.Errorf("20%% errors under a 50%% threshold exited %d", code)
+	}
+	for _, want := range []string{"errors:      2 (20.00%)", "Unavailable", "p50=5ms p90=9ms p99=9ms max=10ms"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report lacks %q:\n%s", want, out.String())
+		}
+	}
+	if code := st.report(io.Discard, 10, time.Second, 0.1); code != 1 {
+		t.Errorf("20%% errors over a 10%% threshold exited %d, want 1", code)
+	}
+}
@@ -0,0 +1,77 @@
+// main.go
+//
+// This Go application implements a gRPC server with mTLS enabled.
+// It requires and verifies client certificates signed by the trusted CA.
+// The server itself lives in pkg/server; this file dispatches the
+// subcommands and otherwise runs the server configured by the flags,
+// until SIGINT or SIGTERM.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+
+	"github.com/dethi/envoy_hck/pkg/server"
+)
+
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "loadtest":
+			os.Exit(runLoadtest(os.Args[2:]))
+		case "client":
+			os.Exit(runClient(os.Args[2:]))
+		case "certs":
+			os.Exit(runCerts(os.Args[2:]))
+		case "xds":
+			os.Exit(runXDS(os.Args[2:]))
+		case "probe":
+			os.Exit(runProbe(os.Args[2:]))
+		case "serve":
+			// Serving is also the default without a subcommand.
+			os.Args = slices.Delete(os.Args, 1, 2)
+		}
+	}
+
+	var cfg server.Config
+	configFile := flag.String("config", os.Getenv(flagEnvVar("config")), "YAML or JSON file of flag values keyed by flag name; ENVOY_HCK_<FLAG> environment variables override it, and command-line flags override both")
+	cfg.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+	if err := applyConfigSources(flag.CommandLine, *configFile); err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+
+	logHandler, err := server.NewLogHandler(cfg.LogFormat, cfg.LogLevel, os.Stderr)
+	if err != nil {
+		log.Fatalf("invalid -log-format or -log-level: %v", err)
+	}
+	slog.SetDefault(slog.New(logHandler))
+	srv, err := server.New(server.WithConfig(cfg))
+	if err != nil {
+		log.Fatalf("invalid configuration:\n%v", err)
+	}
+
+	// --- Graceful shutdown on SIGINT/SIGTERM ---
+	ctx, cancel := context.WithCancel(context.Background())
+	server.InstallStackDumpHandler(context.Background(), cfg.StackDumpFile)
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigCh
+		log.Printf("Received %s", sig)
+		cancel()
+	}()
+	if err := srv.Start(ctx); err != nil {
+		log.Fatal(err)
+	}
+	if err := srv.Wait(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,201 @@
+// probecmd.go
+//
+// This file implements the probe subcommand, a synthetic monitor of the
+// whole Envoy to upstream mTLS chain. Every -interval, it runs a gRPC
+// health check and a GetTime call through Envoy with the client
+// certificate, and records each result and latency in its logs and in
+// Prometheus metrics served on -metrics-addr:
+//
+//	envoy_hck probe -target envoy:10000 -interval 5s
+//
+// With -reconnect, every round dials a new connection, so the TLS
+// handshakes are checked too, not just one long-lived connection.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	"github.com/dethi/envoy_hck/pkg/tlsutil"
+	pb "github.com/dethi/envoy_hck/protos"
+)
+
+// prober runs the checks of the probe subcommand.
+type prober struct {
+	service string
+	timeout time.Duration
+
+	checks  *prometheus.CounterVec
+	latency *prometheus.HistogramVec
+	up      *prometheus.GaugeVec
+}
+
+// newProber registers the metrics of a prober with reg.
+func newProber(reg prometheus.Registerer, service string, timeout time.Duration) *prober {
+	return &prober{
+		service: service,
+		timeout: timeout,
+		checks: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "probe_checks_total",
+			Help: "Probe checks run, by check (health or get_time) and result (OK or the failure).",
+		}, []string{"check", "result"}),
+		latency: promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "probe_latency_seconds",
+			Help:    "Latency of the probe checks, failed ones included.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"check"}),
+		up: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_up",
+			Help: "Whether the last probe check passed (1) or failed (0).",
+		}, []string{"check"}),
+	}
+}
+
+// round runs every check once over conn, and reports whether all passed.
+func (p *prober) round(conn *grpc.ClientConn) bool {
+	health := p.check("health", func(ctx context.Context) error {
+		resp, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: p.service})
+		if err != nil {
+			return err
+		}
+		if s := resp.GetStatus(); s != grpc_health_v1.HealthCheckResponse_SERVING {
+			return errors.New(s.String())
+		}
+		return nil
+	})
+	getTime := p.check("get_time", func(ctx context.Context) error {
+		_, err := pb.NewTimeServiceClient(conn).GetTime(ctx, &pb.TimeRequest{})
+		return err
+	})
+	return health && getTime
+}
+
+// check runs one check within the timeout and records its result.
+func (p *prober) check(name string, run func(context.Context) error) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	start := time.Now()
+	err := run(ctx)
+	elapsed := time.Since(start)
+	p.latency.WithLabelValues(name).Observe(elapsed.Seconds())
+
+	result := "OK"
+	if err != nil {
+		if st, ok := status.FromError(err); ok {
+			result = st.Code().String()
+		} else {
+			result = err.Error()
+		}
+	}
+	p.checks.WithLabelValues(name, result).Inc()
+	latencyMs := float64(elapsed.Microseconds()) / 1000
+	if err != nil {
+		p.up.WithLabelValues(name).Set(0)
+		slog.Warn("Probe failed", "check", name, "result", result, "latency_ms", latencyMs, "error", status.Convert(err).Message())
+		return false
+	}
+	p.up.WithLabelValues(name).Set(1)
+	slog.Info("Probe passed", "check", name, "latency_ms", latencyMs)
+	return true
+}
+
+func runProbe(args []string) int {
+	fs := flag.NewFlagSet("probe", flag.ExitOnError)
+	target := fs.String("target", "localhost:8080", "address of Envoy, or of the server, to probe")
+	caFile := fs.String("ca", "certs/ca.crt", "CA certificate used to verify the server")
+	certFile := fs.String("cert", "certs/client.crt", "client certificate presented to the server")
+	keyFile := fs.String("key", "certs/client.key", "private key of the client certificate")
+	serverName := fs.String("server-name", "", "expected server name, if it differs from the host in -target")
+	interval := fs.Duration("interval", 5*time.Second, "time between probe rounds")
+	timeout := fs.Duration("timeout", 2*time.Second, "deadline of each check")
+	service := fs.String("service", "", "service to health check (default: the whole server)")
+	reconnect := fs.Bool("reconnect", false, "dial a new connection every round, to check the TLS handshake too")
+	metricsAddr := fs.String("metrics-addr", ":9090", "address to serve the probe metrics on at /metrics (empty = none)")
+	count := fs.Int("count", 0, "number of rounds to run before exiting, nonzero if any failed (0 = until interrupted)")
+	fs.Parse(args)
+	if *interval <= 0 || *timeout <= 0 || *count < 0 {
+		fmt.Fprintln(os.Stderr, "probe: -interval and -timeout must be positive and -count not negative")
+		return 2
+	}
+
+	tlsConfig, err := tlsutil.ClientConfig(*caFile, *certFile, *keyFile, *serverName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "probe:", err)
+		return 1
+	}
+	dial := func() (*grpc.ClientConn, error) {
+		return grpc.NewClient(*target, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	}
+	reg := prometheus.NewRegistry()
+	p := newProber(reg, *service, *timeout)
+	if *metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{EnableOpenMetrics: true}))
+		go func() {
+			log.Printf("Serving probe metrics on %s", *metricsAddr)
+			if err := http.ListenAndServe(*metricsAddr, mux); err != nil {
+				log.Fatalf("probe metrics server failed: %v", err)
+			}
+		}()
+	}
+
+	var conn *grpc.ClientConn
+	if !*reconnect {
+		if conn, err = dial(); err != nil {
+			fmt.Fprintln(os.Stderr, "probe:", err)
+			return 1
+		}
+		defer conn.Close()
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	log.Printf("Probing %s every %s", *target, *interval)
+	failed := false
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for round := 1; ; round++ {
+		c := conn
+		if *reconnect {
+			if c, err = dial(); err != nil {
+				fmt.Fprintln(os.Stderr, "probe:", err)
+				return 1
+			}
+		}
+		if !p.round(c) {
+			failed = true
+		}
+		if *reconnect {
+			c.Close()
+		}
+		if round == *count {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return 0
+		case <-ticker.C:
+		}
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
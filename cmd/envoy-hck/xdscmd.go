@@ -0,0 +1,373 @@
+// xdscmd.go
+//
+// This file implements the xds subcommand, a minimal control plane that
+// serves a test Envoy the harness itself over ADS: a cluster (CDS) of the
+// harness instances, their endpoints (EDS) with the health status each
+// instance reports on its HTTP port, and the client certificate and CA
+// bundle (SDS) for mTLS to them. Envoy then needs only a bootstrap naming
+// the ADS server, and health toggles and certificate changes reach it as
+// xDS updates:
+//
+//	envoy_hck xds
+//	envoy_hck xds -upstreams 10.0.0.1:50051=http://10.0.0.1:8081,10.0.0.2:50051=http://10.0.0.2:8081
+//
+// Every Envoy node gets the same resources.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	tlsv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	httpv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/upstreams/http/v3"
+	discoveryv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	xdsserver "github.com/envoyproxy/go-control-plane/pkg/server/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/dethi/envoy_hck/pkg/server"
+)
+
+// Names of the SDS secrets served by the xds subcommand.
+const (
+	xdsCertSecret = "client_cert"
+	xdsCASecret   = "validation_context"
+)
+
+func runXDS(args []string) int {
+	fset := flag.NewFlagSet("xds", flag.ExitOnError)
+	addr := fset.String("addr", ":18000", "address of the ADS server Envoy connects to, without TLS")
+	var upstreams server.ListFlag
+	fset.Var(&upstreams, "upstreams", "comma-separated harness instances as grpc-host:port[=admin-URL]; with an admin URL the endpoint health follows its /health (default 127.0.0.1:50051=http://127.0.0.1:8081)")
+	clusterName := fset.String("cluster", "envoy_hck", "name of the cluster of the harness instances")
+	serverName := fset.String("server-name", "localhost", "SNI Envoy sends to the harness instances")
+	certFile := fset.String("tls-cert", "certs/client.crt", "client certificate Envoy presents, served as the "+xdsCertSecret+" secret")
+	keyFile := fset.String("tls-key", "certs/client.key", "private key of -tls-cert")
+	caFile := fset.String("tls-ca", "certs/ca.crt", "CA bundle that verifies the harness instances, served as the "+xdsCASecret+" secret")
+	token := fset.String("admin-token", os.Getenv("HTTP_TOKEN"), "bearer token for the /health endpoint of the instances, if they require one (defaults to $HTTP_TOKEN)")
+	interval := fset.Duration("interval", time.Second, "how often the health of the instances and the TLS files are checked")
+	fset.Parse(args)
+	if fset.NArg() != 0 || *interval <= 0 {
+		fset.Usage()
+		return 2
+	}
+	if len(upstreams) == 0 {
+		upstreams = server.ListFlag{"127.0.0.1:50051=http://127.0.0.1:8081"}
+	}
+	instances, err := parseXDSUpstreams(upstreams)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "xds:", err)
+		return 2
+	}
+
+	cp := &xdsControlPlane{
+		cache:      cache.NewSnapshotCache(true, anyNode{}, nil),
+		cluster:    *clusterName,
+		serverName: *serverName,
+		certFile:   *certFile,
+		keyFile:    *keyFile,
+		caFile:     *caFile,
+		token:      *token,
+		instances:  instances,
+		client:     &http.Client{Timeout: *interval},
+	}
+	if err := cp.update(context.Background()); err != nil {
+		fmt.Fprintln(os.Stderr, "xds:", err)
+		return 1
+	}
+
+	lis, err := server.ListenAddr(*addr, 0o660)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "xds:", err)
+		return 1
+	}
+	s := grpc.NewServer()
+	discoveryv3.RegisterAggregatedDiscoveryServiceServer(s, xdsserver.NewServer(context.Background(), cp.cache, xdsCallbacks()))
+	go cp.run(*interval)
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		log.Printf("Received %s, stopping the ADS server", <-sigCh)
+		s.Stop()
+	}()
+	log.Printf("ADS server listening at %s, serving cluster %s with %d endpoints", lis.Addr(), cp.cluster, len(instances))
+	if err := s.Serve(lis); err != nil {
+		fmt.Fprintln(os.Stderr, "xds:", err)
+		return 1
+	}
+	return 0
+}
+
+// xdsInstance is a harness instance advertised as an endpoint.
+type xdsInstance struct {
+	host     string
+	port     uint32
+	adminURL string // without one the endpoint is always healthy
+}
+
+// parseXDSUpstreams parses "grpc-host:port[=admin-URL]" entries.
+func parseXDSUpstreams(specs []string) ([]xdsInstance, error) {
+	var out []xdsInstance
+	for _, spec := range specs {
+		hostPort, adminURL, _ := strings.Cut(spec, "=")
+		host, portStr, err := net.SplitHostPort(hostPort)
+		if err != nil {
+			return nil, fmt.Errorf("upstream %q: %v", spec, err)
+		}
+		port, err := strconv.ParseUint(portStr, 10, 16)
+		if err != nil || port == 0 {
+			return nil, fmt.Errorf("upstream %q: invalid port %q", spec, portStr)
+		}
+		out = append(out, xdsInstance{host, uint32(port), strings.TrimSuffix(adminURL, "/")})
+	}
+	return out, nil
+}
+
+// anyNode hashes every Envoy node to the same snapshot.
+type anyNode struct{}
+
+func (anyNode) ID(*corev3.Node) string { return "" }
+
+// xdsControlPlane builds the snapshot of the harness instances and pushes a
+// new version whenever their health or the TLS files change.
+type xdsControlPlane struct {
+	cache                     cache.SnapshotCache
+	cluster, serverName       string
+	certFile, keyFile, caFile string
+	token                     string
+	instances                 []xdsInstance
+	client                    *http.Client
+
+	// Only update uses these.
+	version int
+	last    map[resource.Type][]types.Resource
+}
+
+// run updates the snapshot every interval.
+func (cp *xdsControlPlane) run(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := cp.update(context.Background()); err != nil {
+			log.Printf("Keeping the previous xDS snapshot: %v", err)
+		}
+	}
+}
+
+// update builds the resources and, if they changed, sets them as a new
+// snapshot version.
+func (cp *xdsControlPlane) update(ctx context.Context) error {
+	secrets, err := cp.secrets()
+	if err != nil {
+		return err
+	}
+	resources := map[resource.Type][]types.Resource{
+		resource.ClusterType:  {cp.clusterResource()},
+		resource.EndpointType: {cp.endpoints(ctx)},
+		resource.SecretType:   secrets,
+	}
+	if cp.last != nil && resourcesEqual(cp.last, resources) {
+		return nil
+	}
+	cp.version++
+	snapshot, err := cache.NewSnapshot(strconv.Itoa(cp.version), resources)
+	if err != nil {
+		return err
+	}
+	if err := snapshot.Consistent(); err != nil {
+		return err
+	}
+	if err := cp.cache.SetSnapshot(ctx, "", snapshot); err != nil {
+		return err
+	}
+	cp.last = resources
+	log.Printf("Serving xDS snapshot version %d: %s", cp.version, cp.summary(resources))
+	return nil
+}
+
+// summary lists the health status of every endpoint of resources.
+func (cp *xdsControlPlane) summary(resources map[resource.Type][]types.Resource) string {
+	cla := resources[resource.EndpointType][0].(*endpointv3.ClusterLoadAssignment)
+	var parts []string
+	for _, lb := range cla.Endpoints[0].LbEndpoints {
+		sa := lb.GetEndpoint().Address.GetSocketAddress()
+		parts = append(parts, fmt.Sprintf("%s %s", net.JoinHostPort(sa.Address, strconv.Itoa(int(sa.GetPortValue()))), lb.HealthStatus))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func resourcesEqual(a, b map[resource.Type][]types.Resource) bool {
+	for typ, rs := range a {
+		if len(rs) != len(b[typ]) {
+			return false
+		}
+		for i := range rs {
+			if !proto.Equal(rs[i], b[typ][i]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// adsConfigSource points Envoy back at the ADS stream.
+var adsConfigSource = &corev3.ConfigSource{
+	ResourceApiVersion:    corev3.ApiVersion_V3,
+	ConfigSourceSpecifier: &corev3.ConfigSource_Ads{Ads: &corev3.AggregatedConfigSource{}},
+}
+
+// clusterResource returns the cluster of the instances: EDS, HTTP/2, and
+// mTLS with the secrets of SDS.
+func (cp *xdsControlPlane) clusterResource() *clusterv3.Cluster {
+	upstreamTLS, _ := anypb.New(&tlsv3.UpstreamTlsContext{
+		Sni: cp.serverName,
+		CommonTlsContext: &tlsv3.CommonTlsContext{
+			AlpnProtocols: []string{"h2"},
+			TlsCertificateSdsSecretConfigs: []*tlsv3.SdsSecretConfig{
+				{Name: xdsCertSecret, SdsConfig: adsConfigSource},
+			},
+			ValidationContextType: &tlsv3.CommonTlsContext_ValidationContextSdsSecretConfig{
+				ValidationContextSdsSecretConfig: &tlsv3.SdsSecretConfig{Name: xdsCASecret, SdsConfig: adsConfigSource},
+			},
+		},
+	})
+	http2, _ := anypb.New(&httpv3.HttpProtocolOptions{
+		UpstreamProtocolOptions: &httpv3.HttpProtocolOptions_ExplicitHttpConfig_{
+			ExplicitHttpConfig: &httpv3.HttpProtocolOptions_ExplicitHttpConfig{
+				ProtocolConfig: &httpv3.HttpProtocolOptions_ExplicitHttpConfig_Http2ProtocolOptions{
+					Http2ProtocolOptions: &corev3.Http2ProtocolOptions{},
+				},
+			},
+		},
+	})
+	return &clusterv3.Cluster{
+		Name:                 cp.cluster,
+		ClusterDiscoveryType: &clusterv3.Cluster_Type{Type: clusterv3.Cluster_EDS},
+		EdsClusterConfig:     &clusterv3.Cluster_EdsClusterConfig{EdsConfig: adsConfigSource},
+		ConnectTimeout:       durationpb.New(time.Second),
+		TypedExtensionProtocolOptions: map[string]*anypb.Any{
+			"envoy.extensions.upstreams.http.v3.HttpProtocolOptions": http2,
+		},
+		TransportSocket: &corev3.TransportSocket{
+			Name:       "envoy.transport_sockets.tls",
+			ConfigType: &corev3.TransportSocket_TypedConfig{TypedConfig: upstreamTLS},
+		},
+	}
+}
+
+// endpoints returns the instances with their current health status.
+func (cp *xdsControlPlane) endpoints(ctx context.Context) *endpointv3.ClusterLoadAssignment {
+	locality := &endpointv3.LocalityLbEndpoints{}
+	for _, inst := range cp.instances {
+		locality.LbEndpoints = append(locality.LbEndpoints, &endpointv3.LbEndpoint{
+			HostIdentifier: &endpointv3.LbEndpoint_Endpoint{Endpoint: &endpointv3.Endpoint{
+				Address: &corev3.Address{Address: &corev3.Address_SocketAddress{SocketAddress: &corev3.SocketAddress{
+					Address:       inst.host,
+					PortSpecifier: &corev3.SocketAddress_PortValue{PortValue: inst.port},
+				}}},
+			}},
+			HealthStatus: cp.health(ctx, inst),
+		})
+	}
+	return &endpointv3.ClusterLoadAssignment{
+		ClusterName: cp.cluster,
+		Endpoints:   []*endpointv3.LocalityLbEndpoints{locality},
+	}
+}
+
+// health asks inst for the health status of the whole server. An instance
+// that cannot be asked is unhealthy.
+func (cp *xdsControlPlane) health(ctx context.Context, inst xdsInstance) corev3.HealthStatus {
+	if inst.adminURL == "" {
+		return corev3.HealthStatus_HEALTHY
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, inst.adminURL+"/health", nil)
+	if err != nil {
+		return corev3.HealthStatus_UNHEALTHY
+	}
+	if cp.token != "" {
+		req.Header.Set("Authorization", "Bearer "+cp.token)
+	}
+	resp, err := cp.client.Do(req)
+	if err != nil {
+		return corev3.HealthStatus_UNHEALTHY
+	}
+	defer resp.Body.Close()
+	var services map[string]string
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&services) != nil {
+		return corev3.HealthStatus_UNHEALTHY
+	}
+	if services[""] != "SERVING" {
+		return corev3.HealthStatus_UNHEALTHY
+	}
+	return corev3.HealthStatus_HEALTHY
+}
+
+// secrets returns the client certificate and CA bundle secrets, read from
+// their files.
+func (cp *xdsControlPlane) secrets() ([]types.Resource, error) {
+	certPEM, err := os.ReadFile(cp.certFile)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(cp.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	caPEM, err := os.ReadFile(cp.caFile)
+	if err != nil {
+		return nil, err
+	}
+	inline := func(b []byte) *corev3.DataSource {
+		return &corev3.DataSource{Specifier: &corev3.DataSource_InlineBytes{InlineBytes: b}}
+	}
+	return []types.Resource{
+		&tlsv3.Secret{
+			Name: xdsCertSecret,
+			Type: &tlsv3.Secret_TlsCertificate{TlsCertificate: &tlsv3.TlsCertificate{
+				CertificateChain: inline(certPEM),
+				PrivateKey:       inline(keyPEM),
+			}},
+		},
+		&tlsv3.Secret{
+			Name: xdsCASecret,
+			Type: &tlsv3.Secret_ValidationContext{ValidationContext: &tlsv3.CertificateValidationContext{
+				TrustedCa: inline(caPEM),
+			}},
+		},
+	}, nil
+}
+
+// xdsCallbacks log the Envoy nodes that connect and the updates they
+// reject.
+func xdsCallbacks() xdsserver.Callbacks {
+	return xdsserver.CallbackFuncs{
+		StreamRequestFunc: func(id int64, req *discoveryv3.DiscoveryRequest) error {
+			if req.ErrorDetail != nil {
+				log.Printf("Envoy %s rejected %s version %s: %s", req.GetNode().GetId(), req.TypeUrl, req.VersionInfo, req.ErrorDetail.Message)
+			} else if req.ResponseNonce == "" {
+				log.Printf("Envoy %s subscribed to %s (stream %d)", req.GetNode().GetId(), req.TypeUrl, id)
+			}
+			return nil
+		},
+	}
+}
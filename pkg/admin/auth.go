@@ -0,0 +1,27 @@
+// auth.go
+//
+// This file requires a bearer token on every request to the HTTP server,
+// which is open by default.
+
+package admin
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// RequireToken returns next admitting only requests that present one of
+// tokens, as a bearer token or token query parameter.
+func RequireToken(next http.Handler, tokens ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(requestToken(r))
+		for _, token := range tokens {
+			if token != "" && subtle.ConstantTimeCompare(got, []byte(token)) == 1 {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "missing or invalid token", http.StatusUnauthorized)
+	})
+}
@@ -0,0 +1,81 @@
+// guard.go
+//
+// This file optionally protects the health control endpoints, which are
+// unauthenticated by default, with a shared token and a rate limit.
+
+// Package admin provides the building blocks of the HTTP admin server:
+// token checks, the rate-limited guard of the control endpoints and the
+// landing page.
+package admin
+
+import (
+	"crypto/subtle"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Guard admits requests carrying token, if set, and at most limit of
+// them per window, if limit is positive. The zero value admits everything.
+type Guard struct {
+	token  string
+	limit  int
+	window time.Duration
+
+	mu          sync.Mutex
+	windowStart time.Time
+	count       int
+}
+
+// NewGuard returns a Guard admitting requests carrying token, if set, and
+// at most limit of them per window, if limit is positive.
+func NewGuard(token string, limit int, window time.Duration) *Guard {
+	return &Guard{token: token, limit: limit, window: window}
+}
+
+// requestToken returns the token presented as a bearer token or in the
+// token query parameter.
+func requestToken(r *http.Request) string {
+	if auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return auth
+	}
+	return r.URL.Query().Get("token")
+}
+
+// allow reports whether another request fits in the current window, and
+// otherwise how long until the next one starts.
+func (g *Guard) allow(now time.Time) (bool, time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if now.Sub(g.windowStart) >= g.window {
+		g.windowStart, g.count = now, 0
+	}
+	if g.count >= g.limit {
+		return false, g.windowStart.Add(g.window).Sub(now)
+	}
+	g.count++
+	return true, 0
+}
+
+// Wrap returns next guarded by g: 401 without the token, 429 over the
+// rate limit.
+func (g *Guard) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if g.token != "" && subtle.ConstantTimeCompare([]byte(requestToken(r)), []byte(g.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "missing or invalid token", http.StatusUnauthorized)
+			return
+		}
+		if g.limit > 0 {
+			if ok, wait := g.allow(time.Now()); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, "too many health changes, try again later", http.StatusTooManyRequests)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,72 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGuard(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	do := func(h http.HandlerFunc, target, bearer string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("POST", target, nil)
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		return rec
+	}
+
+	open := (&Guard{}).Wrap(ok)
+	for range 3 {
+		if rec := do(open, "/toggle-health", ""); rec.Code != http.StatusOK {
+			t.Fatalf("the zero Guard answered %d", rec.Code)
+		}
+	}
+
+	locked := NewGuard("s3cret", 2, time.Minute).Wrap(ok)
+	for _, tc := range []struct {
+		name, target, bearer string
+		want                 int
+	}{
+		{"no token", "/toggle-health", "", http.StatusUnauthorized},
+		{"wrong token", "/toggle-health", "guess", http.StatusUnauthorized},
+		{"wrong query token", "/toggle-health?token=guess", "", http.StatusUnauthorized},
+		{"bearer token", "/toggle-health", "s3cret", http.StatusOK},
+		{"query token", "/toggle-health?token=s3cret", "", http.StatusOK},
+		// Rejected requests did not use up the limit of 2.
+		{"over the limit", "/toggle-health", "s3cret", http.StatusTooManyRequests},
+	} {
+		rec := do(locked, tc.target, tc.bearer)
+		if rec.Code != tc.want {
+			t.Errorf("%s: %d, want %d", tc.name, rec.Code, tc.want)
+		}
+		switch rec.Code {
+		case http.StatusUnauthorized:
+			if rec.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Errorf("%s: no WWW-Authenticate challenge", tc.name)
+			}
+		case http.StatusTooManyRequests:
+			if got := rec.Header().Get("Retry-After"); got != "60" {
+				t.Errorf("%s: Retry-After %q, want 60", tc.name, got)
+			}
+		}
+	}
+}
+
+func TestGuardWindow(t *testing.T) {
+	g := NewGuard("", 1, time.Minute)
+	start := time.Now()
+	if ok, _ := g.allow(start); !ok {
+		t.Fatal("first request rejected")
+	}
+	if ok, wait := g.allow(start.Add(20 * time.Second)); ok || wait != 40*time.Second {
+		t.Errorf("second request in the window: %t, wait %s, want rejected for 40s", ok, wait)
+	}
+	if ok, _ := g.allow(start.Add(time.Minute)); !ok {
+		t.Error("request in the next window rejected")
+	}
+}
@@ -0,0 +1,59 @@
+// landing.go
+//
+// This file renders the plain text page served at the root of the HTTP
+// server, which lists the gRPC services and the HTTP control endpoints of
+// the instance so newcomers can find their way around without the code.
+
+package admin
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"slices"
+	"text/tabwriter"
+
+	"google.golang.org/grpc"
+)
+
+// Endpoint describes an endpoint of the HTTP server on the landing page.
+type Endpoint struct {
+	Pattern     string
+	Description string
+}
+
+// LandingPage is rendered once at startup and served as is.
+type LandingPage []byte
+
+// NewLandingPage renders the page under title, listing services and
+// endpoints.
+func NewLandingPage(title string, services map[string]grpc.ServiceInfo, endpoints []Endpoint) LandingPage {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s\n\n", title)
+
+	fmt.Fprintln(&b, "gRPC services:")
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "  %s\n", name)
+		for _, m := range services[name].Methods {
+			fmt.Fprintf(&b, "    %s\n", m.Name)
+		}
+	}
+
+	fmt.Fprintln(&b, "\nHTTP endpoints:")
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	for _, e := range endpoints {
+		fmt.Fprintf(tw, "  %s\t%s\n", e.Pattern, e.Description)
+	}
+	tw.Flush()
+	return b.Bytes()
+}
+
+func (p LandingPage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(p)
+}
@@ -0,0 +1,39 @@
+package admin
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+)
+
+func TestLandingPage(t *testing.T) {
+	services := map[string]grpc.ServiceInfo{
+		"time.TimeService":      {Methods: []grpc.MethodInfo{{Name: "GetTime"}, {Name: "StreamTime", IsServerStream: true}}},
+		"grpc.health.v1.Health": {Methods: []grpc.MethodInfo{{Name: "Check"}}},
+	}
+	page := NewLandingPage("envoy-hck v1.2.3 (commit abc123)", services, []Endpoint{
+		{Pattern: "POST /toggle-health", Description: "flip the health status"},
+		{Pattern: "/metrics", Description: "Prometheus metrics"},
+	})
+	rec := httptest.NewRecorder()
+	page.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Errorf("Content-Type %q", ct)
+	}
+	body := rec.Body.String()
+	if !strings.HasPrefix(body, "envoy-hck v1.2.3 (commit abc123)\n") {
+		t.Errorf("page does not start with the title: %q", body)
+	}
+	// Services are sorted, each followed by its methods.
+	for _, want := range []string{
+		"  grpc.health.v1.Health\n    Check\n  time.TimeService\n    GetTime\n    StreamTime\n",
+		"  POST /toggle-health  flip the health status\n",
+		"  /metrics             Prometheus metrics\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page lacks %q:\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,526 @@
+// faults.go
+//
+// This file implements runtime fault injection for validating Envoy retry
+// policies, outlier detection and circuit breaking. The spec set through
+// the /faults endpoint delays matching RPCs, fails a share of them with a
+// given status, or aborts streams after a number of messages; POST
+// /faults/goaway sends GOAWAY on every client connection.
+//
+// gRPC offers no way to send GOAWAY on a live connection short of stopping
+// the server, so the credentials wrap each connection and write the frame
+// themselves. That is only safe between whole frames, which is why fault
+// injection disables gRPC's write buffer: every write is then exactly one
+// frame.
+
+// Package faults injects delays, errors, stream aborts and GOAWAY frames
+// into a gRPC server at runtime.
+package faults
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	// Registers the google.rpc detail types error_details may name.
+	_ "google.golang.org/genproto/googleapis/rpc/errdetails"
+)
+
+// Spec describes the faults injected into matching RPCs. The zero
+// value injects nothing.
+type Spec struct {
+	// Methods restricts the faults to these full method names (e.g.
+	// "/time.TimeService/GetTime") or services (e.g. "/time.TimeService/").
+	// Empty matches every method; the health and reflection services are
+	// never affected.
+	Methods []string `json:"methods,omitempty"`
+	// DelayMs delays DelayPercent percent of the matching RPCs, 100 if
+	// zero, by this many milliseconds before they are handled.
+	DelayMs      int64   `json:"delay_ms,omitempty"`
+	DelayPercent float64 `json:"delay_percent,omitempty"`
+	// ErrorCode fails ErrorPercent percent of the matching RPCs, 100 if
+	// zero, with this status code (e.g. "UNAVAILABLE") instead of handling
+	// them.
+	ErrorCode    string  `json:"error_code,omitempty"`
+	ErrorPercent float64 `json:"error_percent,omitempty"`
+	// AbortAfterMessages ends matching streams with AbortCode, UNAVAILABLE
+	// if empty, once they have sent this many messages.
+	AbortAfterMessages int    `json:"abort_after_messages,omitempty"`
+	AbortCode          string `json:"abort_code,omitempty"`
+	// ErrorMessage replaces the status message of injected errors and
+	// aborts. ErrorDetails are attached to them as google.rpc.Status
+	// details, sent in grpc-status-details-bin; each is the protojson form
+	// of a detail message with its "@type", e.g.
+	// {"@type": "type.googleapis.com/google.rpc.RetryInfo", "retryDelay": "2s"}.
+	// ErrorTrailers are sent as trailers with them, binary (-bin) values
+	// base64 encoded.
+	ErrorMessage  string            `json:"error_message,omitempty"`
+	ErrorDetails  []json.RawMessage `json:"error_details,omitempty"`
+	ErrorTrailers map[string]string `json:"error_trailers,omitempty"`
+}
+
+// CheckMetadataKey checks that key is a lowercase gRPC metadata key, of
+// digits, letters, '-', '_' and '.', outside the reserved grpc- prefix.
+func CheckMetadataKey(key string) error {
+	for _, c := range key {
+		if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return fmt.Errorf("invalid metadata key %q", key)
+		}
+	}
+	switch {
+	case key == "":
+		return fmt.Errorf("empty metadata key")
+	case strings.HasPrefix(key, "grpc-"):
+		return fmt.Errorf("metadata key %q is reserved", key)
+	}
+	return nil
+}
+
+// parseCode resolves a canonical status code name such as "UNAVAILABLE".
+func parseCode(name string) (codes.Code, error) {
+	var c codes.Code
+	if err := c.UnmarshalJSON([]byte(strconv.Quote(name))); err != nil {
+		return codes.OK, fmt.Errorf("unknown status code %q", name)
+	}
+	return c, nil
+}
+
+// compiled is a validated Spec.
+type compiled struct {
+	spec               Spec
+	delay              time.Duration
+	delayPercent       float64
+	errorCode          codes.Code
+	errorPercent       float64
+	abortAfterMessages int
+	abortCode          codes.Code
+	details            []*anypb.Any
+	trailer            metadata.MD
+}
+
+func compile(spec Spec) (*compiled, error) {
+	f := &compiled{spec: spec, delay: time.Duration(spec.DelayMs) * time.Millisecond, abortAfterMessages: spec.AbortAfterMessages}
+	for _, m := range spec.Methods {
+		if !strings.HasPrefix(m, "/") {
+			return nil, fmt.Errorf("method %q must start with /", m)
+		}
+	}
+	percent := func(name string, p float64) (float64, error) {
+		switch {
+		case p < 0 || p > 100:
+			return 0, fmt.Errorf("%s must be between 0 and 100, got %g", name, p)
+		case p == 0:
+			return 100, nil
+		}
+		return p, nil
+	}
+	var err error
+	switch {
+	case spec.DelayMs < 0:
+		return nil, fmt.Errorf("delay_ms must not be negative, got %d", spec.DelayMs)
+	case spec.DelayMs > 0:
+		if f.delayPercent, err = percent("delay_percent", spec.DelayPercent); err != nil {
+			return nil, err
+		}
+	}
+	if spec.ErrorCode != "" {
+		if f.errorCode, err = parseCode(spec.ErrorCode); err != nil {
+			return nil, fmt.Errorf("error_code: %w", err)
+		}
+		if f.errorCode == codes.OK {
+			return nil, fmt.Errorf("error_code must not be OK")
+		}
+		if f.errorPercent, err = percent("error_percent", spec.ErrorPercent); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case spec.AbortAfterMessages < 0:
+		return nil, fmt.Errorf("abort_after_messages must not be negative, got %d", spec.AbortAfterMessages)
+	case spec.AbortAfterMessages > 0:
+		f.abortCode = codes.Unavailable
+		if spec.AbortCode != "" {
+			if f.abortCode, err = parseCode(spec.AbortCode); err != nil {
+				return nil, fmt.Errorf("abort_code: %w", err)
+			}
+		}
+	}
+	for i, raw := range spec.ErrorDetails {
+		detail := &anypb.Any{}
+		if err := protojson.Unmarshal(raw, detail); err != nil {
+			return nil, fmt.Errorf("error_details[%d]: %v", i, err)
+		}
+		f.details = append(f.details, detail)
+	}
+	f.trailer = metadata.MD{}
+	for key, value := range spec.ErrorTrailers {
+		if err := CheckMetadataKey(key); err != nil {
+			return nil, fmt.Errorf("error_trailers: %v", err)
+		}
+		if strings.HasSuffix(key, "-bin") {
+			b, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return nil, fmt.Errorf("error_trailers: %s: %v", key, err)
+			}
+			value = string(b)
+		}
+		f.trailer.Append(key, value)
+	}
+	return f, nil
+}
+
+// fail returns an injected status with code and, unless the spec
+// overrides it, message, carrying the details of the spec, and sets the
+// trailers of the spec on the RPC of ctx.
+func (f *compiled) fail(ctx context.Context, code codes.Code, message string) error {
+	if f.spec.ErrorMessage != "" {
+		message = f.spec.ErrorMessage
+	}
+	if len(f.trailer) > 0 {
+		grpc.SetTrailer(ctx, f.trailer)
+	}
+	return status.FromProto(&spb.Status{Code: int32(code), Message: message, Details: f.details}).Err()
+}
+
+// matches reports whether the faults apply to method.
+func (f *compiled) matches(method string) bool {
+	if len(f.spec.Methods) == 0 {
+		return true
+	}
+	for _, m := range f.spec.Methods {
+		if method == m || strings.HasSuffix(m, "/") && strings.HasPrefix(method, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// roll reports whether an RPC falls within percent percent of calls.
+func roll(percent float64) bool {
+	return percent >= 100 || rand.Float64()*100 < percent
+}
+
+// Injector holds the faults currently injected. The zero value is
+// ready to use and injects nothing.
+type Injector struct {
+	// OnInject, if set, is called with the method and the kind of every
+	// fault injected: "delay", "error", "abort", or "goaway" with an empty
+	// method.
+	OnInject func(method, fault string)
+
+	mu     sync.Mutex
+	faults *compiled
+	conns  map[*goawayConn]struct{}
+}
+
+// injected reports a fault to OnInject.
+func (fi *Injector) injected(method, fault string) {
+	if fi.OnInject != nil {
+		fi.OnInject(method, fault)
+	}
+}
+
+// current returns the faults applying to method, or nil.
+func (fi *Injector) current(method string) *compiled {
+	fi.mu.Lock()
+	f := fi.faults
+	fi.mu.Unlock()
+	if f == nil || !f.matches(method) {
+		return nil
+	}
+	return f
+}
+
+// begin injects the delay and error faults of f at the start of an RPC. A
+// non-nil error must be returned instead of handling the RPC.
+func (fi *Injector) begin(ctx context.Context, f *compiled, method string) error {
+	if f.delay > 0 && roll(f.delayPercent) {
+		fi.injected(method, "delay")
+		t := time.NewTimer(f.delay)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+	if f.errorCode != codes.OK && roll(f.errorPercent) {
+		fi.injected(method, "error")
+		return f.fail(ctx, f.errorCode, "injected fault")
+	}
+	return nil
+}
+
+// UnaryInterceptor injects the faults into unary RPCs.
+func (fi *Injector) UnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if f := fi.current(info.FullMethod); f != nil {
+		if err := fi.begin(ctx, f, info.FullMethod); err != nil {
+			return nil, err
+		}
+	}
+	return handler(ctx, req)
+}
+
+// StreamInterceptor injects the faults into streaming RPCs.
+func (fi *Injector) StreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	f := fi.current(info.FullMethod)
+	if f == nil {
+		return handler(srv, ss)
+	}
+	if err := fi.begin(ss.Context(), f, info.FullMethod); err != nil {
+		return err
+	}
+	if f.abortAfterMessages == 0 {
+		return handler(srv, ss)
+	}
+	fs := &faultStream{ServerStream: ss, remaining: f.abortAfterMessages, faults: f}
+	err := handler(srv, fs)
+	if fs.aborted != nil {
+		fi.injected(info.FullMethod, "abort")
+		// The handler saw the abort as a failed send; report the injected
+		// status rather than its reaction to it.
+		return fs.aborted
+	}
+	return err
+}
+
+// faultStream fails the send of the last of its remaining messages, after
+// sending it, and every send after that.
+type faultStream struct {
+	grpc.ServerStream
+	remaining int
+	faults    *compiled
+	aborted   error
+}
+
+func (s *faultStream) SendMsg(m any) error {
+	if s.aborted != nil {
+		return s.aborted
+	}
+	if err := s.ServerStream.SendMsg(m); err != nil {
+		return err
+	}
+	if s.remaining--; s.remaining == 0 {
+		s.aborted = s.faults.fail(s.Context(), s.faults.abortCode, "injected abort")
+		return s.aborted
+	}
+	return nil
+}
+
+// ServeHTTP shows the injected faults on GET, replaces them with the
+// Spec in the body on PUT or POST, and clears them on DELETE.
+func (fi *Injector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var spec Spec
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&spec); err != nil {
+			http.Error(w, "invalid fault spec: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := fi.Set(spec); err != nil {
+			http.Error(w, "invalid fault spec: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	case http.MethodDelete:
+		fi.Clear()
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	spec := fi.Spec()
+	if spec == nil {
+		spec = &Spec{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(spec)
+}
+
+// Set validates spec and injects its faults in place of the current ones.
+func (fi *Injector) Set(spec Spec) error {
+	f, err := compile(spec)
+	if err != nil {
+		return err
+	}
+	fi.set(f)
+	return nil
+}
+
+// Clear stops injecting faults.
+func (fi *Injector) Clear() {
+	fi.set(nil)
+}
+
+// set replaces the injected faults, or clears them if f is nil.
+func (fi *Injector) set(f *compiled) {
+	fi.mu.Lock()
+	fi.faults = f
+	fi.mu.Unlock()
+	if f == nil {
+		log.Println("Cleared injected faults")
+		return
+	}
+	body, _ := json.Marshal(f.spec)
+	log.Printf("Injecting faults: %s", body)
+}
+
+// Spec returns the spec of the injected faults, or nil if none are.
+func (fi *Injector) Spec() *Spec {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	if fi.faults == nil {
+		return nil
+	}
+	spec := fi.faults.spec
+	return &spec
+}
+
+// ServeGoaway handles POST /faults/goaway by sending GOAWAY on every open
+// client connection.
+func (fi *Injector) ServeGoaway(w http.ResponseWriter, r *http.Request) {
+	n := fi.Goaway()
+	log.Printf("Sent GOAWAY on %d connection(s)", n)
+	fmt.Fprintf(w, "Sent GOAWAY on %d connection(s)\n", n)
+}
+
+// Goaway sends GOAWAY on every connection that has not received one yet
+// and returns how many there were.
+func (fi *Injector) Goaway() int {
+	fi.mu.Lock()
+	conns := make([]*goawayConn, 0, len(fi.conns))
+	for c := range fi.conns {
+		conns = append(conns, c)
+	}
+	fi.mu.Unlock()
+	n := 0
+	for _, c := range conns {
+		if c.goaway() {
+			n++
+			fi.injected("", "goaway")
+		}
+	}
+	return n
+}
+
+// Creds wraps server credentials so the connections they establish can be
+// sent GOAWAY. The server must be created with a zero write buffer size,
+// so that every write is exactly one frame.
+func (fi *Injector) Creds(c credentials.TransportCredentials) credentials.TransportCredentials {
+	return goawayCreds{c, fi}
+}
+
+type goawayCreds struct {
+	credentials.TransportCredentials
+	fi *Injector
+}
+
+func (c goawayCreds) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	out, info, err := c.TransportCredentials.ServerHandshake(conn)
+	if err != nil {
+		return out, info, err
+	}
+	gc := &goawayConn{Conn: out, fi: c.fi}
+	c.fi.mu.Lock()
+	if c.fi.conns == nil {
+		c.fi.conns = make(map[*goawayConn]struct{})
+	}
+	c.fi.conns[gc] = struct{}{}
+	c.fi.mu.Unlock()
+	return gc, info, nil
+}
+
+func (c goawayCreds) Clone() credentials.TransportCredentials {
+	return goawayCreds{c.TransportCredentials.Clone(), c.fi}
+}
+
+// goawayConn is a server connection that can write a GOAWAY frame between
+// the frames gRPC writes, one per Write.
+type goawayConn struct {
+	net.Conn
+	fi *Injector
+
+	mu sync.Mutex
+	// started is set once the server preface, which must come first, was
+	// written.
+	started bool
+	// inHeaders is set while a header block is split over CONTINUATION
+	// frames, which nothing may interrupt.
+	inHeaders bool
+	pending   bool // a GOAWAY waits for the next frame boundary
+	sent      bool
+}
+
+func (c *goawayConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n, err := c.Conn.Write(b)
+	if err != nil {
+		return n, err
+	}
+	c.started = true
+	if len(b) >= 9 {
+		switch http2.FrameType(b[3]) {
+		case http2.FrameHeaders, http2.FrameContinuation:
+			c.inHeaders = http2.Flags(b[4])&http2.FlagHeadersEndHeaders == 0
+		}
+	}
+	if c.pending && !c.inHeaders {
+		c.pending = false
+		c.writeGoaway()
+	}
+	return n, nil
+}
+
+// goaway sends GOAWAY now or at the next frame boundary. It reports false
+// if the connection was already sent one.
+func (c *goawayConn) goaway() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sent || c.pending {
+		return false
+	}
+	if !c.started || c.inHeaders {
+		c.pending = true
+	} else {
+		c.writeGoaway()
+	}
+	return true
+}
+
+// writeGoaway writes the frame. Like gRPC's own graceful GOAWAY it leaves
+// every stream ID open, so active streams finish while the client stops
+// opening new ones and reconnects.
+func (c *goawayConn) writeGoaway() {
+	c.sent = true
+	if err := http2.NewFramer(c.Conn, nil).WriteGoAway(1<<31-1, http2.ErrCodeNo, []byte("fault injection")); err != nil {
+		log.Printf("Failed to send GOAWAY to %s: %v", c.RemoteAddr(), err)
+	}
+}
+
+func (c *goawayConn) Close() error {
+	c.fi.mu.Lock()
+	delete(c.fi.conns, c)
+	c.fi.mu.Unlock()
+	return c.Conn.Close()
+}
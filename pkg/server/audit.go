@@ -0,0 +1,93 @@
+// audit.go
+//
+// This file keeps a bounded in-memory log of recently completed RPCs,
+// served as JSON on the HTTP server's /audit endpoint for debugging without
+// a log aggregator.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// auditEntry describes one completed RPC.
+type auditEntry struct {
+	Method     string    `json:"method"`
+	Time       time.Time `json:"time"`
+	Peer       string    `json:"peer"`
+	Extension  string    `json:"extension,omitempty"`
+	Code       string    `json:"code"`
+	DurationMS float64   `json:"duration_ms"`
+}
+
+// auditLog is a fixed-size ring buffer of the most recent RPCs.
+type auditLog struct {
+	mu      sync.Mutex
+	entries []auditEntry
+	next    int
+	full    bool
+}
+
+func newAuditLog(size int) *auditLog {
+	return &auditLog{entries: make([]auditEntry, size)}
+}
+
+func (a *auditLog) add(e auditEntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.entries[a.next] = e
+	a.next = (a.next + 1) % len(a.entries)
+	if a.next == 0 {
+		a.full = true
+	}
+}
+
+// snapshot returns the buffered entries, oldest first.
+func (a *auditLog) snapshot() []auditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.full {
+		return append([]auditEntry(nil), a.entries[:a.next]...)
+	}
+	out := make([]auditEntry, 0, len(a.entries))
+	out = append(out, a.entries[a.next:]...)
+	return append(out, a.entries[:a.next]...)
+}
+
+func (a *auditLog) record(ctx context.Context, method string, start time.Time, err error) {
+	id := IdentityFromContext(ctx)
+	a.add(auditEntry{
+		Method:     method,
+		Time:       start,
+		Peer:       id.Name(),
+		Extension:  id.Extension,
+		Code:       status.Code(err).String(),
+		DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+	})
+}
+
+func (a *auditLog) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	a.record(ctx, info.FullMethod, start, err)
+	return resp, err
+}
+
+func (a *auditLog) streamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	a.record(ss.Context(), info.FullMethod, start, err)
+	return err
+}
+
+func (a *auditLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.snapshot())
+}
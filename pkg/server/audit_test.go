@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/grpc"
+
+	pb "github.com/dethi/envoy_hck/protos"
+)
+
+func TestAuditLog(t *testing.T) {
+	audit := newAuditLog(2)
+	client := timeClient(t, newTestServer(t, serviceDefaults{}), grpc.ChainUnaryInterceptor(audit.unaryInterceptor))
+	ctx := context.Background()
+	for _, zone := range []string{"UTC", "Nowhere/Bogus", "Europe/Paris"} {
+		client.GetTime(ctx, &pb.TimeRequest{Timezone: zone})
+	}
+
+	rec := httptest.NewRecorder()
+	audit.ServeHTTP(rec, httptest.NewRequest("GET", "/audit", nil))
+	var entries []auditEntry
+	if err := json.NewDecoder(rec.Body).Decode(&entries); err != nil {
+		t.Fatal(err)
+	}
+	// The buffer keeps the last two RPCs, oldest first.
+	want := []string{"InvalidArgument", "OK"}
+	if len(entries) != len(want) {
+		t.Fatalf("/audit lists %d RPCs, want %d: %+v", len(entries), len(want), entries)
+	}
+	for i, e := range entries {
+		if e.Method != pb.TimeService_GetTime_FullMethodName || e.Code != want[i] || e.Time.IsZero() {
+			t.Errorf("entry %d = %+v, want GetTime with %s", i, e, want[i])
+		}
+	}
+	if !entries[0].Time.Before(entries[1].Time) {
+		t.Error("entries are not oldest first")
+	}
+}
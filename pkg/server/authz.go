@@ -0,0 +1,177 @@
+// authz.go
+//
+// This file authorizes RPCs by client certificate identity, beyond the CA
+// verification of the handshake, so Envoy RBAC can be compared with
+// authorization enforced upstream. The policy is a file of rules allowing
+// SANs or SPIFFE IDs to call methods; it is reloaded when it changes and
+// on SIGHUP, and RPCs no rule allows fail with PERMISSION_DENIED.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v3"
+
+	"github.com/dethi/envoy_hck/pkg/tlsutil"
+)
+
+// authzPolicy is the content of an -authz-policy file, YAML or JSON:
+//
+//	rules:
+//	  - methods: ["/time.TimeService/"]
+//	    principals: ["spiffe://example.org/ns/default/sa/envoy", "localhost"]
+//	  - methods: ["/time.ServerInfo/GetServerInfo"]
+//	    principals: ["*"]
+type authzPolicy struct {
+	Rules []authzRule `yaml:"rules"`
+}
+
+// authzRule allows its principals to call its methods.
+type authzRule struct {
+	// Methods are full method names (e.g. "/time.TimeService/GetTime") or
+	// services (e.g. "/time.TimeService/"). Empty matches every method.
+	Methods []string `yaml:"methods"`
+	// Principals are matched against the SPIFFE ID, URI SANs and DNS SANs
+	// of the client certificate. A value ending in "/" matches as a prefix
+	// (e.g. every SPIFFE ID of a trust domain), and "*" matches any client,
+	// even one without a certificate.
+	Principals []string `yaml:"principals"`
+}
+
+// loadAuthzPolicy reads and checks the policy in path.
+func loadAuthzPolicy(path string) (*authzPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var policy authzPolicy
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&policy); err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	for i, rule := range policy.Rules {
+		for _, m := range rule.Methods {
+			if !strings.HasPrefix(m, "/") {
+				return nil, fmt.Errorf("%s: rule %d: method %q must start with /", filepath.Base(path), i+1, m)
+			}
+		}
+		if len(rule.Principals) == 0 {
+			return nil, fmt.Errorf("%s: rule %d has no principals", filepath.Base(path), i+1)
+		}
+	}
+	return &policy, nil
+}
+
+// allows reports whether the policy lets id call method.
+func (p *authzPolicy) allows(method string, id Identity) bool {
+	names := slices.Concat(id.URIs, id.DNSNames)
+	if id.SPIFFEID != "" && !slices.Contains(names, id.SPIFFEID) {
+		names = append(names, id.SPIFFEID)
+	}
+	for _, rule := range p.Rules {
+		if !rule.matchesMethod(method) {
+			continue
+		}
+		for _, principal := range rule.Principals {
+			if principal == "*" {
+				return true
+			}
+			for _, name := range names {
+				if name == principal || strings.HasSuffix(principal, "/") && strings.HasPrefix(name, principal) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (r authzRule) matchesMethod(method string) bool {
+	if len(r.Methods) == 0 {
+		return true
+	}
+	for _, m := range r.Methods {
+		if method == m || strings.HasSuffix(m, "/") && strings.HasPrefix(method, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// authorizer enforces the policy loaded from a file, which it reloads on
+// change.
+type authorizer struct {
+	path   string
+	policy atomic.Pointer[authzPolicy]
+}
+
+// newAuthorizer loads the policy in path.
+func newAuthorizer(path string) (*authorizer, error) {
+	policy, err := loadAuthzPolicy(path)
+	if err != nil {
+		return nil, err
+	}
+	a := &authorizer{path: path}
+	a.policy.Store(policy)
+	log.Printf("Loaded authorization policy from %s: %d rules", path, len(policy.Rules))
+	return a, nil
+}
+
+// reload replaces the policy with the file's current content. On failure
+// the previous policy stays in force.
+func (a *authorizer) reload(source string) {
+	policy, err := loadAuthzPolicy(a.path)
+	if err != nil {
+		log.Printf("Authorization policy reload (%s) failed, keeping the previous policy: %v", source, err)
+		return
+	}
+	a.policy.Store(policy)
+	log.Printf("Reloaded authorization policy (%s): %d rules", source, len(policy.Rules))
+}
+
+// watch reloads the policy on SIGHUP and, if interval is positive, when
+// the file changes, until ctx is done.
+func (a *authorizer) watch(ctx context.Context, interval time.Duration) {
+	tlsutil.WatchFile(ctx, a.path, interval, a.reload)
+}
+
+// authorize fails the RPC in ctx with PERMISSION_DENIED unless the policy
+// allows its client to call method.
+func (a *authorizer) authorize(ctx context.Context, method string) error {
+	id := IdentityFromContext(ctx)
+	if a.policy.Load().allows(method, id) {
+		authzDecisions.WithLabelValues(method, "allow").Inc()
+		return nil
+	}
+	authzDecisions.WithLabelValues(method, "deny").Inc()
+	log.Printf("Denied %s to %s", method, id.Name())
+	return status.Errorf(codes.PermissionDenied, "%s may not call %s", id.Name(), method)
+}
+
+func (a *authorizer) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := a.authorize(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a *authorizer) streamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := a.authorize(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
@@ -0,0 +1,101 @@
+// bootreport.go
+//
+// This file assembles the boot report: one structured summary of the
+// effective configuration, the TLS certificates in use, the enabled
+// features and the bound addresses, logged once startup has succeeded. It
+// is the first thing to look at when an instance behaves unexpectedly.
+
+package server
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// certSummary describes a certificate in the boot report.
+type certSummary struct {
+	Role      string    `json:"role"` // "server" or "client-ca"
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	DNSNames  []string  `json:"dns_names,omitempty"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+}
+
+func summarizeCert(role string, cert *x509.Certificate) certSummary {
+	return certSummary{
+		Role:      role,
+		Subject:   cert.Subject.String(),
+		Issuer:    cert.Issuer.String(),
+		DNSNames:  cert.DNSNames,
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+	}
+}
+
+// bootReport is the summary logged, and optionally written, at startup.
+type bootReport struct {
+	Version      string            `json:"version"`
+	Commit       string            `json:"commit"`
+	StartedAt    time.Time         `json:"started_at"`
+	Config       map[string]string `json:"config"`
+	Certificates []certSummary     `json:"certificates"`
+	Features     []string          `json:"features"`
+	Addresses    []string          `json:"addresses"`
+}
+
+// effectiveFlags returns the value of every flag under cfg, defaults
+// included. Flags holding secrets are redacted.
+func effectiveFlags(cfg Config) map[string]string {
+	// The flags read bound, which holds the values of cfg once copied over
+	// the defaults RegisterFlags set.
+	var bound Config
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	bound.RegisterFlags(fs)
+	bound = cfg
+	values := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		v := f.Value.String()
+		if strings.Contains(f.Name, "token") && v != "" {
+			v = "[redacted]"
+		}
+		values[f.Name] = v
+	})
+	return values
+}
+
+// log emits the report as a single structured log line.
+func (r bootReport) log() {
+	slog.Info("Boot report",
+		"version", r.Version,
+		"commit", r.Commit,
+		"config", r.Config,
+		"certificates", r.Certificates,
+		"features", r.Features,
+		"addresses", r.Addresses,
+	)
+}
+
+// write saves the report as indented JSON to path.
+func (r bootReport) write(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// serveConfig returns the handler of GET /config, serving the effective
+// flag values as JSON.
+func serveConfig(values map[string]string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(values)
+	}
+}
@@ -0,0 +1,116 @@
+// breaker.go
+//
+// This file implements a circuit breaker on stream sends. When the share
+// of failing sends across all streams exceeds a threshold, the server
+// reports NOT_SERVING so Envoy routes traffic away, and it recovers on its
+// own once the failures subside.
+
+package server
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// sendBucket counts the sends of one second.
+type sendBucket struct {
+	second int64
+	sends  int64
+	errors int64
+}
+
+// sendBreaker tracks the send error rate over a rolling window of
+// one-second buckets.
+type sendBreaker struct {
+	threshold float64 // error rate that opens the breaker
+	minSends  int64   // sends needed in the window before it can open
+	onChange  func(open bool)
+
+	mu      sync.Mutex
+	buckets []sendBucket
+	open    bool
+}
+
+func newSendBreaker(threshold float64, window time.Duration, minSends int64, onChange func(open bool)) *sendBreaker {
+	n := max(int(window/time.Second), 1)
+	return &sendBreaker{
+		threshold: threshold,
+		minSends:  minSends,
+		onChange:  onChange,
+		buckets:   make([]sendBucket, n),
+	}
+}
+
+// record counts one send and its outcome.
+func (b *sendBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now().Unix()
+	bucket := &b.buckets[now%int64(len(b.buckets))]
+	if bucket.second != now {
+		*bucket = sendBucket{second: now}
+	}
+	bucket.sends++
+	if err != nil {
+		bucket.errors++
+	}
+	b.evaluateLocked(now)
+}
+
+func (b *sendBreaker) evaluateLocked(now int64) {
+	var sends, errors int64
+	for _, bucket := range b.buckets {
+		if bucket.second > now-int64(len(b.buckets)) {
+			sends += bucket.sends
+			errors += bucket.errors
+		}
+	}
+	open := sends >= b.minSends && float64(errors) >= b.threshold*float64(sends)
+	if open == b.open {
+		return
+	}
+	b.open = open
+	if open {
+		log.Printf("Send circuit breaker opened: %d of %d sends failed in the last %ds", errors, sends, len(b.buckets))
+	} else {
+		log.Println("Send circuit breaker closed: send errors subsided")
+	}
+	b.onChange(open)
+}
+
+// run re-evaluates the window every second so the breaker closes once old
+// failures age out, even if no stream is sending anymore.
+func (b *sendBreaker) run(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case t := <-ticker.C:
+			b.mu.Lock()
+			b.evaluateLocked(t.Unix())
+			b.mu.Unlock()
+		}
+	}
+}
+
+// streamInterceptor records the outcome of every message sent on a stream.
+func (b *sendBreaker) streamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &breakerStream{ServerStream: ss, breaker: b})
+}
+
+type breakerStream struct {
+	grpc.ServerStream
+	breaker *sendBreaker
+}
+
+func (s *breakerStream) SendMsg(m any) error {
+	err := s.ServerStream.SendMsg(m)
+	s.breaker.record(err)
+	return err
+}
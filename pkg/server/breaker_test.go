@@ -0,0 +1,57 @@
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// sendStream is a grpc.ServerStream whose sends fail with err.
+type sendStream struct {
+	grpc.ServerStream
+	err error
+}
+
+func (s *sendStream) SendMsg(any) error { return s.err }
+
+func TestSendBreakerFlipsHealth(t *testing.T) {
+	healthyProcess(t)
+	hs := health.NewServer()
+	b := newSendBreaker(0.5, 10*time.Second, 4, func(open bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		breakerOpen.Store(open)
+		publishHealth(hs, "circuit-breaker")
+	})
+	mu.Lock()
+	publishHealth(hs, "test")
+	mu.Unlock()
+	ok := &breakerStream{ServerStream: &sendStream{}, breaker: b}
+	failing := &breakerStream{ServerStream: &sendStream{err: errors.New("stuck client")}, breaker: b}
+
+	for range 3 {
+		ok.SendMsg(nil)
+	}
+	failing.SendMsg(nil)
+	if got := overallStatus(t, hs); got != grpc_health_v1.HealthCheckResponse_SERVING {
+		t.Fatalf("1 of 4 sends failed: %s, want SERVING", got)
+	}
+	for range 2 {
+		failing.SendMsg(nil)
+	}
+	if got := overallStatus(t, hs); got != grpc_health_v1.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("3 of 6 sends failed: %s, want NOT_SERVING", got)
+	}
+
+	// Once the failures leave the window the breaker closes.
+	b.mu.Lock()
+	b.evaluateLocked(time.Now().Unix() + 10)
+	b.mu.Unlock()
+	if got := overallStatus(t, hs); got != grpc_health_v1.HealthCheckResponse_SERVING {
+		t.Errorf("after the window: %s, want SERVING", got)
+	}
+}
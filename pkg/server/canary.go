@@ -0,0 +1,65 @@
+// canary.go
+//
+// This file tags requests carrying the canary metadata key so handlers can
+// switch to experimental behavior for them only, which lets canary routing
+// through Envoy be validated end to end.
+
+package server
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// canaryServedHeader is set on responses to canary requests so clients can
+// tell their request reached a backend that honored the tag.
+const canaryServedHeader = "x-canary-served"
+
+type canaryCtxKey struct{}
+
+// isCanary reports whether the RPC was tagged as canary traffic.
+func isCanary(ctx context.Context) bool {
+	return ctx.Value(canaryCtxKey{}) != nil
+}
+
+// canaryTagger marks RPCs whose metadata key holds a true boolean value.
+type canaryTagger string
+
+func newCanaryTagger(key string) (canaryTagger, error) {
+	key = strings.ToLower(strings.TrimSpace(key))
+	if err := validateHeaderKey(key); err != nil {
+		return "", err
+	}
+	return canaryTagger(key), nil
+}
+
+func (k canaryTagger) tag(ctx context.Context) (context.Context, bool) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get(string(k)) {
+		if canary, err := strconv.ParseBool(v); err == nil && canary {
+			return context.WithValue(ctx, canaryCtxKey{}, true), true
+		}
+	}
+	return ctx, false
+}
+
+func (k canaryTagger) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, canary := k.tag(ctx)
+	if canary {
+		grpc.SetHeader(ctx, metadata.Pairs(canaryServedHeader, "true"))
+	}
+	return handler(ctx, req)
+}
+
+func (k canaryTagger) streamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, canary := k.tag(ss.Context())
+	if !canary {
+		return handler(srv, ss)
+	}
+	ss.SetHeader(metadata.Pairs(canaryServedHeader, "true"))
+	return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	pb "github.com/dethi/envoy_hck/protos"
+)
+
+func TestCanaryInterval(t *testing.T) {
+	k, err := newCanaryTagger("X-Canary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(t, serviceDefaults{interval: 200 * time.Millisecond})
+	s.canaryInterval = 10 * time.Millisecond
+	client := timeClient(t, s, grpc.ChainStreamInterceptor(k.streamInterceptor))
+
+	for _, tc := range []struct {
+		value  string
+		canary bool
+	}{
+		{"true", true},
+		{"false", false},
+		{"", false},
+	} {
+		ctx := context.Background()
+		if tc.value != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "x-canary", tc.value)
+		}
+		stream, err := client.StreamTime(ctx, &pb.TimeRequest{MessageCount: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, err := stream.Header()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if served := len(header.Get(canaryServedHeader)) > 0; served != tc.canary {
+			t.Errorf("x-canary %q: %s set = %v, want %v", tc.value, canaryServedHeader, served, tc.canary)
+		}
+		start := time.Now()
+		for range 2 {
+			if _, err := stream.Recv(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// Canary streams tick at the canary interval, others at the default.
+		if took := time.Since(start); (took < 100*time.Millisecond) != tc.canary {
+			t.Errorf("x-canary %q: 2 ticks took %s, want canary %v", tc.value, took, tc.canary)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+// chaos.go
+//
+// This file implements chaos mode, the process-level failures Envoy's
+// outlier detection and panic routing have to cope with and that RPC
+// faults cannot produce:
+//
+//	exit          the process exits at once with a given code, no drain
+//	pause-accept  new connections wait in the kernel's accept queue
+//	hang          connections are no longer read from, like a hung
+//	              upstream: requests and pings go unanswered
+//	pause-sends   streams stop sending mid-flight
+//
+// The last three last for a given duration. Actions run through POST
+// /chaos or, with -chaos-interval, one at random from -chaos-actions on a
+// schedule.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// chaosActions are the actions of chaos mode.
+var chaosActions = []string{"exit", "pause-accept", "hang", "pause-sends"}
+
+// chaosAction is an action run through POST /chaos.
+type chaosAction struct {
+	// Action is one of chaosActions.
+	Action string `json:"action"`
+	// DurationMs is how long pause-accept, hang and pause-sends last.
+	DurationMs int64 `json:"duration_ms,omitempty"`
+	// ExitCode is the exit status of exit, 1 if omitted.
+	ExitCode int `json:"exit_code,omitempty"`
+}
+
+func (a chaosAction) validate() error {
+	switch {
+	case !slices.Contains(chaosActions, a.Action):
+		return fmt.Errorf("unknown action %q, want one of %v", a.Action, chaosActions)
+	case a.Action != "exit" && a.DurationMs <= 0:
+		return fmt.Errorf("duration_ms must be positive for %s", a.Action)
+	case a.ExitCode < 0 || a.ExitCode > 255:
+		return fmt.Errorf("exit_code must be between 0 and 255, got %d", a.ExitCode)
+	}
+	return nil
+}
+
+// chaosGate holds back whoever waits on it while paused. The zero value
+// is open.
+type chaosGate struct {
+	mu     sync.Mutex
+	until  time.Time
+	closed chan struct{} // nil while open
+	gen    int
+}
+
+// openGate is what wait returns while a gate is open.
+var openGate = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+// pause closes the gate for d, or until the end of an ongoing pause if
+// that is later.
+func (g *chaosGate) pause(d time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed == nil {
+		g.closed = make(chan struct{})
+	}
+	if until := time.Now().Add(d); until.After(g.until) {
+		g.until = until
+		g.gen++
+		gen := g.gen
+		time.AfterFunc(d, func() { g.resume(gen) })
+	}
+}
+
+// resume opens the gate, unless it was paused again after gen, when gen
+// is not zero.
+func (g *chaosGate) resume(gen int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed == nil || gen != 0 && gen != g.gen {
+		return
+	}
+	close(g.closed)
+	g.closed, g.until = nil, time.Time{}
+}
+
+// wait returns a channel that is closed once the gate is open.
+func (g *chaosGate) wait() <-chan struct{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed == nil {
+		return openGate
+	}
+	return g.closed
+}
+
+// pausedUntil returns the end of the ongoing pause, or the zero time.
+func (g *chaosGate) pausedUntil() time.Time {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.until
+}
+
+// chaosController runs chaos actions against the gRPC listeners, their
+// connections and their streams.
+type chaosController struct {
+	accept, reads, sends chaosGate
+}
+
+// run runs action, which must be valid, attributed to trigger ("admin" or
+// "schedule").
+func (c *chaosController) run(action chaosAction, trigger string) {
+	chaosActionsRun.WithLabelValues(action.Action, trigger).Inc()
+	d := time.Duration(action.DurationMs) * time.Millisecond
+	switch action.Action {
+	case "exit":
+		log.Printf("Chaos (%s): exiting with status %d", trigger, action.ExitCode)
+		os.Exit(action.ExitCode)
+	case "pause-accept":
+		log.Printf("Chaos (%s): not accepting connections for %s", trigger, d)
+		c.accept.pause(d)
+	case "hang":
+		log.Printf("Chaos (%s): not reading from connections for %s", trigger, d)
+		c.reads.pause(d)
+	case "pause-sends":
+		log.Printf("Chaos (%s): pausing stream sends for %s", trigger, d)
+		c.sends.pause(d)
+	}
+}
+
+// schedule runs one of actions, chosen at random, every interval, each
+// pause lasting d, until ctx is done.
+func (c *chaosController) schedule(ctx context.Context, interval time.Duration, actions []string, d time.Duration, exitCode int) {
+	log.Printf("Running a chaos action out of %v every %s", actions, interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.run(chaosAction{Action: actions[rand.IntN(len(actions))], DurationMs: d.Milliseconds(), ExitCode: exitCode}, "schedule")
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// listener wraps lis so that it stops accepting while pause-accept lasts
+// and its connections stop reading while hang lasts.
+func (c *chaosController) listener(lis net.Listener) net.Listener {
+	return &chaosListener{Listener: lis, chaos: c, done: make(chan struct{})}
+}
+
+type chaosListener struct {
+	net.Listener
+	chaos *chaosController
+	once  sync.Once
+	done  chan struct{}
+}
+
+// Accept holds a connection accepted during pause-accept back until the
+// pause ends, leaving the next ones in the kernel's queue.
+func (l *chaosListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case <-l.chaos.accept.wait():
+	case <-l.done:
+		conn.Close()
+		return nil, net.ErrClosed
+	}
+	return &chaosConn{Conn: conn, chaos: l.chaos, done: make(chan struct{})}, nil
+}
+
+func (l *chaosListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+type chaosConn struct {
+	net.Conn
+	chaos *chaosController
+	once  sync.Once
+	done  chan struct{}
+}
+
+func (c *chaosConn) Read(b []byte) (int, error) {
+	select {
+	case <-c.chaos.reads.wait():
+	case <-c.done:
+		return 0, net.ErrClosed
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *chaosConn) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return c.Conn.Close()
+}
+
+// streamInterceptor holds back the messages of streams while pause-sends
+// lasts.
+func (c *chaosController) streamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &chaosStream{ServerStream: ss, chaos: c})
+}
+
+type chaosStream struct {
+	grpc.ServerStream
+	chaos *chaosController
+}
+
+func (s *chaosStream) SendMsg(m any) error {
+	select {
+	case <-s.chaos.sends.wait():
+	case <-s.Context().Done():
+		return context.Cause(s.Context())
+	}
+	return s.ServerStream.SendMsg(m)
+}
+
+// chaosState is the JSON form of the ongoing pauses.
+type chaosState struct {
+	AcceptPausedUntil time.Time `json:"accept_paused_until,omitzero"`
+	HungUntil         time.Time `json:"hung_until,omitzero"`
+	SendsPausedUntil  time.Time `json:"sends_paused_until,omitzero"`
+}
+
+// ServeHTTP shows the ongoing pauses on GET, runs the chaosAction in the
+// body on POST, and ends every pause on DELETE.
+func (c *chaosController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		action := chaosAction{ExitCode: 1}
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&action); err != nil {
+			writeJSONError(w, "invalid chaos action: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := action.validate(); err != nil {
+			writeJSONError(w, "invalid chaos action: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.run(action, "admin")
+	case http.MethodDelete:
+		c.accept.resume(0)
+		c.reads.resume(0)
+		c.sends.resume(0)
+		log.Println("Chaos: ended every pause")
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		writeJSONError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.state())
+}
+
+// state returns the ongoing pauses.
+func (c *chaosController) state() chaosState {
+	return chaosState{
+		AcceptPausedUntil: c.accept.pausedUntil(),
+		HungUntil:         c.reads.pausedUntil(),
+		SendsPausedUntil:  c.sends.pausedUntil(),
+	}
+}
+
+// restore ends every pause and resumes those of st that have not ended
+// yet, e.g. as saved before a restart.
+func (c *chaosController) restore(st chaosState) {
+	for _, p := range []struct {
+		gate  *chaosGate
+		until time.Time
+	}{{&c.accept, st.AcceptPausedUntil}, {&c.reads, st.HungUntil}, {&c.sends, st.SendsPausedUntil}} {
+		p.gate.resume(0)
+		if d := time.Until(p.until); d > 0 {
+			p.gate.pause(d)
+		}
+	}
+}
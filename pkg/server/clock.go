@@ -0,0 +1,162 @@
+// clock.go
+//
+// This file abstracts the source of the times the time service reports.
+// The server reads them from a clock, the system clock by default; with
+// -clock-control, the /clock endpoint of the HTTP server freezes it,
+// offsets it or makes it run faster or slower, so integration tests
+// comparing timestamps across proxies get deterministic values:
+//
+//	curl -X PUT localhost:8081/clock -d '{"frozen": true, "time": "2030-01-01T00:00:00Z"}'
+//	curl -X PUT localhost:8081/clock -d '{"offset_ms": -3600000}'
+//	curl -X PUT localhost:8081/clock -d '{"rate": 60}'
+//	curl -X DELETE localhost:8081/clock
+//
+// Stream ticks keep firing at their real interval; only the times they
+// report follow the clock.
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// clock tells the time the time service reports. Tests inject fakes.
+type clock interface {
+	Now() time.Time
+}
+
+// systemClock is the wall clock.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// clockSpec sets an adjustableClock, through PUT /clock.
+type clockSpec struct {
+	// Time, in RFC 3339 format, is the time the clock reports when the spec
+	// is set, instead of the base time plus OffsetMs.
+	Time string `json:"time,omitempty"`
+	// OffsetMs shifts the clock from the base time, in milliseconds.
+	OffsetMs int64 `json:"offset_ms,omitempty"`
+	// Rate is how fast the clock runs relative to the base clock, 1 if
+	// omitted.
+	Rate float64 `json:"rate,omitempty"`
+	// Frozen stops the clock.
+	Frozen bool `json:"frozen,omitempty"`
+}
+
+func (s clockSpec) validate() error {
+	switch {
+	case s.Time != "" && s.OffsetMs != 0:
+		return errors.New("time and offset_ms are mutually exclusive")
+	case s.Rate <= 0:
+		return fmt.Errorf("rate must be positive, got %g", s.Rate)
+	case s.Frozen && s.Rate != 1:
+		return errors.New("rate does not apply to a frozen clock")
+	}
+	if s.Time != "" {
+		if _, err := time.Parse(time.RFC3339Nano, s.Time); err != nil {
+			return fmt.Errorf("invalid time: %w", err)
+		}
+	}
+	return nil
+}
+
+// adjustableClock follows a base clock, frozen, offset or at a rate as
+// set by a clockSpec. The zero value is not usable; the base must be set.
+type adjustableClock struct {
+	base clock
+
+	mu   sync.Mutex
+	spec clockSpec // the zero value while following base
+	// Since the spec was set at base time setAt, the clock reports start
+	// plus the base time elapsed times the rate.
+	setAt, start time.Time
+}
+
+func newAdjustableClock(base clock) *adjustableClock {
+	return &adjustableClock{base: base}
+}
+
+func (c *adjustableClock) Now() time.Time {
+	now := c.base.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case c.spec == clockSpec{}:
+		return now
+	case c.spec.Frozen:
+		return c.start
+	default:
+		return c.start.Add(time.Duration(float64(now.Sub(c.setAt)) * c.spec.Rate))
+	}
+}
+
+// set makes the clock follow spec, which must be valid, from now on.
+func (c *adjustableClock) set(spec clockSpec) {
+	now := c.base.Now()
+	start := now.Add(time.Duration(spec.OffsetMs) * time.Millisecond)
+	if spec.Time != "" {
+		start, _ = time.Parse(time.RFC3339Nano, spec.Time)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.spec, c.setAt, c.start = spec, now, start
+	log.Printf("Clock set to %s (frozen=%t, rate %g)", start.Format(time.RFC3339Nano), spec.Frozen, spec.Rate)
+}
+
+// reset makes the clock follow its base again.
+func (c *adjustableClock) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.spec = clockSpec{}
+	log.Println("Clock reset to the system time")
+}
+
+// clockState is the JSON form of an adjustableClock.
+type clockState struct {
+	Now      time.Time  `json:"now"`
+	Adjusted bool       `json:"adjusted"`
+	Spec     *clockSpec `json:"spec,omitempty"`
+}
+
+// ServeHTTP shows the clock on GET, sets it to the clockSpec in the body on
+// PUT, and resets it to the system time on DELETE.
+func (c *adjustableClock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		spec := clockSpec{Rate: 1}
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&spec); err != nil {
+			writeJSONError(w, "invalid clock spec: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := spec.validate(); err != nil {
+			writeJSONError(w, "invalid clock spec: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.set(spec)
+	case http.MethodDelete:
+		c.reset()
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeJSONError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	state := clockState{Now: c.Now()}
+	c.mu.Lock()
+	if c.spec != (clockSpec{}) {
+		spec := c.spec
+		state.Adjusted, state.Spec = true, &spec
+	}
+	c.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
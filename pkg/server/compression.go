@@ -0,0 +1,169 @@
+// compression.go
+//
+// This file controls the compression of gRPC messages. gzip is always
+// registered and zstd with -zstd; by default a response is compressed like
+// the request it answers, as gRPC does. -response-compression forces a
+// compressor on every response the client accepts it for, or forbids
+// compressed responses altogether, and -log-compression logs the
+// grpc-encoding negotiated in each direction per RPC, so Envoy's handling
+// of compressed frames and of message-size limits on them can be tested.
+
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+)
+
+// responseCompressionModes are the values of -response-compression: auto,
+// off, or the compressor to force.
+var responseCompressionModes = []string{"auto", "off", "gzip", "zstd"}
+
+// zstdCompressor implements encoding.Compressor with zstd, reusing encoders
+// and decoders across messages.
+type zstdCompressor struct {
+	encoders, decoders sync.Pool
+}
+
+func (*zstdCompressor) Name() string { return "zstd" }
+
+func (c *zstdCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	enc, ok := c.encoders.Get().(*zstd.Encoder)
+	if !ok {
+		var err error
+		if enc, err = zstd.NewWriter(w, zstd.WithEncoderConcurrency(1)); err != nil {
+			return nil, err
+		}
+	} else {
+		enc.Reset(w)
+	}
+	return &zstdWriter{Encoder: enc, pool: &c.encoders}, nil
+}
+
+type zstdWriter struct {
+	*zstd.Encoder
+	pool *sync.Pool
+}
+
+func (w *zstdWriter) Close() error {
+	err := w.Encoder.Close()
+	w.pool.Put(w.Encoder)
+	return err
+}
+
+func (c *zstdCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	dec, ok := c.decoders.Get().(*zstd.Decoder)
+	if !ok {
+		var err error
+		if dec, err = zstd.NewReader(r, zstd.WithDecoderConcurrency(1)); err != nil {
+			return nil, err
+		}
+	} else if err := dec.Reset(r); err != nil {
+		c.decoders.Put(dec)
+		return nil, err
+	}
+	return &zstdReader{Decoder: dec, pool: &c.decoders}, nil
+}
+
+// zstdReader returns its decoder to the pool once the message is read.
+type zstdReader struct {
+	*zstd.Decoder
+	pool *sync.Pool
+}
+
+func (r *zstdReader) Read(p []byte) (int, error) {
+	n, err := r.Decoder.Read(p)
+	if err == io.EOF {
+		r.pool.Put(r.Decoder)
+	}
+	return n, err
+}
+
+// RegisterZstd registers the zstd compressor. It must be called before
+// any server or connection is created.
+func RegisterZstd() {
+	if encoding.GetCompressor("zstd") == nil {
+		encoding.RegisterCompressor(&zstdCompressor{})
+	}
+}
+
+// compressionPolicy applies -response-compression and -log-compression.
+type compressionPolicy struct {
+	// response is "auto", "off" or the name of the compressor to force.
+	response string
+	log      bool
+}
+
+// messageEncodings returns the grpc-encoding of the request and of the
+// response of the RPC of ctx, "identity" for uncompressed.
+func messageEncodings(ctx context.Context) (request, response string) {
+	request, response = "identity", "identity"
+	stream, ok := grpc.ServerTransportStreamFromContext(ctx).(interface {
+		RecvCompress() string
+		SendCompress() string
+	})
+	if !ok {
+		return request, response
+	}
+	if e := stream.RecvCompress(); e != "" {
+		request = e
+	}
+	if e := stream.SendCompress(); e != "" {
+		response = e
+	}
+	return request, response
+}
+
+// apply sets the compressor of the responses of the RPC of ctx. A forced
+// compressor the client does not accept leaves gRPC's choice in place.
+func (p compressionPolicy) apply(ctx context.Context, method string) {
+	switch p.response {
+	case "auto":
+	case "off":
+		if err := grpc.SetSendCompressor(ctx, "identity"); err != nil {
+			slog.Warn("Cannot disable response compression", "method", method, "error", err)
+		}
+	default:
+		accepted, _ := grpc.ClientSupportedCompressors(ctx)
+		if !slices.Contains(accepted, p.response) {
+			slog.Debug("Client does not accept the forced response compressor", "method", method, "compressor", p.response, "accept_encoding", strings.Join(accepted, ","))
+			return
+		}
+		if err := grpc.SetSendCompressor(ctx, p.response); err != nil {
+			slog.Warn("Cannot force response compression", "method", method, "compressor", p.response, "error", err)
+		}
+	}
+}
+
+// logEncodings logs the encodings the RPC of ctx ended up using, after its
+// handler, which may have picked another compressor, returned.
+func (p compressionPolicy) logEncodings(ctx context.Context, method string) {
+	if !p.log {
+		return
+	}
+	request, response := messageEncodings(ctx)
+	accepted, _ := grpc.ClientSupportedCompressors(ctx)
+	slog.Info("Compression", "method", method, "request_encoding", request, "response_encoding", response, "accept_encoding", strings.Join(accepted, ","))
+}
+
+func (p compressionPolicy) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	p.apply(ctx, info.FullMethod)
+	resp, err := handler(ctx, req)
+	p.logEncodings(ctx, info.FullMethod)
+	return resp, err
+}
+
+func (p compressionPolicy) streamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	p.apply(ss.Context(), info.FullMethod)
+	err := handler(srv, ss)
+	p.logEncodings(ss.Context(), info.FullMethod)
+	return err
+}
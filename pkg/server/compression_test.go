@@ -0,0 +1,111 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	grpcgzip "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/dethi/envoy_hck/protos"
+)
+
+// encodings is a client stats.Handler recording the grpc-encoding of the
+// response headers.
+type encodings struct {
+	mu   sync.Mutex
+	seen []string
+}
+
+func (*encodings) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context   { return ctx }
+func (*encodings) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context { return ctx }
+func (*encodings) HandleConn(context.Context, stats.ConnStats)                       {}
+
+func (e *encodings) HandleRPC(_ context.Context, s stats.RPCStats) {
+	if h, ok := s.(*stats.InHeader); ok && h.Client {
+		e.mu.Lock()
+		e.seen = append(e.seen, h.Compression)
+		e.mu.Unlock()
+	}
+}
+
+func TestStreamTimeCompression(t *testing.T) {
+	srv := grpc.NewServer()
+	pb.RegisterTimeServiceServer(srv, newTestServer(t, serviceDefaults{}))
+	var enc encodings
+	client := pb.NewTimeServiceClient(bufconnClient(t, srv, grpc.WithStatsHandler(&enc)))
+
+	stream, err := client.StreamTime(context.Background(), &pb.TimeRequest{IntervalMs: 1, Compression: "gzip", MessageCount: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		resp, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.GetCurrentTime() == "" {
+			t.Error("empty time in a compressed response")
+		}
+	}
+	enc.mu.Lock()
+	if len(enc.seen) != 1 || enc.seen[0] != "gzip" {
+		t.Errorf("response encodings %q, want gzip", enc.seen)
+	}
+	enc.mu.Unlock()
+
+	stream, err = client.StreamTime(context.Background(), &pb.TimeRequest{Compression: "brotli"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("unregistered compressor: %v, want INVALID_ARGUMENT", err)
+	}
+}
+
+// gzipSize returns the size of data compressed by the registered gzip
+// compressor at level.
+func gzipSize(t *testing.T, level int, data []byte) int {
+	t.Helper()
+	if err := grpcgzip.SetLevel(level); err != nil {
+		t.Fatal(err)
+	}
+	// The compressor pools its writers, which keep the level they were
+	// created with; two collections empty the pool.
+	runtime.GC()
+	runtime.GC()
+	var buf bytes.Buffer
+	w, err := encoding.GetCompressor("gzip").Compress(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Len()
+}
+
+func TestGzipLevel(t *testing.T) {
+	t.Cleanup(func() { grpcgzip.SetLevel(gzip.DefaultCompression) })
+	data := bytes.Repeat([]byte("2024-03-01T12:00:00Z "), 1000)
+
+	none := gzipSize(t, gzip.NoCompression, data)
+	if best := gzipSize(t, gzip.BestCompression, data); best >= none {
+		t.Errorf("level 9 gave %d bytes, level 0 %d: the level is not applied", best, none)
+	}
+	for _, level := range []int{gzip.HuffmanOnly, gzip.BestCompression + 1} {
+		if err := (&Config{GzipLevel: level}).validate(); err == nil || !strings.Contains(err.Error(), "-gzip-level") {
+			t.Errorf("-gzip-level %d: validate = %v, want a -gzip-level error", level, err)
+		}
+	}
+}
@@ -0,0 +1,834 @@
+// config.go
+//
+// This file defines the settings of the server, the command-line flag of
+// each, and the validation run before it starts.
+
+// Package server implements a gRPC server with mTLS enabled, an upstream
+// for testing Envoy: it requires and verifies client certificates signed
+// by the trusted CA, and serves the time, health and diagnostics services
+// alongside an HTTP server controlling their behavior.
+//
+// Much of the server's state, such as the health status, the metrics and
+// the event stream, is process-wide: a process runs at most one Server at
+// a time.
+package server
+
+import (
+	"compress/gzip"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dethi/envoy_hck/pkg/tlsutil"
+)
+
+// Config holds the settings the server is started with.
+type Config struct {
+	GRPCAddr string // address of the mTLS gRPC listener
+	HTTPAddr string // address of the health toggle HTTP server
+
+	LogFormat string // "text" or "json"
+	LogLevel  string // "debug", "info", "warn" or "error"
+
+	// TLSSource is where the server certificate and client CA bundle come
+	// from: "file" (CertFile, KeyFile and CAFile), "spiffe" (the SPIFFE
+	// Workload API at SPIFFESocket, or $SPIFFE_ENDPOINT_SOCKET) or "sds"
+	// (the secrets SDSCertName and SDSCAName on the SDS server at SDSAddr).
+	// Startup waits up to TLSSourceTimeout for the first certificate.
+	TLSSource        string
+	TLSSourceTimeout time.Duration
+
+	// CertFile, KeyFile and CAFile are the server certificate and key and
+	// the CA bundle client certificates must chain to.
+	CertFile string
+	KeyFile  string
+	CAFile   string
+
+	SPIFFESocket string
+
+	SDSAddr     string
+	SDSCertName string
+	SDSCAName   string
+
+	// SelfSigned replaces the certificate files with an ephemeral CA,
+	// server and client certificate generated at startup.
+	SelfSigned bool
+
+	// CertLifetime, with SelfSigned, is how long the server certificate is
+	// valid after it is issued. CertRenewal is "renew" to issue a fresh
+	// one at half its lifetime, or "lapse" to keep serving it once
+	// expired. Zero keeps the day-long certificate of SelfSigned.
+	CertLifetime time.Duration
+	CertRenewal  string
+
+	// MaxVerifyDepth bounds the length of a verified client certificate
+	// chain, leaf and root included. Zero means unlimited.
+	MaxVerifyDepth int
+
+	// ClientCertPins, when non-empty, restricts clients to leaf
+	// certificates with one of these SHA-256 fingerprints.
+	ClientCertPins []string
+
+	// RequireH2ALPN rejects TLS connections that do not negotiate h2.
+	RequireH2ALPN bool
+
+	// MaxStreamsPerConn caps concurrent streams on a single connection.
+	// Zero means unlimited.
+	MaxStreamsPerConn int64
+
+	// ReplayFile, when set, names a file of RFC3339 timestamps that
+	// StreamTime emits instead of the current time.
+	ReplayFile string
+	ReplayLoop bool // restart the replay sequence instead of ending the stream
+
+	// LeaderLockFile enables active/passive mode: only the instance holding
+	// the lock on this file reports SERVING.
+	LeaderLockFile string
+
+	// DrainGoawayDelay is how long a graceful stop waits after sending
+	// GOAWAY before closing the remaining connections, aborting streams
+	// that never finished.
+	DrainGoawayDelay time.Duration
+
+	// PrestopDelay is how long the server keeps serving after reporting
+	// NOT_SERVING on SIGTERM, before it starts draining. It should cover
+	// Envoy's health-check interval times its unhealthy threshold.
+	PrestopDelay time.Duration
+
+	// AuditSize is the number of recent RPCs kept for the /audit endpoint.
+	// Zero disables the audit log.
+	AuditSize int
+
+	// MetricsIdentityLabel adds the client identity as a label on RPC
+	// metrics, keeping at most MetricsIdentityLimit distinct values.
+	MetricsIdentityLabel bool
+	MetricsIdentityLimit int
+
+	// FixedTime, when set, is reported instead of the current time. With
+	// FixedTimeAdvance, stream ticks step forward from it by the interval.
+	FixedTime        string
+	FixedTimeAdvance bool
+
+	// StackDumpFile receives the goroutine stacks dumped on SIGUSR1.
+	// Empty means stderr.
+	StackDumpFile string
+
+	// GzipLevel is the compression level of the gzip compressor, from
+	// gzip.DefaultCompression (-1) to gzip.BestCompression (9).
+	GzipLevel int
+
+	// Zstd registers the zstd compressor besides gzip.
+	Zstd bool
+
+	// ResponseCompression is "auto" to compress responses like their
+	// requests, "off" to never compress them, or the name of the
+	// compressor to compress them with whenever the client accepts it.
+	ResponseCompression string
+
+	// LogCompression logs the grpc-encoding of the request and of the
+	// response of every RPC except health checks and reflection.
+	LogCompression bool
+
+	// ResponseHeaders are key=value pairs set as response headers on every
+	// RPC.
+	ResponseHeaders []string
+
+	// LogUnknownMethods logs every call to a service or method the server
+	// does not implement before rejecting it.
+	LogUnknownMethods bool
+
+	// LogConnections logs every client connection as it opens, with the
+	// HTTP/2 settings advertised on it, and as it closes, with its
+	// lifetime, RPCs, bytes and last GOAWAY.
+	LogConnections bool
+
+	// LogHandshakes logs every TLS handshake of the gRPC listeners with
+	// what the client offered and what was negotiated. Failed handshakes
+	// are always logged.
+	LogHandshakes bool
+
+	// LogRPCs logs every completed RPC except health checks and
+	// reflection, with its method, peer, identity, status, duration,
+	// message counts and request ID.
+	LogRPCs bool
+
+	// LogPeers logs the peer address and client certificate identity of
+	// every RPC except health checks and reflection.
+	LogPeers bool
+
+	// LogDroppedTicks logs every StreamTime tick skipped because a slow
+	// client held up the previous send. Dropped ticks are always counted
+	// in dropped_ticks_total, labeled by identity up to
+	// MetricsIdentityLimit distinct values.
+	LogDroppedTicks bool
+
+	// SendBreakerThreshold is the share of failed stream sends, over
+	// SendBreakerWindow and at least SendBreakerMinSends sends, at which
+	// the server reports NOT_SERVING until failures subside. Zero disables
+	// the breaker.
+	SendBreakerThreshold float64
+	SendBreakerWindow    time.Duration
+	SendBreakerMinSends  int64
+
+	// SendTimeout aborts a stream with DeadlineExceeded when a single send
+	// blocks for longer than this, e.g. on a client that stopped reading.
+	// Zero means no limit.
+	SendTimeout time.Duration
+
+	// SendBuffer, when positive, queues up to this many messages per
+	// StreamTime stream for a sender goroutine, so a slow client does not
+	// hold up the ticks. SendBufferOverflow says what happens to a message
+	// that does not fit: drop-oldest, drop-newest or disconnect.
+	SendBuffer         int
+	SendBufferOverflow string
+
+	// MaxHeaderListSize bounds the size of the header list the server
+	// accepts on a request, in bytes. Requests over it are rejected.
+	MaxHeaderListSize uint
+
+	// MaxRecvMsgSize and MaxSendMsgSize bound the size of the messages the
+	// server receives and sends, in bytes. Zero means gRPC's default, 4MiB
+	// received and no limit sent.
+	MaxRecvMsgSize int
+	MaxSendMsgSize int
+
+	// InitialWindowSize and InitialConnWindowSize are the HTTP/2 flow
+	// control windows of each stream and of each connection, in bytes,
+	// at least 64KiB. Zero keeps gRPC's window, which grows with the
+	// bandwidth-delay product.
+	InitialWindowSize     int
+	InitialConnWindowSize int
+
+	// WriteBufferSize and ReadBufferSize are the sizes of the buffers of
+	// each connection, in bytes. Zero writes every frame at once and
+	// reads without buffering.
+	WriteBufferSize int
+	ReadBufferSize  int
+
+	// Ready, if non-nil, is closed once the gRPC listener is bound and
+	// Serve has been started on it, so connections dialed after it is
+	// closed are accepted. It is meant for programs and tests embedding
+	// the server and has no flag.
+	Ready chan<- struct{}
+
+	// SelfSignedBundle, with SelfSigned, is the material served instead of
+	// a freshly generated one, so an embedding test can trust its CA and
+	// dial with its client certificate. It has no flag.
+	SelfSignedBundle *tlsutil.SelfSignedBundle
+
+	// IdentityHeader, if set, is the response header that carries the
+	// verified client identity (SPIFFE ID or certificate CN).
+	IdentityHeader string
+
+	// ListenerCount is the number of SO_REUSEPORT listeners bound on
+	// GRPCAddr, each served by its own gRPC server, to spread accept and
+	// handshake load across cores.
+	ListenerCount int
+
+	// Instances, if more than one, replaces the listeners of GRPCAddr with
+	// those of as many instances on consecutive ports from BasePort, or
+	// from the port of GRPCAddr if zero, each with its own gRPC server,
+	// instance ID and health status.
+	Instances int
+	BasePort  int
+
+	// ExtraListeners are additional gRPC listeners, "addr=profile" with
+	// profile mtls, tls (one-way) or plaintext, serving the same services
+	// as GRPCAddr.
+	ExtraListeners []string
+
+	// CanaryKey, if set, is the request metadata key that tags canary
+	// traffic when its value is true. Canary streams start at
+	// CanaryInterval, and canary responses carry x-canary-served.
+	CanaryKey      string
+	CanaryInterval time.Duration
+
+	// SlowThreshold logs RPCs that take longer than this. StreamTime and
+	// ControlledTime are only logged when they exceed SlowStreamThreshold.
+	// Zero disables either.
+	SlowThreshold       time.Duration
+	SlowStreamThreshold time.Duration
+
+	// ExpectedServerName, if set, is the hostname clients use to reach the
+	// server; startup fails unless the server certificate is valid for it.
+	ExpectedServerName string
+
+	// EmitTLSHeaders sets x-tls-version and x-tls-cipher response headers
+	// from the TLS state of the connection, for auditing.
+	EmitTLSHeaders bool
+
+	// ClientAuth is "require" to reject clients without a valid
+	// certificate, or "request" to also accept clients that send none;
+	// their RPCs then carry an empty Identity. "none" asks for no
+	// certificate, and the -unverified variants accept certificates the CA
+	// did not sign.
+	ClientAuth string
+
+	// TLSMinVersion and TLSMaxVersion bound the TLS versions of the gRPC
+	// listeners, "1.0" to "1.3". TLSCipherSuites, if set, are the TLS 1.0-1.2
+	// cipher suites offered, by name. ALPNProtocols are the protocols
+	// offered besides h2, which gRPC always adds.
+	TLSMinVersion   string
+	TLSMaxVersion   string
+	TLSCipherSuites []string
+	ALPNProtocols   []string
+
+	// TLSSessionTickets lets clients resume TLS sessions with tickets.
+	// TLSTicketKeyRotation, if set, replaces the ticket key every interval
+	// instead of Go's daily rotation; tickets sealed with the previous key
+	// still resume.
+	TLSSessionTickets    bool
+	TLSTicketKeyRotation time.Duration
+
+	// TLSKeyLogFile, if set, receives the TLS session secrets of every
+	// connection in NSS key log format, for decrypting captures with
+	// Wireshark. It defaults to $SSLKEYLOGFILE. Test environments only.
+	TLSKeyLogFile string
+
+	// Metrics is the backend for RPC counts, latencies and active streams.
+	// When nil it is chosen by MetricsBackend, a comma-separated list of
+	// "prometheus", "otel" and "none".
+	Metrics        Metrics
+	MetricsBackend string
+
+	// CertReloadEndpoint enables POST /reload-certs on the HTTP server,
+	// which reloads the certificate, key and CA files without a restart.
+	CertReloadEndpoint bool
+
+	// Health and Reflection register the gRPC health and server
+	// reflection services. Without health, Envoy's gRPC health checks
+	// fail with UNIMPLEMENTED; the statuses are still kept for /health.
+	Health     bool
+	Reflection bool
+
+	// DummyServices are placeholder services registered besides the real
+	// ones, as full service names each followed by its methods after
+	// slashes, e.g. acme.orders.v1.Orders/Get/List. Their methods answer
+	// UNIMPLEMENTED, but reflection describes them and the health service
+	// reports them.
+	DummyServices []string
+
+	// HealthServices are extra service names reported by the health
+	// service, e.g. the service_name of an Envoy health check, besides the
+	// registered gRPC services.
+	HealthServices []string
+
+	// HealthFlapInterval makes the health of HealthFlapServices, or of
+	// the whole server if empty, alternate between SERVING and NOT_SERVING
+	// every interval, shifted by up to HealthFlapJitter either way. Zero
+	// leaves flapping to the /health-flap endpoint.
+	HealthFlapInterval time.Duration
+	HealthFlapJitter   time.Duration
+	HealthFlapServices []string
+
+	// HealthWatchDelay delays the first response of every health Watch
+	// stream, HealthWatchMaxUpdates ends Watch streams with UNAVAILABLE
+	// after that many responses (zero for never), and
+	// HealthUnknownServices selects how unknown service names are
+	// answered: "spec", "service-unknown" or "not-found". All three can
+	// be changed through /health-behavior.
+	HealthWatchDelay      time.Duration
+	HealthWatchMaxUpdates int
+	HealthUnknownServices string
+
+	// StartupProbeDelay keeps the /startupz probe failing for this long
+	// after the gRPC listeners start serving, to simulate a slow start.
+	StartupProbeDelay time.Duration
+
+	// ToggleEndpoint serves the legacy /toggle-health endpoint, which flips
+	// the overall health status; PUT /health replaces it.
+	ToggleEndpoint bool
+
+	// Tracing records a span per RPC, continuing the trace propagated by
+	// Envoy, and exports the spans over OTLP as configured by the
+	// OTEL_EXPORTER_OTLP_* environment variables.
+	Tracing bool
+	// TracingForceSample samples the RPCs carrying an x-force-sample: true
+	// metadata whatever the sampler decides, for debug requests.
+	TracingForceSample bool
+
+	// DebugEndpoints serves net/http/pprof, /debug/goroutines and
+	// /debug/gc on the HTTP server, for profiling soak tests.
+	DebugEndpoints bool
+
+	// FaultInjection enables the /faults endpoints on the HTTP server,
+	// which inject delays, errors, stream aborts and GOAWAY frames at
+	// runtime. It disables gRPC's write buffering.
+	FaultInjection bool
+
+	// Chaos enables the /chaos endpoint on the HTTP server, which makes
+	// the process exit, stop accepting connections, stop reading from them
+	// or pause stream sends. With ChaosInterval, one of ChaosActions runs
+	// at random every interval, its pauses lasting ChaosDuration and exit
+	// exiting with ChaosExitCode.
+	Chaos         bool
+	ChaosInterval time.Duration
+	ChaosActions  []string
+	ChaosDuration time.Duration
+	ChaosExitCode int
+
+	// ClockControl enables the /clock endpoint on the HTTP server, which
+	// freezes, offsets or changes the rate of the clock the time service
+	// reports.
+	ClockControl bool
+
+	// RESTGateway serves TimeService as JSON on the HTTP server under
+	// /v1/time, following the conventions of Envoy's gRPC-JSON transcoder.
+	RESTGateway bool
+	// RESTStatusMap are CODE=STATUS pairs overriding the HTTP code the
+	// gateway answers a gRPC code with, e.g. RESOURCE_EXHAUSTED=503.
+	RESTStatusMap []string
+
+	// AuthzPolicy is a YAML or JSON file of rules allowing client
+	// certificate SANs or SPIFFE IDs to call methods; other RPCs fail with
+	// PERMISSION_DENIED, except health checks and reflection. The file is
+	// reloaded on SIGHUP and, every AuthzWatchInterval, when it changed.
+	AuthzPolicy        string
+	AuthzWatchInterval time.Duration
+
+	// CertWatchInterval is how often the certificate, key and CA files are
+	// checked for changes, which are then reloaded. Zero disables watching.
+	CertWatchInterval time.Duration
+
+	// ListenBacklog is the accept queue length of the gRPC listeners, to
+	// absorb connection bursts; zero keeps the system default. TCPKeepAlive
+	// is the keepalive period of accepted connections; zero keeps Go's
+	// default and a negative value disables it.
+	ListenBacklog int
+	TCPKeepAlive  time.Duration
+
+	// AcceptDelay holds every accepted gRPC connection back before its
+	// handshake. MaxConns caps the connections open across the gRPC
+	// listeners, zero for no cap; MaxConnsAction is what happens to those
+	// beyond it: close, reset or queue. TCPResetOnClose closes connections
+	// with RST instead of FIN.
+	AcceptDelay     time.Duration
+	MaxConns        int
+	MaxConnsAction  string
+	TCPResetOnClose bool
+
+	// ToggleToken, if set, must be presented as a bearer token or token
+	// query parameter on the health control endpoints. ToggleRateLimit
+	// caps the health changes accepted per minute; zero is unlimited.
+	ToggleToken     string
+	ToggleRateLimit int
+
+	// HTTPTLS serves the HTTP server over TLS with the gRPC server's
+	// certificate. HTTPClientAuth is its client certificate policy, one of
+	// the -client-auth modes, verified against the same CA pool but set
+	// independently of -client-auth. HTTPToken, if set, must be presented
+	// as a bearer token or token query parameter on every HTTP request;
+	// the toggle token is accepted too.
+	HTTPTLS        bool
+	HTTPClientAuth string
+	HTTPToken      string
+
+	// HandshakeTimeout closes connections that do not complete the TLS
+	// handshake in time.
+	HandshakeTimeout time.Duration
+
+	// ProxyProtocol reads a PROXY protocol v1 or v2 header before the TLS
+	// handshake on the gRPC listeners: "off", "on" (required) or
+	// "optional".
+	ProxyProtocol string
+
+	// UnixSocketMode is the permission, in octal, of the socket files of
+	// unix:// listener addresses.
+	UnixSocketMode string
+
+	// IPFamily selects the IP families the gRPC listeners bind: "any",
+	// "4", "6", or "both" for separate IPv4 and IPv6 listeners.
+	IPFamily string
+
+	// KeepaliveMinTime is the shortest interval between client pings the
+	// server tolerates; clients pinging more often are sent GOAWAY
+	// (ENHANCE_YOUR_CALM, "too_many_pings"). KeepalivePermitWithoutStream
+	// also allows pings on connections with no active stream.
+	KeepaliveMinTime             time.Duration
+	KeepalivePermitWithoutStream bool
+
+	// KeepaliveTime is how long a connection may go without activity
+	// before the server pings the client, and KeepaliveTimeout how long
+	// it waits for the ack before closing the connection.
+	KeepaliveTime    time.Duration
+	KeepaliveTimeout time.Duration
+
+	// MaxConnectionIdle closes connections that had no active stream for
+	// that long, and MaxConnectionAge those older than that, both with a
+	// graceful GOAWAY; zero disables them. MaxConnectionAgeGrace is how
+	// long streams may still run on a connection closed for its age.
+	MaxConnectionIdle     time.Duration
+	MaxConnectionAge      time.Duration
+	MaxConnectionAgeGrace time.Duration
+
+	// RedactMetadata lists metadata keys, in addition to authorization,
+	// cookie and proxy-authorization, whose values DumpMetadata hides.
+	RedactMetadata []string
+
+	// DefaultInterval, DefaultFormat and DefaultTimezone are the stream
+	// tick interval, time format and local time zone used when a request
+	// does not set its own.
+	DefaultInterval time.Duration
+	DefaultFormat   string
+	DefaultTimezone string
+
+	// MetricsSnapshotFile, if set, receives the counter values on shutdown,
+	// and the values it holds at startup are added to the fresh counters,
+	// so counters continue across quick restarts.
+	MetricsSnapshotFile string
+
+	// StateFile, if set, receives the runtime state set through the admin
+	// API (health, faults, chaos) after every change, and the state it
+	// holds at startup is restored.
+	StateFile string
+
+	// RequiredCertExtension, if set, is the dotted OID of an extension
+	// every client leaf certificate must carry. Its value is exposed as
+	// Identity.Extension to handlers, the audit log and the StreamTime
+	// summary.
+	RequiredCertExtension string
+
+	// CRLFile is a PEM or DER file of CRLs; client certificates they revoke,
+	// or whose intermediates they revoke, are rejected. It is reloaded like
+	// the TLS files. OCSPCheck queries the OCSP responder of client
+	// certificates: off, soft (accept when the status is unavailable) or
+	// hard (reject then). OCSPStapleFile is a DER OCSP response stapled to
+	// the server certificate, also reloaded.
+	CRLFile        string
+	OCSPCheck      string
+	OCSPStapleFile string
+
+	// LandingPage serves a plain text page at / listing the gRPC services,
+	// the HTTP endpoints and the build version.
+	LandingPage bool
+
+	// MaxGoroutines refuses new StreamTime streams and unary RPCs while
+	// more goroutines than this are running, and MaxInFlight every new RPC
+	// while that many are being handled. Zero disables either. Refused
+	// RPCs fail with OverloadCode, RESOURCE_EXHAUSTED or UNAVAILABLE, and
+	// a retry-after trailer of OverloadRetryAfter, if positive.
+	MaxGoroutines      int
+	MaxInFlight        int64
+	OverloadCode       string
+	OverloadRetryAfter time.Duration
+
+	// RateLimit caps the RPCs per second the whole server accepts, or
+	// each client identity with RateLimitScope "identity", with bursts of
+	// up to RateLimitBurst; excess RPCs fail with RESOURCE_EXHAUSTED and
+	// a retry-after trailer. Health checks and reflection are exempt. Zero
+	// disables the limit.
+	RateLimit      float64
+	RateLimitBurst int
+	RateLimitScope string
+
+	// BootReportFile, if set, receives the boot report logged at startup
+	// as JSON.
+	BootReportFile string
+}
+
+// features lists the optional capabilities enabled by cfg, as reported by
+// the ServerInfo service.
+func (cfg Config) features() []string {
+	features := []string{"mtls"}
+	if cfg.Health {
+		features = append(features, "health")
+	}
+	if cfg.Reflection {
+		features = append(features, "reflection")
+	}
+	return append(features, "server-info", "diagnostics")
+}
+
+// validate reports every invalid or conflicting combination of settings in
+// cfg at once, so a bad deployment fails at startup with the full list
+// instead of one problem at a time or obscurely later.
+func (cfg Config) validate() error {
+	var errs []error
+	check := func(bad bool, format string, args ...any) {
+		if bad {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	check(cfg.FixedTime != "" && cfg.ReplayFile != "", "-fixed-time and -replay-file are mutually exclusive")
+	check(cfg.FixedTimeAdvance && cfg.FixedTime == "", "-fixed-time-advance requires -fixed-time")
+	check(cfg.ReplayLoop && cfg.ReplayFile == "", "-replay-loop requires -replay-file")
+	check(cfg.SelfSigned && len(cfg.ClientCertPins) > 0, "-client-cert-pins cannot match the client certificate generated by -self-signed")
+	check(cfg.KeepaliveMinTime < 0, "-keepalive-min-time must not be negative, got %s", cfg.KeepaliveMinTime)
+	check(cfg.KeepaliveTime < 0, "-keepalive-time must not be negative, got %s", cfg.KeepaliveTime)
+	check(cfg.KeepaliveTimeout < 0, "-keepalive-timeout must not be negative, got %s", cfg.KeepaliveTimeout)
+	check(cfg.MaxConnectionIdle < 0, "-max-connection-idle must not be negative, got %s", cfg.MaxConnectionIdle)
+	check(cfg.MaxConnectionAge < 0, "-max-connection-age must not be negative, got %s", cfg.MaxConnectionAge)
+	check(cfg.MaxConnectionAgeGrace < 0, "-max-connection-age-grace must not be negative, got %s", cfg.MaxConnectionAgeGrace)
+	check(cfg.DefaultInterval <= 0, "-default-interval must be positive, got %s", cfg.DefaultInterval)
+	_, knownFormat := timeFormats[cfg.DefaultFormat]
+	check(!knownFormat, "unknown -default-format %q, want rfc3339, rfc3339nano, rfc1123 or datetime", cfg.DefaultFormat)
+	check(cfg.RateLimit < 0, "-rate-limit must not be negative, got %g", cfg.RateLimit)
+	check(cfg.RateLimit > 0 && cfg.RateLimitBurst < 1, "-rate-limit-burst must be at least 1 with -rate-limit, got %d", cfg.RateLimitBurst)
+	check(!slices.Contains(rateLimitScopes, cfg.RateLimitScope), "-rate-limit-scope must be one of %s, got %q", strings.Join(rateLimitScopes, ", "), cfg.RateLimitScope)
+	check(cfg.SendBuffer < 0, "-send-buffer must not be negative, got %d", cfg.SendBuffer)
+	check(!slices.Contains(sendOverflowPolicies, cfg.SendBufferOverflow), "-send-buffer-overflow must be one of %s, got %q", strings.Join(sendOverflowPolicies, ", "), cfg.SendBufferOverflow)
+	check(cfg.MaxGoroutines < 0, "-max-goroutines must not be negative, got %d", cfg.MaxGoroutines)
+	check(cfg.MaxInFlight < 0, "-max-in-flight must not be negative, got %d", cfg.MaxInFlight)
+	_, knownOverloadCode := overloadCodes[cfg.OverloadCode]
+	check(!knownOverloadCode, "-overload-code must be RESOURCE_EXHAUSTED or UNAVAILABLE, got %q", cfg.OverloadCode)
+	check(cfg.OverloadRetryAfter < 0, "-overload-retry-after must not be negative, got %s", cfg.OverloadRetryAfter)
+	check(cfg.StartupProbeDelay < 0, "-startup-probe-delay must not be negative, got %s", cfg.StartupProbeDelay)
+	check(cfg.ChaosInterval < 0, "-chaos-interval must not be negative, got %s", cfg.ChaosInterval)
+	check(cfg.ChaosInterval > 0 && !cfg.Chaos, "-chaos-interval requires -chaos")
+	for _, action := range cfg.ChaosActions {
+		check(!slices.Contains(chaosActions, action), "unknown -chaos-actions action %q, want one of %s", action, strings.Join(chaosActions, ", "))
+	}
+	check(cfg.ChaosDuration <= 0, "-chaos-duration must be positive, got %s", cfg.ChaosDuration)
+	check(cfg.ChaosExitCode < 0 || cfg.ChaosExitCode > 255, "-chaos-exit-code must be between 0 and 255, got %d", cfg.ChaosExitCode)
+	check(!slices.Contains(responseCompressionModes, cfg.ResponseCompression), "unknown -response-compression %q, want one of %s", cfg.ResponseCompression, strings.Join(responseCompressionModes, ", "))
+	check(cfg.ResponseCompression == "zstd" && !cfg.Zstd, "-response-compression zstd requires -zstd")
+	check(cfg.HandshakeTimeout <= 0, "-handshake-timeout must be positive, got %s", cfg.HandshakeTimeout)
+	check(cfg.AuthzWatchInterval < 0, "-authz-watch-interval must not be negative, got %s", cfg.AuthzWatchInterval)
+	check(cfg.ToggleRateLimit < 0, "-toggle-rate-limit must not be negative, got %d", cfg.ToggleRateLimit)
+	_, knownClientAuth := tlsutil.ClientAuthModes[cfg.ClientAuth]
+	check(!knownClientAuth, "unknown -client-auth %q, want one of %s", cfg.ClientAuth, strings.Join(tlsutil.ClientAuthModeNames(), ", "))
+	check(cfg.ClientAuth == "none" && len(cfg.ClientCertPins) > 0, "-client-cert-pins requires a client certificate, not -client-auth=none")
+	minVersion, minErr := tlsutil.ParseVersion(cfg.TLSMinVersion)
+	check(minErr != nil, "invalid -tls-min-version: %v", minErr)
+	maxVersion, maxErr := tlsutil.ParseVersion(cfg.TLSMaxVersion)
+	check(maxErr != nil, "invalid -tls-max-version: %v", maxErr)
+	check(minErr == nil && maxErr == nil && minVersion > maxVersion, "-tls-min-version %s is above -tls-max-version %s", cfg.TLSMinVersion, cfg.TLSMaxVersion)
+	_, suitesErr := tlsutil.ParseCipherSuites(cfg.TLSCipherSuites)
+	check(suitesErr != nil, "invalid -tls-cipher-suites: %v", suitesErr)
+	check(cfg.TLSTicketKeyRotation < 0, "-tls-ticket-key-rotation must not be negative, got %s", cfg.TLSTicketKeyRotation)
+	check(cfg.TLSTicketKeyRotation > 0 && !cfg.TLSSessionTickets, "-tls-ticket-key-rotation requires -tls-session-tickets")
+	_, httpClientAuthErr := tlsutil.ParseClientAuth(cfg.HTTPClientAuth)
+	check(httpClientAuthErr != nil, "invalid -http-client-auth: %v", httpClientAuthErr)
+	check(httpClientAuthErr == nil && cfg.HTTPClientAuth != "none" && !cfg.HTTPTLS, "-http-client-auth=%s requires -http-tls", cfg.HTTPClientAuth)
+	_, knownFamily := familyNetworks[cfg.IPFamily]
+	check(!knownFamily, "-ip-family must be any, 4, 6 or both, got %q", cfg.IPFamily)
+	check(cfg.ListenBacklog < 0, "-listen-backlog must not be negative, got %d", cfg.ListenBacklog)
+	check(cfg.AcceptDelay < 0, "-accept-delay must not be negative, got %s", cfg.AcceptDelay)
+	check(cfg.MaxConns < 0, "-max-conns must not be negative, got %d", cfg.MaxConns)
+	check(!slices.Contains(maxConnsActions, cfg.MaxConnsAction), "-max-conns-action must be one of %s, got %q", strings.Join(maxConnsActions, ", "), cfg.MaxConnsAction)
+	check(cfg.ListenerCount < 1, "-listener-count must be at least 1, got %d", cfg.ListenerCount)
+	_, unixGRPC := unixSocketPath(cfg.GRPCAddr)
+	check(unixGRPC && cfg.ListenerCount > 1, "-listener-count requires a TCP -grpc-addr, got %s", cfg.GRPCAddr)
+	_, modeErr := parseFileMode(cfg.UnixSocketMode)
+	check(modeErr != nil, "invalid -unix-socket-mode: %v", modeErr)
+	extras, extrasErr := parseExtraListeners(cfg.ExtraListeners)
+	check(extrasErr != nil, "invalid -extra-listeners: %v", extrasErr)
+	check(cfg.Instances < 1, "-instances must be at least 1, got %d", cfg.Instances)
+	var instanceAddresses []string
+	if cfg.Instances > 1 {
+		var err error
+		instanceAddresses, err = instanceAddrs(cfg.GRPCAddr, cfg.Instances, cfg.BasePort)
+		check(err != nil, "invalid -instances: %v", err)
+	}
+	for _, e := range extras {
+		check(e.addr == cfg.GRPCAddr || e.addr == cfg.HTTPAddr, "-extra-listeners address %s is already in use by -grpc-addr or -http-addr", e.addr)
+		check(slices.Contains(instanceAddresses, e.addr), "-extra-listeners address %s is already in use by -instances", e.addr)
+	}
+	check(cfg.MaxStreamsPerConn < 0, "-max-streams-per-conn must not be negative, got %d", cfg.MaxStreamsPerConn)
+	check(cfg.MaxHeaderListSize > math.MaxUint32, "-max-header-list-size must fit in 32 bits, got %d", cfg.MaxHeaderListSize)
+	check(cfg.MaxRecvMsgSize < 0, "-max-recv-msg-size must not be negative, got %d", cfg.MaxRecvMsgSize)
+	check(cfg.MaxSendMsgSize < 0, "-max-send-msg-size must not be negative, got %d", cfg.MaxSendMsgSize)
+	for name, size := range map[string]int{"-initial-window-size": cfg.InitialWindowSize, "-initial-conn-window-size": cfg.InitialConnWindowSize} {
+		check(size != 0 && (size < 64<<10 || size > math.MaxInt32), "%s must be 0 or between 65536 and %d, got %d", name, math.MaxInt32, size)
+	}
+	check(cfg.WriteBufferSize < 0, "-write-buffer-size must not be negative, got %d", cfg.WriteBufferSize)
+	check(cfg.ReadBufferSize < 0, "-read-buffer-size must not be negative, got %d", cfg.ReadBufferSize)
+	check(cfg.GzipLevel < gzip.DefaultCompression || cfg.GzipLevel > gzip.BestCompression, "-gzip-level must be between -1 and 9, got %d", cfg.GzipLevel)
+	check(cfg.AuditSize < 0, "-audit-size must not be negative, got %d", cfg.AuditSize)
+	check(cfg.MetricsIdentityLabel && cfg.MetricsIdentityLimit < 1, "-metrics-identity-limit must be at least 1 with -metrics-identity-label, got %d", cfg.MetricsIdentityLimit)
+	check(cfg.SendBreakerThreshold < 0 || cfg.SendBreakerThreshold > 1, "-send-breaker-threshold must be between 0 and 1, got %g", cfg.SendBreakerThreshold)
+	check(cfg.SendBreakerThreshold > 0 && cfg.SendBreakerWindow < time.Second, "-send-breaker-window must be at least 1s, got %s", cfg.SendBreakerWindow)
+	check(cfg.CanaryKey != "" && cfg.CanaryInterval <= 0, "-canary-interval must be positive with -canary-key, got %s", cfg.CanaryInterval)
+	check(cfg.CanaryKey != "" && strings.EqualFold(cfg.CanaryKey, cfg.IdentityHeader), "-canary-key and -identity-header must differ")
+	check(!slices.Contains([]string{"file", "spiffe", "sds"}, cfg.TLSSource), "unknown -tls-source %q, want file, spiffe or sds", cfg.TLSSource)
+	check(cfg.SelfSigned && cfg.TLSSource != "file", "-self-signed replaces -tls-source %s", cfg.TLSSource)
+	check(cfg.TLSSource == "sds" && cfg.SDSAddr == "", "-tls-source sds requires -sds-addr")
+	check(cfg.TLSSource != "file" && cfg.CertReloadEndpoint, "-cert-reload-endpoint only reloads -tls-source file")
+	check(!slices.Contains([]string{"off", "on", "optional"}, cfg.ProxyProtocol), "-proxy-protocol must be off, on or optional, got %q", cfg.ProxyProtocol)
+	check(!slices.Contains([]string{"off", "soft", "hard"}, cfg.OCSPCheck), "-ocsp-check must be off, soft or hard, got %q", cfg.OCSPCheck)
+	check(cfg.CertWatchInterval < 0, "-cert-watch-interval must not be negative, got %s", cfg.CertWatchInterval)
+	check(cfg.CertLifetime < 0, "-cert-lifetime must not be negative, got %s", cfg.CertLifetime)
+	check(cfg.CertLifetime > 0 && cfg.CertLifetime < time.Second, "-cert-lifetime must be at least 1s, got %s", cfg.CertLifetime)
+	check(cfg.CertLifetime > 0 && !cfg.SelfSigned, "-cert-lifetime requires -self-signed")
+	check(!slices.Contains(certRenewals, cfg.CertRenewal), "-cert-renewal must be one of %s, got %q", strings.Join(certRenewals, ", "), cfg.CertRenewal)
+	check(cfg.SelfSigned && cfg.CertReloadEndpoint, "-cert-reload-endpoint has no files to reload with -self-signed")
+	check(cfg.HealthFlapInterval < 0, "-health-flap-interval must not be negative, got %s", cfg.HealthFlapInterval)
+	check(cfg.HealthFlapJitter < 0 || cfg.HealthFlapInterval > 0 && cfg.HealthFlapJitter >= cfg.HealthFlapInterval, "-health-flap-jitter must be between 0 and -health-flap-interval, got %s", cfg.HealthFlapJitter)
+	for _, s := range cfg.DummyServices {
+		_, err := parseDummyService(s)
+		check(err != nil, "-dummy-services: %v", err)
+	}
+	check(len(cfg.HealthFlapServices) > 0 && cfg.HealthFlapInterval == 0, "-health-flap-services requires -health-flap-interval")
+	check(cfg.HealthWatchDelay < 0, "-health-watch-delay must not be negative, got %s", cfg.HealthWatchDelay)
+	check(cfg.HealthWatchMaxUpdates < 0, "-health-watch-max-updates must not be negative, got %d", cfg.HealthWatchMaxUpdates)
+	check(!slices.Contains(unknownServiceModes, cfg.HealthUnknownServices), "-health-unknown-services must be one of %s, got %q", strings.Join(unknownServiceModes, ", "), cfg.HealthUnknownServices)
+	check(cfg.TracingForceSample && !cfg.Tracing, "-tracing-force-sample requires -tracing")
+	_, statusMapErr := parseStatusMap(cfg.RESTStatusMap)
+	check(statusMapErr != nil, "invalid -rest-status-map: %v", statusMapErr)
+	check(len(cfg.RESTStatusMap) > 0 && !cfg.RESTGateway, "-rest-status-map requires -rest-gateway")
+	check(cfg.GRPCAddr == cfg.HTTPAddr && !strings.HasSuffix(cfg.GRPCAddr, ":0"), "the gRPC and HTTP servers cannot share the address %s", cfg.GRPCAddr)
+	return errors.Join(errs...)
+}
+
+// RegisterFlags defines a flag on fs for every setting of cfg, with its
+// default, and sets cfg to the defaults.
+func (cfg *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&cfg.GRPCAddr, "grpc-addr", ":50051", "address of the mTLS gRPC listener, host:port or unix:///path")
+	fs.StringVar(&cfg.HTTPAddr, "http-addr", ":8081", "address of the HTTP server for health control and metrics, host:port or unix:///path")
+	fs.StringVar(&cfg.CertFile, "tls-cert", "certs/server.crt", "server certificate file")
+	fs.StringVar(&cfg.KeyFile, "tls-key", "certs/server.key", "server private key file")
+	fs.StringVar(&cfg.CAFile, "tls-ca", "certs/ca.crt", "CA bundle that client certificates must chain to")
+	fs.StringVar(&cfg.TLSSource, "tls-source", "file", "source of the server certificate and client CA bundle: file, spiffe or sds")
+	fs.DurationVar(&cfg.TLSSourceTimeout, "tls-source-timeout", 30*time.Second, "how long startup waits for the first certificate from -tls-source spiffe or sds")
+	fs.StringVar(&cfg.SPIFFESocket, "spiffe-socket", "", "address of the SPIFFE Workload API, e.g. unix:///run/spire/agent.sock (defaults to $SPIFFE_ENDPOINT_SOCKET)")
+	fs.StringVar(&cfg.SDSAddr, "sds-addr", "", "gRPC target of the SDS server, e.g. unix:///run/sds.sock")
+	fs.StringVar(&cfg.SDSCertName, "sds-cert-name", "server_cert", "name of the SDS secret holding the server certificate")
+	fs.StringVar(&cfg.SDSCAName, "sds-ca-name", "validation_context", "name of the SDS secret holding the client CA bundle")
+	fs.StringVar(&cfg.LogLevel, "log-level", "info", "minimum log level: debug, info, warn or error")
+	fs.BoolVar(&cfg.SelfSigned, "self-signed", false, "generate an in-memory CA, server and client certificate instead of reading the -tls-* files, and print the CA and client credentials")
+	fs.DurationVar(&cfg.CertLifetime, "cert-lifetime", 0, "with -self-signed, how long the server certificate is valid after it is issued, e.g. 30s (0 = a day)")
+	fs.StringVar(&cfg.CertRenewal, "cert-renewal", "renew", "with -cert-lifetime: renew (issue a fresh certificate at half its lifetime) or lapse (keep serving it once expired)")
+	fs.IntVar(&cfg.MaxVerifyDepth, "max-verify-depth", 0, "maximum client certificate chain length including leaf and root (0 = unlimited)")
+	fs.Var((*ListFlag)(&cfg.ClientCertPins), "client-cert-pins", "comma-separated SHA-256 fingerprints of the only client certificates accepted, in addition to CA verification")
+	fs.BoolVar(&cfg.RequireH2ALPN, "require-h2-alpn", false, "reject TLS connections whose negotiated ALPN protocol is not h2")
+	fs.StringVar(&cfg.LogFormat, "log-format", defaultLogFormat(), "log output format: text or json (defaults to text on a terminal, json otherwise)")
+	fs.Int64Var(&cfg.MaxStreamsPerConn, "max-streams-per-conn", 0, "maximum concurrent streams on a single client connection (0 = unlimited)")
+	fs.StringVar(&cfg.ReplayFile, "replay-file", "", "file of RFC3339 timestamps, one per line, that StreamTime emits instead of the current time")
+	fs.BoolVar(&cfg.ReplayLoop, "replay-loop", false, "loop the -replay-file sequence instead of ending the stream after the last entry")
+	fs.StringVar(&cfg.LeaderLockFile, "leader-lock-file", "", "enable leader/standby mode; only the instance holding a lock on this file reports SERVING")
+	fs.DurationVar(&cfg.DrainGoawayDelay, "drain-goaway-delay", 30*time.Second, "time to wait after sending GOAWAY on shutdown before closing remaining connections, aborting the streams still running (alias -graceful-timeout)")
+	fs.Var(fs.Lookup("drain-goaway-delay").Value, "graceful-timeout", "alias for -drain-goaway-delay")
+	fs.IntVar(&cfg.AuditSize, "audit-size", 1000, "number of recent RPCs kept in memory and served on /audit (0 = disabled)")
+	fs.BoolVar(&cfg.MetricsIdentityLabel, "metrics-identity-label", false, "label RPC metrics with the client certificate identity")
+	fs.IntVar(&cfg.MetricsIdentityLimit, "metrics-identity-limit", 100, "distinct identities labeled before further ones are reported as \"other\"")
+	fs.StringVar(&cfg.FixedTime, "fixed-time", "", "RFC3339 instant reported by GetTime and StreamTime instead of the current time")
+	fs.BoolVar(&cfg.FixedTimeAdvance, "fixed-time-advance", false, "advance -fixed-time by the tick interval on every StreamTime tick")
+	fs.StringVar(&cfg.StackDumpFile, "stack-dump-file", "", "file to append goroutine stacks to on SIGUSR1 (default stderr)")
+	fs.BoolVar(&cfg.Zstd, "zstd", false, "register the zstd compressor besides gzip")
+	fs.StringVar(&cfg.ResponseCompression, "response-compression", "auto", "compression of responses: auto (like the request), off, gzip or zstd (whenever the client accepts it)")
+	fs.BoolVar(&cfg.LogCompression, "log-compression", false, "log the grpc-encoding of the request and response of every RPC (health checks and reflection excepted)")
+	fs.IntVar(&cfg.GzipLevel, "gzip-level", gzip.DefaultCompression, "gzip compression level for compressed responses: 0 (none) to 9 (best), -1 uses the library default (6)")
+	fs.Var((*ListFlag)(&cfg.ResponseHeaders), "response-header", "comma-separated key=value pairs set as response headers on every RPC (repeatable)")
+	fs.BoolVar(&cfg.LogUnknownMethods, "log-unknown-methods", false, "log calls to unknown services or methods (method and peer) before returning Unimplemented")
+	fs.DurationVar(&cfg.PrestopDelay, "prestop-delay", 0, "time to keep serving after reporting NOT_SERVING on shutdown, before draining (e.g. Envoy health-check interval x unhealthy threshold)")
+	fs.BoolVar(&cfg.LogHandshakes, "log-handshakes", false, "log every TLS handshake with the offered SNI, ALPN and versions and the negotiated parameters (failures are always logged)")
+	fs.BoolVar(&cfg.LogRPCs, "log-rpcs", false, "log every completed RPC with method, peer, identity, status, duration, message counts and x-request-id (health checks and reflection excepted)")
+	fs.BoolVar(&cfg.LogPeers, "log-peers", false, "log the peer address and client certificate identity of every RPC (health checks and reflection excepted)")
+	fs.BoolVar(&cfg.LogConnections, "log-connections", false, "verbose connection logging: log each connection open with the HTTP/2 settings advertised on it, and close with its lifetime, RPCs, bytes and last GOAWAY")
+	fs.Float64Var(&cfg.SendBreakerThreshold, "send-breaker-threshold", 0, "fraction of failed stream sends that makes the server report NOT_SERVING until they subside (0 = disabled)")
+	fs.DurationVar(&cfg.SendBreakerWindow, "send-breaker-window", 10*time.Second, "rolling window over which the send error rate is computed")
+	fs.Int64Var(&cfg.SendBreakerMinSends, "send-breaker-min-sends", 20, "minimum sends in the window before the send breaker can open")
+	fs.DurationVar(&cfg.SendTimeout, "send-timeout", 0, "abort a stream when a single send blocks for longer than this (0 = no limit)")
+	fs.IntVar(&cfg.SendBuffer, "send-buffer", 0, "messages each StreamTime stream queues for a slow client (0 = send from the tick loop)")
+	fs.StringVar(&cfg.SendBufferOverflow, "send-buffer-overflow", "drop-oldest", "what to do with a message that does not fit in -send-buffer: "+strings.Join(sendOverflowPolicies, ", "))
+	fs.IntVar(&cfg.MaxRecvMsgSize, "max-recv-msg-size", 0, "maximum size in bytes of a received message; larger ones fail with RESOURCE_EXHAUSTED (0 = gRPC's 4MiB)")
+	fs.IntVar(&cfg.MaxSendMsgSize, "max-send-msg-size", 0, "maximum size in bytes of a sent message; larger ones fail with RESOURCE_EXHAUSTED (0 = unlimited)")
+	fs.IntVar(&cfg.InitialWindowSize, "initial-window-size", 0, "HTTP/2 flow control window of each stream in bytes, at least 65536 (0 = dynamic, from the bandwidth-delay product)")
+	fs.IntVar(&cfg.InitialConnWindowSize, "initial-conn-window-size", 0, "HTTP/2 flow control window of each connection in bytes, at least 65536 (0 = dynamic, from the bandwidth-delay product)")
+	fs.IntVar(&cfg.WriteBufferSize, "write-buffer-size", 32<<10, "size in bytes of the write buffer of each connection (0 = write every frame at once)")
+	fs.IntVar(&cfg.ReadBufferSize, "read-buffer-size", 32<<10, "size in bytes of the read buffer of each connection (0 = unbuffered)")
+	fs.UintVar(&cfg.MaxHeaderListSize, "max-header-list-size", 64<<10, "maximum size in bytes of request headers; larger requests are rejected (default is above Envoy's 60KiB max_request_headers_kb)")
+	fs.StringVar(&cfg.IdentityHeader, "identity-header", "", "response header to set to the verified client identity, e.g. x-verified-client (empty = disabled)")
+	fs.Var((*ListFlag)(&cfg.ExtraListeners), "extra-listeners", "comma-separated additional gRPC listeners as addr=profile, profile mtls, tls (one-way) or plaintext, e.g. :50052=tls,:50053=plaintext")
+	fs.IntVar(&cfg.ListenerCount, "listener-count", 1, "number of SO_REUSEPORT listeners on the gRPC address, each with its own accept loop")
+	fs.IntVar(&cfg.Instances, "instances", 1, "serve this many instances, each with its own gRPC listener on consecutive ports from -base-port, instance ID and health status")
+	fs.IntVar(&cfg.BasePort, "base-port", 0, "port of the first of -instances, on the host of -grpc-addr (0 = the port of -grpc-addr)")
+	fs.StringVar(&cfg.CanaryKey, "canary-key", "", "request metadata key that tags canary traffic when true, e.g. x-canary (empty = disabled)")
+	fs.DurationVar(&cfg.CanaryInterval, "canary-interval", 500*time.Millisecond, "initial tick interval of canary streams")
+	fs.DurationVar(&cfg.SlowThreshold, "slow-threshold", 0, "log RPCs taking longer than this, with method, duration and peer (0 = disabled)")
+	fs.DurationVar(&cfg.SlowStreamThreshold, "slow-stream-threshold", 0, "log StreamTime and ControlledTime streams lasting longer than this (0 = never)")
+	fs.StringVar(&cfg.ExpectedServerName, "expected-server-name", "", "fail at startup unless the server certificate's SANs cover this hostname, as checked by Envoy")
+	fs.BoolVar(&cfg.EmitTLSHeaders, "emit-tls-headers", false, "set x-tls-version and x-tls-cipher response headers from the negotiated TLS parameters")
+	fs.StringVar(&cfg.ClientAuth, "client-auth", "require", "client certificate policy: require (alias require-and-verify), request to also accept clients without a certificate, none, or request-unverified/require-unverified to skip CA verification")
+	fs.StringVar(&cfg.TLSMinVersion, "tls-min-version", "1.2", "minimum TLS version of the gRPC listeners: 1.0, 1.1, 1.2 or 1.3")
+	fs.StringVar(&cfg.TLSMaxVersion, "tls-max-version", "1.3", "maximum TLS version of the gRPC listeners: 1.0, 1.1, 1.2 or 1.3")
+	fs.BoolVar(&cfg.TLSSessionTickets, "tls-session-tickets", true, "let clients resume TLS sessions of the gRPC listeners with session tickets")
+	fs.DurationVar(&cfg.TLSTicketKeyRotation, "tls-ticket-key-rotation", 0, "replace the TLS session ticket key this often; tickets of the previous key still resume (0 = Go's daily rotation)")
+	fs.Var((*ListFlag)(&cfg.TLSCipherSuites), "tls-cipher-suites", "comma-separated TLS 1.0-1.2 cipher suites offered, by Go name, e.g. TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 (default: Go's)")
+	fs.Var((*ListFlag)(&cfg.ALPNProtocols), "alpn", "comma-separated ALPN protocols offered besides h2, in order of preference")
+	fs.StringVar(&cfg.TLSKeyLogFile, "tls-keylog-file", os.Getenv("SSLKEYLOGFILE"), "append TLS session secrets to this file in NSS key log format, for Wireshark; exposes all traffic, test environments only (default $SSLKEYLOGFILE)")
+	fs.StringVar(&cfg.MetricsBackend, "metrics-backend", "prometheus", "comma-separated backends for RPC metrics: prometheus (served on /metrics), otel (OTLP export configured by OTEL_EXPORTER_OTLP_* variables) or none")
+	fs.BoolVar(&cfg.CertReloadEndpoint, "cert-reload-endpoint", false, "serve POST /reload-certs to reload the TLS certificate, key and CA files")
+	fs.BoolVar(&cfg.Health, "health", true, "register the grpc.health.v1.Health service")
+	fs.BoolVar(&cfg.Reflection, "reflection", true, "register the gRPC server reflection service")
+	fs.Var((*ListFlag)(&cfg.DummyServices), "dummy-services", "comma-separated placeholder services whose methods answer UNIMPLEMENTED but appear in reflection and health, as service/method/..., e.g. acme.orders.v1.Orders/Get/List (repeatable)")
+	fs.Var((*ListFlag)(&cfg.HealthServices), "health-service", "comma-separated extra service names reported by the health service, besides the gRPC services (repeatable)")
+	fs.DurationVar(&cfg.HealthFlapInterval, "health-flap-interval", 0, "alternate the health status between SERVING and NOT_SERVING this often (0 = off)")
+	fs.DurationVar(&cfg.HealthFlapJitter, "health-flap-jitter", 0, "shift every -health-flap-interval period by a random amount of up to this either way")
+	fs.Var((*ListFlag)(&cfg.HealthFlapServices), "health-flap-services", "comma-separated services that flap with -health-flap-interval (default: the whole server)")
+	fs.DurationVar(&cfg.HealthWatchDelay, "health-watch-delay", 0, "delay the first response of every health Watch stream by this much")
+	fs.IntVar(&cfg.HealthWatchMaxUpdates, "health-watch-max-updates", 0, "end health Watch streams with UNAVAILABLE after this many responses (0 = never)")
+	fs.StringVar(&cfg.HealthUnknownServices, "health-unknown-services", "spec", "answer for unknown health service names: spec (Check NOT_FOUND, Watch SERVICE_UNKNOWN), service-unknown or not-found")
+	fs.DurationVar(&cfg.StartupProbeDelay, "startup-probe-delay", 0, "keep the /startupz probe failing for this long after the gRPC listeners start serving")
+	fs.BoolVar(&cfg.ToggleEndpoint, "toggle-endpoint", true, "serve the legacy /toggle-health endpoint besides PUT /health")
+	fs.BoolVar(&cfg.TracingForceSample, "tracing-force-sample", false, "sample the RPCs with an x-force-sample: true metadata regardless of OTEL_TRACES_SAMPLER")
+	fs.BoolVar(&cfg.Tracing, "tracing", false, "export a span per RPC over OTLP, continuing W3C traceparent or B3 trace context (exporter configured by OTEL_EXPORTER_OTLP_* variables)")
+	fs.BoolVar(&cfg.RESTGateway, "rest-gateway", false, "serve TimeService as JSON on the HTTP server: GET /v1/time and GET /v1/time/stream (server-sent events)")
+	fs.Var((*ListFlag)(&cfg.RESTStatusMap), "rest-status-map", "comma-separated CODE=STATUS pairs overriding the HTTP status the REST gateway answers a gRPC code with, e.g. RESOURCE_EXHAUSTED=503 (repeatable)")
+	fs.StringVar(&cfg.AuthzPolicy, "authz-policy", "", "YAML or JSON file of rules allowing client certificate SANs or SPIFFE IDs to call methods (empty = no authorization)")
+	fs.DurationVar(&cfg.AuthzWatchInterval, "authz-watch-interval", 5*time.Second, "how often to check -authz-policy for changes and reload it (0 = only on SIGHUP)")
+	fs.BoolVar(&cfg.DebugEndpoints, "debug-endpoints", false, "serve /debug/pprof/, /debug/goroutines and /debug/gc on the HTTP server")
+	fs.BoolVar(&cfg.FaultInjection, "fault-injection", false, "serve the /faults endpoints to inject delays, errors, stream aborts and GOAWAY at runtime; test environments only")
+	fs.BoolVar(&cfg.ClockControl, "clock-control", false, "serve the /clock endpoint to freeze, offset or speed up the time reported by the time service")
+	fs.BoolVar(&cfg.Chaos, "chaos", false, "serve the /chaos endpoint to make the process exit, stop accepting or reading connections, or pause stream sends; test environments only")
+	fs.DurationVar(&cfg.ChaosInterval, "chaos-interval", 0, "run one of -chaos-actions at random this often (0 = only through /chaos)")
+	fs.Var((*ListFlag)(&cfg.ChaosActions), "chaos-actions", "comma-separated actions -chaos-interval picks from: exit, pause-accept, hang, pause-sends (default: all but exit)")
+	fs.DurationVar(&cfg.ChaosDuration, "chaos-duration", 10*time.Second, "how long the pauses of -chaos-interval last")
+	fs.IntVar(&cfg.ChaosExitCode, "chaos-exit-code", 1, "exit status of the exit action of -chaos-interval")
+	fs.IntVar(&cfg.ListenBacklog, "listen-backlog", 0, "accept queue length of the gRPC listeners, capped by the kernel's somaxconn (0 = system default; Unix only)")
+	fs.DurationVar(&cfg.TCPKeepAlive, "tcp-keepalive", 0, "TCP keepalive period of accepted gRPC connections (0 = Go default of 15s, negative = disabled)")
+	fs.DurationVar(&cfg.AcceptDelay, "accept-delay", 0, "hold every accepted gRPC connection back this long before its handshake, one at a time like a slow accept loop")
+	fs.IntVar(&cfg.MaxConns, "max-conns", 0, "maximum gRPC connections open across all listeners (0 = unlimited)")
+	fs.StringVar(&cfg.MaxConnsAction, "max-conns-action", "close", "what to do with connections beyond -max-conns: "+strings.Join(maxConnsActions, ", ")+" (leave them in the kernel's accept queue)")
+	fs.BoolVar(&cfg.TCPResetOnClose, "tcp-reset-on-close", false, "close gRPC connections with RST instead of FIN")
+	fs.StringVar(&cfg.ToggleToken, "toggle-token", os.Getenv("TOGGLE_TOKEN"), "token required by /toggle-health and POST /health/{service}, as a bearer token or ?token= (default $TOGGLE_TOKEN; empty = open)")
+	fs.IntVar(&cfg.ToggleRateLimit, "toggle-rate-limit", 0, "maximum health changes accepted per minute on the control endpoints (0 = unlimited)")
+	fs.BoolVar(&cfg.HTTPTLS, "http-tls", false, "serve the HTTP server over TLS with the gRPC server's certificate")
+	fs.StringVar(&cfg.HTTPClientAuth, "http-client-auth", "none", "client certificate policy of the HTTP server with -http-tls, as for -client-auth: "+strings.Join(tlsutil.ClientAuthModeNames(), ", "))
+	fs.StringVar(&cfg.HTTPToken, "http-token", os.Getenv("HTTP_TOKEN"), "token required by every HTTP endpoint, as a bearer token or ?token= (default $HTTP_TOKEN; empty = open)")
+	fs.DurationVar(&cfg.HandshakeTimeout, "handshake-timeout", 10*time.Second, "close connections that do not complete the TLS handshake within this time")
+	fs.StringVar(&cfg.ProxyProtocol, "proxy-protocol", "off", "read a PROXY protocol v1 or v2 header before TLS on the gRPC listeners: off, on (required) or optional")
+	fs.StringVar(&cfg.UnixSocketMode, "unix-socket-mode", "0660", "permissions, in octal, of the socket files of unix:// listener addresses")
+	fs.StringVar(&cfg.IPFamily, "ip-family", "any", "IP families of the gRPC listener: any (platform default), 4, 6, or both for separate IPv4 and IPv6-only listeners")
+	fs.BoolVar(&cfg.LogDroppedTicks, "log-dropped-ticks", false, "log each StreamTime tick dropped because the client is falling behind")
+	fs.DurationVar(&cfg.KeepaliveMinTime, "keepalive-min-time", 5*time.Minute, "minimum interval between client keepalive pings before the connection is closed with too_many_pings")
+	fs.BoolVar(&cfg.KeepalivePermitWithoutStream, "keepalive-permit-without-stream", false, "accept keepalive pings on connections without active streams")
+	fs.DurationVar(&cfg.KeepaliveTime, "keepalive-time", 2*time.Hour, "idle time after which the server pings the client")
+	fs.DurationVar(&cfg.KeepaliveTimeout, "keepalive-timeout", 20*time.Second, "time to wait for a keepalive ping ack before closing the connection")
+	fs.DurationVar(&cfg.MaxConnectionIdle, "max-connection-idle", 0, "close connections without active streams for this long with GOAWAY (0 = never)")
+	fs.DurationVar(&cfg.MaxConnectionAge, "max-connection-age", 0, "close connections older than this with GOAWAY (0 = never)")
+	fs.DurationVar(&cfg.MaxConnectionAgeGrace, "max-connection-age-grace", 0, "time streams may still run on a connection closed for its age (0 = unlimited)")
+	fs.Var((*ListFlag)(&cfg.RedactMetadata), "redact-metadata", "comma-separated metadata keys whose values DumpMetadata hides, in addition to authorization, cookie and proxy-authorization (repeatable)")
+	fs.DurationVar(&cfg.DefaultInterval, "default-interval", defaultInterval, "StreamTime tick interval for requests that do not set interval_ms")
+	fs.StringVar(&cfg.DefaultFormat, "default-format", "rfc3339", "time format for requests that do not set one: rfc3339, rfc3339nano, rfc1123 or datetime")
+	fs.StringVar(&cfg.DefaultTimezone, "default-timezone", "", "IANA time zone of local_time for requests that do not set a timezone")
+	fs.StringVar(&cfg.MetricsSnapshotFile, "metrics-snapshot-file", "", "save counters to this file on shutdown and restore them from it on startup")
+	fs.StringVar(&cfg.StateFile, "state-file", "", "save the health, fault and chaos state set through the admin API to this file and restore it on startup")
+	fs.StringVar(&cfg.RequiredCertExtension, "required-cert-extension", "", "dotted OID of an extension client certificates must carry, e.g. a tenant ID; its value is added to the client identity")
+	fs.BoolVar(&cfg.LandingPage, "landing-page", true, "serve a page at / on the HTTP server listing the gRPC services and HTTP endpoints")
+	fs.IntVar(&cfg.MaxGoroutines, "max-goroutines", 0, "refuse new streams and unary RPCs while more goroutines than this are running (0 = no limit)")
+	fs.Float64Var(&cfg.RateLimit, "rate-limit", 0, "RPCs per second accepted by the whole server, excluding health checks and reflection (0 = unlimited)")
+	fs.IntVar(&cfg.RateLimitBurst, "rate-limit-burst", 10, "RPCs accepted at once above -rate-limit")
+	fs.StringVar(&cfg.RateLimitScope, "rate-limit-scope", "global", "what -rate-limit applies to: global (the whole server) or identity (each client identity)")
+	fs.Int64Var(&cfg.MaxInFlight, "max-in-flight", 0, "refuse new RPCs, streams included, while this many are being handled, excluding health checks and reflection (0 = no limit)")
+	fs.StringVar(&cfg.OverloadCode, "overload-code", "RESOURCE_EXHAUSTED", "status code of RPCs refused by -max-goroutines and -max-in-flight: RESOURCE_EXHAUSTED or UNAVAILABLE")
+	fs.DurationVar(&cfg.OverloadRetryAfter, "overload-retry-after", time.Second, "retry-after trailer of RPCs refused by -max-goroutines and -max-in-flight, rounded up to seconds (0 = none)")
+	fs.StringVar(&cfg.BootReportFile, "boot-report-file", "", "also write the startup boot report to this file as JSON")
+	fs.StringVar(&cfg.CRLFile, "crl-file", "", "PEM or DER file of CRLs revoking client certificates, reloaded like the TLS files")
+	fs.StringVar(&cfg.OCSPCheck, "ocsp-check", "off", "check client certificates with their OCSP responder: off, soft (accept if the status is unavailable) or hard")
+	fs.StringVar(&cfg.OCSPStapleFile, "ocsp-staple-file", "", "DER OCSP response stapled to the server certificate, reloaded like the TLS files")
+	fs.DurationVar(&cfg.CertWatchInterval, "cert-watch-interval", 5*time.Second, "how often to check the TLS files for changes and reload them (0 = never)")
+}
+
+// DefaultConfig returns the configuration the server starts with when no
+// flag is set.
+func DefaultConfig() Config {
+	var cfg Config
+	cfg.RegisterFlags(flag.NewFlagSet("defaults", flag.ContinueOnError))
+	return cfg
+}
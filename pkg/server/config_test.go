@@ -0,0 +1,64 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	if err := DefaultConfig().validate(); err != nil {
+		t.Fatalf("the defaults do not validate: %v", err)
+	}
+	for _, tc := range []struct {
+		name   string
+		modify func(*Config)
+		want   []string
+	}{
+		{"fixed time and replay", func(c *Config) {
+			c.FixedTime, c.ReplayFile = "2024-01-01T00:00:00Z", "times.txt"
+		}, []string{"-fixed-time and -replay-file are mutually exclusive"}},
+		{"pins without client certificates", func(c *Config) {
+			c.ClientAuth, c.ClientCertPins = "none", []string{"abcd"}
+		}, []string{"-client-cert-pins requires a client certificate"}},
+		{"inverted TLS versions", func(c *Config) {
+			c.TLSMinVersion, c.TLSMaxVersion = "1.3", "1.2"
+		}, []string{"-tls-min-version 1.3 is above -tls-max-version 1.2"}},
+		{"HTTP client auth without HTTP TLS", func(c *Config) {
+			c.HTTPClientAuth, c.HTTPTLS = "require", false
+		}, []string{"-http-client-auth=require requires -http-tls"}},
+		{"unknown HTTP client auth", func(c *Config) {
+			c.HTTPClientAuth, c.HTTPTLS = "mandatory", true
+		}, []string{`invalid -http-client-auth: unknown mode "mandatory", want one of none, request,`}},
+		{"status map without the gateway", func(c *Config) {
+			c.RESTStatusMap = []string{"UNAVAILABLE=503"}
+		}, []string{"-rest-status-map requires -rest-gateway"}},
+		{"bad send buffer", func(c *Config) {
+			c.SendBuffer, c.SendBufferOverflow = -1, "block"
+		}, []string{"-send-buffer must not be negative", `-send-buffer-overflow must be one of drop-oldest, drop-newest, disconnect, got "block"`}},
+		{"shared address", func(c *Config) {
+			c.GRPCAddr, c.HTTPAddr = ":9000", ":9000"
+		}, []string{"cannot share the address :9000"}},
+		{"several problems at once", func(c *Config) {
+			c.ReplayLoop = true
+			c.TracingForceSample, c.Tracing = true, false
+			c.ListenerCount = 0
+		}, []string{"-replay-loop requires -replay-file", "-tracing-force-sample requires -tracing", "-listener-count must be at least 1"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			tc.modify(&cfg)
+			err := cfg.validate()
+			if err == nil {
+				t.Fatal("validate succeeded")
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("validate: %v\nwant it to report %q", err, want)
+				}
+			}
+			if lines := strings.Count(err.Error(), "\n") + 1; lines != len(tc.want) {
+				t.Errorf("validate reported %d problems, want %d: %v", lines, len(tc.want), err)
+			}
+		})
+	}
+}
@@ -0,0 +1,314 @@
+// conns.go
+//
+// This file tracks streams per HTTP/2 connection. A stats handler tags each
+// connection, and a stream interceptor counts the streams opened on it so a
+// single client connection cannot monopolize server goroutines. The same
+// handler keeps a registry of the open connections, served as JSON on the
+// HTTP server's /connections endpoint with the RPCs, message bytes and
+// GOAWAY frames of each, keeps the last closed ones for
+// /connections/closed, and optionally logs connections as they open and
+// close. Connection churn behind Envoy shows in the lifetimes and RPC
+// counts of the closed connections and in the connection metrics.
+//
+// The client identity is fixed for the lifetime of a connection, so it is
+// extracted once when the connection is tagged, after the TLS handshake,
+// and kept in the connection state. It goes away with the connection.
+
+package server
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+)
+
+type connKey struct{}
+
+// connState is the per-connection bookkeeping attached to the connection
+// context by connTracker.
+type connState struct {
+	id       uint64
+	remote   net.Addr
+	opened   time.Time
+	tls      tls.ConnectionState
+	identity Identity
+	streams  atomic.Int64
+
+	// rpcs counts the RPCs of every kind started on the connection, and
+	// bytesReceived and bytesSent their messages as on the wire, framing
+	// included.
+	rpcs          atomic.Int64
+	bytesReceived atomic.Int64
+	bytesSent     atomic.Int64
+
+	// goaway is the reason of the last GOAWAY frame sent on the
+	// connection, if any. Guarded by the registry's mu.
+	goaway string
+}
+
+// closedConnsKept is how many closed connections /connections/closed
+// lists.
+const closedConnsKept = 100
+
+// connRegistry tracks open connections and the last closed ones. The zero
+// value is ready to use.
+type connRegistry struct {
+	mu     sync.Mutex
+	nextID uint64
+	conns  map[uint64]*connState
+	closed []connEntry // oldest first
+}
+
+func (r *connRegistry) add(c *connState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conns == nil {
+		r.conns = make(map[uint64]*connState)
+	}
+	r.nextID++
+	c.id = r.nextID
+	r.conns[c.id] = c
+}
+
+// remove moves c to the closed connections and returns its final entry.
+func (r *connRegistry) remove(c *connState) connEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.conns, c.id)
+	entry := c.entry()
+	now := time.Now()
+	entry.Closed, entry.DurationMs = now, now.Sub(c.opened).Milliseconds()
+	if len(r.closed) == closedConnsKept {
+		r.closed = slices.Delete(r.closed, 0, 1)
+	}
+	r.closed = append(r.closed, entry)
+	return entry
+}
+
+// goaway records a GOAWAY frame with reason sent on the open connection
+// to remote.
+func (r *connRegistry) goaway(remote net.Addr, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range r.conns {
+		if c.remote == remote {
+			c.goaway = reason
+			return
+		}
+	}
+}
+
+// connEntry is the JSON form of a connState.
+type connEntry struct {
+	ID            uint64    `json:"id"`
+	Remote        string    `json:"remote"`
+	Source        string    `json:"source,omitempty"`
+	Opened        time.Time `json:"opened"`
+	Closed        time.Time `json:"closed,omitzero"`
+	DurationMs    int64     `json:"duration_ms,omitempty"`
+	TLSVersion    string    `json:"tls_version"`
+	CipherSuite   string    `json:"cipher_suite"`
+	Identity      Identity  `json:"identity"`
+	Streams       int64     `json:"streams"`
+	RPCs          int64     `json:"rpcs"`
+	BytesReceived int64     `json:"bytes_received"`
+	BytesSent     int64     `json:"bytes_sent"`
+	Goaway        string    `json:"goaway,omitempty"`
+}
+
+// entry returns the JSON form of c. Callers must hold the registry's mu.
+func (c *connState) entry() connEntry {
+	peerAddr, source := splitProxied(c.remote)
+	entry := connEntry{
+		ID:            c.id,
+		Remote:        peerAddr.String(),
+		Opened:        c.opened,
+		TLSVersion:    tls.VersionName(c.tls.Version),
+		CipherSuite:   tls.CipherSuiteName(c.tls.CipherSuite),
+		Identity:      c.identity,
+		Streams:       c.streams.Load(),
+		RPCs:          c.rpcs.Load(),
+		BytesReceived: c.bytesReceived.Load(),
+		BytesSent:     c.bytesSent.Load(),
+		Goaway:        c.goaway,
+	}
+	if source != nil {
+		entry.Source = source.String()
+	}
+	return entry
+}
+
+// ServeHTTP lists the open connections, oldest first.
+func (r *connRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	entries := make([]connEntry, 0, len(r.conns))
+	for _, c := range r.conns {
+		entries = append(entries, c.entry())
+	}
+	r.mu.Unlock()
+	slices.SortFunc(entries, func(a, b connEntry) int { return cmp.Compare(a.ID, b.ID) })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// serveClosed lists the last closed connections, most recent first.
+func (r *connRegistry) serveClosed(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	entries := slices.Clone(r.closed)
+	r.mu.Unlock()
+	slices.Reverse(entries)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// connFromContext returns the state of the connection an RPC arrived on, or
+// nil if the connection was not tagged.
+func connFromContext(ctx context.Context) *connState {
+	c, _ := ctx.Value(connKey{}).(*connState)
+	return c
+}
+
+// http2Settings are the HTTP/2 settings the server advertises to clients,
+// as configured on grpc.NewServer. Zero fields mean gRPC's default.
+type http2Settings struct {
+	InitialWindowSize     int32
+	InitialConnWindowSize int32
+	MaxConcurrentStreams  uint32
+	MaxHeaderListSize     uint32
+}
+
+func (h http2Settings) String() string {
+	window := func(n int32) string {
+		switch {
+		case n != 0:
+			return strconv.Itoa(int(n))
+		case h.InitialWindowSize == 0 && h.InitialConnWindowSize == 0:
+			return "64KiB (dynamic BDP)"
+		default:
+			// Setting either window turns BDP estimation off for both.
+			return "64KiB"
+		}
+	}
+	streams := "unlimited"
+	if h.MaxConcurrentStreams != 0 {
+		streams = strconv.Itoa(int(h.MaxConcurrentStreams))
+	}
+	headers := "16MiB"
+	if h.MaxHeaderListSize != 0 {
+		headers = strconv.Itoa(int(h.MaxHeaderListSize))
+	}
+	return fmt.Sprintf("initial_window=%s initial_conn_window=%s max_concurrent_streams=%s max_header_list_size=%s",
+		window(h.InitialWindowSize), window(h.InitialConnWindowSize), streams, headers)
+}
+
+// connTracker is a stats.Handler that attaches a connState to every
+// incoming connection and registers it in conns. With verbose set, it also
+// logs each connection along with the HTTP/2 settings advertised on it.
+type connTracker struct {
+	verbose  bool
+	settings http2Settings
+	conns    *connRegistry
+}
+
+func (t connTracker) TagConn(ctx context.Context, info *stats.ConnTagInfo) context.Context {
+	c := &connState{remote: info.RemoteAddr, opened: time.Now(), identity: peerIdentity(ctx)}
+	if p, ok := peer.FromContext(ctx); ok {
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			c.tls = tlsInfo.State
+		}
+	}
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+func (t connTracker) HandleConn(ctx context.Context, s stats.ConnStats) {
+	c := connFromContext(ctx)
+	switch s.(type) {
+	case *stats.ConnBegin:
+		t.conns.add(c)
+		connectionsOpened.Inc()
+		openConnections.Inc()
+		events.publish("connection_open", map[string]any{"conn_id": c.id, "remote": c.remote.String(), "identity": c.identity.Name()})
+		if t.verbose {
+			log.Printf("Connection from %s opened, advertised HTTP/2 settings: %s", c.remote, t.settings)
+		}
+	case *stats.ConnEnd:
+		e := t.conns.remove(c)
+		openConnections.Dec()
+		connectionDuration.Observe(time.Since(c.opened).Seconds())
+		connectionRPCs.Observe(float64(e.RPCs))
+		fields := map[string]any{"conn_id": c.id, "remote": c.remote.String(), "duration_ms": e.DurationMs, "rpcs": e.RPCs, "bytes_received": e.BytesReceived, "bytes_sent": e.BytesSent}
+		if e.Goaway != "" {
+			fields["goaway"] = e.Goaway
+		}
+		events.publish("connection_close", fields)
+		if t.verbose {
+			goaway := "none"
+			if e.Goaway != "" {
+				goaway = e.Goaway
+			}
+			log.Printf("Connection from %s closed after %s: %d RPCs, %d bytes received, %d bytes sent, last GOAWAY %s",
+				c.remote, time.Duration(e.DurationMs)*time.Millisecond, e.RPCs, e.BytesReceived, e.BytesSent, goaway)
+		}
+	}
+}
+
+func (connTracker) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+// HandleRPC accounts the RPCs and message bytes of each connection.
+func (connTracker) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	c := connFromContext(ctx)
+	if c == nil {
+		return
+	}
+	switch s := s.(type) {
+	case *stats.Begin:
+		c.rpcs.Add(1)
+	case *stats.InPayload:
+		c.bytesReceived.Add(int64(s.WireLength))
+		messageBytesReceived.Add(float64(s.WireLength))
+	case *stats.OutPayload:
+		c.bytesSent.Add(int64(s.WireLength))
+		messageBytesSent.Add(float64(s.WireLength))
+	}
+}
+
+// connStreamLimit returns a stream interceptor that records how many
+// streams are open on each connection and, when max is positive, rejects
+// streams beyond max with ResourceExhausted.
+func connStreamLimit(max int64) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		c := connFromContext(ss.Context())
+		if c == nil {
+			return handler(srv, ss)
+		}
+		n := c.streams.Add(1)
+		defer c.streams.Add(-1)
+		if max > 0 && n > max {
+			connectionStreamRejections.Inc()
+			return status.Errorf(codes.ResourceExhausted, "connection already has %d open streams (limit %d)", n-1, max)
+		}
+		connectionStreams.Observe(float64(n))
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/dethi/envoy_hck/protos"
+)
+
+func TestConnStreamLimit(t *testing.T) {
+	var conns connRegistry
+	client := timeClient(t, newTestServer(t, serviceDefaults{}),
+		grpc.StatsHandler(connTracker{conns: &conns}),
+		grpc.ChainStreamInterceptor(connStreamLimit(2)))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	open := func(ctx context.Context) (pb.TimeService_StreamTimeClient, error) {
+		stream, err := client.StreamTime(ctx, &pb.TimeRequest{IntervalMs: 10})
+		if err != nil {
+			return nil, err
+		}
+		_, err = stream.Recv()
+		return stream, err
+	}
+	first, firstCancel := context.WithCancel(ctx)
+	defer firstCancel()
+	if _, err := open(first); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := open(ctx); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("third stream on the connection: %v, want RESOURCE_EXHAUSTED", err)
+	}
+
+	firstCancel()
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, err := open(ctx)
+		if err == nil {
+			break
+		}
+		if status.Code(err) != codes.ResourceExhausted || time.Now().After(deadline) {
+			t.Fatalf("stream after one closed: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConnSettingsLog(t *testing.T) {
+	settings := http2Settings{InitialWindowSize: 1 << 20, MaxConcurrentStreams: 100}
+	for _, verbose := range []bool{false, true} {
+		logs := captureLog(t)
+		var conns connRegistry
+		client := timeClient(t, newTestServer(t, serviceDefaults{}), grpc.StatsHandler(connTracker{verbose: verbose, settings: settings, conns: &conns}))
+		if _, err := client.GetTime(context.Background(), &pb.TimeRequest{}); err != nil {
+			t.Fatal(err)
+		}
+		want := "opened, advertised HTTP/2 settings: initial_window=1048576 initial_conn_window=64KiB max_concurrent_streams=100 max_header_list_size=16MiB"
+		if got := strings.Contains(logs.String(), want); got != verbose {
+			t.Errorf("verbose %v: settings logged on connect = %v:\n%s", verbose, got, logs)
+		}
+	}
+	if got, want := (http2Settings{}).String(), "initial_window=64KiB (dynamic BDP) initial_conn_window=64KiB (dynamic BDP) max_concurrent_streams=unlimited max_header_list_size=16MiB"; got != want {
+		t.Errorf("default settings = %q, want %q", got, want)
+	}
+}
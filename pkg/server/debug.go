@@ -0,0 +1,101 @@
+// debug.go
+//
+// This file serves runtime debugging endpoints on the HTTP server, for
+// profiling the app when a soak test through Envoy shows it growing:
+//
+//	/debug/pprof/       net/http/pprof, e.g. go tool pprof http://localhost:8081/debug/pprof/heap
+//	/debug/goroutines   the stacks of all goroutines, as in a SIGQUIT dump
+//	/debug/gc           GC and heap statistics; POST forces a collection
+//
+// The endpoints are registered on the server's own mux, and only with
+// -debug-endpoints; net/http/pprof is imported for its handlers alone, so
+// a program embedding the server keeps its default mux to itself.
+
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"time"
+)
+
+// registerPprof serves the net/http/pprof handlers on mux.
+func registerPprof(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
+
+// serveGoroutines handles GET /debug/goroutines.
+func serveGoroutines(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(allStacks())
+}
+
+// gcStats is the JSON form of /debug/gc.
+type gcStats struct {
+	NumGC          uint32    `json:"num_gc"`
+	LastGC         time.Time `json:"last_gc,omitzero"`
+	PauseTotalMs   float64   `json:"pause_total_ms"`
+	LastPauseMs    float64   `json:"last_pause_ms"`
+	HeapAllocBytes uint64    `json:"heap_alloc_bytes"`
+	HeapInuseBytes uint64    `json:"heap_inuse_bytes"`
+	HeapIdleBytes  uint64    `json:"heap_idle_bytes"`
+	HeapObjects    uint64    `json:"heap_objects"`
+	NextGCBytes    uint64    `json:"next_gc_bytes"`
+	SysBytes       uint64    `json:"sys_bytes"`
+	Goroutines     int       `json:"goroutines"`
+	GOGC           int       `json:"gogc"`
+	GOMEMLIMIT     int64     `json:"gomemlimit"`
+}
+
+func readGCStats() gcStats {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	st := gcStats{
+		NumGC:          ms.NumGC,
+		PauseTotalMs:   float64(ms.PauseTotalNs) / 1e6,
+		HeapAllocBytes: ms.HeapAlloc,
+		HeapInuseBytes: ms.HeapInuse,
+		HeapIdleBytes:  ms.HeapIdle,
+		HeapObjects:    ms.HeapObjects,
+		NextGCBytes:    ms.NextGC,
+		SysBytes:       ms.Sys,
+		Goroutines:     runtime.NumGoroutine(),
+	}
+	samples := []metrics.Sample{{Name: "/gc/gogc:percent"}, {Name: "/gc/gomemlimit:bytes"}}
+	metrics.Read(samples)
+	st.GOGC = int(samples[0].Value.Uint64())
+	st.GOMEMLIMIT = int64(samples[1].Value.Uint64())
+	if ms.NumGC > 0 {
+		st.LastGC = time.Unix(0, int64(ms.LastGC))
+		st.LastPauseMs = float64(ms.PauseNs[(ms.NumGC+255)%256]) / 1e6
+	}
+	return st
+}
+
+// serveGC handles /debug/gc: GET shows the statistics, and POST first
+// runs a collection and returns the memory it can to the OS.
+func serveGC(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		before := readGCStats().HeapAllocBytes
+		start := time.Now()
+		debug.FreeOSMemory()
+		log.Printf("Forced GC in %s, heap %d -> %d bytes", time.Since(start).Round(time.Microsecond), before, readGCStats().HeapAllocBytes)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(readGCStats())
+}
@@ -0,0 +1,177 @@
+// diagnostics.go
+//
+// This file implements the Diagnostics service, a set of RPCs for probing
+// the path through Envoy independently of the time semantics.
+
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/dethi/envoy_hck/pkg/faults"
+	pb "github.com/dethi/envoy_hck/protos"
+)
+
+type diagnosticsServer struct {
+	pb.UnimplementedDiagnosticsServer
+
+	drain *drainer
+
+	// redacted holds the lowercase metadata keys whose values DumpMetadata
+	// hides.
+	redacted map[string]bool
+}
+
+// defaultRedactedMetadata are the metadata keys DumpMetadata always redacts.
+var defaultRedactedMetadata = []string{"authorization", "cookie", "proxy-authorization"}
+
+func newDiagnosticsServer(drain *drainer, redact []string) *diagnosticsServer {
+	s := &diagnosticsServer{drain: drain, redacted: make(map[string]bool)}
+	for _, key := range append(defaultRedactedMetadata, redact...) {
+		s.redacted[strings.ToLower(key)] = true
+	}
+	return s
+}
+
+func (s *diagnosticsServer) DumpMetadata(ctx context.Context, _ *pb.DumpMetadataRequest) (*pb.DumpMetadataResponse, error) {
+	log.Println("DumpMetadata request received")
+	md, _ := metadata.FromIncomingContext(ctx)
+	return &pb.DumpMetadataResponse{Metadata: s.dumpMetadata(md)}, nil
+}
+
+// dumpMetadata converts md to its protobuf form, redacting sensitive
+// values and base64 encoding binary ones.
+func (s *diagnosticsServer) dumpMetadata(md metadata.MD) map[string]*pb.MetadataValues {
+	out := make(map[string]*pb.MetadataValues, len(md))
+	for key, values := range md {
+		dumped := make([]string, len(values))
+		for i, v := range values {
+			switch {
+			case s.redacted[key]:
+				dumped[i] = "[redacted]"
+			case strings.HasSuffix(key, "-bin"):
+				// Binary values are decoded by gRPC and need not be UTF-8.
+				dumped[i] = base64.StdEncoding.EncodeToString([]byte(v))
+			default:
+				dumped[i] = v
+			}
+		}
+		out[key] = &pb.MetadataValues{Values: dumped}
+	}
+	return out
+}
+
+func (s *diagnosticsServer) EchoHeaders(ctx context.Context, req *pb.EchoHeadersRequest) (*pb.EchoHeadersResponse, error) {
+	log.Println("EchoHeaders request received")
+	header, err := parseMetadata(req.GetResponseHeaders())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "response_headers: %v", err)
+	}
+	trailer, err := parseMetadata(req.GetResponseTrailers())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "response_trailers: %v", err)
+	}
+	if err := grpc.SetHeader(ctx, header); err != nil {
+		return nil, err
+	}
+	grpc.SetTrailer(ctx, trailer)
+	md, _ := metadata.FromIncomingContext(ctx)
+	return &pb.EchoHeadersResponse{Metadata: s.dumpMetadata(md)}, nil
+}
+
+// parseMetadata converts metadata in its protobuf form to metadata.MD,
+// rejecting keys that are invalid or reserved by gRPC and decoding binary
+// values.
+func parseMetadata(in map[string]*pb.MetadataValues) (metadata.MD, error) {
+	md := metadata.MD{}
+	for key, values := range in {
+		if err := faults.CheckMetadataKey(key); err != nil {
+			return nil, err
+		}
+		for _, v := range values.GetValues() {
+			if strings.HasSuffix(key, "-bin") {
+				b, err := base64.StdEncoding.DecodeString(v)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", key, err)
+				}
+				v = string(b)
+			}
+			md.Append(key, v)
+		}
+	}
+	return md, nil
+}
+
+func (s *diagnosticsServer) Ping(stream pb.Diagnostics_PingServer) error {
+	log.Println("Ping request received")
+
+	pings := make(chan *pb.PingRequest)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case pings <- req:
+			case <-stream.Context().Done():
+				return
+			}
+		}
+	}()
+
+	drained := s.drain.C()
+	for {
+		select {
+		case <-stream.Context().Done():
+			log.Println("Client disconnected")
+			return nil
+		case <-drained:
+			log.Println("Draining stream")
+			return status.Error(codes.Unavailable, "server is draining")
+		case err := <-recvErr:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		case req := <-pings:
+			resp := &pb.PingResponse{Request: req, ServerTimeUnixNano: time.Now().UnixNano()}
+			if err := stream.Send(resp); err != nil {
+				log.Printf("Error sending pong: %v", err)
+				return sendFailed("pong", err)
+			}
+		}
+	}
+}
+
+// maxBlobBytes bounds GetBlob payloads, well above any message size limit
+// worth testing.
+const maxBlobBytes = 256 << 20
+
+func (s *diagnosticsServer) GetBlob(_ context.Context, req *pb.BlobRequest) (*pb.BlobResponse, error) {
+	size := req.GetSize()
+	log.Printf("GetBlob request received for %d bytes", size)
+	if size < 0 || size > maxBlobBytes {
+		return nil, status.Errorf(codes.InvalidArgument, "size must be between 0 and %d, got %d", maxBlobBytes, size)
+	}
+	data := make([]byte, size)
+	if !req.GetCompressible() {
+		for b := data; len(b) > 0; {
+			b = b[copy(b, fillerBlock()):]
+		}
+	}
+	return &pb.BlobResponse{Data: data}, nil
+}
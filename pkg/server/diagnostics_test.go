@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	pb "github.com/dethi/envoy_hck/protos"
+)
+
+// diagnosticsClient serves s as Diagnostics in memory, with opts, and
+// returns a client of it.
+func diagnosticsClient(t *testing.T, s *diagnosticsServer, opts ...grpc.ServerOption) pb.DiagnosticsClient {
+	t.Helper()
+	srv := grpc.NewServer(opts...)
+	pb.RegisterDiagnosticsServer(srv, s)
+	return pb.NewDiagnosticsClient(bufconnClient(t, srv))
+}
+
+func TestPing(t *testing.T) {
+	client := diagnosticsClient(t, newDiagnosticsServer(&drainer{}, nil))
+	stream, err := client.Ping(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for seq := range uint64(3) {
+		sent := time.Now().UnixNano()
+		if err := stream.Send(&pb.PingRequest{Sequence: seq, ClientTimeUnixNano: sent}); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.GetRequest().GetSequence() != seq || resp.GetRequest().GetClientTimeUnixNano() != sent {
+			t.Errorf("pong %d echoes %v", seq, resp.GetRequest())
+		}
+		if st := resp.GetServerTimeUnixNano(); st < sent || st > time.Now().UnixNano() {
+			t.Errorf("pong %d server time %d is outside the round trip", seq, st)
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Errorf("after CloseSend: %v, want EOF", err)
+	}
+}
+
+func TestDumpMetadata(t *testing.T) {
+	client := diagnosticsClient(t, newDiagnosticsServer(&drainer{}, []string{"X-Api-Key"}))
+	md := metadata.Pairs(
+		"x-envoy-original-path", "/time.TimeService/GetTime",
+		"x-forwarded-for", "10.0.0.1",
+		"x-forwarded-for", "10.0.0.2",
+		"authorization", "Bearer s3cret",
+		"x-api-key", "k3y",
+		"trace-bin", "\x00\xff",
+	)
+	resp, err := client.DumpMetadata(metadata.NewOutgoingContext(context.Background(), md), &pb.DumpMetadataRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := resp.GetMetadata()
+	for key, want := range map[string][]string{
+		"x-envoy-original-path": {"/time.TimeService/GetTime"},
+		"x-forwarded-for":       {"10.0.0.1", "10.0.0.2"},
+		"authorization":         {"[redacted]"},
+		"x-api-key":             {"[redacted]"},
+		"trace-bin":             {"AP8="},
+	} {
+		if values := got[key].GetValues(); !slices.Equal(values, want) {
+			t.Errorf("%s = %q, want %q", key, values, want)
+		}
+	}
+	// The transport's own metadata is echoed too.
+	if got[":authority"] == nil || got["content-type"] == nil {
+		t.Errorf("metadata %v lacks :authority or content-type", got)
+	}
+}
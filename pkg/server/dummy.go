@@ -0,0 +1,114 @@
+// dummy.go
+//
+// This file registers placeholder services given by -dummy-services. They
+// implement nothing, every method answers UNIMPLEMENTED, but they are
+// described to server reflection and reported by the health service like
+// the real ones, so Envoy routes matching on gRPC service and method names,
+// and reflection-based tooling, can be tested against a larger service
+// surface than this app's own.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// dummyService is a parsed -dummy-services entry.
+type dummyService struct {
+	name    protoreflect.FullName
+	methods []protoreflect.Name
+}
+
+// parseDummyService parses a -dummy-services entry: a fully qualified
+// service name followed by its methods, each after a slash, e.g.
+// acme.orders.v1.Orders/Get/List.
+func parseDummyService(s string) (dummyService, error) {
+	name, methods, _ := strings.Cut(s, "/")
+	svc := dummyService{name: protoreflect.FullName(name)}
+	if !svc.name.IsValid() {
+		return dummyService{}, fmt.Errorf("invalid dummy service name %q", name)
+	}
+	if methods == "" {
+		return svc, nil
+	}
+	for _, m := range strings.Split(methods, "/") {
+		method := protoreflect.Name(m)
+		if !method.IsValid() {
+			return dummyService{}, fmt.Errorf("invalid method name %q of dummy service %s", m, name)
+		}
+		svc.methods = append(svc.methods, method)
+	}
+	return svc, nil
+}
+
+// describe adds a file declaring the service to the global registry
+// reflection serves from. Every method takes and returns
+// google.protobuf.Empty, which decodes any request message.
+func (d dummyService) describe() error {
+	file := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String(fmt.Sprintf("dummy/%s.proto", d.name)),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{emptypb.File_google_protobuf_empty_proto.Path()},
+		Service:    []*descriptorpb.ServiceDescriptorProto{{Name: proto.String(string(d.name.Name()))}},
+	}
+	if pkg := d.name.Parent(); pkg != "" {
+		file.Package = proto.String(string(pkg))
+	}
+	for _, m := range d.methods {
+		file.Service[0].Method = append(file.Service[0].Method, &descriptorpb.MethodDescriptorProto{
+			Name:       proto.String(string(m)),
+			InputType:  proto.String(".google.protobuf.Empty"),
+			OutputType: proto.String(".google.protobuf.Empty"),
+		})
+	}
+	fd, err := protodesc.NewFile(file, protoregistry.GlobalFiles)
+	if err != nil {
+		return fmt.Errorf("describing dummy service %s: %w", d.name, err)
+	}
+	if err := protoregistry.GlobalFiles.RegisterFile(fd); err != nil {
+		return fmt.Errorf("describing dummy service %s: %w", d.name, err)
+	}
+	return nil
+}
+
+// serviceDesc returns the service for grpc.Server.RegisterService. Its
+// methods go through the interceptors like real ones, so faults, limits
+// and logging apply to them too.
+func (d dummyService) serviceDesc() *grpc.ServiceDesc {
+	desc := &grpc.ServiceDesc{
+		ServiceName: string(d.name),
+		HandlerType: (*any)(nil),
+		Metadata:    fmt.Sprintf("dummy/%s.proto", d.name),
+	}
+	for _, m := range d.methods {
+		fullMethod := fmt.Sprintf("/%s/%s", d.name, m)
+		desc.Methods = append(desc.Methods, grpc.MethodDesc{
+			MethodName: string(m),
+			Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+				if err := dec(new(emptypb.Empty)); err != nil {
+					return nil, err
+				}
+				handler := func(context.Context, any) (any, error) {
+					return nil, status.Errorf(codes.Unimplemented, "%s belongs to a dummy service registered by -dummy-services", fullMethod)
+				}
+				if interceptor == nil {
+					return handler(ctx, nil)
+				}
+				return interceptor(ctx, nil, &grpc.UnaryServerInfo{Server: srv, FullMethod: fullMethod}, handler)
+			},
+		})
+	}
+	return desc
+}
@@ -0,0 +1,239 @@
+// events.go
+//
+// This file streams server-side events to the HTTP server's /events
+// endpoint as server-sent events, one JSON object each: connections
+// opening and closing, TLS handshakes, RPCs starting and finishing, and
+// health transitions. Test automation can subscribe before driving
+// traffic through Envoy and assert on what the server saw, instead of
+// scraping the logs. Events are only built while someone is subscribed.
+//
+//	curl -N localhost:8081/events?types=rpc_finish,health
+//
+// A subscriber that falls behind loses events rather than slowing the
+// server down, and is told how many with a "dropped" event.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// eventTypes are the types of events published.
+var eventTypes = []string{"connection_open", "connection_close", "handshake", "rpc_start", "rpc_finish", "health"}
+
+const (
+	// eventBuffer is how many events a subscriber may lag behind.
+	eventBuffer = 1024
+	// eventHeartbeat is how often an idle stream gets a comment, so
+	// proxies and clients do not time it out.
+	eventHeartbeat = 15 * time.Second
+)
+
+// events is the server's event stream.
+var events = &eventBus{}
+
+// eventBus fans published events out to the subscribers of /events. The
+// zero value is ready to use.
+type eventBus struct {
+	mu     sync.Mutex
+	subs   map[*eventSub]struct{}
+	active atomic.Int32 // len(subs), read without the lock
+	seq    uint64
+	closed bool
+}
+
+// event is a published event, encoded once for all subscribers.
+type event struct {
+	typ  string
+	body []byte
+}
+
+// eventSub is one /events stream.
+type eventSub struct {
+	types   map[string]bool // nil for all
+	c       chan event
+	dropped atomic.Int64
+}
+
+// enabled reports whether publishing an event would reach anyone, to skip
+// building it otherwise.
+func (b *eventBus) enabled() bool { return b.active.Load() > 0 }
+
+// publish sends an event of type typ with the given fields to every
+// subscriber that wants it.
+func (b *eventBus) publish(typ string, fields map[string]any) {
+	if !b.enabled() {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	fields["seq"], fields["time"], fields["type"] = b.seq, time.Now(), typ
+	body, err := json.Marshal(fields)
+	if err != nil {
+		return
+	}
+	for sub := range b.subs {
+		if sub.types != nil && !sub.types[typ] {
+			continue
+		}
+		select {
+		case sub.c <- event{typ, body}:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+func (b *eventBus) subscribe(types map[string]bool) *eventSub {
+	sub := &eventSub{types: types, c: make(chan event, eventBuffer)}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(sub.c)
+		return sub
+	}
+	if b.subs == nil {
+		b.subs = make(map[*eventSub]struct{})
+	}
+	b.subs[sub] = struct{}{}
+	b.active.Add(1)
+	return sub
+}
+
+func (b *eventBus) unsubscribe(sub *eventSub) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		b.active.Add(-1)
+	}
+}
+
+// close ends every stream, for the HTTP server to shut down.
+func (b *eventBus) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for sub := range b.subs {
+		close(sub.c)
+		delete(b.subs, sub)
+	}
+	b.active.Store(0)
+}
+
+// ServeHTTP handles GET /events. The types query parameter, a
+// comma-separated list, restricts the stream to those event types.
+func (b *eventBus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var types map[string]bool
+	if v := r.URL.Query().Get("types"); v != "" {
+		types = make(map[string]bool)
+		for _, typ := range strings.Split(v, ",") {
+			if !slices.Contains(eventTypes, typ) {
+				writeJSONError(w, fmt.Sprintf("unknown event type %q, want one of %s", typ, strings.Join(eventTypes, ", ")), http.StatusBadRequest)
+				return
+			}
+			types[typ] = true
+		}
+	}
+	rc := http.NewResponseController(w)
+	sub := b.subscribe(types)
+	defer b.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": heartbeat\n\n")
+		case ev, ok := <-sub.c:
+			if !ok {
+				return
+			}
+			if n := sub.dropped.Swap(0); n > 0 {
+				fmt.Fprintf(w, "event: dropped\ndata: {\"count\":%d}\n\n", n)
+			}
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.typ, ev.body)
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// rpcEventFields returns the fields common to the events of an RPC.
+func rpcEventFields(ctx context.Context, method string) map[string]any {
+	fields := map[string]any{
+		"method":   method,
+		"identity": IdentityFromContext(ctx).Name(),
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		fields["peer"] = p.Addr.String()
+	}
+	if c := connFromContext(ctx); c != nil {
+		fields["conn_id"] = c.id
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get("x-request-id"); len(v) > 0 {
+		fields["request_id"] = v[0]
+	}
+	return fields
+}
+
+// publishRPC publishes the start of an RPC and returns the function that
+// publishes its end.
+func publishRPC(ctx context.Context, method string) func(error) {
+	if !events.enabled() {
+		return func(error) {}
+	}
+	start := time.Now()
+	events.publish("rpc_start", rpcEventFields(ctx, method))
+	return func(err error) {
+		fields := rpcEventFields(ctx, method)
+		fields["code"] = status.Code(err).String()
+		fields["duration_ms"] = float64(time.Since(start).Microseconds()) / 1000
+		if err != nil {
+			fields["error"] = status.Convert(err).Message()
+		}
+		events.publish("rpc_finish", fields)
+	}
+}
+
+func eventsUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	finish := publishRPC(ctx, info.FullMethod)
+	resp, err := handler(ctx, req)
+	finish(err)
+	return resp, err
+}
+
+func eventsStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	finish := publishRPC(ss.Context(), info.FullMethod)
+	err := handler(srv, ss)
+	finish(err)
+	return err
+}
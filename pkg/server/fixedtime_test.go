@@ -0,0 +1,51 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	pb "github.com/dethi/envoy_hck/protos"
+)
+
+func TestFixedTime(t *testing.T) {
+	fixed := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, advance := range []bool{false, true} {
+		s := newTestServer(t, serviceDefaults{location: paris})
+		s.fixedTime, s.fixedAdvance = fixed, advance
+		client := timeClient(t, s)
+
+		resp, err := client.GetTime(context.Background(), &pb.TimeRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.GetCurrentTime() != "2024-03-01T12:00:00Z" || resp.GetLocalTime() != "2024-03-01T13:00:00+01:00" {
+			t.Errorf("advance %v: GetTime = %s / %s, want the fixed time", advance, resp.GetCurrentTime(), resp.GetLocalTime())
+		}
+
+		stream, err := client.StreamTime(context.Background(), &pb.TimeRequest{IntervalMs: 10, MessageCount: 3, Timezone: "UTC", Format: "rfc3339nano"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for {
+			resp, err := stream.Recv()
+			if err != nil {
+				break
+			}
+			got = append(got, resp.GetCurrentTime())
+		}
+		want := []string{"2024-03-01T12:00:00Z", "2024-03-01T12:00:00Z", "2024-03-01T12:00:00Z"}
+		if advance {
+			want = []string{"2024-03-01T12:00:00Z", "2024-03-01T12:00:00.01Z", "2024-03-01T12:00:00.02Z"}
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("advance %v: StreamTime = %v, want %v", advance, got, want)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+// flags.go
+//
+// This file contains flag.Value implementations for options that do not
+// map onto the standard flag types.
+
+package server
+
+import "strings"
+
+// ListFlag is a comma-separated list flag. Repeating the flag appends to
+// the list.
+type ListFlag []string
+
+func (l *ListFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *ListFlag) Set(v string) error {
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
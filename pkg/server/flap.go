@@ -0,0 +1,189 @@
+// flap.go
+//
+// This file implements health flapping: the health status of the whole
+// server or of some services alternates between SERVING and NOT_SERVING on
+// a schedule, optionally jittered, so Envoy's active health checking and
+// panic thresholds can be exercised unattended. Flapping starts from
+// -health-flap-interval and is controlled at runtime through /health-flap.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// flapSpec is a flapping schedule. The zero value flaps nothing.
+type flapSpec struct {
+	// ServingMs and NotServingMs are how long each status lasts, in
+	// milliseconds. NotServingMs defaults to ServingMs.
+	ServingMs    int64 `json:"serving_ms,omitempty"`
+	NotServingMs int64 `json:"not_serving_ms,omitempty"`
+	// JitterMs shifts every period by a random amount of up to this many
+	// milliseconds either way.
+	JitterMs int64 `json:"jitter_ms,omitempty"`
+	// Services are the services that flap; "" or an empty list is the
+	// whole server.
+	Services []string `json:"services,omitempty"`
+}
+
+// validate checks spec against the known services and fills in defaults.
+// Callers must hold mu.
+func (spec *flapSpec) validate() error {
+	if spec.NotServingMs == 0 {
+		spec.NotServingMs = spec.ServingMs
+	}
+	switch {
+	case spec.ServingMs <= 0 || spec.NotServingMs <= 0:
+		return fmt.Errorf("serving_ms and not_serving_ms must be positive")
+	case spec.JitterMs < 0 || spec.JitterMs >= min(spec.ServingMs, spec.NotServingMs):
+		return fmt.Errorf("jitter_ms must be between 0 and the shortest period, got %d", spec.JitterMs)
+	}
+	if len(spec.Services) == 0 {
+		spec.Services = []string{""}
+	}
+	for _, svc := range spec.Services {
+		if svc != "" && !slices.Contains(healthServices, svc) {
+			return fmt.Errorf("unknown service %q", svc)
+		}
+	}
+	return nil
+}
+
+// flapper runs at most one flapping schedule at a time.
+type flapper struct {
+	hs *health.Server
+
+	mu     sync.Mutex
+	spec   *flapSpec
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// start replaces the running schedule, if any, with spec, which must be
+// valid.
+func (f *flapper) start(spec flapSpec) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stopLocked()
+	ctx, cancel := context.WithCancel(context.Background())
+	f.spec, f.cancel, f.done = &spec, cancel, make(chan struct{})
+	go f.run(ctx, spec, f.done)
+	log.Printf("Flapping health of %q every %s/%s (jitter %s)", spec.Services,
+		time.Duration(spec.ServingMs)*time.Millisecond, time.Duration(spec.NotServingMs)*time.Millisecond, time.Duration(spec.JitterMs)*time.Millisecond)
+}
+
+// stop ends the running schedule, if any, leaving its services SERVING.
+func (f *flapper) stop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stopLocked()
+}
+
+func (f *flapper) stopLocked() {
+	if f.cancel == nil {
+		return
+	}
+	f.cancel()
+	<-f.done
+	log.Println("Stopped health flapping")
+	f.spec, f.cancel, f.done = nil, nil, nil
+}
+
+func (f *flapper) run(ctx context.Context, spec flapSpec, done chan struct{}) {
+	defer close(done)
+	defer f.set(spec.Services, true)
+	serving := true
+	for {
+		d := spec.ServingMs
+		if !serving {
+			d = spec.NotServingMs
+		}
+		if spec.JitterMs > 0 {
+			d += rand.Int64N(2*spec.JitterMs+1) - spec.JitterMs
+		}
+		t := time.NewTimer(time.Duration(d) * time.Millisecond)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+		serving = !serving
+		f.set(spec.Services, serving)
+	}
+}
+
+// set makes services SERVING or NOT_SERVING.
+func (f *flapper) set(services []string, serving bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, svc := range services {
+		switch {
+		case svc == "":
+			isHealthy.Store(serving)
+		case serving:
+			delete(serviceOverride, svc)
+		default:
+			serviceOverride[svc] = grpc_health_v1.HealthCheckResponse_NOT_SERVING
+		}
+	}
+	publishHealth(f.hs, "flapping")
+}
+
+// ServeHTTP shows the running schedule on GET, replaces it with the
+// flapSpec in the body on PUT, and stops it on DELETE.
+func (f *flapper) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var spec flapSpec
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&spec); err != nil {
+			writeJSONError(w, "invalid flap spec: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		err := spec.validate()
+		mu.Unlock()
+		if err != nil {
+			writeJSONError(w, "invalid flap spec: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.start(spec)
+	case http.MethodDelete:
+		f.stop()
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeJSONError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var spec flapSpec
+	if running := f.current(); running != nil {
+		spec = *running
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(spec)
+}
+
+// current returns a copy of the running schedule, or nil.
+func (f *flapper) current() *flapSpec {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.spec == nil {
+		return nil
+	}
+	spec := *f.spec
+	return &spec
+}
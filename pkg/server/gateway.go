@@ -0,0 +1,381 @@
+// gateway.go
+//
+// This file implements an HTTP/JSON gateway to TimeService on the HTTP
+// server, so Envoy's gRPC-JSON transcoder filter can be compared against
+// an upstream speaking JSON natively. It calls the service in process,
+// through a gRPC server of its own, and follows the transcoder's
+// conventions: query parameters map to request fields, messages are
+// encoded with protojson and failures carry the HTTP code of their gRPC
+// status, which -rest-status-map may change, and a google.rpc.Status
+// body. Streams are served as server-sent events.
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	pb "github.com/dethi/envoy_hck/protos"
+)
+
+// restGateway serves TimeService as JSON:
+//
+//	GET /v1/time?timezone=Europe/Paris         GetTime
+//	GET /v1/time/stream?interval_ms=500        StreamTime, as server-sent events
+//
+// Every request is an RPC to srv over a connection of its own, which
+// carries the address and TLS state of the HTTP client, so the
+// interceptors see the same identity as over gRPC.
+type restGateway struct {
+	srv       *grpc.Server
+	lis       *gatewayListener
+	httpCodes map[codes.Code]int // the HTTP code of each gRPC code
+}
+
+// newRESTGateway returns a gateway to srv, which must be started on its
+// lis, answering failures with the HTTP codes of httpCodes.
+func newRESTGateway(srv *grpc.Server, httpCodes map[codes.Code]int) *restGateway {
+	return &restGateway{srv: srv, lis: newGatewayListener(), httpCodes: httpCodes}
+}
+
+// register adds the gateway routes to mux.
+func (g *restGateway) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /v1/time", g.getTime)
+	mux.HandleFunc("GET /v1/time/stream", g.streamTime)
+}
+
+// client returns a client of the time service over a new connection for
+// r, to close once done.
+func (g *restGateway) client(r *http.Request) (pb.TimeServiceClient, io.Closer, error) {
+	dial := func(ctx context.Context, _ string) (net.Conn, error) { return g.lis.dial(ctx, r) }
+	conn, err := grpc.NewClient("passthrough:///rest-gateway",
+		grpc.WithContextDialer(dial),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, nil, err
+	}
+	return pb.NewTimeServiceClient(conn), conn, nil
+}
+
+// defaultHTTPCodes maps gRPC codes to HTTP status codes as the gRPC-JSON
+// transcoder does.
+var defaultHTTPCodes = map[codes.Code]int{
+	codes.OK:                 http.StatusOK,
+	codes.Canceled:           499,
+	codes.Unknown:            http.StatusInternalServerError,
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+	codes.NotFound:           http.StatusNotFound,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.FailedPrecondition: http.StatusBadRequest,
+	codes.Aborted:            http.StatusConflict,
+	codes.OutOfRange:         http.StatusBadRequest,
+	codes.Unimplemented:      http.StatusNotImplemented,
+	codes.Internal:           http.StatusInternalServerError,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.DataLoss:           http.StatusInternalServerError,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+}
+
+// gatewayListener is the in-process listener of the gateway's server.
+// Each connection is one end of a pipe whose other end a gateway request
+// dialed.
+type gatewayListener struct {
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newGatewayListener() *gatewayListener {
+	return &gatewayListener{conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+// dial returns a connection to the server for r.
+func (l *gatewayListener) dial(ctx context.Context, r *http.Request) (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case l.conns <- &gatewayConn{Conn: server, remote: remoteAddr(r.RemoteAddr), tls: r.TLS}:
+		return client, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *gatewayListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *gatewayListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *gatewayListener) Addr() net.Addr { return remoteAddr("rest-gateway") }
+
+// gatewayConn is the server end of a gateway connection, reporting the
+// HTTP client as its peer.
+type gatewayConn struct {
+	net.Conn
+	remote remoteAddr
+	tls    *tls.ConnectionState // nil over plaintext HTTP
+}
+
+func (c *gatewayConn) RemoteAddr() net.Addr { return c.remote }
+
+// parseStatusMap returns defaultHTTPCodes with the overrides of
+// -rest-status-map, each a code name and HTTP code such as
+// RESOURCE_EXHAUSTED=503.
+func parseStatusMap(pairs []string) (map[codes.Code]int, error) {
+	m := maps.Clone(defaultHTTPCodes)
+	for _, pair := range pairs {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not in CODE=STATUS form", pair)
+		}
+		var c codes.Code
+		if err := c.UnmarshalJSON([]byte(strconv.Quote(strings.ToUpper(strings.TrimSpace(name))))); err != nil {
+			return nil, fmt.Errorf("unknown status code %q", name)
+		}
+		code, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid HTTP status %q for %s", value, c)
+		}
+		m[c] = code
+	}
+	return m, nil
+}
+
+// remoteAddr is a net.Addr for the RemoteAddr of an http.Request.
+type remoteAddr string
+
+func (a remoteAddr) Network() string { return "tcp" }
+func (a remoteAddr) String() string  { return string(a) }
+
+// gatewayCreds are the transport credentials of the gateway's server: the
+// connection is in process, and its auth info is the TLS state of the HTTP
+// request.
+type gatewayCreds struct{}
+
+func (gatewayCreds) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	c, ok := conn.(*gatewayConn)
+	if !ok || c.tls == nil {
+		return conn, nil, nil
+	}
+	info := credentials.TLSInfo{State: *c.tls}
+	info.SecurityLevel = credentials.PrivacyAndIntegrity
+	return conn, info, nil
+}
+
+func (gatewayCreds) ClientHandshake(context.Context, string, net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, errors.New("gateway credentials are for the server only")
+}
+
+func (gatewayCreds) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "rest-gateway"}
+}
+
+func (c gatewayCreds) Clone() credentials.TransportCredentials { return c }
+func (gatewayCreds) OverrideServerName(string) error           { return nil }
+
+// parseQuery fills the scalar fields of msg from the query parameters of
+// r, named after either the proto or the JSON name of the field.
+func parseQuery(r *http.Request, msg proto.Message) error {
+	m := msg.ProtoReflect()
+	fields := m.Descriptor().Fields()
+	for name, values := range r.URL.Query() {
+		if name == "token" {
+			continue // consumed by -http-token
+		}
+		fd := fields.ByName(protoreflect.Name(name))
+		if fd == nil {
+			fd = fields.ByJSONName(name)
+		}
+		if fd == nil || fd.IsList() || fd.IsMap() {
+			return fmt.Errorf("unknown query parameter %q", name)
+		}
+		value := values[len(values)-1]
+		var v protoreflect.Value
+		var err error
+		switch fd.Kind() {
+		case protoreflect.StringKind:
+			v = protoreflect.ValueOfString(value)
+		case protoreflect.BoolKind:
+			var b bool
+			b, err = strconv.ParseBool(value)
+			v = protoreflect.ValueOfBool(b)
+		case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+			var n int64
+			n, err = strconv.ParseInt(value, 10, 32)
+			v = protoreflect.ValueOfInt32(int32(n))
+		case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+			var n int64
+			n, err = strconv.ParseInt(value, 10, 64)
+			v = protoreflect.ValueOfInt64(n)
+		default:
+			return fmt.Errorf("query parameter %q cannot be set from the query", name)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid query parameter %q: %v", name, err)
+		}
+		m.Set(fd, v)
+	}
+	return nil
+}
+
+// writeStatus answers with the HTTP code and google.rpc.Status body of
+// err, details included.
+func (g *restGateway) writeStatus(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(g.httpCodes[st.Code()])
+	w.Write(statusJSON(st))
+}
+
+// statusJSON is the google.rpc.Status of st in protojson, without the
+// details whose type is unknown to this binary.
+func statusJSON(st *status.Status) []byte {
+	p := st.Proto()
+	body, err := protojson.Marshal(p)
+	if err == nil {
+		return body
+	}
+	var known []*anypb.Any
+	for _, d := range p.GetDetails() {
+		if _, err := d.UnmarshalNew(); err == nil {
+			known = append(known, d)
+		}
+	}
+	p.Details = known
+	body, _ = protojson.Marshal(p)
+	return body
+}
+
+func (g *restGateway) getTime(w http.ResponseWriter, r *http.Request) {
+	req := &pb.TimeRequest{}
+	if err := parseQuery(r, req); err != nil {
+		g.writeStatus(w, status.Error(codes.InvalidArgument, err.Error()))
+		return
+	}
+	client, conn, err := g.client(r)
+	if err != nil {
+		g.writeStatus(w, status.Errorf(codes.Internal, "failed to connect to the server: %v", err))
+		return
+	}
+	defer conn.Close()
+	var header, trailer metadata.MD
+	resp, err := client.GetTime(r.Context(), req, grpc.Header(&header), grpc.Trailer(&trailer))
+	setHeaders(w, header, "")
+	setHeaders(w, trailer, http.TrailerPrefix)
+	if err != nil {
+		g.writeStatus(w, err)
+		return
+	}
+	body, err := protojson.Marshal(resp)
+	if err != nil {
+		g.writeStatus(w, status.Errorf(codes.Internal, "failed to encode response: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// streamTime serves StreamTime as server-sent events: every response is a
+// message event, and the stream ends with an error event carrying its
+// google.rpc.Status unless it ended with OK. Response headers are those
+// the stream sent before its first message; its trailers become HTTP
+// trailers.
+func (g *restGateway) streamTime(w http.ResponseWriter, r *http.Request) {
+	req := &pb.TimeRequest{}
+	if err := parseQuery(r, req); err != nil {
+		g.writeStatus(w, status.Error(codes.InvalidArgument, err.Error()))
+		return
+	}
+	client, conn, err := g.client(r)
+	if err != nil {
+		g.writeStatus(w, status.Errorf(codes.Internal, "failed to connect to the server: %v", err))
+		return
+	}
+	defer conn.Close()
+	stream, err := client.StreamTime(r.Context(), req)
+	if err != nil {
+		g.writeStatus(w, err)
+		return
+	}
+	rc := http.NewResponseController(w)
+	started := false
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			setHeaders(w, stream.Trailer(), http.TrailerPrefix)
+			if err == io.EOF {
+				return
+			}
+			if !started {
+				if header, herr := stream.Header(); herr == nil {
+					setHeaders(w, header, "")
+				}
+				g.writeStatus(w, err)
+				return
+			}
+			body := statusJSON(status.Convert(err))
+			fmt.Fprintf(w, "event: error\ndata: %s\n\n", body)
+			rc.Flush()
+			return
+		}
+		body, err := protojson.Marshal(resp)
+		if err != nil {
+			g.writeStatus(w, status.Errorf(codes.Internal, "failed to encode response: %v", err))
+			return
+		}
+		if !started {
+			started = true
+			if header, err := stream.Header(); err == nil {
+				setHeaders(w, header, "")
+			}
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.WriteHeader(http.StatusOK)
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", body); err != nil {
+			return
+		}
+		rc.Flush()
+	}
+}
+
+// setHeaders sets the response headers, or with http.TrailerPrefix the
+// trailers, of w to md.
+func setHeaders(w http.ResponseWriter, md metadata.MD, prefix string) {
+	for k, v := range md {
+		w.Header()[prefix+http.CanonicalHeaderKey(k)] = v
+	}
+}
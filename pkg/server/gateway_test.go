@@ -0,0 +1,124 @@
+package server_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/dethi/envoy_hck/pkg/faults"
+	"github.com/dethi/envoy_hck/pkg/server"
+)
+
+func withRESTGateway(c *server.Config) { c.RESTGateway = true }
+
+func TestRESTGatewayRunsInterceptors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	srv, _ := startEmbedded(t, ctx, withRESTGateway, server.WithFaultInjection())
+	defer func() {
+		cancel()
+		srv.Wait()
+	}()
+	url := "http://" + srv.HTTPAddr().String()
+
+	resp, err := http.Get(url + "/v1/time?timezone=UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body map[string]any
+	json.NewDecoder(resp.Body).Decode(&body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || body["currentTime"] == nil {
+		t.Fatalf("GET /v1/time = %d %v, want 200 with currentTime", resp.StatusCode, body)
+	}
+
+	if err := srv.Faults().Set(faults.Spec{ErrorCode: "UNAVAILABLE"}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = http.Get(url + "/v1/time")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body = nil
+	json.NewDecoder(resp.Body).Decode(&body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || body["code"] != float64(14) {
+		t.Errorf("GET /v1/time with a fault = %d %v, want 503 with code 14", resp.StatusCode, body)
+	}
+
+	resp, err = http.Get(url + "/v1/time/stream?interval_ms=10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("GET /v1/time/stream with a fault = %d, want 503", resp.StatusCode)
+	}
+
+	srv.Faults().Clear()
+	streamCtx, stopStream := context.WithCancel(ctx)
+	defer stopStream()
+	req, _ := http.NewRequestWithContext(streamCtx, "GET", url+"/v1/time/stream?interval_ms=10", nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "data: {") {
+		t.Errorf("first event = %q, %v, want a data event", line, err)
+	}
+}
+
+func TestRESTGatewayStatusMap(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	statusMap := func(c *server.Config) { c.RESTStatusMap = []string{"RESOURCE_EXHAUSTED=503"} }
+	srv, _ := startEmbedded(t, ctx, withRESTGateway, statusMap, server.WithFaultInjection())
+	defer func() {
+		cancel()
+		srv.Wait()
+	}()
+	url := "http://" + srv.HTTPAddr().String()
+
+	for _, tc := range []struct {
+		code string
+		want int
+	}{
+		{"RESOURCE_EXHAUSTED", http.StatusServiceUnavailable}, // overridden
+		{"NOT_FOUND", http.StatusNotFound},                    // default
+	} {
+		err := srv.Faults().Set(faults.Spec{
+			ErrorCode:    tc.code,
+			ErrorDetails: []json.RawMessage{json.RawMessage(`{"@type": "type.googleapis.com/google.rpc.RetryInfo", "retryDelay": "2s"}`)},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.Get(url + "/v1/time")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body struct {
+			Details []map[string]any `json:"details"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("%s: HTTP status %d, want %d", tc.code, resp.StatusCode, tc.want)
+		}
+		if len(body.Details) != 1 || body.Details[0]["retryDelay"] != "2s" {
+			t.Errorf("%s: details %v, want the RetryInfo", tc.code, body.Details)
+		}
+	}
+}
+
+func TestRESTStatusMapValidation(t *testing.T) {
+	for _, pairs := range [][]string{{"RESOURCE_EXHAUSTED"}, {"NOPE=500"}, {"UNAVAILABLE=99"}, {"UNAVAILABLE=abc"}} {
+		_, err := server.New(withRESTGateway, func(c *server.Config) { c.RESTStatusMap = pairs })
+		if err == nil {
+			t.Errorf("-rest-status-map %v accepted", pairs)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// handoff.go
+//
+// This file implements listener handoff for zero-downtime binary upgrades.
+// A running process starts its replacement with the listening sockets as
+// inherited file descriptors, so the kernel keeps queueing connections
+// while the old process drains and the new one takes over. The operator's
+// health toggle is passed along so an instance taken out of rotation stays
+// out after the upgrade.
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+const (
+	// listenFDsEnv holds the number of inherited gRPC listeners. They
+	// start at fd 3 and are followed by the HTTP listener.
+	listenFDsEnv = "ENVOY_HCK_LISTEN_FDS"
+	// healthyEnv holds the inherited health toggle.
+	healthyEnv = "ENVOY_HCK_HEALTHY"
+)
+
+// firstInheritedFD is the first descriptor after stdin, stdout and stderr.
+const firstInheritedFD = 3
+
+// inheritedListeners returns the listeners passed by a parent process, or
+// nil listeners when the process was not started by a handoff.
+func inheritedListeners() ([]net.Listener, net.Listener, error) {
+	v, ok := os.LookupEnv(listenFDsEnv)
+	if !ok {
+		return nil, nil, nil
+	}
+	os.Unsetenv(listenFDsEnv)
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return nil, nil, fmt.Errorf("invalid %s=%q", listenFDsEnv, v)
+	}
+	var all []net.Listener
+	for fd := firstInheritedFD; fd <= firstInheritedFD+n; fd++ {
+		f := os.NewFile(uintptr(fd), "listener-"+strconv.Itoa(fd))
+		lis, err := net.FileListener(f)
+		// FileListener dups the descriptor; the original is not needed.
+		f.Close()
+		if err != nil {
+			for _, l := range all {
+				l.Close()
+			}
+			return nil, nil, fmt.Errorf("inherited fd %d: %w", fd, err)
+		}
+		if unix, ok := lis.(*net.UnixListener); ok {
+			// This process owns the socket file now.
+			unix.SetUnlinkOnClose(true)
+		}
+		all = append(all, lis)
+	}
+	return all[:n], all[n], nil
+}
+
+// inheritedHealthy returns the health toggle passed by a parent process,
+// defaulting to healthy.
+func inheritedHealthy() bool {
+	v, ok := os.LookupEnv(healthyEnv)
+	if !ok {
+		return true
+	}
+	os.Unsetenv(healthyEnv)
+	healthy, err := strconv.ParseBool(v)
+	return err != nil || healthy
+}
+
+// startUpgrade starts the current binary again with the same arguments,
+// handing it grpcLis and httpLis. The caller should drain and exit once it
+// returns successfully.
+func startUpgrade(grpcLis []net.Listener, httpLis net.Listener, healthy bool) (*os.Process, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, lis := range append(grpcLis[:len(grpcLis):len(grpcLis)], httpLis) {
+		var f *os.File
+		switch lis := lis.(type) {
+		case *net.TCPListener:
+			f, err = lis.File()
+		case *net.UnixListener:
+			// The new process keeps serving on the socket file.
+			lis.SetUnlinkOnClose(false)
+			f, err = lis.File()
+		default:
+			return nil, fmt.Errorf("cannot hand off %T", lis)
+		}
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(),
+		listenFDsEnv+"="+strconv.Itoa(len(grpcLis)),
+		healthyEnv+"="+strconv.FormatBool(healthy),
+	)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return cmd.Process, nil
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+// handoff_other.go
+//
+// SIGUSR2 does not exist here, so upgrades cannot be triggered.
+
+package server
+
+import "context"
+
+func installUpgradeHandler(ctx context.Context, upgrade func()) {}
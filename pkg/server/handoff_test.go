@@ -0,0 +1,138 @@
+//go:build unix
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/dethi/envoy_hck/pkg/tlsutil"
+)
+
+// TestListenerHandoff hands two listeners to a copy of the test binary,
+// which answers on them with what it inherited.
+func TestListenerHandoff(t *testing.T) {
+	if _, ok := os.LookupEnv(listenFDsEnv); ok {
+		serveInherited()
+		return
+	}
+
+	grpcLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := os.Args
+	os.Args = []string{args[0], "-test.run=^TestListenerHandoff$"}
+	proc, err := startUpgrade([]net.Listener{grpcLis}, httpLis, false)
+	os.Args = args
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Connections queue on the sockets while both processes hold them, and
+	// the child serves them once the parent has closed its listeners.
+	grpcLis.Close()
+	httpLis.Close()
+
+	// The child answers on the gRPC listener first.
+	for _, tc := range []struct {
+		lis  net.Listener
+		want string
+	}{
+		{grpcLis, "grpc healthy=false"},
+		{httpLis, "http healthy=false"},
+	} {
+		lis, want := tc.lis, tc.want
+		conn, err := net.DialTimeout("tcp", lis.Addr().String(), 5*time.Second)
+		if err != nil {
+			t.Fatalf("dial %s after the handoff: %v", lis.Addr(), err)
+		}
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		got, err := io.ReadAll(conn)
+		conn.Close()
+		if string(got) != want {
+			t.Errorf("%s answered %q, %v, want %q", lis.Addr(), got, err, want)
+		}
+	}
+	state, err := proc.Wait()
+	if err != nil || !state.Success() {
+		t.Errorf("child exited with %v, %v", state, err)
+	}
+}
+
+// serveInherited answers one connection on each inherited listener, then
+// exits.
+func serveInherited() {
+	grpcLis, httpLis, err := inheritedListeners()
+	if err != nil || len(grpcLis) != 1 {
+		fmt.Fprintln(os.Stderr, "inherited listeners:", len(grpcLis), err)
+		os.Exit(1)
+	}
+	healthy := strconv.FormatBool(inheritedHealthy())
+	for i, lis := range []net.Listener{grpcLis[0], httpLis} {
+		name := [...]string{"grpc", "http"}[i]
+		conn, err := lis.Accept()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "accept:", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(conn, "%s healthy=%s", name, healthy)
+		conn.Close()
+	}
+	os.Exit(0)
+}
+
+// TestUpgradeStopsServer upgrades a running server to a copy of the test
+// binary, which exits at once, and checks that the server stops without
+// taking the process down.
+func TestUpgradeStopsServer(t *testing.T) {
+	if _, ok := os.LookupEnv(listenFDsEnv); ok {
+		return
+	}
+	logs := captureLog(t)
+	bundle, err := tlsutil.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := New(WithSelfSigned(bundle), WithGRPCAddr("127.0.0.1:0"), WithHTTPAddr("127.0.0.1:0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The upgrade starts os.Args[0] with os.Args[1:].
+	args := os.Args
+	os.Args = []string{args[0], "-test.run=^TestUpgradeStopsServer$"}
+	defer func() { os.Args = args }()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := srv.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR2); err != nil {
+		t.Fatal(err)
+	}
+	stopped := make(chan error, 1)
+	go func() { stopped <- srv.Wait() }()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Errorf("Wait after the upgrade: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the server did not stop after handing over")
+	}
+	if !strings.Contains(logs.String(), "handing over") {
+		t.Errorf("no handover logged:\n%s", logs)
+	}
+}
@@ -0,0 +1,32 @@
+//go:build unix
+
+// handoff_unix.go
+//
+// This file triggers a binary upgrade on SIGUSR2.
+
+package server
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// installUpgradeHandler calls upgrade when the process receives SIGUSR2,
+// until ctx is done.
+func installUpgradeHandler(ctx context.Context, upgrade func()) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGUSR2)
+	go func() {
+		defer signal.Stop(sigCh)
+		for {
+			select {
+			case <-sigCh:
+				upgrade()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
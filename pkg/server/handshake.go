@@ -0,0 +1,88 @@
+// handshake.go
+//
+// This file bounds TLS handshakes. gRPC's connection timeout puts a
+// deadline on the handshake of every accepted connection; these
+// credentials count the connections closed because it expired, such as
+// slowloris-style clients that never finish the handshake, along with the
+// outcome of every other handshake.
+
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// handshakeTimeoutCreds wraps server credentials to count handshakes by
+// outcome, log those that hit their deadline, and record all of them in
+// handshakes.
+type handshakeTimeoutCreds struct {
+	credentials.TransportCredentials
+	handshakes *handshakeLog
+}
+
+func (c handshakeTimeoutCreds) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	start := time.Now()
+	out, info, err := c.TransportCredentials.ServerHandshake(conn)
+	if err == nil {
+		tlsHandshakes.WithLabelValues("success", "").Inc()
+		if tlsInfo, ok := info.(credentials.TLSInfo); ok && tlsInfo.State.DidResume {
+			tlsResumptions.Inc()
+		}
+		c.handshakes.record(conn, start, info, "", nil)
+		return out, info, nil
+	}
+	reason := handshakeFailureReason(err)
+	tlsHandshakes.WithLabelValues("failure", reason).Inc()
+	if reason == "timeout" {
+		tlsHandshakeTimeouts.Inc()
+		log.Printf("TLS handshake from %s timed out, closing the connection", conn.RemoteAddr())
+	}
+	c.handshakes.record(conn, start, nil, reason, err)
+	return out, info, err
+}
+
+// handshakeFailureReason classifies a failed handshake. crypto/tls has no
+// error types for a missing client certificate or an alert from the
+// client, so those are recognized by their message.
+func handshakeFailureReason(err error) string {
+	var (
+		netErr    net.Error
+		recordErr tls.RecordHeaderError
+		unknownCA x509.UnknownAuthorityError
+		invalid   x509.CertificateInvalidError
+	)
+	switch {
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET):
+		return "client-closed"
+	case errors.As(err, &recordErr):
+		return "not-tls"
+	case errors.As(err, &unknownCA):
+		return "unknown-ca"
+	case errors.As(err, &invalid) && invalid.Reason == x509.Expired:
+		return "expired"
+	case errors.As(err, &invalid):
+		return "invalid-cert"
+	case strings.Contains(err.Error(), "didn't provide a certificate"):
+		return "no-client-cert"
+	case strings.Contains(err.Error(), "remote error"):
+		return "client-alert"
+	}
+	// Includes the certificate checks of the server, e.g. -client-cert-pins.
+	return "rejected"
+}
+
+func (c handshakeTimeoutCreds) Clone() credentials.TransportCredentials {
+	return handshakeTimeoutCreds{c.TransportCredentials.Clone(), c.handshakes}
+}
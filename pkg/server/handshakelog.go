@@ -0,0 +1,181 @@
+// handshakelog.go
+//
+// This file records the TLS handshakes of the gRPC listeners, so a wrong
+// Envoy upstream TLS configuration shows up instead of vanishing: what the
+// client offered in its ClientHello (SNI, ALPN, versions), what was
+// negotiated, and the precise error of failed handshakes. Failures are
+// always logged, successes with -log-handshakes, and /handshakes serves
+// the counts by outcome with the most recent failures.
+
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// handshakeFailuresKept is how many recent failures /handshakes lists.
+const handshakeFailuresKept = 50
+
+// handshakeRecord describes one handshake.
+type handshakeRecord struct {
+	Time     time.Time `json:"time"`
+	Remote   string    `json:"remote"`
+	Result   string    `json:"result"`
+	Reason   string    `json:"reason,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	SNI      string    `json:"sni,omitempty"`
+	ALPN     []string  `json:"offered_alpn,omitempty"`
+	Versions []string  `json:"offered_versions,omitempty"`
+	Version  string    `json:"version,omitempty"`
+	Cipher   string    `json:"cipher_suite,omitempty"`
+	Protocol string    `json:"negotiated_alpn,omitempty"`
+	Resumed  bool      `json:"resumed,omitempty"`
+	ClientCN string    `json:"client_subject,omitempty"`
+	ClientCA string    `json:"client_issuer,omitempty"`
+	Verified bool      `json:"client_verified,omitempty"`
+	Duration string    `json:"duration"`
+}
+
+// handshakeLog collects handshake records. The zero value is not usable;
+// see newHandshakeLog.
+type handshakeLog struct {
+	verbose bool // log successful handshakes too
+
+	// hellos holds the ClientHello of handshakes in progress, by
+	// connection.
+	hellos sync.Map
+
+	mu       sync.Mutex
+	counts   map[string]int // by result, or "failure/<reason>"
+	failures []handshakeRecord
+}
+
+func newHandshakeLog(verbose bool) *handshakeLog {
+	return &handshakeLog{verbose: verbose, counts: make(map[string]int)}
+}
+
+// configForClient wraps a tls.Config.GetConfigForClient callback, or base
+// when there is none, to capture the ClientHello of every handshake.
+func (h *handshakeLog) configForClient(base *tls.Config, next func(*tls.ClientHelloInfo) (*tls.Config, error)) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		h.hellos.Store(hello.Conn, hello)
+		if next != nil {
+			return next(hello)
+		}
+		cfg := base.Clone()
+		cfg.GetConfigForClient = nil
+		return cfg, nil
+	}
+}
+
+// record logs and keeps the outcome of the handshake of conn, which
+// started at start.
+func (h *handshakeLog) record(conn net.Conn, start time.Time, info credentials.AuthInfo, reason string, err error) {
+	elapsed := time.Since(start)
+	rec := handshakeRecord{
+		Time:     start,
+		Remote:   conn.RemoteAddr().String(),
+		Result:   "success",
+		Duration: elapsed.Round(time.Microsecond).String(),
+	}
+	if v, ok := h.hellos.LoadAndDelete(conn); ok {
+		hello := v.(*tls.ClientHelloInfo)
+		rec.SNI, rec.ALPN = hello.ServerName, hello.SupportedProtos
+		for _, version := range hello.SupportedVersions {
+			rec.Versions = append(rec.Versions, tls.VersionName(version))
+		}
+	}
+	if tlsInfo, ok := info.(credentials.TLSInfo); ok {
+		st := tlsInfo.State
+		rec.Version, rec.Cipher, rec.Protocol = tls.VersionName(st.Version), tls.CipherSuiteName(st.CipherSuite), st.NegotiatedProtocol
+		rec.Resumed = st.DidResume
+		if len(st.PeerCertificates) > 0 {
+			rec.ClientCN, rec.Verified = st.PeerCertificates[0].Subject.String(), len(st.VerifiedChains) > 0
+		}
+	}
+	key := "success"
+	if err != nil {
+		rec.Result, rec.Reason, rec.Error = "failure", reason, err.Error()
+		key = "failure/" + reason
+		if cert := rejectedCert(err); cert != nil {
+			rec.ClientCN, rec.ClientCA = cert.Subject.String(), cert.Issuer.String()
+		}
+	}
+
+	h.mu.Lock()
+	h.counts[key]++
+	if rec.Resumed {
+		h.counts["resumed"]++
+	}
+	if err != nil {
+		h.failures = append(h.failures, rec)
+		if len(h.failures) > handshakeFailuresKept {
+			h.failures = h.failures[1:]
+		}
+	}
+	h.mu.Unlock()
+
+	if events.enabled() {
+		fields := map[string]any{
+			"remote": rec.Remote, "result": rec.Result, "sni": rec.SNI, "version": rec.Version,
+			"cipher_suite": rec.Cipher, "alpn": rec.Protocol, "resumed": rec.Resumed,
+			"client_subject": rec.ClientCN, "duration_ms": elapsed.Milliseconds(),
+		}
+		if err != nil {
+			fields["reason"], fields["error"] = reason, rec.Error
+		}
+		events.publish("handshake", fields)
+	}
+
+	attrs := []any{"remote", rec.Remote, "sni", rec.SNI, "offered_alpn", rec.ALPN, "offered_versions", rec.Versions, "duration_ms", elapsed.Milliseconds()}
+	if err != nil {
+		slog.Warn("TLS handshake failed", append(attrs, "reason", reason, "error", rec.Error, "client_subject", rec.ClientCN, "client_issuer", rec.ClientCA)...)
+	} else if h.verbose {
+		kind := "full"
+		if rec.Resumed {
+			kind = "resumption"
+		}
+		slog.Info("TLS handshake", append(attrs, "kind", kind, "version", rec.Version, "cipher_suite", rec.Cipher, "alpn", rec.Protocol, "client_subject", rec.ClientCN, "client_verified", rec.Verified)...)
+	}
+}
+
+// rejectedCert returns the client certificate that failed verification in
+// err, if err says which.
+func rejectedCert(err error) *x509.Certificate {
+	var (
+		unknownCA x509.UnknownAuthorityError
+		invalid   x509.CertificateInvalidError
+	)
+	switch {
+	case errors.As(err, &unknownCA):
+		return unknownCA.Cert
+	case errors.As(err, &invalid):
+		return invalid.Cert
+	}
+	return nil
+}
+
+// ServeHTTP answers with the handshake counts and the recent failures,
+// oldest first.
+func (h *handshakeLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	out := struct {
+		Counts   map[string]int    `json:"counts"`
+		Failures []handshakeRecord `json:"recent_failures"`
+	}{maps.Clone(h.counts), slices.Clone(h.failures)}
+	h.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
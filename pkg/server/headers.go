@@ -0,0 +1,118 @@
+// headers.go
+//
+// This file attaches operator-configured metadata to the response headers
+// of every RPC, for experimenting with how Envoy handles backend-provided
+// headers, and optionally echoes the verified client identity and the
+// negotiated TLS parameters.
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// parseResponseHeaders parses key=value pairs into metadata. Keys are
+// lowercased and must be valid gRPC metadata keys outside the reserved
+// "grpc-" namespace.
+func parseResponseHeaders(pairs []string) (metadata.MD, error) {
+	md := metadata.MD{}
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("header %q is not in key=value form", pair)
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		if err := validateHeaderKey(key); err != nil {
+			return nil, err
+		}
+		md.Append(key, value)
+	}
+	return md, nil
+}
+
+func validateHeaderKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("empty header key")
+	}
+	if strings.HasPrefix(key, "grpc-") {
+		return fmt.Errorf("header %q uses the reserved grpc- prefix", key)
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return fmt.Errorf("header %q contains invalid character %q", key, r)
+		}
+	}
+	return nil
+}
+
+// responseHeaders sets md as response headers on every RPC.
+type responseHeaders metadata.MD
+
+func (h responseHeaders) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	grpc.SetHeader(ctx, metadata.MD(h))
+	return handler(ctx, req)
+}
+
+func (h responseHeaders) streamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ss.SetHeader(metadata.MD(h))
+	return handler(srv, ss)
+}
+
+// identityHeader sets the verified client identity as a response header
+// under the given key, so downstream logging can correlate it after Envoy
+// rewrites the request. Only Identity.Name is sent, never other
+// certificate fields.
+type identityHeader string
+
+func newIdentityHeader(key string) (identityHeader, error) {
+	key = strings.ToLower(strings.TrimSpace(key))
+	if err := validateHeaderKey(key); err != nil {
+		return "", err
+	}
+	return identityHeader(key), nil
+}
+
+func (h identityHeader) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	grpc.SetHeader(ctx, metadata.Pairs(string(h), IdentityFromContext(ctx).Name()))
+	return handler(ctx, req)
+}
+
+func (h identityHeader) streamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ss.SetHeader(metadata.Pairs(string(h), IdentityFromContext(ss.Context()).Name()))
+	return handler(srv, ss)
+}
+
+// tlsResponseHeaders returns the negotiated TLS version and cipher suite of
+// the connection an RPC arrived on, as x-tls-version and x-tls-cipher.
+func tlsResponseHeaders(ctx context.Context) metadata.MD {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil
+	}
+	return metadata.Pairs(
+		"x-tls-version", tls.VersionName(info.State.Version),
+		"x-tls-cipher", tls.CipherSuiteName(info.State.CipherSuite),
+	)
+}
+
+func tlsHeadersUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	grpc.SetHeader(ctx, tlsResponseHeaders(ctx))
+	return handler(ctx, req)
+}
+
+func tlsHeadersStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ss.SetHeader(tlsResponseHeaders(ss.Context()))
+	return handler(srv, ss)
+}
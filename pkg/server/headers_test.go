@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/dethi/envoy_hck/protos"
+)
+
+func TestResponseHeaders(t *testing.T) {
+	md, err := parseResponseHeaders([]string{"X-Env=staging", "x-multi=a", "x-multi=b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := responseHeaders(md)
+	client := timeClient(t, newTestServer(t, serviceDefaults{}),
+		grpc.ChainUnaryInterceptor(h.unaryInterceptor), grpc.ChainStreamInterceptor(h.streamInterceptor))
+
+	var header metadata.MD
+	if _, err := client.GetTime(context.Background(), &pb.TimeRequest{}, grpc.Header(&header)); err != nil {
+		t.Fatal(err)
+	}
+	stream, err := client.StreamTime(context.Background(), &pb.TimeRequest{IntervalMs: 1, MessageCount: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamHeader, err := stream.Header()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rpc, got := range map[string]metadata.MD{"GetTime": header, "StreamTime": streamHeader} {
+		if v := got.Get("x-env"); !slices.Equal(v, []string{"staging"}) {
+			t.Errorf("%s: x-env = %q, want staging", rpc, v)
+		}
+		if v := got.Get("x-multi"); !slices.Equal(v, []string{"a", "b"}) {
+			t.Errorf("%s: x-multi = %q, want a and b", rpc, v)
+		}
+	}
+
+	for _, pair := range []string{"x-env", "=v", "grpc-status=0", "x env=v", "x-é=v"} {
+		if _, err := parseResponseHeaders([]string{pair}); err == nil {
+			t.Errorf("header %q accepted", pair)
+		}
+	}
+}
+
+func TestMaxHeaderListSize(t *testing.T) {
+	client := timeClient(t, newTestServer(t, serviceDefaults{}), grpc.MaxHeaderListSize(1<<10))
+	small := metadata.AppendToOutgoingContext(context.Background(), "x-small", "ok")
+	if _, err := client.GetTime(small, &pb.TimeRequest{}); err != nil {
+		t.Fatalf("small metadata: %v", err)
+	}
+	big := metadata.AppendToOutgoingContext(context.Background(), "x-big", strings.Repeat("a", 4<<10))
+	// The limit is advertised in the HTTP/2 settings, so the client
+	// refuses to send the request.
+	_, err := client.GetTime(big, &pb.TimeRequest{})
+	if st := status.Convert(err); st.Code() != codes.Internal || !strings.Contains(st.Message(), "maximum size (1024 bytes) set by server") {
+		t.Errorf("4KiB of metadata under a 1KiB limit: %v, want INTERNAL naming the limit", err)
+	}
+}
+
+func TestIdentityHeader(t *testing.T) {
+	h, err := newIdentityHeader("X-Verified-Client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unaryPeer, streamPeer := injectPeer(clientCert(t, "tenant-a", "tenant-a.example.com"))
+	client := timeClient(t, newTestServer(t, serviceDefaults{}),
+		grpc.ChainUnaryInterceptor(unaryPeer, identityUnaryInterceptor, h.unaryInterceptor),
+		grpc.ChainStreamInterceptor(streamPeer, identityStreamInterceptor, h.streamInterceptor))
+
+	var header metadata.MD
+	if _, err := client.GetTime(context.Background(), &pb.TimeRequest{}, grpc.Header(&header)); err != nil {
+		t.Fatal(err)
+	}
+	stream, err := client.StreamTime(context.Background(), &pb.TimeRequest{IntervalMs: 1, MessageCount: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamHeader, err := stream.Header()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rpc, got := range map[string]metadata.MD{"GetTime": header, "StreamTime": streamHeader} {
+		if v := got.Get("x-verified-client"); !slices.Equal(v, []string{"tenant-a"}) {
+			t.Errorf("%s: x-verified-client = %q, want the CN tenant-a", rpc, v)
+		}
+		for key, values := range got {
+			for _, v := range values {
+				if strings.Contains(v, "example.com") {
+					t.Errorf("%s: header %s leaks the SAN: %q", rpc, key, v)
+				}
+			}
+		}
+	}
+	if _, err := newIdentityHeader("grpc-identity"); err == nil {
+		t.Error("a reserved identity header key was accepted")
+	}
+}
@@ -0,0 +1,288 @@
+// health.go
+//
+// This file holds the state behind the gRPC health service. The reported
+// status combines the operator's health toggle with leadership and the
+// send circuit breaker, so for example a healthy standby instance still
+// reports NOT_SERVING. Each registered service can additionally be taken
+// out of service on its own through the HTTP API served here.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"maps"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+var (
+	// mu serializes health transitions so that the flags below and the
+	// health server's status never disagree. Readers load the flags
+	// without it.
+	mu          sync.Mutex
+	isHealthy   atomic.Bool
+	isLeader    atomic.Bool
+	breakerOpen atomic.Bool
+
+	// shuttingDown latches the status to NOT_SERVING once shutdown starts,
+	// so a concurrent health toggle cannot put the endpoint back into
+	// rotation while it drains.
+	shuttingDown atomic.Bool
+
+	// healthServices are the services reported besides the overall ""
+	// status, and serviceOverride the status an operator forced on some of
+	// them, NOT_SERVING or UNKNOWN. Both are guarded by mu.
+	healthServices  []string
+	serviceOverride = map[string]grpc_health_v1.HealthCheckResponse_ServingStatus{}
+
+	// published is the status last set on the health server per service,
+	// guarded by mu.
+	published = map[string]grpc_health_v1.HealthCheckResponse_ServingStatus{}
+)
+
+// healthStatuses returns the status of the overall server, under "", and of
+// every service in healthServices. Services are only SERVING while the
+// server is and report their override, if any, otherwise. Callers must
+// hold mu.
+func healthStatuses() map[string]grpc_health_v1.HealthCheckResponse_ServingStatus {
+	status := func(serving bool) grpc_health_v1.HealthCheckResponse_ServingStatus {
+		if serving {
+			return grpc_health_v1.HealthCheckResponse_SERVING
+		}
+		return grpc_health_v1.HealthCheckResponse_NOT_SERVING
+	}
+	overall := isHealthy.Load() && isLeader.Load() && !breakerOpen.Load() && !shuttingDown.Load()
+	statuses := map[string]grpc_health_v1.HealthCheckResponse_ServingStatus{"": status(overall)}
+	for _, svc := range healthServices {
+		if override, ok := serviceOverride[svc]; ok {
+			statuses[svc] = override
+		} else {
+			statuses[svc] = status(overall)
+		}
+	}
+	return statuses
+}
+
+// publishHealth sets the status of hs from the current flags. It is the
+// only place statuses change, so every transition is logged as a
+// structured event and counted, attributed to trigger (e.g.
+// "toggle-endpoint", "shutdown"). Callers must hold mu.
+func publishHealth(hs *health.Server, trigger string) {
+	statuses := healthStatuses()
+	for _, svc := range slices.Sorted(maps.Keys(statuses)) {
+		status := statuses[svc]
+		old, ok := published[svc]
+		if ok && old == status {
+			continue
+		}
+		published[svc] = status
+		hs.SetServingStatus(svc, status)
+		if !ok {
+			old = grpc_health_v1.HealthCheckResponse_UNKNOWN
+		}
+		slog.Info("Health transition", "service", svc, "old", old.String(), "new", status.String(), "trigger", trigger)
+		healthTransitions.WithLabelValues(svc, status.String(), trigger).Inc()
+		events.publish("health", map[string]any{"service": svc, "old": old.String(), "new": status.String(), "trigger": trigger})
+	}
+	for _, inst := range clusterInstances {
+		inst.publish(statuses, trigger)
+	}
+}
+
+// healthAPI lists and sets health over HTTP:
+//
+//	GET  /health                            statuses of all services
+//	PUT  /health                            {"status": "NOT_SERVING"} for the whole server
+//	PUT  /health/{service}                  {"status": "UNKNOWN"} for one service
+//	POST /health/{service}?status=NOT_SERVING
+//
+// PUT takes the status as JSON or as the status form value and always
+// answers with JSON; POST is the older form-only variant.
+type healthAPI struct {
+	hs *health.Server
+}
+
+// parseServingStatus resolves a status name, case-insensitively, among
+// allowed.
+func parseServingStatus(name string, allowed ...grpc_health_v1.HealthCheckResponse_ServingStatus) (grpc_health_v1.HealthCheckResponse_ServingStatus, error) {
+	v, ok := grpc_health_v1.HealthCheckResponse_ServingStatus_value[strings.ToUpper(name)]
+	status := grpc_health_v1.HealthCheckResponse_ServingStatus(v)
+	if !ok || !slices.Contains(allowed, status) {
+		names := make([]string, len(allowed))
+		for i, s := range allowed {
+			names[i] = s.String()
+		}
+		last := len(names) - 1
+		return 0, fmt.Errorf("status must be %s or %s", strings.Join(names[:last], ", "), names[last])
+	}
+	return status, nil
+}
+
+// requestStatus returns the status of a PUT request, taken from its JSON
+// body or its status form value.
+func requestStatus(r *http.Request) string {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+		var body struct {
+			Status string `json:"status"`
+		}
+		if json.NewDecoder(r.Body).Decode(&body) == nil {
+			return body.Status
+		}
+		return ""
+	}
+	return r.FormValue("status")
+}
+
+// writeJSONError answers with {"error": msg}.
+func writeJSONError(w http.ResponseWriter, msg string, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+func (a healthAPI) list(w http.ResponseWriter, r *http.Request) {
+	mu.Lock()
+	statuses := healthStatuses()
+	mu.Unlock()
+	out := make(map[string]string, len(statuses))
+	for svc, status := range statuses {
+		out[svc] = status.String()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+func (a healthAPI) set(w http.ResponseWriter, r *http.Request) {
+	svc := r.PathValue("service")
+	status, err := parseServingStatus(r.FormValue("status"),
+		grpc_health_v1.HealthCheckResponse_SERVING, grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Contains(healthServices, svc) {
+		http.Error(w, fmt.Sprintf("unknown service %q", svc), http.StatusNotFound)
+		return
+	}
+	if shuttingDown.Load() {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	a.override(svc, status)
+	writeHealthResult(w, r, svc, fmt.Sprintf("Health of %s is now %s", svc, healthStatuses()[svc]))
+}
+
+// put handles PUT /health and PUT /health/{service}.
+func (a healthAPI) put(w http.ResponseWriter, r *http.Request) {
+	svc := r.PathValue("service")
+	allowed := []grpc_health_v1.HealthCheckResponse_ServingStatus{
+		grpc_health_v1.HealthCheckResponse_SERVING, grpc_health_v1.HealthCheckResponse_NOT_SERVING,
+	}
+	if svc != "" {
+		allowed = append(allowed, grpc_health_v1.HealthCheckResponse_UNKNOWN)
+	}
+	status, err := parseServingStatus(requestStatus(r), allowed...)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if svc != "" && !slices.Contains(healthServices, svc) {
+		writeJSONError(w, fmt.Sprintf("unknown service %q", svc), http.StatusNotFound)
+		return
+	}
+	if shuttingDown.Load() {
+		writeJSONError(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if svc == "" {
+		isHealthy.Store(status == grpc_health_v1.HealthCheckResponse_SERVING)
+		publishHealth(a.hs, "health-api")
+		log.Printf("Health status set to %s", status)
+	} else {
+		a.override(svc, status)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"service": svc, "status": healthStatuses()[svc].String()})
+}
+
+// override forces the status of svc, or lifts its override for SERVING.
+// Callers must hold mu.
+func (a healthAPI) override(svc string, status grpc_health_v1.HealthCheckResponse_ServingStatus) {
+	if status == grpc_health_v1.HealthCheckResponse_SERVING {
+		delete(serviceOverride, svc)
+	} else {
+		serviceOverride[svc] = status
+	}
+	publishHealth(a.hs, "health-api")
+	log.Printf("Health of %s set to %s", svc, healthStatuses()[svc])
+}
+
+// acceptsJSON reports whether the Accept header of r lists
+// application/json.
+func acceptsJSON(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			if mediaType, _, err := mime.ParseMediaType(mediaRange); err == nil && mediaType == "application/json" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeHealthResult answers a health change with the resulting status of
+// svc as JSON if the client accepts it, and with the text line otherwise.
+// Callers must hold mu.
+func writeHealthResult(w http.ResponseWriter, r *http.Request, svc, text string) {
+	if !acceptsJSON(r) {
+		fmt.Fprintln(w, text)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"service": svc, "status": healthStatuses()[svc].String()})
+}
+
+// drainer broadcasts a request to end active streams. The zero value is
+// ready to use.
+type drainer struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+// C returns a channel that is closed by the next call to Drain. Streams
+// should fetch it once when they start.
+func (d *drainer) C() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.ch == nil {
+		d.ch = make(chan struct{})
+	}
+	return d.ch
+}
+
+// Drain ends every stream currently waiting on C. Streams started later
+// are unaffected.
+func (d *drainer) Drain() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.ch != nil {
+		close(d.ch)
+		d.ch = nil
+	}
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// BenchmarkHealthRead compares reading the health flag from every RPC
+// under the toggle lock with the lock-free load the server does, while a
+// writer toggles it.
+func BenchmarkHealthRead(b *testing.B) {
+	toggle := func(b *testing.B) {
+		stop := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(time.Millisecond)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					mu.Lock()
+					isHealthy.Store(!isHealthy.Load())
+					mu.Unlock()
+				case <-stop:
+					return
+				}
+			}
+		}()
+		b.Cleanup(func() {
+			close(stop)
+			wg.Wait()
+		})
+	}
+
+	b.Run("mutex", func(b *testing.B) {
+		toggle(b)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				mu.Lock()
+				_ = isHealthy.Load()
+				mu.Unlock()
+			}
+		})
+	})
+	b.Run("atomic", func(b *testing.B) {
+		toggle(b)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				_ = isHealthy.Load()
+			}
+		})
+	})
+}
+
+func TestHealthAPIServices(t *testing.T) {
+	healthyProcess(t)
+	hs := health.NewServer()
+	mu.Lock()
+	healthServices = []string{"a.Service", "b.Service"}
+	publishHealth(hs, "test")
+	mu.Unlock()
+	api := healthAPI{hs: hs}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", api.list)
+	mux.HandleFunc("POST /health/{service}", api.set)
+
+	list := func() map[string]string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", "/health", nil))
+		var statuses map[string]string
+		if err := json.NewDecoder(rec.Body).Decode(&statuses); err != nil {
+			t.Fatal(err)
+		}
+		return statuses
+	}
+	set := func(svc, status string) int {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/health/"+svc, strings.NewReader("status="+status))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	check := func(svc string) grpc_health_v1.HealthCheckResponse_ServingStatus {
+		t.Helper()
+		resp, err := hs.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: svc})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.GetStatus()
+	}
+
+	want := map[string]string{"": "SERVING", "a.Service": "SERVING", "b.Service": "SERVING"}
+	if got := list(); !maps.Equal(got, want) {
+		t.Fatalf("GET /health = %v, want %v", got, want)
+	}
+	if code := set("a.Service", "NOT_SERVING"); code != http.StatusOK {
+		t.Fatalf("POST /health/a.Service = %d", code)
+	}
+	want["a.Service"] = "NOT_SERVING"
+	if got := list(); !maps.Equal(got, want) {
+		t.Errorf("after setting a.Service: %v, want %v", got, want)
+	}
+	if check("a.Service") != grpc_health_v1.HealthCheckResponse_NOT_SERVING || check("b.Service") != grpc_health_v1.HealthCheckResponse_SERVING {
+		t.Error("the health server does not reflect the per-service statuses")
+	}
+
+	if code := set("a.Service", "SERVING"); code != http.StatusOK {
+		t.Fatalf("POST /health/a.Service = %d", code)
+	}
+	if got := list()["a.Service"]; got != "SERVING" {
+		t.Errorf("a.Service back to %s, want SERVING", got)
+	}
+	if code := set("c.Service", "SERVING"); code != http.StatusNotFound {
+		t.Errorf("POST /health/c.Service = %d, want 404", code)
+	}
+	if code := set("b.Service", "MAYBE"); code != http.StatusBadRequest {
+		t.Errorf("POST with status MAYBE = %d, want 400", code)
+	}
+}
+
+func TestHealthTransitionEvents(t *testing.T) {
+	logs := captureSlog(t)
+	healthyProcess(t)
+	hs := health.NewServer()
+	const trigger = "transition-test"
+	mu.Lock()
+	publishHealth(hs, trigger)
+	isHealthy.Store(false)
+	publishHealth(hs, trigger)
+	// Publishing an unchanged status is not a transition.
+	publishHealth(hs, trigger)
+	mu.Unlock()
+
+	type event struct {
+		Msg, Service, Old, New, Trigger string
+	}
+	var got []event
+	dec := json.NewDecoder(strings.NewReader(logs.String()))
+	for dec.More() {
+		var e event
+		if err := dec.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Msg == "Health transition" {
+			got = append(got, e)
+		}
+	}
+	want := []event{
+		{"Health transition", "", "UNKNOWN", "SERVING", trigger},
+		{"Health transition", "", "SERVING", "NOT_SERVING", trigger},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("logged transitions %+v, want %+v", got, want)
+	}
+	body := scrape(t)
+	for _, status := range []string{"SERVING", "NOT_SERVING"} {
+		series := `health_transitions_total{service="",status="` + status + `",trigger="` + trigger + `"} 1`
+		if !strings.Contains(body, series) {
+			t.Errorf("/metrics lacks %s", series)
+		}
+	}
+}
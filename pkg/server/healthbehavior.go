@@ -0,0 +1,226 @@
+// healthbehavior.go
+//
+// This file bends the answers of the gRPC health service, to reproduce the
+// edge cases health checkers must cope with: a Watch stream whose first
+// response comes late, Watch streams the server drops after a number of
+// updates, and the two ways of answering for a service the server does not
+// know. The behavior starts from the -health-watch-delay,
+// -health-watch-max-updates and -health-unknown-services flags and is
+// changed at runtime through /health-behavior:
+//
+//	curl -X PUT localhost:8081/health-behavior -d '{"watch_delay_ms": 3000, "watch_max_updates": 2}'
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// unknownServiceModes are how the health service can answer for a service
+// it does not know:
+//
+//   - spec: Check fails with NOT_FOUND and Watch reports SERVICE_UNKNOWN,
+//     as the health checking protocol says;
+//   - service-unknown: Check, too, answers SERVICE_UNKNOWN;
+//   - not-found: Watch, too, fails with NOT_FOUND, like servers predating
+//     Watch's SERVICE_UNKNOWN.
+var unknownServiceModes = []string{"spec", "service-unknown", "not-found"}
+
+// healthBehaviorSpec describes how the health service deviates from its
+// defaults. The zero value deviates from nothing.
+type healthBehaviorSpec struct {
+	// WatchDelayMs delays the first response of every Watch stream by this
+	// many milliseconds.
+	WatchDelayMs int64 `json:"watch_delay_ms,omitempty"`
+	// WatchMaxUpdates ends Watch streams with UNAVAILABLE once they have
+	// sent this many responses, the initial one included.
+	WatchMaxUpdates int `json:"watch_max_updates,omitempty"`
+	// UnknownServices is one of unknownServiceModes, spec if empty.
+	UnknownServices string `json:"unknown_services,omitempty"`
+}
+
+// validate checks spec.
+func (spec *healthBehaviorSpec) validate() error {
+	switch {
+	case spec.WatchDelayMs < 0:
+		return fmt.Errorf("watch_delay_ms must not be negative, got %d", spec.WatchDelayMs)
+	case spec.WatchMaxUpdates < 0:
+		return fmt.Errorf("watch_max_updates must not be negative, got %d", spec.WatchMaxUpdates)
+	case spec.UnknownServices != "" && !slices.Contains(unknownServiceModes, spec.UnknownServices):
+		return fmt.Errorf("unknown_services must be one of %q, got %q", unknownServiceModes, spec.UnknownServices)
+	}
+	return nil
+}
+
+// healthBehavior is the health service as registered on the gRPC
+// servers: the health server, answering as the current spec says.
+type healthBehavior struct {
+	*health.Server
+
+	mu   sync.Mutex
+	spec healthBehaviorSpec
+}
+
+func (h *healthBehavior) current() healthBehaviorSpec {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.spec
+}
+
+// set replaces the spec, which must be valid.
+func (h *healthBehavior) set(spec healthBehaviorSpec) {
+	h.mu.Lock()
+	h.spec = spec
+	h.mu.Unlock()
+	body, _ := json.Marshal(spec)
+	log.Printf("Health service behavior set to %s", body)
+}
+
+// healthServiceKnown reports whether the health server has a status for
+// svc.
+func healthServiceKnown(svc string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	_, ok := published[svc]
+	return ok
+}
+
+func (h *healthBehavior) Check(ctx context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	return h.check(h.Server, ctx, req)
+}
+
+func (h *healthBehavior) Watch(req *grpc_health_v1.HealthCheckRequest, stream grpc_health_v1.Health_WatchServer) error {
+	return h.watch(h.Server, req, stream)
+}
+
+// on returns the health service answering with the statuses of hs but
+// behaving as h says, for the instances of cluster mode.
+func (h *healthBehavior) on(hs *health.Server) grpc_health_v1.HealthServer {
+	return healthBehaviorOn{Server: hs, behavior: h}
+}
+
+type healthBehaviorOn struct {
+	*health.Server
+	behavior *healthBehavior
+}
+
+func (h healthBehaviorOn) Check(ctx context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	return h.behavior.check(h.Server, ctx, req)
+}
+
+func (h healthBehaviorOn) Watch(req *grpc_health_v1.HealthCheckRequest, stream grpc_health_v1.Health_WatchServer) error {
+	return h.behavior.watch(h.Server, req, stream)
+}
+
+func (h *healthBehavior) check(hs *health.Server, ctx context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	resp, err := hs.Check(ctx, req)
+	if status.Code(err) == codes.NotFound && h.current().UnknownServices == "service-unknown" {
+		return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVICE_UNKNOWN}, nil
+	}
+	return resp, err
+}
+
+func (h *healthBehavior) watch(hs *health.Server, req *grpc_health_v1.HealthCheckRequest, stream grpc_health_v1.Health_WatchServer) error {
+	spec := h.current()
+	if spec.UnknownServices == "not-found" && !healthServiceKnown(req.GetService()) {
+		return status.Error(codes.NotFound, "unknown service")
+	}
+	if spec.WatchDelayMs == 0 && spec.WatchMaxUpdates == 0 {
+		return hs.Watch(req, stream)
+	}
+	// The health server ends Watch when its context is done, so the
+	// stream is cut by canceling a context of its own.
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	ws := &watchStream{
+		Health_WatchServer: stream,
+		ctx:                ctx,
+		cancel:             cancel,
+		delay:              time.Duration(spec.WatchDelayMs) * time.Millisecond,
+		remaining:          spec.WatchMaxUpdates,
+	}
+	err := hs.Watch(req, ws)
+	if ws.dropped {
+		return status.Errorf(codes.Unavailable, "health watch dropped after %d update(s)", spec.WatchMaxUpdates)
+	}
+	return err
+}
+
+// watchStream delays the first response of a Watch stream and cuts the
+// stream after its remaining responses, if any. The health server sends
+// from the Watch goroutine only.
+type watchStream struct {
+	grpc_health_v1.Health_WatchServer
+	ctx       context.Context
+	cancel    context.CancelFunc
+	delay     time.Duration
+	remaining int // 0 for unlimited
+	dropped   bool
+}
+
+func (s *watchStream) Context() context.Context { return s.ctx }
+
+func (s *watchStream) Send(resp *grpc_health_v1.HealthCheckResponse) error {
+	if s.delay > 0 {
+		t := time.NewTimer(s.delay)
+		s.delay = 0
+		select {
+		case <-t.C:
+		case <-s.ctx.Done():
+			t.Stop()
+			return s.ctx.Err()
+		}
+	}
+	if err := s.Health_WatchServer.Send(resp); err != nil {
+		return err
+	}
+	if s.remaining > 0 {
+		if s.remaining--; s.remaining == 0 {
+			s.dropped = true
+			s.cancel()
+		}
+	}
+	return nil
+}
+
+// ServeHTTP shows the behavior on GET, replaces it with the
+// healthBehaviorSpec in the body on PUT, and resets it on DELETE.
+func (h *healthBehavior) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var spec healthBehaviorSpec
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&spec); err != nil {
+			writeJSONError(w, "invalid health behavior: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := spec.validate(); err != nil {
+			writeJSONError(w, "invalid health behavior: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.set(spec)
+	case http.MethodDelete:
+		h.set(healthBehaviorSpec{})
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeJSONError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	spec := h.current()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(spec)
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"log"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/dethi/envoy_hck/pkg/tlsutil"
+	pb "github.com/dethi/envoy_hck/protos"
+)
+
+// bufconnClient serves srv in memory and returns a connection to it,
+// dialed with opts.
+func bufconnClient(t *testing.T, srv *grpc.Server, opts ...grpc.DialOption) *grpc.ClientConn {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	opts = append([]grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	}, opts...)
+	conn, err := grpc.NewClient("passthrough:///bufconn", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// timeClient serves s as TimeService in memory, with opts, and returns a
+// client of it.
+func timeClient(t *testing.T, s *server, opts ...grpc.ServerOption) pb.TimeServiceClient {
+	t.Helper()
+	srv := grpc.NewServer(opts...)
+	pb.RegisterTimeServiceServer(srv, s)
+	return pb.NewTimeServiceClient(bufconnClient(t, srv))
+}
+
+// newTestServer returns a time service with defaults, failing t if they
+// are invalid.
+func newTestServer(t *testing.T, defaults serviceDefaults) *server {
+	t.Helper()
+	s, err := newServer(defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// As start does, with the default -metrics-identity-limit.
+	s.tickLabels = newIdentityLabeler(100)
+	return s
+}
+
+// healthyProcess resets the process-wide health state to a healthy
+// leader, as after startup, and resets it again once t is done.
+func healthyProcess(t *testing.T) {
+	t.Helper()
+	resetProcessState()
+	isHealthy.Store(true)
+	isLeader.Store(true)
+	t.Cleanup(resetProcessState)
+}
+
+// overallStatus returns the status hs reports for the whole server.
+func overallStatus(t *testing.T, hs *health.Server) grpc_health_v1.HealthCheckResponse_ServingStatus {
+	t.Helper()
+	resp, err := hs.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.GetStatus()
+}
+
+// clientCert issues a client certificate for cn and sans from a new CA.
+func clientCert(t *testing.T, cn string, sans ...string) *x509.Certificate {
+	t.Helper()
+	ca, err := tlsutil.GenerateCert(tlsutil.CATemplate("test CA", time.Hour), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := tlsutil.GenerateCert(tlsutil.LeafTemplate(cn, sans, x509.ExtKeyUsageClientAuth, time.Hour), ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.Cert
+}
+
+// tlsPeerContext returns an incoming RPC context whose peer presented
+// the given certificates over TLS, as the gRPC transport sets it.
+func tlsPeerContext(certs ...*x509.Certificate) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{
+		Addr:     &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000},
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: certs}},
+	})
+}
+
+// logBuffer collects log output; it is safe for concurrent use.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// captureLog redirects the standard logger to a buffer until t is done.
+func captureLog(t *testing.T) *logBuffer {
+	t.Helper()
+	b := &logBuffer{}
+	prev := log.Writer()
+	log.SetOutput(b)
+	t.Cleanup(func() { log.SetOutput(prev) })
+	return b
+}
+
+// captureSlog makes the default slog logger write JSON to a buffer until
+// t is done.
+func captureSlog(t *testing.T) *logBuffer {
+	t.Helper()
+	b := &logBuffer{}
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(b, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	return b
+}
+
+// injectPeer returns interceptors that make RPCs look as if they arrived
+// over TLS from a client presenting cert.
+func injectPeer(cert *x509.Certificate) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	withPeer := func(ctx context.Context) context.Context {
+		p, _ := peer.FromContext(tlsPeerContext(cert))
+		return peer.NewContext(ctx, p)
+	}
+	unary := func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		return handler(withPeer(ctx), req)
+	}
+	stream := func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &contextStream{ServerStream: ss, ctx: withPeer(ss.Context())})
+	}
+	return unary, stream
+}
+
+// withTestIdentity returns a context carrying the identity of a client
+// whose certificate has the common name name.
+func withTestIdentity(name string) context.Context {
+	return context.WithValue(context.Background(), identityKey{}, Identity{HasCert: true, CommonName: name})
+}
@@ -0,0 +1,130 @@
+// identity.go
+//
+// This file resolves the mTLS identity of the client behind each RPC. An
+// interceptor extracts it from the peer certificate once and stores it in
+// the RPC context, where handlers and other interceptors read it with
+// IdentityFromContext.
+
+package server
+
+import (
+	"context"
+	"encoding/asn1"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+
+	"github.com/dethi/envoy_hck/pkg/tlsutil"
+)
+
+// Identity describes the client certificate presented on an RPC's
+// connection. It is the zero value when the client sent no certificate,
+// which -client-auth=request allows.
+type Identity struct {
+	HasCert    bool     `json:"has_cert"` // whether the client presented a certificate
+	CommonName string   `json:"common_name,omitempty"`
+	DNSNames   []string `json:"dns_names,omitempty"`
+	URIs       []string `json:"uris,omitempty"`
+	SPIFFEID   string   `json:"spiffe_id,omitempty"` // the spiffe:// URI SAN, if any
+	Extension  string   `json:"extension,omitempty"` // value of the identityExtension extension, if any
+}
+
+// identityExtension, when set, is the OID of the custom certificate
+// extension (e.g. a tenant) copied into Identity.Extension.
+var identityExtension asn1.ObjectIdentifier
+
+// Name returns the most specific name of the identity: its SPIFFE ID, then
+// its common name, or "unknown" if it has neither.
+func (id Identity) Name() string {
+	switch {
+	case id.SPIFFEID != "":
+		return id.SPIFFEID
+	case id.CommonName != "":
+		return id.CommonName
+	default:
+		return "unknown"
+	}
+}
+
+type identityKey struct{}
+
+// IdentityFromContext returns the client identity of the RPC in ctx. It
+// uses the value stored by the identity interceptor when present and
+// otherwise that of the connection.
+func IdentityFromContext(ctx context.Context) Identity {
+	if id, ok := ctx.Value(identityKey{}).(Identity); ok {
+		return id
+	}
+	return connIdentity(ctx)
+}
+
+// connIdentity returns the identity cached on the connection of the RPC in
+// ctx, extracting it from the peer if the connection was not tagged.
+func connIdentity(ctx context.Context) Identity {
+	if c := connFromContext(ctx); c != nil {
+		return c.identity
+	}
+	return peerIdentity(ctx)
+}
+
+// peerIdentity extracts the identity from the leaf certificate of the
+// peer in ctx.
+func peerIdentity(ctx context.Context) Identity {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return Identity{}
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.PeerCertificates) == 0 {
+		return Identity{}
+	}
+	leaf := info.State.PeerCertificates[0]
+	id := Identity{
+		HasCert:    true,
+		CommonName: leaf.Subject.CommonName,
+		DNSNames:   leaf.DNSNames,
+	}
+	if identityExtension != nil {
+		id.Extension, _ = tlsutil.CertExtension(leaf, identityExtension)
+	}
+	for _, uri := range leaf.URIs {
+		id.URIs = append(id.URIs, uri.String())
+		if uri.Scheme == "spiffe" && id.SPIFFEID == "" {
+			id.SPIFFEID = uri.String()
+		}
+	}
+	return id
+}
+
+// withIdentity returns ctx carrying the identity of its peer, and counts
+// RPCs from clients without a certificate.
+func withIdentity(ctx context.Context, method string) context.Context {
+	id := connIdentity(ctx)
+	if !id.HasCert {
+		rpcWithoutClientCert.WithLabelValues(method).Inc()
+	}
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// identityUnaryInterceptor stores the client identity in the RPC context.
+// It should run first so later interceptors can rely on it.
+func identityUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	return handler(withIdentity(ctx, info.FullMethod), req)
+}
+
+// identityStreamInterceptor is the streaming counterpart of
+// identityUnaryInterceptor.
+func identityStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &contextStream{ServerStream: ss, ctx: withIdentity(ss.Context(), info.FullMethod)})
+}
+
+// contextStream overrides the context of a grpc.ServerStream.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
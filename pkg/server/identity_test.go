@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"net"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/stats"
+
+	"github.com/dethi/envoy_hck/pkg/tlsutil"
+)
+
+func TestIdentityFromContext(t *testing.T) {
+	cert := clientCert(t, "tenant-a", "tenant-a.example.com", "spiffe://example.com/ns/prod/sa/tenant-a")
+	var got Identity
+	handler := func(ctx context.Context, _ any) (any, error) {
+		got = IdentityFromContext(ctx)
+		return nil, nil
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Identity/Get"}
+	if _, err := identityUnaryInterceptor(tlsPeerContext(cert), nil, info, handler); err != nil {
+		t.Fatal(err)
+	}
+	if !got.HasCert || got.CommonName != "tenant-a" || got.SPIFFEID != "spiffe://example.com/ns/prod/sa/tenant-a" ||
+		!slices.Equal(got.DNSNames, []string{"tenant-a.example.com"}) || len(got.URIs) != 1 {
+		t.Errorf("identity %+v does not match the certificate", got)
+	}
+	if got.Name() != got.SPIFFEID {
+		t.Errorf("Name() = %q, want the SPIFFE ID", got.Name())
+	}
+
+	if _, err := identityUnaryInterceptor(context.Background(), nil, info, handler); err != nil {
+		t.Fatal(err)
+	}
+	if got.HasCert || got.Name() != "unknown" {
+		t.Errorf("identity without a certificate = %+v, %q", got, got.Name())
+	}
+}
+
+func TestIdentityExtension(t *testing.T) {
+	oid, err := tlsutil.ParseOID("1.3.6.1.4.1.55555.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	identityExtension = oid
+	t.Cleanup(func() { identityExtension = nil })
+	value, err := asn1.Marshal("tenant-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := tlsutil.GenerateCert(tlsutil.CATemplate("test CA", time.Hour), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := tlsutil.LeafTemplate("client", nil, x509.ExtKeyUsageClientAuth, time.Hour)
+	template.ExtraExtensions = []pkix.Extension{{Id: oid, Value: value}}
+	tenant, err := tlsutil.GenerateCert(template, ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := peerIdentity(tlsPeerContext(tenant.Cert)); got.Extension != "tenant-a" {
+		t.Errorf("identity extension %q, want tenant-a", got.Extension)
+	}
+	if got := peerIdentity(tlsPeerContext(clientCert(t, "plain"))); got.Extension != "" {
+		t.Errorf("identity extension %q without the extension, want empty", got.Extension)
+	}
+}
+
+func TestCachedIdentity(t *testing.T) {
+	var conns connRegistry
+	tracker := connTracker{conns: &conns}
+	live := tlsPeerContext(clientCert(t, "tenant-a", "tenant-a.example.com", "spiffe://example.com/ns/prod/sa/tenant-a"))
+	ctx := tracker.TagConn(live, &stats.ConnTagInfo{RemoteAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000}})
+	tracker.HandleConn(ctx, &stats.ConnBegin{})
+
+	if got, want := connIdentity(ctx), peerIdentity(live); !reflect.DeepEqual(got, want) {
+		t.Errorf("cached identity %+v, want the live %+v", got, want)
+	}
+	if connFromContext(live) != nil {
+		t.Fatal("an untagged context has a connection")
+	}
+	// Without a tagged connection the identity is extracted from the peer.
+	if got, want := connIdentity(live), peerIdentity(live); !reflect.DeepEqual(got, want) {
+		t.Errorf("identity of an untagged RPC %+v, want %+v", got, want)
+	}
+
+	tracker.HandleConn(ctx, &stats.ConnEnd{})
+	conns.mu.Lock()
+	defer conns.mu.Unlock()
+	if len(conns.conns) != 0 {
+		t.Errorf("%d connections cached after the connection closed", len(conns.conns))
+	}
+}
+
+// BenchmarkIdentity compares extracting the identity from the peer
+// certificate on every RPC with reading the one cached on the connection.
+func BenchmarkIdentity(b *testing.B) {
+	ca, _ := tlsutil.GenerateCert(tlsutil.CATemplate("test CA", time.Hour), nil)
+	leaf, _ := tlsutil.GenerateCert(tlsutil.LeafTemplate("tenant-a", []string{"tenant-a.example.com", "spiffe://example.com/ns/prod/sa/tenant-a"}, x509.ExtKeyUsageClientAuth, time.Hour), ca)
+	live := tlsPeerContext(leaf.Cert)
+	cached := connTracker{conns: &connRegistry{}}.TagConn(live, &stats.ConnTagInfo{})
+	b.Run("peer", func(b *testing.B) {
+		for b.Loop() {
+			_ = connIdentity(live)
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		for b.Loop() {
+			_ = connIdentity(cached)
+		}
+	})
+}
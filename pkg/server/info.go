@@ -0,0 +1,66 @@
+// info.go
+//
+// This file implements the ServerInfo service, which reports build and
+// runtime metadata about the running instance.
+
+package server
+
+import (
+	"context"
+	"os"
+	"runtime/debug"
+	"time"
+
+	pb "github.com/dethi/envoy_hck/protos"
+)
+
+// Build metadata, overridable at link time:
+//
+//	go build -ldflags "-X github.com/dethi/envoy_hck/pkg/server.version=v1.2.3 -X github.com/dethi/envoy_hck/pkg/server.commit=abc123" ./cmd/envoy-hck
+var (
+	version = "dev"
+	commit  = ""
+)
+
+// bootTime records when the process started.
+var bootTime = time.Now()
+
+type infoServer struct {
+	pb.UnimplementedServerInfoServer
+
+	features        []string
+	listenAddresses []string
+	instanceID      string
+}
+
+func (s *infoServer) GetServerInfo(ctx context.Context, req *pb.ServerInfoRequest) (*pb.ServerInfoResponse, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return &pb.ServerInfoResponse{
+		Hostname:        hostname,
+		Version:         version,
+		Commit:          buildCommit(),
+		BootTime:        bootTime.Format(time.RFC3339),
+		Features:        s.features,
+		ListenAddresses: s.listenAddresses,
+		InstanceId:      s.instanceID,
+	}, nil
+}
+
+// buildCommit returns the commit set at link time, falling back to the VCS
+// revision the Go toolchain stamps into the binary.
+func buildCommit() string {
+	if commit != "" {
+		return commit
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			if s.Key == "vcs.revision" {
+				return s.Value
+			}
+		}
+	}
+	return "unknown"
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/reflection/grpc_reflection_v1"
+
+	pb "github.com/dethi/envoy_hck/protos"
+)
+
+func TestGetServerInfo(t *testing.T) {
+	s := &infoServer{
+		features:        []string{"health", "reflection"},
+		listenAddresses: []string{":50051", ":8081"},
+		instanceID:      "1",
+	}
+	resp, err := s.GetServerInfo(context.Background(), &pb.ServerInfoRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetHostname() == "" || resp.GetVersion() != version || resp.GetCommit() == "" {
+		t.Errorf("hostname %q, version %q, commit %q: want all set", resp.GetHostname(), resp.GetVersion(), resp.GetCommit())
+	}
+	if boot, err := time.Parse(time.RFC3339, resp.GetBootTime()); err != nil || boot.After(time.Now()) {
+		t.Errorf("boot time %q is not a past RFC 3339 time", resp.GetBootTime())
+	}
+	if !slices.Equal(resp.GetFeatures(), s.features) || !slices.Equal(resp.GetListenAddresses(), s.listenAddresses) || resp.GetInstanceId() != "1" {
+		t.Errorf("features %v, addresses %v, instance %q: want those of the server", resp.GetFeatures(), resp.GetListenAddresses(), resp.GetInstanceId())
+	}
+}
+
+func TestServerInfoReflection(t *testing.T) {
+	srv := grpc.NewServer()
+	pb.RegisterServerInfoServer(srv, &infoServer{})
+	reflection.Register(srv)
+	client := grpc_reflection_v1.NewServerReflectionClient(bufconnClient(t, srv))
+	stream, err := client.ServerReflectionInfo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := pb.ServerInfo_ServiceDesc.ServiceName
+
+	if err := stream.Send(&grpc_reflection_v1.ServerReflectionRequest{
+		MessageRequest: &grpc_reflection_v1.ServerReflectionRequest_ListServices{},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var services []string
+	for _, s := range resp.GetListServicesResponse().GetService() {
+		services = append(services, s.GetName())
+	}
+	if !slices.Contains(services, service) {
+		t.Errorf("reflection lists %v, want %s", services, service)
+	}
+
+	if err := stream.Send(&grpc_reflection_v1.ServerReflectionRequest{
+		MessageRequest: &grpc_reflection_v1.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: service},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files := resp.GetFileDescriptorResponse().GetFileDescriptorProto(); len(files) == 0 {
+		t.Errorf("no descriptor for %s: %v", service, resp.GetErrorResponse())
+	}
+}
@@ -0,0 +1,207 @@
+// instances.go
+//
+// This file implements cluster mode: with -instances, the process serves
+// several upstream endpoints on consecutive ports from -base-port, each
+// with its own gRPC server, instance ID and health status, so Envoy load
+// balancing, locality weighting and zone-aware routing can be tested
+// against one process. Every response carries the ID of the instance that
+// answered in the x-instance-id header, and ServerInfo reports it.
+//
+// An instance reports the server's health, unless taken out of service on
+// its own through PUT /instances/{id}/health.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+)
+
+// instanceHeader is the response header naming the answering instance.
+const instanceHeader = "x-instance-id"
+
+// instance is one endpoint of cluster mode.
+type instance struct {
+	id        string
+	addresses []string
+	hs        *health.Server
+
+	// healthy is false while the instance is out of service, and
+	// published the status last set on hs per service. Both are guarded
+	// by mu.
+	healthy   bool
+	published map[string]grpc_health_v1.HealthCheckResponse_ServingStatus
+}
+
+// clusterInstances are the endpoints of cluster mode, in ID order, and none
+// outside it. Set at startup.
+var clusterInstances []*instance
+
+// instanceAddrs returns the gRPC addresses of n instances on the host of
+// grpcAddr, from basePort on, or from the port of grpcAddr if basePort is
+// zero.
+func instanceAddrs(grpcAddr string, n, basePort int) ([]string, error) {
+	if _, ok := unixSocketPath(grpcAddr); ok {
+		return nil, fmt.Errorf("instances need a TCP -grpc-addr, got %s", grpcAddr)
+	}
+	host, port, err := net.SplitHostPort(grpcAddr)
+	if err != nil {
+		return nil, err
+	}
+	if basePort == 0 {
+		if basePort, err = strconv.Atoi(port); err != nil || basePort == 0 {
+			return nil, fmt.Errorf("instances need -base-port or a fixed port in -grpc-addr, got %s", grpcAddr)
+		}
+	}
+	if basePort < 0 || basePort+n-1 > 65535 {
+		return nil, fmt.Errorf("ports %d to %d are out of range", basePort, basePort+n-1)
+	}
+	addrs := make([]string, n)
+	for i := range addrs {
+		addrs[i] = net.JoinHostPort(host, strconv.Itoa(basePort+i))
+	}
+	return addrs, nil
+}
+
+// instanceOf returns the index of the instance listening at addr among
+// addrs, or -1, matching by port so that wildcard and per-family
+// listeners are found too.
+func instanceOf(addr net.Addr, addrs []string) int {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return -1
+	}
+	return slices.IndexFunc(addrs, func(a string) bool {
+		_, port, _ := net.SplitHostPort(a)
+		return port == strconv.Itoa(tcp.Port)
+	})
+}
+
+func newInstance(id int, addresses []string) *instance {
+	return &instance{
+		id:        strconv.Itoa(id),
+		addresses: addresses,
+		hs:        health.NewServer(),
+		healthy:   true,
+		published: map[string]grpc_health_v1.HealthCheckResponse_ServingStatus{},
+	}
+}
+
+// serverOptions returns the options of the gRPC server of the instance,
+// which name it in every response and count its RPCs, except health checks
+// and reflection.
+func (inst *instance) serverOptions() []grpc.ServerOption {
+	md := metadata.Pairs(instanceHeader, inst.id)
+	rpcs := instanceRPCs.WithLabelValues(inst.id)
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			if exemptInfrastructure(info.FullMethod) {
+				rpcs.Inc()
+			}
+			return responseHeaders(md).unaryInterceptor(ctx, req, info, handler)
+		}),
+		grpc.ChainStreamInterceptor(func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if exemptInfrastructure(info.FullMethod) {
+				rpcs.Inc()
+			}
+			return responseHeaders(md).streamInterceptor(srv, ss, info, handler)
+		}),
+	}
+}
+
+// publish sets the statuses of the server on the health server of the
+// instance, NOT_SERVING while the instance is out of service. Callers must
+// hold mu.
+func (inst *instance) publish(statuses map[string]grpc_health_v1.HealthCheckResponse_ServingStatus, trigger string) {
+	for _, svc := range slices.Sorted(maps.Keys(statuses)) {
+		status := statuses[svc]
+		if !inst.healthy {
+			status = grpc_health_v1.HealthCheckResponse_NOT_SERVING
+		}
+		old, ok := inst.published[svc]
+		if ok && old == status {
+			continue
+		}
+		inst.published[svc] = status
+		inst.hs.SetServingStatus(svc, status)
+		if !ok {
+			old = grpc_health_v1.HealthCheckResponse_UNKNOWN
+		}
+		slog.Info("Health transition", "instance", inst.id, "service", svc, "old", old.String(), "new", status.String(), "trigger", trigger)
+		events.publish("health", map[string]any{"instance": inst.id, "service": svc, "old": old.String(), "new": status.String(), "trigger": trigger})
+	}
+}
+
+// instanceEntry is the JSON form of an instance.
+type instanceEntry struct {
+	ID        string            `json:"id"`
+	Addresses []string          `json:"addresses"`
+	Healthy   bool              `json:"healthy"`
+	Health    map[string]string `json:"health"`
+}
+
+// entry returns the JSON form of inst. Callers must hold mu.
+func (inst *instance) entry() instanceEntry {
+	e := instanceEntry{ID: inst.id, Addresses: inst.addresses, Healthy: inst.healthy, Health: map[string]string{}}
+	for svc, status := range inst.published {
+		e.Health[svc] = status.String()
+	}
+	return e
+}
+
+// instanceAPI lists the instances and sets their health over HTTP:
+//
+//	GET /instances                     every instance and its statuses
+//	PUT /instances/{id}/health         {"status": "NOT_SERVING"} takes it out of service
+type instanceAPI struct{}
+
+func (instanceAPI) list(w http.ResponseWriter, r *http.Request) {
+	mu.Lock()
+	entries := make([]instanceEntry, len(clusterInstances))
+	for i, inst := range clusterInstances {
+		entries[i] = inst.entry()
+	}
+	mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+func (instanceAPI) put(w http.ResponseWriter, r *http.Request) {
+	status, err := parseServingStatus(requestStatus(r), grpc_health_v1.HealthCheckResponse_SERVING, grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id := r.PathValue("id")
+	i := slices.IndexFunc(clusterInstances, func(inst *instance) bool { return inst.id == id })
+	if i < 0 {
+		writeJSONError(w, fmt.Sprintf("unknown instance %q", id), http.StatusNotFound)
+		return
+	}
+	inst := clusterInstances[i]
+
+	mu.Lock()
+	defer mu.Unlock()
+	if shuttingDown.Load() {
+		writeJSONError(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	inst.healthy = status == grpc_health_v1.HealthCheckResponse_SERVING
+	inst.publish(healthStatuses(), "instance-api")
+	log.Printf("Health of instance %s set to %s", inst.id, status)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(inst.entry())
+}
@@ -0,0 +1,61 @@
+// interceptors.go
+//
+// This file provides helpers to apply interceptors to a subset of methods,
+// so infrastructure services such as health checking and reflection can be
+// exempted declaratively instead of in each interceptor.
+
+package server
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+)
+
+// methodPredicate reports whether an interceptor applies to a full method
+// name of the form "/package.Service/Method".
+type methodPredicate func(fullMethod string) bool
+
+// exemptServices returns a predicate matching every method except those of
+// the named services.
+func exemptServices(services ...string) methodPredicate {
+	return func(fullMethod string) bool {
+		for _, svc := range services {
+			if strings.HasPrefix(fullMethod, "/"+svc+"/") {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// exemptInfrastructure skips the health and reflection services.
+var exemptInfrastructure = exemptServices(
+	grpc_health_v1.Health_ServiceDesc.ServiceName,
+	grpc_reflection_v1.ServerReflection_ServiceDesc.ServiceName,
+	grpc_reflection_v1alpha.ServerReflection_ServiceDesc.ServiceName,
+)
+
+// unaryWhen runs interceptor only for methods matching pred.
+func unaryWhen(pred methodPredicate, interceptor grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if !pred(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		return interceptor(ctx, req, info, handler)
+	}
+}
+
+// streamWhen runs interceptor only for methods matching pred.
+func streamWhen(pred methodPredicate, interceptor grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !pred(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		return interceptor(srv, ss, info, handler)
+	}
+}
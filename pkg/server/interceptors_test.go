@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	pb "github.com/dethi/envoy_hck/protos"
+)
+
+func TestExemptInfrastructure(t *testing.T) {
+	var mu sync.Mutex
+	var seen []string
+	see := func(method string) {
+		mu.Lock()
+		defer mu.Unlock()
+		seen = append(seen, method)
+	}
+	unary := func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		see(info.FullMethod)
+		return handler(ctx, req)
+	}
+	stream := func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		see(info.FullMethod)
+		return handler(srv, ss)
+	}
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(unaryWhen(exemptInfrastructure, unary)),
+		grpc.ChainStreamInterceptor(streamWhen(exemptInfrastructure, stream)))
+	grpc_health_v1.RegisterHealthServer(srv, health.NewServer())
+	pb.RegisterTimeServiceServer(srv, newTestServer(t, serviceDefaults{}))
+	conn := bufconnClient(t, srv)
+	ctx := context.Background()
+
+	healthClient := grpc_health_v1.NewHealthClient(conn)
+	if _, err := healthClient.Check(ctx, &grpc_health_v1.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	watch, err := healthClient.Watch(ctx, &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := watch.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	timeClient := pb.NewTimeServiceClient(conn)
+	if _, err := timeClient.GetTime(ctx, &pb.TimeRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	times, err := timeClient.StreamTime(ctx, &pb.TimeRequest{IntervalMs: 1, MessageCount: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := times.Recv(); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{pb.TimeService_GetTime_FullMethodName, pb.TimeService_StreamTime_FullMethodName}; !slices.Equal(seen, want) {
+		t.Errorf("interceptor ran for %q, want only %q", seen, want)
+	}
+	for method, want := range map[string]bool{
+		"/grpc.reflection.v1.ServerReflection/ServerReflectionInfo":      false,
+		"/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo": false,
+		"/grpc.health.v1.HealthCheck/Check":                              true, // not the health service
+	} {
+		if got := exemptInfrastructure(method); got != want {
+			t.Errorf("exemptInfrastructure(%q) = %v, want %v", method, got, want)
+		}
+	}
+}
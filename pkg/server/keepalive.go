@@ -0,0 +1,136 @@
+// keepalive.go
+//
+// This file logs the GOAWAY frames the server sends, so connections that
+// gRPC's keepalive closes (-max-connection-age, -max-connection-idle, or a
+// client pinging more often than -keepalive-min-time) can be told apart
+// from those Envoy closes. gRPC keeps these decisions internal; the only
+// trace is the frame on the wire, so the credentials wrap each connection
+// and follow the HTTP/2 frames it writes. Each frame's reason is also
+// recorded on its connection, for /connections.
+
+package server
+
+import (
+	"encoding/binary"
+	"log"
+	"net"
+	"sync"
+
+	"golang.org/x/net/http2"
+	"google.golang.org/grpc/credentials"
+)
+
+// goawayReasons maps the debug data gRPC puts in its GOAWAY frames to the
+// reason logged and counted.
+var goawayReasons = map[string]string{
+	"max_age":         "max-connection-age",
+	"max_idle":        "max-connection-idle",
+	"too_many_pings":  "too-many-pings",
+	"graceful_stop":   "shutdown",
+	"fault injection": "fault-injection",
+}
+
+// goawayReason returns the reason of a GOAWAY frame with code and debug
+// data.
+func goawayReason(code http2.ErrCode, debug string) string {
+	if reason, ok := goawayReasons[debug]; ok {
+		return reason
+	}
+	if code == http2.ErrCodeNo {
+		return "drain"
+	}
+	return "error"
+}
+
+// goawayLogCreds wraps server credentials to log the GOAWAY frames sent on
+// the connections they establish, and record them in conns.
+type goawayLogCreds struct {
+	credentials.TransportCredentials
+	conns *connRegistry
+}
+
+func (c goawayLogCreds) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	out, info, err := c.TransportCredentials.ServerHandshake(conn)
+	if err != nil {
+		return out, info, err
+	}
+	return &frameWatchConn{Conn: out, conns: c.conns}, info, nil
+}
+
+func (c goawayLogCreds) Clone() credentials.TransportCredentials {
+	return goawayLogCreds{c.TransportCredentials.Clone(), c.conns}
+}
+
+// goawayMaxPayload caps the GOAWAY payload kept for logging: the last
+// stream ID, the error code and the start of the debug data.
+const goawayMaxPayload = 8 + 64
+
+// frameWatchConn is a server connection that follows the frames written
+// to it, however gRPC batches them into writes, and logs GOAWAY frames.
+type frameWatchConn struct {
+	net.Conn
+	conns *connRegistry
+
+	mu        sync.Mutex
+	header    [9]byte
+	headerLen int
+	remaining int    // payload bytes of the current frame still to come
+	goaway    []byte // payload of the current frame, if a GOAWAY
+	inGoaway  bool
+}
+
+func (c *frameWatchConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.mu.Lock()
+	c.scan(b[:n])
+	c.mu.Unlock()
+	return n, err
+}
+
+// scan advances over b, the next bytes written.
+func (c *frameWatchConn) scan(b []byte) {
+	for len(b) > 0 {
+		if c.remaining == 0 && !c.inGoaway {
+			k := copy(c.header[c.headerLen:], b)
+			c.headerLen += k
+			b = b[k:]
+			if c.headerLen < len(c.header) {
+				return
+			}
+			c.headerLen = 0
+			c.remaining = int(c.header[0])<<16 | int(c.header[1])<<8 | int(c.header[2])
+			c.inGoaway = http2.FrameType(c.header[3]) == http2.FrameGoAway
+			c.goaway = c.goaway[:0]
+		}
+		k := min(c.remaining, len(b))
+		if c.inGoaway && len(c.goaway) < goawayMaxPayload {
+			c.goaway = append(c.goaway, b[:min(k, goawayMaxPayload-len(c.goaway))]...)
+		}
+		c.remaining -= k
+		b = b[k:]
+		if c.remaining == 0 && c.inGoaway {
+			c.inGoaway = false
+			c.logGoaway()
+		}
+	}
+}
+
+// logGoaway logs and counts the GOAWAY frame just written. gRPC drains a
+// connection with two frames, a first one leaving every stream ID open and
+// a final one once the client acknowledged a ping; only the final one is
+// logged.
+func (c *frameWatchConn) logGoaway() {
+	if len(c.goaway) < 8 {
+		return
+	}
+	lastStream := binary.BigEndian.Uint32(c.goaway) & (1<<31 - 1)
+	code := http2.ErrCode(binary.BigEndian.Uint32(c.goaway[4:]))
+	debug := string(c.goaway[8:])
+	reason := goawayReason(code, debug)
+	if lastStream == 1<<31-1 && code == http2.ErrCodeNo && reason != "fault-injection" {
+		return
+	}
+	goawaysSent.WithLabelValues(reason).Inc()
+	c.conns.goaway(c.RemoteAddr(), reason)
+	log.Printf("Sent GOAWAY to %s: %s (%s, last stream %d)", c.RemoteAddr(), reason, code, lastStream)
+}
@@ -0,0 +1,39 @@
+// leader.go
+//
+// This file implements the optional leader/standby mode for active/passive
+// deployments behind Envoy. Only the leader reports SERVING, so Envoy's
+// health checks route all traffic to it.
+
+package server
+
+import (
+	"context"
+	"log"
+
+	"google.golang.org/grpc/health"
+)
+
+// LeaderElector decides whether this instance is the active one.
+type LeaderElector interface {
+	// Campaign runs until ctx is done, calling onChange with true whenever
+	// leadership is gained and with false whenever it is lost.
+	Campaign(ctx context.Context, onChange func(leader bool))
+}
+
+// runLeaderElection ties e to the health state: the instance reports
+// SERVING only while it leads, and drains its streams when it steps down so
+// clients reconnect to the new leader.
+func runLeaderElection(ctx context.Context, e LeaderElector, hs *health.Server, drain *drainer) {
+	e.Campaign(ctx, func(leader bool) {
+		mu.Lock()
+		isLeader.Store(leader)
+		publishHealth(hs, "leader-election")
+		mu.Unlock()
+		if leader {
+			log.Println("Acquired leadership")
+			return
+		}
+		log.Println("Lost leadership, draining streams")
+		drain.Drain()
+	})
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+// leader_other.go
+//
+// File-lock leader election relies on flock and is unavailable here.
+
+package server
+
+import "errors"
+
+func newFileLockElector(path string) (LeaderElector, error) {
+	return nil, errors.New("file lock leader election is not supported on this platform")
+}
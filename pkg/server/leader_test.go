@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// fakeElector reports the leadership changes sent on changes.
+type fakeElector struct {
+	changes chan bool
+	applied chan struct{}
+}
+
+func (e *fakeElector) Campaign(ctx context.Context, onChange func(leader bool)) {
+	for {
+		select {
+		case leader := <-e.changes:
+			onChange(leader)
+			e.applied <- struct{}{}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (e *fakeElector) set(leader bool) {
+	e.changes <- leader
+	<-e.applied
+}
+
+func TestLeaderElection(t *testing.T) {
+	healthyProcess(t)
+	isLeader.Store(false)
+	hs := health.NewServer()
+	mu.Lock()
+	publishHealth(hs, "test")
+	mu.Unlock()
+	var drain drainer
+	streams := drain.C()
+
+	e := &fakeElector{changes: make(chan bool), applied: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go runLeaderElection(ctx, e, hs, &drain)
+
+	if got := overallStatus(t, hs); got != grpc_health_v1.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("standby reports %s", got)
+	}
+	e.set(true)
+	if got := overallStatus(t, hs); got != grpc_health_v1.HealthCheckResponse_SERVING {
+		t.Errorf("leader reports %s", got)
+	}
+	select {
+	case <-streams:
+		t.Fatal("streams drained on gaining leadership")
+	default:
+	}
+	e.set(false)
+	if got := overallStatus(t, hs); got != grpc_health_v1.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("former leader reports %s", got)
+	}
+	select {
+	case <-streams:
+	case <-time.After(time.Second):
+		t.Error("streams not drained on losing leadership")
+	}
+}
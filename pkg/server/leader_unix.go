@@ -0,0 +1,93 @@
+//go:build unix
+
+// leader_unix.go
+//
+// This file implements a LeaderElector backed by an advisory lock on a
+// shared lease file.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"syscall"
+	"time"
+)
+
+// leaderPollInterval is how often a standby retries the lock and a leader
+// checks that it still holds it.
+const leaderPollInterval = time.Second
+
+// fileLockElector grants leadership to the process holding an exclusive
+// flock on path. A leader that finds the file removed or replaced steps
+// down and then campaigns again like any standby.
+type fileLockElector struct {
+	path string
+}
+
+func newFileLockElector(path string) (LeaderElector, error) {
+	return &fileLockElector{path: path}, nil
+}
+
+func (e *fileLockElector) Campaign(ctx context.Context, onChange func(leader bool)) {
+	ticker := time.NewTicker(leaderPollInterval)
+	defer ticker.Stop()
+
+	var lease *os.File
+	for {
+		if lease == nil {
+			if lease = e.tryLock(); lease != nil {
+				onChange(true)
+			}
+		} else if !e.held(lease) {
+			lease.Close()
+			lease = nil
+			onChange(false)
+		}
+
+		select {
+		case <-ctx.Done():
+			if lease != nil {
+				lease.Close()
+				onChange(false)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// tryLock returns the locked lease file, or nil if another process holds
+// the lock.
+func (e *fileLockElector) tryLock() *os.File {
+	f, err := os.OpenFile(e.path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		log.Printf("Failed to open leader lock file: %v", err)
+		return nil
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		return nil
+	}
+	// Record the holder for operators inspecting the file.
+	hostname, _ := os.Hostname()
+	if err := f.Truncate(0); err == nil {
+		fmt.Fprintf(f, "%s %d\n", hostname, os.Getpid())
+	}
+	return f
+}
+
+// held reports whether lease is still the file at e.path.
+func (e *fileLockElector) held(lease *os.File) bool {
+	locked, err := lease.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(e.path)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(locked, current)
+}
@@ -0,0 +1,176 @@
+// listen.go
+//
+// This file opens the gRPC listeners. With more than one listener, each
+// socket binds the same address with SO_REUSEPORT so the kernel spreads
+// incoming connections, and with them the accept and TLS handshake work,
+// across listeners served by separate gRPC servers.
+//
+// The accept backlog and TCP keepalive are configurable. Go already sets
+// SO_REUSEADDR on listening sockets on Unix, so restarts can rebind a port
+// with connections in TIME_WAIT. The backlog is applied by calling listen
+// again after Go opened the socket, which Linux and the BSDs honor; the
+// kernel still caps it at net.core.somaxconn (kern.ipc.somaxconn).
+//
+// An address of the form unix:///path binds a Unix domain socket instead,
+// for Envoy in the same pod. A stale socket file left by a crashed process
+// is removed first, the file gets the configured permissions, and it is
+// removed again when the listener closes.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listenOptions are the socket settings of the gRPC listeners.
+type listenOptions struct {
+	// family selects the IP families to bind: "any" for Go's default
+	// (dual-stack where the platform allows it), "4", "6", or "both" for
+	// separate IPv4 and IPv6-only listeners.
+	family string
+	// backlog is the accept queue length; zero keeps Go's default, the
+	// system maximum.
+	backlog int
+	// keepAlive is the TCP keepalive period of accepted connections; zero
+	// keeps Go's default of 15s and a negative value disables keepalive.
+	keepAlive time.Duration
+	// unixMode is the permission of Unix domain socket files.
+	unixMode os.FileMode
+}
+
+// familyNetworks maps an IP family option to the networks to listen on.
+var familyNetworks = map[string][]string{
+	"any":  {"tcp"},
+	"4":    {"tcp4"},
+	"6":    {"tcp6"},
+	"both": {"tcp4", "tcp6"},
+}
+
+// listenGRPC opens n listeners on addr for each network of opts.family. A
+// single listener is a plain TCP listener; several per network require
+// SO_REUSEPORT support.
+func listenGRPC(addr string, n int, opts listenOptions) ([]net.Listener, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("listener count must be at least 1, got %d", n)
+	}
+	if path, ok := unixSocketPath(addr); ok {
+		if n > 1 {
+			return nil, fmt.Errorf("%s: a Unix domain socket takes one listener, got %d", addr, n)
+		}
+		lis, err := listenUnix(path, opts.unixMode)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{lis}, nil
+	}
+	networks, ok := familyNetworks[opts.family]
+	if !ok {
+		return nil, fmt.Errorf("unknown IP family %q, want any, 4, 6 or both", opts.family)
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	lc := net.ListenConfig{KeepAlive: opts.keepAlive}
+	if n > 1 {
+		lc.Control = reusePortControl
+	}
+	var listeners []net.Listener
+	for _, network := range networks {
+		for range n {
+			lis, err := lc.Listen(context.Background(), network, addr)
+			if err == nil && opts.backlog > 0 {
+				if err = setBacklog(lis, opts.backlog); err != nil {
+					lis.Close()
+				}
+			}
+			if err != nil {
+				for _, l := range listeners {
+					l.Close()
+				}
+				return nil, fmt.Errorf("%s: %w", network, err)
+			}
+			log.Printf("Bound %s listener on %s", network, lis.Addr())
+			listeners = append(listeners, lis)
+			// Bind the remaining sockets to the port picked for the first
+			// one, in case addr asked for any free port.
+			addr = net.JoinHostPort(host, strconv.Itoa(lis.Addr().(*net.TCPAddr).Port))
+		}
+	}
+	return listeners, nil
+}
+
+// unixScheme prefixes the addresses of Unix domain sockets.
+const unixScheme = "unix://"
+
+// unixSocketPath returns the socket path of a unix:// address.
+func unixSocketPath(addr string) (string, bool) {
+	path, ok := strings.CutPrefix(addr, unixScheme)
+	return path, ok && path != ""
+}
+
+// listenerNetworks returns the number of listeners addr binds per
+// listener count: one per network of family, or one for a Unix domain
+// socket.
+func listenerNetworks(addr, family string) int {
+	if _, ok := unixSocketPath(addr); ok {
+		return 1
+	}
+	return len(familyNetworks[family])
+}
+
+// ListenAddr opens a TCP listener on addr, or a Unix domain socket for a
+// unix:// address.
+func ListenAddr(addr string, mode os.FileMode) (net.Listener, error) {
+	if path, ok := unixSocketPath(addr); ok {
+		return listenUnix(path, mode)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// listenUnix binds a Unix domain socket at path with permissions mode,
+// replacing a socket file no process listens on any more.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another process", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+		log.Printf("Removed stale socket %s", path)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		lis.Close()
+		return nil, err
+	}
+	log.Printf("Bound unix listener on %s (mode %04o)", path, mode)
+	return lis, nil
+}
+
+// parseFileMode parses an octal permission such as 0660.
+func parseFileMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("%q is not an octal permission such as 0660", s)
+	}
+	return os.FileMode(mode), nil
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+// listen_other.go
+//
+// The accept backlog cannot be changed here.
+
+package server
+
+import (
+	"errors"
+	"net"
+)
+
+func setBacklog(lis net.Listener, backlog int) error {
+	return errors.New("setting the listen backlog is not supported on this platform")
+}
@@ -0,0 +1,166 @@
+//go:build linux
+
+package server
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// sockopt returns an integer option of the socket behind conn.
+func sockopt(t *testing.T, conn syscall.Conn, level, opt int) int {
+	t.Helper()
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v int
+	var optErr error
+	if err := raw.Control(func(fd uintptr) { v, optErr = unix.GetsockoptInt(int(fd), level, opt) }); err != nil {
+		t.Fatal(err)
+	}
+	if optErr != nil {
+		t.Fatal(optErr)
+	}
+	return v
+}
+
+func TestListenSocketOptions(t *testing.T) {
+	listeners, err := listenGRPC("127.0.0.1:0", 2, listenOptions{family: "4", backlog: 7, keepAlive: 42 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, lis := range listeners {
+		defer lis.Close()
+		tcp := lis.(*net.TCPListener)
+		if sockopt(t, tcp, unix.SOL_SOCKET, unix.SO_REUSEPORT) != 1 {
+			t.Error("the Control function did not set SO_REUSEPORT")
+		}
+		if sockopt(t, tcp, unix.SOL_SOCKET, unix.SO_REUSEADDR) != 1 {
+			t.Error("SO_REUSEADDR is not set")
+		}
+		// On a listening socket Linux reports the backlog as tcpi_sacked.
+		raw, err := tcp.SyscallConn()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var info *unix.TCPInfo
+		var infoErr error
+		if err := raw.Control(func(fd uintptr) { info, infoErr = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO) }); err != nil {
+			t.Fatal(err)
+		}
+		if err := infoErr; err != nil {
+			t.Fatal(err)
+		}
+		if info.Sacked != 7 {
+			t.Errorf("backlog %d, want 7", info.Sacked)
+		}
+	}
+
+	client, err := net.Dial("tcp", listeners[0].Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	accepted := make(chan net.Conn, len(listeners))
+	for _, lis := range listeners {
+		go func() {
+			if conn, err := lis.Accept(); err == nil {
+				accepted <- conn
+			}
+		}()
+	}
+	conn := (<-accepted).(*net.TCPConn)
+	defer conn.Close()
+	if sockopt(t, conn, unix.SOL_SOCKET, unix.SO_KEEPALIVE) != 1 {
+		t.Error("keepalive is off on accepted connections")
+	}
+	if idle := sockopt(t, conn, unix.IPPROTO_TCP, unix.TCP_KEEPIDLE); idle != 42 {
+		t.Errorf("keepalive idle time %ds, want 42s", idle)
+	}
+
+	single, err := listenGRPC("127.0.0.1:0", 1, listenOptions{family: "4", keepAlive: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer single[0].Close()
+	if sockopt(t, single[0].(*net.TCPListener), unix.SOL_SOCKET, unix.SO_REUSEPORT) != 0 {
+		t.Error("a single listener set SO_REUSEPORT")
+	}
+	client2, err := net.Dial("tcp", single[0].Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client2.Close()
+	conn2, err := single[0].Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+	if sockopt(t, conn2.(*net.TCPConn), unix.SOL_SOCKET, unix.SO_KEEPALIVE) != 0 {
+		t.Error("a negative keepalive left keepalive on")
+	}
+}
+
+func TestListenBothFamilies(t *testing.T) {
+	if lis, err := net.Listen("tcp6", "[::1]:0"); err != nil {
+		t.Skipf("no IPv6 loopback: %v", err)
+	} else {
+		lis.Close()
+	}
+	logs := captureLog(t)
+	// Bind the wildcard address, as by default: localhost may only
+	// resolve to one family.
+	listeners, err := listenGRPC(":0", 1, listenOptions{family: "both"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listeners) != 2 {
+		t.Fatalf("%d listeners, want one per family", len(listeners))
+	}
+	var v4, v6 *net.TCPAddr
+	for _, lis := range listeners {
+		defer lis.Close()
+		addr := lis.Addr().(*net.TCPAddr)
+		if addr.IP.To4() != nil {
+			v4 = addr
+		} else {
+			v6 = addr
+		}
+		go func() {
+			for {
+				conn, err := lis.Accept()
+				if err != nil {
+					return
+				}
+				conn.Close()
+			}
+		}()
+	}
+	if v4 == nil || v6 == nil {
+		t.Fatalf("bound %v and %v, want an IPv4 and an IPv6 address", listeners[0].Addr(), listeners[1].Addr())
+	}
+	if v4.Port != v6.Port {
+		t.Errorf("IPv4 port %d and IPv6 port %d differ", v4.Port, v6.Port)
+	}
+	for _, host := range []string{"127.0.0.1", "::1"} {
+		addr := net.JoinHostPort(host, strconv.Itoa(v4.Port))
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Errorf("dial %s: %v", addr, err)
+			continue
+		}
+		conn.Close()
+	}
+	for _, want := range []string{"Bound tcp4 listener on " + v4.String(), "Bound tcp6 listener on " + v6.String()} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("log lacks %q: %s", want, logs)
+		}
+	}
+}
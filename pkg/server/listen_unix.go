@@ -0,0 +1,34 @@
+//go:build unix
+
+// listen_unix.go
+//
+// This file changes the accept backlog of an open listening socket.
+
+package server
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// setBacklog calls listen again on lis with the given backlog.
+func setBacklog(lis net.Listener, backlog int) error {
+	tcp, ok := lis.(*net.TCPListener)
+	if !ok {
+		return fmt.Errorf("cannot set the backlog of %T", lis)
+	}
+	raw, err := tcp.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var listenErr error
+	err = raw.Control(func(fd uintptr) {
+		listenErr = unix.Listen(int(fd), backlog)
+	})
+	if err != nil {
+		return err
+	}
+	return listenErr
+}
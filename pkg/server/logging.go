@@ -0,0 +1,48 @@
+// logging.go
+//
+// This file configures the process-wide slog logger. The standard library
+// log package is routed through it, so existing log.Printf calls share the
+// selected format.
+
+package server
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// NewLogHandler returns the slog handler for the given format ("text" or
+// "json") writing records at level ("debug", "info", "warn" or "error")
+// and above to w.
+func NewLogHandler(format, level string, w io.Writer) (slog.Handler, error) {
+	var opts slog.HandlerOptions
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("unknown log level %q (want debug, info, warn or error)", level)
+	}
+	opts.Level = l
+	switch format {
+	case "text":
+		return slog.NewTextHandler(w, &opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, &opts), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q (want text or json)", format)
+	}
+}
+
+// defaultLogFormat picks human-readable logs for interactive terminals and
+// JSON everywhere else.
+func defaultLogFormat() string {
+	if isTerminal(os.Stderr) {
+		return "text"
+	}
+	return "json"
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewLogHandler(t *testing.T) {
+	for format, want := range map[string]string{"text": "*slog.TextHandler", "json": "*slog.JSONHandler"} {
+		h, err := NewLogHandler(format, "info", io.Discard)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if got := fmt.Sprintf("%T", h); got != want {
+			t.Errorf("%s: handler is %s, want %s", format, got, want)
+		}
+	}
+	if _, err := NewLogHandler("xml", "info", io.Discard); err == nil {
+		t.Error("unknown format accepted")
+	}
+	if _, err := NewLogHandler("text", "loud", io.Discard); err == nil {
+		t.Error("unknown level accepted")
+	}
+}
+
+func TestIsTerminal(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if isTerminal(f) {
+		t.Error("a regular file is a terminal")
+	}
+}
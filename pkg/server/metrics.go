@@ -0,0 +1,363 @@
+// metrics.go
+//
+// This file defines the Prometheus registry and the metrics exported on the
+// HTTP server's /metrics endpoint. RPC counts, latencies and active streams
+// go through the Metrics interface so another backend can be plugged in.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// registry holds every metric exported by the server.
+var registry = prometheus.NewRegistry()
+
+func init() {
+	// Go runtime and process metrics; go_goroutines in particular exposes
+	// leaked stream goroutines, and the GC, memory and scheduler metrics of
+	// runtime/metrics show where a soak test's memory goes.
+	registry.MustRegister(
+		collectors.NewGoCollector(collectors.WithGoCollectorRuntimeMetrics(
+			collectors.MetricsGC, collectors.MetricsMemory, collectors.MetricsScheduler)),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+var (
+	connectionStreams = promauto.With(registry).NewHistogram(prometheus.HistogramOpts{
+		Name:    "grpc_connection_streams",
+		Help:    "Number of concurrent streams on a connection, observed each time a stream opens.",
+		Buckets: []float64{1, 2, 4, 8, 16, 32, 64, 128},
+	})
+	connectionStreamRejections = promauto.With(registry).NewCounter(prometheus.CounterOpts{
+		Name: "grpc_connection_stream_rejections_total",
+		Help: "Streams rejected because their connection reached the per-connection stream limit.",
+	})
+	rpcHandled = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_server_handled_total",
+		Help: "RPCs completed on the server, by method, status code and client identity.",
+	}, []string{"method", "code", "identity"})
+	rpcWithoutClientCert = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "rpc_without_client_cert_total",
+		Help: "RPCs from clients that presented no certificate, allowed by -client-auth=request, by method.",
+	}, []string{"method"})
+	rpcDuration = promauto.With(registry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "grpc_server_handling_seconds",
+		Help:    "Time taken to complete RPCs, by method and client identity.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "identity"})
+	tlsHandshakeTimeouts = promauto.With(registry).NewCounter(prometheus.CounterOpts{
+		Name: "tls_handshake_timeout_total",
+		Help: "Connections closed because the TLS handshake did not complete within -handshake-timeout.",
+	})
+	tlsReloadFailures = promauto.With(registry).NewCounter(prometheus.CounterOpts{
+		Name: "tls_reload_failures_total",
+		Help: "TLS reloads rejected because the certificate, key or CA bundle was invalid; the previous material stays in use.",
+	})
+	tlsHandshakes = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "tls_handshakes_total",
+		Help: "TLS handshakes on the gRPC listeners, by result (success or failure) and failure reason.",
+	}, []string{"result", "reason"})
+	tlsResumptions = promauto.With(registry).NewCounter(prometheus.CounterOpts{
+		Name: "tls_session_resumptions_total",
+		Help: "Successful TLS handshakes on the gRPC listeners that resumed a session instead of a full handshake.",
+	})
+	healthTransitions = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "health_transitions_total",
+		Help: "Health status changes, by service, new status and trigger (e.g. toggle-endpoint).",
+	}, []string{"service", "status", "trigger"})
+	droppedTicks = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "dropped_ticks_total",
+		Help: "StreamTime ticks skipped because the previous message was still being sent, by client identity.",
+	}, []string{"identity"})
+	sendBufferDropped = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "send_buffer_dropped_total",
+		Help: "StreamTime messages discarded because the stream's -send-buffer was full, by -send-buffer-overflow policy.",
+	}, []string{"policy"})
+	overloadRejections = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "overload_rejections_total",
+		Help: "RPCs refused because the goroutine count exceeded -max-goroutines, by method.",
+	}, []string{"method"})
+	rateLimitRejections = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "rate_limit_rejections_total",
+		Help: "RPCs refused with RESOURCE_EXHAUSTED by the rate limiter, by method.",
+	}, []string{"method"})
+	inFlightRejections = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "in_flight_limit_rejections_total",
+		Help: "RPCs refused because -max-in-flight RPCs were already being handled, by method.",
+	}, []string{"method"})
+	faultsInjected = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "faults_injected_total",
+		Help: "Faults injected through the /faults endpoint, by method and fault (delay, error, abort, goaway).",
+	}, []string{"method", "fault"})
+	revocationRejections = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "tls_revocation_rejections_total",
+		Help: "Client certificates rejected as revoked, or with an unknown status under -ocsp-check=hard, by source (crl, ocsp).",
+	}, []string{"source"})
+	proxyProtocolConns = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "proxy_protocol_connections_total",
+		Help: "Connections on the gRPC listeners with -proxy-protocol, by header version (v1, v2, local, none) or invalid.",
+	}, []string{"version"})
+	tcpConnsRejected = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "tcp_connections_rejected_total",
+		Help: "gRPC connections closed on accept because -max-conns were open, by -max-conns-action (close or reset).",
+	}, []string{"action"})
+	goawaysSent = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_server_goaways_total",
+		Help: "GOAWAY frames sent to clients, by reason (max-connection-age, max-connection-idle, too-many-pings, shutdown, fault-injection, drain, error).",
+	}, []string{"reason"})
+	chaosActionsRun = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "chaos_actions_total",
+		Help: "Chaos actions run, by action (exit, pause-accept, hang, pause-sends) and trigger (admin, schedule).",
+	}, []string{"action", "trigger"})
+	instanceRPCs = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "cluster_instance_rpcs_total",
+		Help: "RPCs received by each instance in cluster mode (-instances), excluding health checks and reflection, by instance ID.",
+	}, []string{"instance"})
+	authzDecisions = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "authz_decisions_total",
+		Help: "RPCs checked against the -authz-policy, by method and decision (allow, deny).",
+	}, []string{"method", "decision"})
+	streamMessagesSent = promauto.With(registry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "grpc_server_stream_messages_sent",
+		Help:    "Messages sent on a stream, observed when it ends, by method.",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 8),
+	}, []string{"method"})
+	connectionsOpened = promauto.With(registry).NewCounter(prometheus.CounterOpts{
+		Name: "grpc_server_connections_opened_total",
+		Help: "Client connections opened on the gRPC listeners.",
+	})
+	openConnections = promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		Name: "grpc_server_open_connections",
+		Help: "Client connections currently open on the gRPC listeners.",
+	})
+	connectionDuration = promauto.With(registry).NewHistogram(prometheus.HistogramOpts{
+		Name:    "grpc_server_connection_duration_seconds",
+		Help:    "Lifetime of client connections, observed when they close.",
+		Buckets: prometheus.ExponentialBuckets(0.1, 4, 10),
+	})
+	connectionRPCs = promauto.With(registry).NewHistogram(prometheus.HistogramOpts{
+		Name:    "grpc_server_connection_rpcs",
+		Help:    "RPCs carried by a client connection, observed when it closes.",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 10),
+	})
+	messageBytesReceived = promauto.With(registry).NewCounter(prometheus.CounterOpts{
+		Name: "grpc_server_message_bytes_received_total",
+		Help: "Bytes of the messages received, as on the wire.",
+	})
+	messageBytesSent = promauto.With(registry).NewCounter(prometheus.CounterOpts{
+		Name: "grpc_server_message_bytes_sent_total",
+		Help: "Bytes of the messages sent, as on the wire.",
+	})
+	activeStreams = promauto.With(registry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "grpc_server_active_streams",
+		Help: "Streams currently open, by method.",
+	}, []string{"method"})
+)
+
+// Metrics is the backend the RPC interceptors record to. Implementations
+// must be safe for concurrent use.
+type Metrics interface {
+	// RPCHandled records a completed RPC. identity is "" unless identity
+	// labels are enabled, and traceID is "" when the RPC carried no trace
+	// context.
+	RPCHandled(method, code, identity, traceID string, duration time.Duration)
+	// StreamStarted and StreamEnded bracket every stream.
+	StreamStarted(method string)
+	StreamEnded(method string)
+}
+
+// newMetricsBackend returns the Metrics backend named by -metrics-backend,
+// a comma-separated list of backends that all record every metric.
+func newMetricsBackend(names string) (Metrics, error) {
+	var backends multiMetrics
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(name) {
+		case "prometheus":
+			backends = append(backends, prometheusMetrics{})
+		case "otel":
+			m, err := newOTelMetrics()
+			if err != nil {
+				return nil, fmt.Errorf("otel: %w", err)
+			}
+			backends = append(backends, m)
+		case "none":
+			backends = append(backends, noopMetrics{})
+		default:
+			return nil, fmt.Errorf("unknown backend %q, want prometheus, otel or none", name)
+		}
+	}
+	if len(backends) == 1 {
+		return backends[0], nil
+	}
+	return backends, nil
+}
+
+// metricsShutdowner is implemented by backends that buffer metrics and
+// must flush them before the process exits.
+type metricsShutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// shutdownMetrics flushes m if it buffers metrics.
+func shutdownMetrics(ctx context.Context, m Metrics) error {
+	if s, ok := m.(metricsShutdowner); ok {
+		return s.Shutdown(ctx)
+	}
+	return nil
+}
+
+// multiMetrics records to several backends.
+type multiMetrics []Metrics
+
+func (ms multiMetrics) RPCHandled(method, code, identity, traceID string, duration time.Duration) {
+	for _, m := range ms {
+		m.RPCHandled(method, code, identity, traceID, duration)
+	}
+}
+
+func (ms multiMetrics) StreamStarted(method string) {
+	for _, m := range ms {
+		m.StreamStarted(method)
+	}
+}
+
+func (ms multiMetrics) StreamEnded(method string) {
+	for _, m := range ms {
+		m.StreamEnded(method)
+	}
+}
+
+func (ms multiMetrics) Shutdown(ctx context.Context) error {
+	var errs []error
+	for _, m := range ms {
+		errs = append(errs, shutdownMetrics(ctx, m))
+	}
+	return errors.Join(errs...)
+}
+
+// prometheusMetrics records to the registry served on /metrics.
+type prometheusMetrics struct{}
+
+func (prometheusMetrics) RPCHandled(method, code, identity, traceID string, duration time.Duration) {
+	rpcHandled.WithLabelValues(method, code, identity).Inc()
+	observer := rpcDuration.WithLabelValues(method, identity)
+	if traceID != "" {
+		// Link the observation to the request's trace; exemplars are only
+		// exposed when the scraper negotiates the OpenMetrics format.
+		observer.(prometheus.ExemplarObserver).ObserveWithExemplar(duration.Seconds(), prometheus.Labels{"trace_id": traceID})
+		return
+	}
+	observer.Observe(duration.Seconds())
+}
+
+func (prometheusMetrics) StreamStarted(method string) {
+	activeStreams.WithLabelValues(method).Inc()
+}
+
+func (prometheusMetrics) StreamEnded(method string) {
+	activeStreams.WithLabelValues(method).Dec()
+}
+
+// noopMetrics discards everything.
+type noopMetrics struct{}
+
+func (noopMetrics) RPCHandled(method, code, identity, traceID string, duration time.Duration) {}
+func (noopMetrics) StreamStarted(method string)                                               {}
+func (noopMetrics) StreamEnded(method string)                                                 {}
+
+// rpcMetrics records RPC counts and latencies to backend. When identities
+// is nil the identity label is left empty.
+type rpcMetrics struct {
+	backend    Metrics
+	identities *identityLabeler
+}
+
+func (m rpcMetrics) observe(ctx context.Context, method string, start time.Time, err error) {
+	identity := ""
+	if m.identities != nil {
+		identity = m.identities.label(IdentityFromContext(ctx).Name())
+	}
+	m.backend.RPCHandled(method, status.Code(err).String(), identity, traceIDFromContext(ctx), time.Since(start))
+}
+
+// traceIDFromContext returns the trace ID propagated by Envoy in the
+// incoming W3C traceparent or B3 headers, or "" if the RPC carries no
+// trace context.
+func traceIDFromContext(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get("traceparent"); len(v) > 0 {
+		// version-traceid-parentid-flags
+		if parts := strings.Split(v[0], "-"); len(parts) == 4 && len(parts[1]) == 32 {
+			return parts[1]
+		}
+	}
+	if v := md.Get("x-b3-traceid"); len(v) > 0 && (len(v[0]) == 16 || len(v[0]) == 32) {
+		return v[0]
+	}
+	return ""
+}
+
+func (m rpcMetrics) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	m.observe(ctx, info.FullMethod, start, err)
+	return resp, err
+}
+
+func (m rpcMetrics) streamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	m.backend.StreamStarted(info.FullMethod)
+	defer m.backend.StreamEnded(info.FullMethod)
+	start := time.Now()
+	err := handler(srv, ss)
+	m.observe(ss.Context(), info.FullMethod, start, err)
+	return err
+}
+
+// identityLabeler bounds the cardinality of the identity label: the first
+// limit distinct identities keep their own value and any later one is
+// reported as "other".
+type identityLabeler struct {
+	limit int
+
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+func newIdentityLabeler(limit int) *identityLabeler {
+	return &identityLabeler{limit: limit, seen: make(map[string]bool)}
+}
+
+func (l *identityLabeler) label(identity string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.seen[identity] {
+		return identity
+	}
+	if len(l.seen) >= l.limit {
+		return "other"
+	}
+	l.seen[identity] = true
+	return identity
+}
+
+// metricsHandler serves the registry, in the OpenMetrics format when the
+// scraper asks for it so that exemplars are included.
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{EnableOpenMetrics: true})
+}
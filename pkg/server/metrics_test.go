@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	pb "github.com/dethi/envoy_hck/protos"
+)
+
+// scrape returns the text exposition of /metrics.
+func scrape(t *testing.T) string {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	rec := httptest.NewRecorder()
+	metricsHandler().ServeHTTP(rec, req)
+	body, _ := io.ReadAll(rec.Result().Body)
+	return string(body)
+}
+
+func TestMetricsRuntimeStats(t *testing.T) {
+	body := scrape(t)
+	for _, name := range []string{"go_goroutines ", "go_memstats_heap_alloc_bytes ", "go_gc_duration_seconds"} {
+		if !strings.Contains(body, name) {
+			t.Errorf("/metrics lacks %s", strings.TrimSpace(name))
+		}
+	}
+}
+
+// recordingMetrics is a Metrics backend that keeps what it is given.
+type recordingMetrics struct {
+	mu      sync.Mutex
+	handled []handledRPC
+	streams map[string]int
+}
+
+type handledRPC struct {
+	method, code, identity, traceID string
+}
+
+func (m *recordingMetrics) RPCHandled(method, code, identity, traceID string, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handled = append(m.handled, handledRPC{method, code, identity, traceID})
+}
+
+func (m *recordingMetrics) StreamStarted(method string) { m.addStream(method, 1) }
+func (m *recordingMetrics) StreamEnded(method string)   { m.addStream(method, -1) }
+
+func (m *recordingMetrics) addStream(method string, n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.streams == nil {
+		m.streams = make(map[string]int)
+	}
+	m.streams[method] += n
+}
+
+func TestIdentityLabel(t *testing.T) {
+	backend := &recordingMetrics{}
+	labeled := rpcMetrics{backend: backend, identities: newIdentityLabeler(1)}
+	labeled.observe(withTestIdentity("tenant-a"), "/m", time.Now(), nil)
+	labeled.observe(withTestIdentity("tenant-b"), "/m", time.Now(), nil)
+	labeled.observe(withTestIdentity("tenant-a"), "/m", time.Now(), nil)
+	rpcMetrics{backend: backend}.observe(withTestIdentity("tenant-a"), "/m", time.Now(), nil)
+
+	var got []string
+	for _, h := range backend.handled {
+		got = append(got, h.identity)
+	}
+	if want := []string{"tenant-a", "other", "tenant-a", ""}; !slices.Equal(got, want) {
+		t.Errorf("identity labels %q, want %q", got, want)
+	}
+
+	rpcMetrics{backend: prometheusMetrics{}, identities: newIdentityLabeler(1)}.observe(withTestIdentity("tenant-a"), "/test.Identity/Label", time.Now(), nil)
+	if want := `grpc_server_handled_total{code="OK",identity="tenant-a",method="/test.Identity/Label"} 1`; !strings.Contains(scrape(t), want) {
+		t.Errorf("/metrics lacks %s", want)
+	}
+}
+
+func TestLatencyExemplars(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929e0e0e4736"
+	m := rpcMetrics{backend: prometheusMetrics{}}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01"))
+	m.observe(ctx, "/test.Exemplar/Traced", time.Now(), nil)
+	m.observe(context.Background(), "/test.Exemplar/Untraced", time.Now(), nil)
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	rec := httptest.NewRecorder()
+	metricsHandler().ServeHTTP(rec, req)
+	var traced, untraced bool
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if !strings.HasPrefix(line, "grpc_server_handling_seconds_bucket") {
+			continue
+		}
+		switch {
+		case strings.Contains(line, "/test.Exemplar/Traced"):
+			traced = traced || strings.Contains(line, `# {trace_id="`+traceID+`"}`)
+		case strings.Contains(line, "/test.Exemplar/Untraced"):
+			untraced = untraced || strings.Contains(line, "# {")
+		}
+	}
+	if !traced {
+		t.Error("no exemplar with the trace ID on the traced RPC's latency")
+	}
+	if untraced {
+		t.Error("exemplar on an RPC without trace context")
+	}
+	if strings.Contains(scrape(t), "trace_id") {
+		t.Error("exemplars in the text format")
+	}
+}
+
+func TestPluggableMetricsBackend(t *testing.T) {
+	backend := &recordingMetrics{}
+	m := rpcMetrics{backend: backend}
+	s := newTestServer(t, serviceDefaults{interval: 10 * time.Millisecond})
+	client := timeClient(t, s, grpc.UnaryInterceptor(m.unaryInterceptor), grpc.StreamInterceptor(m.streamInterceptor))
+	const streamMethod = "/time.TimeService/StreamTime"
+	snapshot := func() ([]handledRPC, int) {
+		backend.mu.Lock()
+		defer backend.mu.Unlock()
+		return slices.Clone(backend.handled), backend.streams[streamMethod]
+	}
+
+	if _, err := client.GetTime(context.Background(), &pb.TimeRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	client.GetTime(context.Background(), &pb.TimeRequest{Timezone: "Nowhere/Special"})
+	stream, err := client.StreamTime(context.Background(), &pb.TimeRequest{MessageCount: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	if _, open := snapshot(); open != 1 {
+		t.Errorf("%d streams open during the stream, want 1", open)
+	}
+	for err == nil {
+		_, err = stream.Recv()
+	}
+	if err != io.EOF {
+		t.Fatal(err)
+	}
+
+	want := []handledRPC{
+		{method: "/time.TimeService/GetTime", code: "OK"},
+		{method: "/time.TimeService/GetTime", code: "InvalidArgument"},
+		{method: streamMethod, code: "OK"},
+	}
+	// The stream is recorded once its handler returns, which may be just
+	// after the client sees the end of the stream.
+	deadline := time.Now().Add(time.Second)
+	handled, open := snapshot()
+	for len(handled) < len(want) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+		handled, open = snapshot()
+	}
+	if !slices.Equal(handled, want) {
+		t.Errorf("handled %+v, want %+v", handled, want)
+	}
+	if open != 0 {
+		t.Errorf("%d streams open after the stream ended, want 0", open)
+	}
+}
@@ -0,0 +1,108 @@
+// otel.go
+//
+// This file implements a Metrics backend that records the RPC metrics
+// through an OpenTelemetry MeterProvider and exports them over OTLP/gRPC.
+// The exporter is configured by the standard OTEL_EXPORTER_OTLP_*
+// environment variables; without an endpoint the backend records nothing.
+
+package server
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// otelMetrics records to OpenTelemetry instruments of the same names and
+// meaning as the Prometheus metrics.
+type otelMetrics struct {
+	provider *sdkmetric.MeterProvider
+	handled  metric.Int64Counter
+	duration metric.Float64Histogram
+	active   metric.Int64UpDownCounter
+}
+
+// newOTelMetrics returns a backend exporting over OTLP, or noopMetrics when
+// neither OTEL_EXPORTER_OTLP_ENDPOINT nor OTEL_EXPORTER_OTLP_METRICS_ENDPOINT
+// is set.
+func newOTelMetrics() (Metrics, error) {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_METRICS_ENDPOINT") == "" {
+		log.Println("No OTLP endpoint configured, OpenTelemetry metrics are disabled")
+		return noopMetrics{}, nil
+	}
+	exporter, err := otlpmetricgrpc.New(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	res := resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName("envoy-hck"),
+		semconv.ServiceVersion(version),
+	)
+	provider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter)),
+		sdkmetric.WithResource(res),
+	)
+	m, err := newOTelMetricsFrom(provider)
+	if err != nil {
+		provider.Shutdown(context.Background())
+		return nil, err
+	}
+	return m, nil
+}
+
+// newOTelMetricsFrom creates the instruments on provider.
+func newOTelMetricsFrom(provider *sdkmetric.MeterProvider) (*otelMetrics, error) {
+	meter := provider.Meter("github.com/dethi/envoy_hck")
+	m := &otelMetrics{provider: provider}
+	var err error
+	if m.handled, err = meter.Int64Counter("grpc_server_handled_total",
+		metric.WithDescription("RPCs completed on the server, by method, status code and client identity.")); err != nil {
+		return nil, err
+	}
+	if m.duration, err = meter.Float64Histogram("grpc_server_handling_seconds",
+		metric.WithDescription("Time taken to complete RPCs, by method and client identity."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(prometheus.DefBuckets...)); err != nil {
+		return nil, err
+	}
+	if m.active, err = meter.Int64UpDownCounter("grpc_server_active_streams",
+		metric.WithDescription("Streams currently open, by method.")); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *otelMetrics) RPCHandled(method, code, identity, traceID string, duration time.Duration) {
+	ctx := context.Background()
+	m.handled.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("method", method),
+		attribute.String("code", code),
+		attribute.String("identity", identity),
+	))
+	m.duration.Record(ctx, duration.Seconds(), metric.WithAttributes(
+		attribute.String("method", method),
+		attribute.String("identity", identity),
+	))
+}
+
+func (m *otelMetrics) StreamStarted(method string) {
+	m.active.Add(context.Background(), 1, metric.WithAttributes(attribute.String("method", method)))
+}
+
+func (m *otelMetrics) StreamEnded(method string) {
+	m.active.Add(context.Background(), -1, metric.WithAttributes(attribute.String("method", method)))
+}
+
+// Shutdown exports the metrics recorded since the last export.
+func (m *otelMetrics) Shutdown(ctx context.Context) error {
+	return m.provider.Shutdown(ctx)
+}
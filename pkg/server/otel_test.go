@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestOTelMetrics(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	m, err := newOTelMetricsFrom(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const method = "/time.TimeService/StreamTime"
+	m.StreamStarted(method)
+	m.StreamStarted(method)
+	m.StreamEnded(method)
+	m.RPCHandled(method, "OK", "tenant-a", "", 20*time.Millisecond)
+	m.RPCHandled(method, "OK", "tenant-a", "", 40*time.Millisecond)
+	m.RPCHandled(method, "Unavailable", "tenant-a", "", time.Millisecond)
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	instruments := make(map[string]metricdata.Aggregation)
+	for _, sm := range rm.ScopeMetrics {
+		for _, im := range sm.Metrics {
+			instruments[im.Name] = im.Data
+		}
+	}
+
+	handled, ok := instruments["grpc_server_handled_total"].(metricdata.Sum[int64])
+	if !ok {
+		t.Fatalf("grpc_server_handled_total is %T, want an int64 sum", instruments["grpc_server_handled_total"])
+	}
+	counts := make(map[string]int64)
+	for _, dp := range handled.DataPoints {
+		code, _ := dp.Attributes.Value("code")
+		if identity, _ := dp.Attributes.Value("identity"); identity != attribute.StringValue("tenant-a") {
+			t.Errorf("data point identity %v, want tenant-a", identity.Emit())
+		}
+		counts[code.AsString()] = dp.Value
+	}
+	if counts["OK"] != 2 || counts["Unavailable"] != 1 {
+		t.Errorf("handled counts %v, want 2 OK and 1 Unavailable", counts)
+	}
+
+	duration, ok := instruments["grpc_server_handling_seconds"].(metricdata.Histogram[float64])
+	if !ok || len(duration.DataPoints) != 1 {
+		t.Fatalf("grpc_server_handling_seconds is %#v, want one float64 histogram point", instruments["grpc_server_handling_seconds"])
+	}
+	if dp := duration.DataPoints[0]; dp.Count != 3 || dp.Sum < 0.06 || dp.Sum > 0.062 {
+		t.Errorf("handling seconds count %d sum %g, want 3 totalling 0.061", dp.Count, dp.Sum)
+	}
+
+	active, ok := instruments["grpc_server_active_streams"].(metricdata.Sum[int64])
+	if !ok || len(active.DataPoints) != 1 || active.DataPoints[0].Value != 1 {
+		t.Errorf("grpc_server_active_streams is %#v, want 1", instruments["grpc_server_active_streams"])
+	}
+}
+
+func TestOTelMetricsWithoutEndpoint(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_METRICS_ENDPOINT", "")
+	m, err := newMetricsBackend("otel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.(noopMetrics); !ok {
+		t.Errorf("otel without an endpoint is %T, want noopMetrics", m)
+	}
+	both, err := newMetricsBackend("prometheus, otel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ms, ok := both.(multiMetrics); !ok || len(ms) != 2 {
+		t.Errorf("prometheus,otel is %#v, want both backends", both)
+	}
+	if _, err := newMetricsBackend("statsd"); err == nil {
+		t.Error("unknown backend accepted")
+	}
+}
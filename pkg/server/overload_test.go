@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/dethi/envoy_hck/protos"
+)
+
+func TestGoroutineGuard(t *testing.T) {
+	guard := &goroutineGuard{max: 1, response: overloadResponse{code: codes.ResourceExhausted, retryAfter: 1500 * time.Millisecond}}
+	s := newTestServer(t, serviceDefaults{interval: 10 * time.Millisecond})
+	s.overload = guard
+	client := timeClient(t, s, grpc.UnaryInterceptor(guard.unaryInterceptor))
+	rejections := overloadRejections.WithLabelValues("/time.TimeService/GetTime")
+	before := counterValue(t, rejections)
+
+	var trailer metadata.MD
+	_, err := client.GetTime(context.Background(), &pb.TimeRequest{}, grpc.Trailer(&trailer))
+	if st := status.Convert(err); st.Code() != codes.ResourceExhausted || !strings.Contains(st.Message(), "limit is 1") {
+		t.Errorf("GetTime over the limit: %v, want RESOURCE_EXHAUSTED naming the limit", err)
+	}
+	if got := trailer.Get("retry-after"); len(got) != 1 || got[0] != "2" {
+		t.Errorf("retry-after %v, want 2", got)
+	}
+	if got := counterValue(t, rejections) - before; got != 1 {
+		t.Errorf("overload_rejections_total went up by %g, want 1", got)
+	}
+
+	stream, err := client.StreamTime(context.Background(), &pb.TimeRequest{MessageCount: 1})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("StreamTime over the limit: %v, want RESOURCE_EXHAUSTED", err)
+	}
+
+	guard.max = 1 << 20
+	if _, err := client.GetTime(context.Background(), &pb.TimeRequest{}); err != nil {
+		t.Errorf("GetTime under the limit: %v", err)
+	}
+}
+
+func TestInFlightLimiter(t *testing.T) {
+	limiter := &inFlightLimiter{max: 1, response: overloadResponse{code: codes.Unavailable}}
+	s := newTestServer(t, serviceDefaults{interval: 10 * time.Millisecond})
+	client := timeClient(t, s, grpc.UnaryInterceptor(limiter.unaryInterceptor), grpc.StreamInterceptor(limiter.streamInterceptor))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := client.StreamTime(ctx, &pb.TimeRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	var trailer metadata.MD
+	if _, err := client.GetTime(context.Background(), &pb.TimeRequest{}, grpc.Trailer(&trailer)); status.Code(err) != codes.Unavailable {
+		t.Errorf("GetTime with a stream in flight: %v, want UNAVAILABLE", err)
+	}
+	if got := trailer.Get("retry-after"); got != nil {
+		t.Errorf("retry-after %v without -overload-retry-after", got)
+	}
+
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for limiter.n.Load() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the cancelled stream was not released")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := client.GetTime(context.Background(), &pb.TimeRequest{}); err != nil {
+		t.Errorf("GetTime once the stream ended: %v", err)
+	}
+}
@@ -0,0 +1,43 @@
+// padding.go
+//
+// This file produces the filler bytes TimeResponse carries when a client
+// asks for padding, to simulate larger payloads through Envoy's buffering
+// and the compressors.
+
+package server
+
+import (
+	"math/rand/v2"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxPadBytes keeps padded responses under gRPC's default 4MiB receive
+// limit on clients.
+const maxPadBytes = 4<<20 - 1024
+
+// fillerBlock is pseudo-random so that padding compresses like real data
+// rather than collapsing to nothing. Responses share slices of it; they
+// are only read.
+var fillerBlock = sync.OnceValue(func() []byte {
+	r := rand.New(rand.NewPCG(1, 2))
+	b := make([]byte, maxPadBytes)
+	for i := range b {
+		b[i] = byte(r.Uint32())
+	}
+	return b
+})
+
+// padding returns n filler bytes, or an InvalidArgument error when n is out
+// of range.
+func padding(n int32) ([]byte, error) {
+	if n < 0 || n > maxPadBytes {
+		return nil, status.Errorf(codes.InvalidArgument, "pad_bytes must be between 0 and %d, got %d", maxPadBytes, n)
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	return fillerBlock()[:n], nil
+}
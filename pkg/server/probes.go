@@ -0,0 +1,172 @@
+// probes.go
+//
+// This file serves Kubernetes-style HTTP probes on the HTTP server:
+//
+//	/healthz    liveness: passes while the process serves HTTP at all
+//	/readyz     readiness: passes while the gRPC health service reports
+//	            the server, or the service of ?service=, SERVING
+//	/startupz   startup: passes once the gRPC listeners are serving and
+//	            -startup-probe-delay has passed
+//
+// Readiness reads the same state as the gRPC health service, so the two
+// agree by default. /probes overrides each probe independently, to
+// simulate an instance whose HTTP and gRPC health checks disagree.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// probeModes are the overrides a probe accepts; "" derives the result
+// from the server's state.
+var probeModes = []string{"", "pass", "fail"}
+
+// probeOverrides forces the result of some probes. The zero value
+// overrides nothing.
+type probeOverrides struct {
+	Healthz  string `json:"healthz,omitempty"`
+	Readyz   string `json:"readyz,omitempty"`
+	Startupz string `json:"startupz,omitempty"`
+}
+
+func (o probeOverrides) validate() error {
+	for probe, mode := range map[string]string{"healthz": o.Healthz, "readyz": o.Readyz, "startupz": o.Startupz} {
+		if !slices.Contains(probeModes, mode) {
+			return fmt.Errorf("%s must be pass, fail or empty, got %q", probe, mode)
+		}
+	}
+	return nil
+}
+
+// probes answers the HTTP probes.
+type probes struct {
+	started atomic.Bool
+
+	mu        sync.Mutex
+	overrides probeOverrides
+}
+
+// markStarted makes the startup probe pass.
+func (p *probes) markStarted() {
+	p.started.Store(true)
+	log.Println("Startup probe passing")
+}
+
+// readiness returns why the server, or svc if not empty, is not ready, or
+// "" if it is.
+func readiness(svc string) string {
+	mu.Lock()
+	defer mu.Unlock()
+	status, ok := healthStatuses()[svc]
+	switch {
+	case !ok:
+		return fmt.Sprintf("unknown service %q", svc)
+	case status == grpc_health_v1.HealthCheckResponse_SERVING:
+		return ""
+	case shuttingDown.Load():
+		return "shutting down"
+	case !isLeader.Load():
+		return "not the leader"
+	case breakerOpen.Load():
+		return "send circuit breaker open"
+	case svc != "" && isHealthy.Load():
+		return fmt.Sprintf("health of %s set to %s", svc, status)
+	default:
+		return "health set to " + status.String()
+	}
+}
+
+func (p *probes) serveHealthz(w http.ResponseWriter, r *http.Request) {
+	p.answer(w, r, "healthz", p.override().Healthz, "")
+}
+
+func (p *probes) serveReadyz(w http.ResponseWriter, r *http.Request) {
+	p.answer(w, r, "readyz", p.override().Readyz, readiness(r.URL.Query().Get("service")))
+}
+
+func (p *probes) serveStartupz(w http.ResponseWriter, r *http.Request) {
+	reason := ""
+	if !p.started.Load() {
+		reason = "starting"
+	}
+	p.answer(w, r, "startupz", p.override().Startupz, reason)
+}
+
+func (p *probes) override() probeOverrides {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.overrides
+}
+
+// answer writes the result of probe: 200 if it passes, 503 with the
+// reason otherwise. mode, if set, overrides the reason derived from the
+// server's state. The result is JSON if the client accepts it.
+func (p *probes) answer(w http.ResponseWriter, r *http.Request, probe, mode, reason string) {
+	switch mode {
+	case "pass":
+		reason = ""
+	case "fail":
+		reason = "overridden through /probes"
+	}
+	code, result := http.StatusOK, "ok"
+	if reason != "" {
+		code, result = http.StatusServiceUnavailable, "failing"
+	}
+	if acceptsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]string{"probe": probe, "result": result, "reason": reason})
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(code)
+	if reason != "" {
+		result += ": " + reason
+	}
+	fmt.Fprintln(w, result)
+}
+
+// ServeHTTP shows the overrides on GET, replaces them with the
+// probeOverrides in the body on PUT, and clears them on DELETE.
+func (p *probes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var overrides probeOverrides
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&overrides); err != nil {
+			writeJSONError(w, "invalid probe overrides: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := overrides.validate(); err != nil {
+			writeJSONError(w, "invalid probe overrides: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		p.set(overrides)
+	case http.MethodDelete:
+		p.set(probeOverrides{})
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeJSONError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p.override())
+}
+
+func (p *probes) set(overrides probeOverrides) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.overrides = overrides
+	log.Printf("Probe overrides set to healthz=%q readyz=%q startupz=%q", overrides.Healthz, overrides.Readyz, overrides.Startupz)
+}
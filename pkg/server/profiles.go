@@ -0,0 +1,94 @@
+// profiles.go
+//
+// This file defines the TLS profiles of additional gRPC listeners, so Envoy
+// clusters with different transport sockets can be tested side by side
+// against one process. Every listener serves the same services and shares
+// all state; only the transport differs:
+//
+//   - mtls: TLS requiring a client certificate signed by the CA;
+//   - tls: one-way TLS, no client certificate requested;
+//   - plaintext: no TLS, gRPC over cleartext HTTP/2.
+//
+// In the configuration file:
+//
+//	extra-listeners:
+//	  - :50052=tls
+//	  - :50053=plaintext
+//	  - unix:///run/envoy-hck/grpc.sock=plaintext
+
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// listenerProfiles are the transports an extra listener can have.
+var listenerProfiles = []string{"mtls", "tls", "plaintext"}
+
+// extraListener is one entry of -extra-listeners.
+type extraListener struct {
+	addr, profile string
+}
+
+// parseExtraListeners parses "addr=profile" entries.
+func parseExtraListeners(specs []string) ([]extraListener, error) {
+	var out []extraListener
+	for _, spec := range specs {
+		i := strings.LastIndex(spec, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("%q: want addr=profile", spec)
+		}
+		addr, profile := spec[:i], spec[i+1:]
+		if _, ok := unixSocketPath(addr); !ok {
+			if _, _, err := net.SplitHostPort(addr); err != nil {
+				return nil, fmt.Errorf("%q: %v", spec, err)
+			}
+		}
+		if !slices.Contains(listenerProfiles, profile) {
+			return nil, fmt.Errorf("%q: unknown profile %q, want %s", spec, profile, strings.Join(listenerProfiles, ", "))
+		}
+		out = append(out, extraListener{addr, profile})
+	}
+	return out, nil
+}
+
+// profileCreds returns the credentials of a listener with profile. The TLS
+// profiles derive from base, the configuration of -grpc-addr, and keep its
+// certificate source, verifiers and TLS policy; they only override the
+// client authentication.
+func profileCreds(profile string, base *tls.Config, handshakes *handshakeLog) credentials.TransportCredentials {
+	switch profile {
+	case "plaintext":
+		return insecure.NewCredentials()
+	case "tls":
+		return handshakeTimeoutCreds{credentials.NewTLS(withClientAuth(base, tls.NoClientCert)), handshakes}
+	default:
+		return handshakeTimeoutCreds{credentials.NewTLS(withClientAuth(base, tls.RequireAndVerifyClientCert)), handshakes}
+	}
+}
+
+// withClientAuth returns a copy of base, including the configurations its
+// GetConfigForClient returns, with the client authentication mode auth.
+func withClientAuth(base *tls.Config, auth tls.ClientAuthType) *tls.Config {
+	cfg := base.Clone()
+	cfg.ClientAuth = auth
+	if next := base.GetConfigForClient; next != nil {
+		cfg.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			c, err := next(hello)
+			if err != nil || c == nil {
+				return c, err
+			}
+			c = c.Clone()
+			c.ClientAuth = auth
+			return c, nil
+		}
+	}
+	return cfg
+}
@@ -0,0 +1,194 @@
+// proxyproto.go
+//
+// This file accepts the PROXY protocol, versions 1 and 2, on the gRPC
+// listeners, for Envoy's proxy_protocol transport socket or listener
+// filter. The header is read before the TLS handshake, under the same
+// deadline, and the downstream address it carries becomes the remote
+// address of the connection: logs show it, and WhoAmI and /connections
+// report it next to the address of the proxy.
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// proxyV2Signature starts every PROXY protocol v2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyV1MaxLength is the longest v1 header, CRLF included.
+const proxyV1MaxLength = 107
+
+// proxiedAddr is the remote address of a connection with a PROXY header:
+// the downstream source, as it prints, and the peer that sent the header.
+type proxiedAddr struct {
+	source net.Addr
+	peer   net.Addr
+}
+
+func (a proxiedAddr) Network() string { return a.source.Network() }
+func (a proxiedAddr) String() string  { return a.source.String() }
+
+// splitProxied returns the peer of a connection and, if it sent a PROXY
+// header for another source, that source.
+func splitProxied(addr net.Addr) (peer, source net.Addr) {
+	if p, ok := addr.(proxiedAddr); ok {
+		return p.peer, p.source
+	}
+	return addr, nil
+}
+
+// proxyProtocolCreds wraps server credentials to read a PROXY header first.
+// With optional set, connections without one are accepted as they are.
+type proxyProtocolCreds struct {
+	credentials.TransportCredentials
+	optional bool
+}
+
+func (c proxyProtocolCreds) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	pconn, version, err := readProxyHeader(conn, c.optional)
+	if err != nil {
+		proxyProtocolConns.WithLabelValues("invalid").Inc()
+		log.Printf("Rejecting connection from %s: invalid PROXY protocol header: %v", conn.RemoteAddr(), err)
+		return nil, nil, err
+	}
+	proxyProtocolConns.WithLabelValues(version).Inc()
+	return c.TransportCredentials.ServerHandshake(pconn)
+}
+
+func (c proxyProtocolCreds) Clone() credentials.TransportCredentials {
+	return proxyProtocolCreds{c.TransportCredentials.Clone(), c.optional}
+}
+
+// proxyConn is a connection whose PROXY header was consumed.
+type proxyConn struct {
+	net.Conn
+	r      *bufio.Reader
+	remote net.Addr
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) { return c.r.Read(b) }
+func (c *proxyConn) RemoteAddr() net.Addr       { return c.remote }
+
+// readProxyHeader reads the PROXY header of conn and returns conn with the
+// source address it names, and the header version: "v1", "v2", "local"
+// for a v2 header sent by the proxy itself (e.g. a health check), or
+// "none" for an optional header that is missing.
+func readProxyHeader(conn net.Conn, optional bool) (net.Conn, string, error) {
+	r := bufio.NewReader(conn)
+	pc := &proxyConn{Conn: conn, r: r, remote: conn.RemoteAddr()}
+	start, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, "", err
+	}
+	var source net.Addr
+	version := "v1"
+	switch {
+	case bytes.Equal(start, proxyV2Signature):
+		version = "v2"
+		source, err = readProxyV2(r)
+		if err == nil && source == nil {
+			version = "local"
+		}
+	case bytes.HasPrefix(start, []byte("PROXY ")):
+		source, err = readProxyV1(r)
+	case optional:
+		return pc, "none", nil
+	default:
+		return nil, "", errors.New("no PROXY protocol header")
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if source != nil {
+		pc.remote = proxiedAddr{source: source, peer: conn.RemoteAddr()}
+	}
+	return pc, version, nil
+}
+
+// readProxyV1 reads a text header such as
+// "PROXY TCP4 203.0.113.7 10.0.0.1 56324 443\r\n". The source of
+// "PROXY UNKNOWN" is nil.
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < proxyV1MaxLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	text, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, errors.New("v1 header is not terminated by CRLF")
+	}
+	fields := strings.Fields(text)
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || fields[1] != "TCP4" && fields[1] != "TCP6" {
+		return nil, fmt.Errorf("malformed v1 header %q", text)
+	}
+	ip, err := netip.ParseAddr(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed v1 source address: %v", err)
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("malformed v1 source port: %v", err)
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(port))), nil
+}
+
+// readProxyV2 reads a binary header. The source of a LOCAL command, or of
+// an address family other than TCP over IPv4 or IPv6, is nil.
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported v2 version %d", hdr[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	command, family := hdr[12]&0xf, hdr[13]
+	switch {
+	case command == 0:
+		return nil, nil
+	case command != 1:
+		return nil, fmt.Errorf("unknown v2 command %d", command)
+	}
+	var ipLen int
+	switch family {
+	case 0x11: // TCP over IPv4
+		ipLen = 4
+	case 0x21: // TCP over IPv6
+		ipLen = 16
+	default:
+		return nil, nil
+	}
+	if len(body) < 2*ipLen+4 {
+		return nil, fmt.Errorf("v2 address block too short: %d bytes", len(body))
+	}
+	ip, _ := netip.AddrFromSlice(body[:ipLen])
+	port := binary.BigEndian.Uint16(body[2*ipLen:])
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, port)), nil
+}
@@ -0,0 +1,113 @@
+// ratelimit.go
+//
+// This file caps the request rate of the whole server, or of each client
+// identity, with a token bucket, to protect it from thundering herds such
+// as every client reconnecting after an Envoy failover. Rejected RPCs
+// carry a retry-after trailer with the number of seconds until a token
+// frees up.
+
+package server
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// rateLimitScopes are the scopes of -rate-limit: one bucket for the whole
+// server, or one per client identity.
+var rateLimitScopes = []string{"global", "identity"}
+
+// rateLimiter admits RPCs at a steady rate with bursts.
+type rateLimiter struct {
+	perSecond float64
+	burst     int
+	// limiter is the global bucket, nil when every identity has its own
+	// in identities. Identities come from client certificates the CA
+	// signed, so their number is bounded.
+	limiter    *rate.Limiter
+	mu         sync.Mutex
+	identities map[string]*rate.Limiter
+}
+
+// newRateLimiter admits perSecond RPCs per second on average and up to
+// burst at once, in total or for each identity with perIdentity. A global
+// limiter exports the tokens currently available.
+func newRateLimiter(perSecond float64, burst int, perIdentity bool) *rateLimiter {
+	l := &rateLimiter{perSecond: perSecond, burst: burst}
+	if perIdentity {
+		l.identities = make(map[string]*rate.Limiter)
+		return l
+	}
+	l.limiter = rate.NewLimiter(rate.Limit(perSecond), burst)
+	promauto.With(registry).NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "rate_limit_tokens",
+		Help: "RPCs the global rate limiter would admit right now.",
+	}, func() float64 { return l.limiter.Tokens() })
+	return l
+}
+
+// bucket returns the bucket the RPC of ctx draws from.
+func (l *rateLimiter) bucket(ctx context.Context) *rate.Limiter {
+	if l.limiter != nil {
+		return l.limiter
+	}
+	id := IdentityFromContext(ctx).Name()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.identities[id]
+	if !ok {
+		b = rate.NewLimiter(rate.Limit(l.perSecond), l.burst)
+		l.identities[id] = b
+	}
+	return b
+}
+
+// admit reports whether the RPC of ctx may proceed and, if not, returns
+// the ResourceExhausted error along with the trailer to send.
+func (l *rateLimiter) admit(ctx context.Context, method string) (metadata.MD, error) {
+	r := l.bucket(ctx).Reserve()
+	delay := r.Delay()
+	if delay == 0 {
+		return nil, nil
+	}
+	// Give the token back; the client is told when to come back instead.
+	r.Cancel()
+	rateLimitRejections.WithLabelValues(method).Inc()
+	if delay == rate.InfDuration {
+		delay = time.Second
+	}
+	retryAfter := strconv.Itoa(int(math.Ceil(delay.Seconds())))
+	scope := "server"
+	if l.limiter == nil {
+		scope = "client"
+	}
+	return metadata.Pairs("retry-after", retryAfter),
+		status.Errorf(codes.ResourceExhausted, "%s request rate limit exceeded, retry in %ss", scope, retryAfter)
+}
+
+func (l *rateLimiter) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if trailer, err := l.admit(ctx, info.FullMethod); err != nil {
+		grpc.SetTrailer(ctx, trailer)
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (l *rateLimiter) streamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if trailer, err := l.admit(ss.Context(), info.FullMethod); err != nil {
+		ss.SetTrailer(trailer)
+		return err
+	}
+	return handler(srv, ss)
+}
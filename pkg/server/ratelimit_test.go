@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/dethi/envoy_hck/protos"
+)
+
+func TestGlobalRateLimit(t *testing.T) {
+	// One token every two seconds, so none frees up during the test.
+	limiter := &rateLimiter{limiter: rate.NewLimiter(0.5, 2)}
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(unaryWhen(exemptInfrastructure, limiter.unaryInterceptor)),
+		grpc.StreamInterceptor(streamWhen(exemptInfrastructure, limiter.streamInterceptor)),
+	)
+	pb.RegisterTimeServiceServer(srv, newTestServer(t, serviceDefaults{}))
+	grpc_health_v1.RegisterHealthServer(srv, health.NewServer())
+	conn := bufconnClient(t, srv)
+	client := pb.NewTimeServiceClient(conn)
+	rejections := rateLimitRejections.WithLabelValues("/time.TimeService/GetTime")
+	before := counterValue(t, rejections)
+
+	for i := range 2 {
+		if _, err := client.GetTime(context.Background(), &pb.TimeRequest{}); err != nil {
+			t.Fatalf("GetTime %d within the burst: %v", i, err)
+		}
+	}
+	for i := range 3 {
+		var trailer metadata.MD
+		_, err := client.GetTime(context.Background(), &pb.TimeRequest{}, grpc.Trailer(&trailer))
+		if status.Code(err) != codes.ResourceExhausted {
+			t.Errorf("GetTime %d above the rate: %v, want RESOURCE_EXHAUSTED", i, err)
+		}
+		if got := trailer.Get("retry-after"); len(got) != 1 || got[0] != "2" {
+			t.Errorf("GetTime %d: retry-after %v, want 2", i, got)
+		}
+	}
+	if got := counterValue(t, rejections) - before; got != 3 {
+		t.Errorf("rate_limit_rejections_total went up by %g, want 3", got)
+	}
+
+	stream, err := client.StreamTime(context.Background(), &pb.TimeRequest{MessageCount: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("StreamTime above the rate: %v, want RESOURCE_EXHAUSTED", err)
+	}
+	if got := stream.Trailer().Get("retry-after"); len(got) != 1 || got[0] != "2" {
+		t.Errorf("StreamTime: retry-after %v, want 2", got)
+	}
+
+	for range 5 {
+		if _, err := grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{}); err != nil {
+			t.Fatalf("health check above the rate: %v, want it exempt", err)
+		}
+	}
+	if tokens := limiter.limiter.Tokens(); tokens >= 1 {
+		t.Errorf("%g tokens left, want none", tokens)
+	}
+}
@@ -0,0 +1,108 @@
+// recovery.go
+//
+// This file turns panics in RPC handlers into status errors instead of
+// crashing the process. The code returned depends on the panic value:
+// handlers may panic with a ValidationError, a registered sentinel, or a
+// status error to fail the RPC with a specific code, and anything else
+// becomes Internal.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"runtime/debug"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ValidationError is a panic value that fails the RPC with
+// codes.InvalidArgument.
+type ValidationError struct {
+	Msg string
+}
+
+func (e ValidationError) Error() string { return e.Msg }
+
+// panicCode maps the panic values accepted by match to code.
+type panicCode struct {
+	name  string
+	match func(v any) bool
+	code  codes.Code
+}
+
+// panicCodes is the registry consulted in order when a handler panics.
+var panicCodes []panicCode
+
+// registerPanicType maps panics whose value has type T to code.
+func registerPanicType[T any](code codes.Code) {
+	panicCodes = append(panicCodes, panicCode{
+		name:  reflect.TypeFor[T]().String(),
+		match: func(v any) bool { _, ok := v.(T); return ok },
+		code:  code,
+	})
+}
+
+// registerPanicError maps panics with an error wrapping target to code.
+func registerPanicError(target error, code codes.Code) {
+	panicCodes = append(panicCodes, panicCode{
+		name: target.Error(),
+		match: func(v any) bool {
+			err, ok := v.(error)
+			return ok && errors.Is(err, target)
+		},
+		code: code,
+	})
+}
+
+func init() {
+	registerPanicType[ValidationError](codes.InvalidArgument)
+	registerPanicError(context.DeadlineExceeded, codes.DeadlineExceeded)
+	registerPanicError(context.Canceled, codes.Canceled)
+}
+
+// panicStatus returns the status error for a recovered panic value and the
+// mapping rule that produced it.
+func panicStatus(v any) (error, string) {
+	if err, ok := v.(error); ok {
+		if st, ok := status.FromError(err); ok {
+			return st.Err(), "status error"
+		}
+	}
+	for _, pc := range panicCodes {
+		if pc.match(v) {
+			return status.Error(pc.code, fmt.Sprint(v)), pc.name
+		}
+	}
+	// Unknown panics may carry internal details; keep them in the logs.
+	return status.Error(codes.Internal, "internal error"), "default"
+}
+
+func recoverRPC(method string, err *error) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	var rule string
+	*err, rule = panicStatus(v)
+	log.Printf("Recovered panic in %s: %v; returning %s (mapping: %s)\n%s", method, v, status.Code(*err), rule, debug.Stack())
+}
+
+// recoveryUnaryInterceptor converts handler panics to status errors. It
+// should run last so every other interceptor sees a regular error.
+func recoveryUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	defer recoverRPC(info.FullMethod, &err)
+	return handler(ctx, req)
+}
+
+// recoveryStreamInterceptor is the streaming counterpart of
+// recoveryUnaryInterceptor.
+func recoveryStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer recoverRPC(info.FullMethod, &err)
+	return handler(srv, ss)
+}
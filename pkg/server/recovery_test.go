@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPanicCodes(t *testing.T) {
+	errQuota := errors.New("quota exceeded")
+	saved := slices.Clone(panicCodes)
+	registerPanicError(errQuota, codes.ResourceExhausted)
+	t.Cleanup(func() { panicCodes = saved })
+	logs := captureLog(t)
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Panic/Unary"}
+	for _, tc := range []struct {
+		name    string
+		value   any
+		code    codes.Code
+		message string
+		rule    string
+	}{
+		{"validation error", ValidationError{Msg: "bad input"}, codes.InvalidArgument, "bad input", "server.ValidationError"},
+		{"status error", status.Error(codes.NotFound, "no such thing"), codes.NotFound, "no such thing", "status error"},
+		{"deadline", fmt.Errorf("waiting: %w", context.DeadlineExceeded), codes.DeadlineExceeded, "waiting: context deadline exceeded", "context deadline exceeded"},
+		{"registered sentinel", fmt.Errorf("tenant a: %w", errQuota), codes.ResourceExhausted, "tenant a: quota exceeded", "quota exceeded"},
+		{"string", "secret internals", codes.Internal, "internal error", "default"},
+		{"other error", errors.New("boom"), codes.Internal, "internal error", "default"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := recoveryUnaryInterceptor(context.Background(), nil, info, func(context.Context, any) (any, error) {
+				panic(tc.value)
+			})
+			if st := status.Convert(err); st.Code() != tc.code || st.Message() != tc.message {
+				t.Errorf("panic(%#v) returned %v, want %s %q", tc.value, err, tc.code, tc.message)
+			}
+			if want := fmt.Sprintf("returning %s (mapping: %s)", tc.code, tc.rule); !strings.Contains(logs.String(), want) {
+				t.Errorf("log lacks %q", want)
+			}
+		})
+	}
+
+	streamInfo := &grpc.StreamServerInfo{FullMethod: "/test.Panic/Stream"}
+	err := recoveryStreamInterceptor(nil, nil, streamInfo, func(any, grpc.ServerStream) error {
+		panic(ValidationError{Msg: "bad stream"})
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("stream panic returned %v, want INVALID_ARGUMENT", err)
+	}
+}
@@ -0,0 +1,46 @@
+// replay.go
+//
+// This file loads a recorded sequence of timestamps that StreamTime can emit
+// instead of the wall clock, for deterministic demos and client assertions.
+
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// loadReplay reads one RFC3339 timestamp per line from path. Blank lines and
+// lines starting with '#' are ignored. Any other line that does not parse
+// fails the whole load so a typo is caught at startup, not mid-stream.
+func loadReplay(path string) ([]time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var seq []time.Time
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, text)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid RFC3339 timestamp %q", path, line, text)
+		}
+		seq = append(seq, t)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(seq) == 0 {
+		return nil, fmt.Errorf("%s contains no timestamps", path)
+	}
+	return seq, nil
+}
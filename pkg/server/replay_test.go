@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pb "github.com/dethi/envoy_hck/protos"
+)
+
+func TestLoadReplay(t *testing.T) {
+	seq, err := loadReplay("testdata/replay.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seq) != 3 || !seq[2].Equal(time.Date(2024, 3, 1, 11, 0, 10, 0, time.UTC)) {
+		t.Errorf("loaded %v", seq)
+	}
+
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"bad":   "2024-03-01T12:00:00Z\nyesterday\n",
+		"empty": "# nothing\n\n",
+	} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(content), 0o600)
+		if _, err := loadReplay(path); err == nil {
+			t.Errorf("%s replay file accepted", name)
+		}
+	}
+}
+
+func TestStreamTimeReplay(t *testing.T) {
+	seq, err := loadReplay("testdata/replay.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, loop := range []bool{false, true} {
+		s := newTestServer(t, serviceDefaults{})
+		s.replay, s.replayLoop = seq, loop
+		stream, err := timeClient(t, s).StreamTime(context.Background(), &pb.TimeRequest{IntervalMs: 1, Format: "rfc3339"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for range 5 {
+			resp, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, resp.GetCurrentTime())
+		}
+		want := []string{"2024-03-01T12:00:00Z", "2024-03-01T12:00:05Z", "2024-03-01T12:00:10+01:00"}
+		if loop {
+			want = append(want, want[:2]...)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("loop %v: got %v, want %v", loop, got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("loop %v: tick %d = %s, want %s", loop, i, got[i], want[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// retry.go
+//
+// This file implements the simulated flaky backend used by GetTime: a
+// request carrying a RetryHint fails with a retriable status a fixed number
+// of times before succeeding, so proxy retry policies can be validated end
+// to end.
+
+package server
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+)
+
+// retriableCodes are the status codes a RetryHint may ask for, keyed by
+// their canonical name.
+var retriableCodes = map[string]codes.Code{
+	"UNAVAILABLE":        codes.Unavailable,
+	"RESOURCE_EXHAUSTED": codes.ResourceExhausted,
+}
+
+// retriableCode resolves the code named in a RetryHint, defaulting to
+// UNAVAILABLE.
+func retriableCode(name string) (codes.Code, error) {
+	if name == "" {
+		return codes.Unavailable, nil
+	}
+	c, ok := retriableCodes[name]
+	if !ok {
+		return codes.OK, fmt.Errorf("unsupported retry code %q (want UNAVAILABLE or RESOURCE_EXHAUSTED)", name)
+	}
+	return c, nil
+}
+
+// maxRetryKeys bounds how many retry keys a retryTracker remembers, since
+// clients choose them freely.
+const maxRetryKeys = 10000
+
+// retryTracker counts the failures already served for each retry key. The
+// zero value is ready to use and remembers up to maxRetryKeys keys.
+type retryTracker struct {
+	mu    sync.Mutex
+	max   int // overrides maxRetryKeys if positive
+	seq   uint64
+	fails map[string]retryEntry
+}
+
+// retryEntry is the state of one retry key.
+type retryEntry struct {
+	attempts int32
+	seq      uint64 // when the key was last attempted, for eviction
+}
+
+// fail records an attempt for key with a budget of n failures. It returns
+// the attempt number and whether the attempt must fail. Once the budget is
+// spent the key is forgotten, so the next request starts a new sequence.
+// A new key arriving when the tracker is full evicts the key attempted
+// least recently, whose sequence then starts over.
+func (t *retryTracker) fail(key string, n int32) (int32, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.fails == nil {
+		t.fails = make(map[string]retryEntry)
+	}
+	e, ok := t.fails[key]
+	attempt := e.attempts + 1
+	if attempt > n {
+		delete(t.fails, key)
+		return attempt, false
+	}
+	if !ok {
+		t.evict()
+	}
+	t.seq++
+	t.fails[key] = retryEntry{attempts: attempt, seq: t.seq}
+	return attempt, true
+}
+
+// evict makes room for one more key. Callers must hold mu.
+func (t *retryTracker) evict() {
+	limit := t.max
+	if limit <= 0 {
+		limit = maxRetryKeys
+	}
+	if len(t.fails) < limit {
+		return
+	}
+	var oldest string
+	oldestSeq := uint64(math.MaxUint64)
+	for key, e := range t.fails {
+		if e.seq < oldestSeq {
+			oldest, oldestSeq = key, e.seq
+		}
+	}
+	delete(t.fails, oldest)
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/dethi/envoy_hck/protos"
+)
+
+func TestRetryHint(t *testing.T) {
+	client := timeClient(t, newTestServer(t, serviceDefaults{}))
+	ctx := context.Background()
+	req := &pb.TimeRequest{RetryHint: &pb.RetryHint{Failures: 2, Code: "RESOURCE_EXHAUSTED", Key: "test"}}
+	for attempt := 1; attempt <= 2; attempt++ {
+		if _, err := client.GetTime(ctx, req); status.Code(err) != codes.ResourceExhausted {
+			t.Fatalf("attempt %d: %v, want RESOURCE_EXHAUSTED", attempt, err)
+		}
+	}
+	if _, err := client.GetTime(ctx, req); err != nil {
+		t.Fatalf("attempt 3: %v, want success", err)
+	}
+	// The budget starts over for the next request.
+	if _, err := client.GetTime(ctx, req); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("next request: %v, want RESOURCE_EXHAUSTED", err)
+	}
+	// Keys have budgets of their own.
+	other := &pb.TimeRequest{RetryHint: &pb.RetryHint{Failures: 1, Key: "other"}}
+	if _, err := client.GetTime(ctx, other); status.Code(err) != codes.Unavailable {
+		t.Errorf("other key: %v, want UNAVAILABLE", err)
+	}
+	bad := &pb.TimeRequest{RetryHint: &pb.RetryHint{Failures: 1, Code: "INTERNAL"}}
+	if _, err := client.GetTime(ctx, bad); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("unsupported code: %v, want INVALID_ARGUMENT", err)
+	}
+}
+
+func TestRetryTrackerEviction(t *testing.T) {
+	tracker := &retryTracker{max: 2}
+	tracker.fail("a", 3)
+	tracker.fail("b", 3)
+	tracker.fail("a", 3) // a is now the most recently attempted
+	tracker.fail("c", 3) // evicts b
+	if len(tracker.fails) != 2 {
+		t.Fatalf("%d keys remembered, want 2", len(tracker.fails))
+	}
+	if attempt, _ := tracker.fail("a", 3); attempt != 3 {
+		t.Errorf("a at attempt %d, want 3", attempt)
+	}
+	if attempt, _ := tracker.fail("b", 3); attempt != 1 {
+		t.Errorf("evicted b at attempt %d, want a new sequence", attempt)
+	}
+	if _, ok := tracker.fails["c"]; ok {
+		t.Error("c was kept over the more recent a and b")
+	}
+
+	// A client sending a new key on every call cannot grow it unbounded.
+	var unbounded retryTracker
+	for i := range maxRetryKeys + 100 {
+		unbounded.fail(strconv.Itoa(i), 1)
+	}
+	if len(unbounded.fails) != maxRetryKeys {
+		t.Errorf("%d keys remembered, want %d", len(unbounded.fails), maxRetryKeys)
+	}
+}
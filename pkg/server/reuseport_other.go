@@ -0,0 +1,16 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+// reuseport_other.go
+//
+// This file rejects multiple listeners on platforms without SO_REUSEPORT.
+
+package server
+
+import (
+	"errors"
+	"syscall"
+)
+
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return errors.New("multiple listeners require SO_REUSEPORT, which is not supported on this platform")
+}
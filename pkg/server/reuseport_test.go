@@ -0,0 +1,66 @@
+//go:build linux
+
+package server
+
+import (
+	"net"
+	"sync"
+	"testing"
+)
+
+// Linux balances the connections of a port across the sockets bound to
+// it with SO_REUSEPORT; other systems may hand them all to one socket.
+func TestReusePortDistributesConnections(t *testing.T) {
+	listeners, err := listenGRPC("127.0.0.1:0", 4, listenOptions{family: "4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	accepted := make(map[int]int)
+	for i, lis := range listeners {
+		defer lis.Close()
+		if lis.Addr().String() != listeners[0].Addr().String() {
+			t.Fatalf("listener %d bound %s, want %s", i, lis.Addr(), listeners[0].Addr())
+		}
+		go func() {
+			for {
+				conn, err := lis.Accept()
+				if err != nil {
+					return
+				}
+				mu.Lock()
+				accepted[i]++
+				mu.Unlock()
+				conn.Close()
+			}
+		}()
+	}
+
+	const conns = 64
+	var wg sync.WaitGroup
+	for range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := net.Dial("tcp", listeners[0].Addr().String())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			// Wait for the server side to close it.
+			conn.Read(make([]byte, 1))
+			conn.Close()
+		}()
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	total := 0
+	for _, n := range accepted {
+		total += n
+	}
+	if total != conns || len(accepted) < 2 {
+		t.Errorf("connections per listener %v, want %d spread over several", accepted, conns)
+	}
+}
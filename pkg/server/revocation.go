@@ -0,0 +1,282 @@
+// revocation.go
+//
+// This file rejects revoked client certificates, so revocation, a common
+// mTLS edge case, can be reproduced: against CRLs loaded from a file, which
+// is reloaded when it changes, and against the OCSP responders named by
+// the certificates. It also staples an OCSP response to the server
+// certificate, for clients that check the server's revocation status.
+
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// loadCRLs parses the PEM or DER encoded CRLs in path.
+func loadCRLs(path string) ([]*x509.RevocationList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Contains(data, []byte("-----BEGIN")) {
+		crl, err := x509.ParseRevocationList(data)
+		if err != nil {
+			return nil, err
+		}
+		return []*x509.RevocationList{crl}, nil
+	}
+	var crls []*x509.RevocationList
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "X509 CRL" {
+			continue
+		}
+		crl, err := x509.ParseRevocationList(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		crls = append(crls, crl)
+	}
+	if len(crls) == 0 {
+		return nil, errors.New("no X509 CRL block found")
+	}
+	return crls, nil
+}
+
+// crlChecker rejects client certificates revoked by the CRLs of a file.
+type crlChecker struct {
+	path string
+	crls atomic.Pointer[[]*x509.RevocationList]
+}
+
+// newCRLChecker loads the CRLs in path.
+func newCRLChecker(path string) (*crlChecker, error) {
+	c := &crlChecker{path: path}
+	crls, err := loadCRLs(path)
+	if err != nil {
+		return nil, err
+	}
+	c.crls.Store(&crls)
+	log.Printf("Loaded %d CRLs from %s", len(crls), path)
+	return c, nil
+}
+
+// reload replaces the CRLs with the file's current content. On failure the
+// previous CRLs stay in force.
+func (c *crlChecker) reload(source string) {
+	crls, err := loadCRLs(c.path)
+	if err != nil {
+		log.Printf("CRL reload (%s) failed, keeping the previous CRLs: %v", source, err)
+		return
+	}
+	c.crls.Store(&crls)
+	log.Printf("Reloaded %d CRLs (%s)", len(crls), source)
+}
+
+// revoked reports whether a CRL signed by issuer revokes cert.
+func (c *crlChecker) revoked(cert, issuer *x509.Certificate) bool {
+	for _, crl := range *c.crls.Load() {
+		if !bytes.Equal(crl.RawIssuer, cert.RawIssuer) || crl.CheckSignatureFrom(issuer) != nil {
+			continue
+		}
+		for _, entry := range crl.RevokedCertificateEntries {
+			if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// verify is a tlsutil.PeerVerifier rejecting clients whose certificate, or any
+// intermediate of their chain, is revoked.
+func (c *crlChecker) verify(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+	for _, chain := range verifiedChains {
+		for i := 0; i+1 < len(chain); i++ {
+			if c.revoked(chain[i], chain[i+1]) {
+				revocationRejections.WithLabelValues("crl").Inc()
+				err := fmt.Errorf("certificate %q (serial %s) is revoked by CRL", chain[i].Subject, chain[i].SerialNumber.Text(16))
+				log.Printf("Rejecting client: %v", err)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ocspChecker queries the OCSP responder of client certificates and rejects
+// revoked ones. Responses are cached until their NextUpdate. In soft mode,
+// a certificate whose status cannot be determined is accepted; in hard
+// mode it is rejected.
+type ocspChecker struct {
+	hard   bool
+	client *http.Client
+
+	mu    sync.Mutex
+	cache map[string]*ocsp.Response // by issuer and serial
+}
+
+// ocspCacheTTL is how long a response without NextUpdate is cached.
+const ocspCacheTTL = time.Hour
+
+func newOCSPChecker(hard bool) *ocspChecker {
+	return &ocspChecker{
+		hard:   hard,
+		client: &http.Client{Timeout: 5 * time.Second},
+		cache:  make(map[string]*ocsp.Response),
+	}
+}
+
+// status returns the OCSP response for cert, from the cache or its
+// responder.
+func (o *ocspChecker) status(cert, issuer *x509.Certificate) (*ocsp.Response, error) {
+	key := string(issuer.RawSubjectPublicKeyInfo) + cert.SerialNumber.String()
+	o.mu.Lock()
+	resp, ok := o.cache[key]
+	o.mu.Unlock()
+	if ok && time.Now().Before(ocspExpiry(resp)) {
+		return resp, nil
+	}
+	if len(cert.OCSPServer) == 0 {
+		return nil, errors.New("certificate names no OCSP responder")
+	}
+	req, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+	httpResp, err := o.client.Post(cert.OCSPServer[0], "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OCSP responder answered %s", httpResp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(httpResp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	resp, err = ocsp.ParseResponseForCert(body, cert, issuer)
+	if err != nil {
+		return nil, err
+	}
+	o.mu.Lock()
+	o.cache[key] = resp
+	o.mu.Unlock()
+	return resp, nil
+}
+
+// ocspExpiry returns when resp should be fetched again.
+func ocspExpiry(resp *ocsp.Response) time.Time {
+	if resp.NextUpdate.IsZero() {
+		return resp.ThisUpdate.Add(ocspCacheTTL)
+	}
+	return resp.NextUpdate
+}
+
+// verify is a tlsutil.PeerVerifier checking the leaf certificate of the client
+// with its OCSP responder.
+func (o *ocspChecker) verify(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+	if len(verifiedChains) == 0 || len(verifiedChains[0]) < 2 {
+		return nil
+	}
+	leaf, issuer := verifiedChains[0][0], verifiedChains[0][1]
+	if err := o.check(leaf, issuer); err != nil {
+		revocationRejections.WithLabelValues("ocsp").Inc()
+		log.Printf("Rejecting client: %v", err)
+		return err
+	}
+	return nil
+}
+
+// check returns why leaf must be rejected, if it must.
+func (o *ocspChecker) check(leaf, issuer *x509.Certificate) error {
+	resp, err := o.status(leaf, issuer)
+	switch {
+	case err != nil && o.hard:
+		return fmt.Errorf("cannot check OCSP status of %q: %v", leaf.Subject, err)
+	case err != nil:
+		log.Printf("Accepting %q without OCSP status: %v", leaf.Subject, err)
+		return nil
+	case resp.Status == ocsp.Revoked:
+		return fmt.Errorf("certificate %q (serial %s) is revoked by OCSP since %s", leaf.Subject, leaf.SerialNumber.Text(16), resp.RevokedAt.Format(time.RFC3339))
+	case resp.Status != ocsp.Good && o.hard:
+		return fmt.Errorf("OCSP status of %q is unknown", leaf.Subject)
+	}
+	return nil
+}
+
+// ocspStapler staples the OCSP response of a file to the server
+// certificate.
+type ocspStapler struct {
+	path   string
+	staple atomic.Pointer[[]byte]
+}
+
+// newOCSPStapler loads the DER encoded OCSP response in path.
+func newOCSPStapler(path string) (*ocspStapler, error) {
+	s := &ocspStapler{path: path}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *ocspStapler) load() error {
+	der, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	resp, err := ocsp.ParseResponse(der, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", s.path, err)
+	}
+	s.staple.Store(&der)
+	log.Printf("Stapling OCSP response from %s: serial %s, next update %s", s.path, resp.SerialNumber.Text(16), resp.NextUpdate.Format(time.RFC3339))
+	return nil
+}
+
+// reload replaces the staple with the file's current content. On failure
+// the previous staple is kept.
+func (s *ocspStapler) reload(source string) {
+	if err := s.load(); err != nil {
+		log.Printf("OCSP staple reload (%s) failed, keeping the previous response: %v", source, err)
+	}
+}
+
+// configForClient wraps a tls.Config.GetConfigForClient callback, or base
+// when there is none, so the server certificate carries the staple.
+func (s *ocspStapler) configForClient(base *tls.Config, next func(*tls.ClientHelloInfo) (*tls.Config, error)) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		cfg := base
+		if next != nil {
+			var err error
+			if cfg, err = next(hello); err != nil {
+				return nil, err
+			}
+		}
+		cfg = cfg.Clone()
+		cfg.GetConfigForClient = nil
+		cfg.Certificates = append([]tls.Certificate(nil), cfg.Certificates...)
+		cfg.Certificates[0].OCSPStaple = *s.staple.Load()
+		return cfg, nil
+	}
+}
@@ -0,0 +1,85 @@
+// rpclog.go
+//
+// This file logs one structured record per completed RPC, with the method,
+// peer, client identity, status, duration and message counts, plus the
+// x-request-id and trace ID Envoy propagates, so the server's logs can be
+// joined with Envoy's access logs on %REQ(X-REQUEST-ID)%.
+
+package server
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// rpcLogLevel returns the level of the record of an RPC ending with code:
+// error for codes that point at a server bug, info otherwise.
+func rpcLogLevel(code codes.Code) slog.Level {
+	switch code {
+	case codes.Unknown, codes.Internal, codes.DataLoss:
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// logRPC logs an RPC that started at start and ended with err, having
+// received and sent the given numbers of messages.
+func logRPC(ctx context.Context, method string, start time.Time, received, sent int, err error) {
+	code := status.Code(err)
+	var addr string
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+	}
+	attrs := []slog.Attr{
+		slog.String("method", method),
+		slog.String("peer", addr),
+		slog.String("identity", IdentityFromContext(ctx).Name()),
+		slog.String("code", code.String()),
+		slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+		slog.Int("messages_received", received),
+		slog.Int("messages_sent", sent),
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get("x-request-id"); len(v) > 0 {
+		attrs = append(attrs, slog.String("request_id", v[0]))
+	}
+	if id := traceIDFromContext(ctx); id != "" {
+		attrs = append(attrs, slog.String("trace_id", id))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", status.Convert(err).Message()))
+	}
+	slog.LogAttrs(ctx, rpcLogLevel(code), "RPC", attrs...)
+}
+
+// rpcLogUnaryInterceptor logs every unary RPC.
+func rpcLogUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	sent := 0
+	if err == nil {
+		sent = 1
+	}
+	logRPC(ctx, info.FullMethod, start, 1, sent, err)
+	return resp, err
+}
+
+// rpcLogStreamInterceptor logs every streaming RPC with the messages it
+// carried. Handlers may receive from another goroutine, which can still be
+// running when they return, hence the atomic counts.
+func rpcLogStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	var received, sent atomic.Int64
+	err := handler(srv, &countingStream{ServerStream: ss, sent: &sent, received: &received})
+	logRPC(ss.Context(), info.FullMethod, start, int(received.Load()), int(sent.Load()), err)
+	return err
+}
@@ -0,0 +1,202 @@
+// sds.go
+//
+// This file obtains the server certificate and client trust bundle over
+// Envoy's Secret Discovery Service (SDS) instead of files. A small client
+// subscribes to one TLS certificate secret and one validation context
+// secret, and every update the control plane pushes replaces the TLS
+// material for new handshakes. The SDS server is expected to be local,
+// typically on a Unix socket, and is reached without TLS.
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	tlsv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	discoveryv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	secretv3 "github.com/envoyproxy/go-control-plane/envoy/service/secret/v3"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/dethi/envoy_hck/pkg/tlsutil"
+)
+
+// secretTypeURL is the type of the resources served by SDS.
+const secretTypeURL = "type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.Secret"
+
+// sdsSource is a tlsutil.Store fed by an SDS server.
+type sdsSource struct {
+	tlsutil.Store
+
+	client           secretv3.SecretDiscoveryServiceClient
+	certName, caName string
+
+	secrets   map[string]*tlsv3.Secret // latest secrets by name; only run uses it
+	ready     chan struct{}
+	readyOnce sync.Once
+}
+
+// newSDSSource subscribes to the secrets certName and caName on the SDS
+// server at addr, a gRPC target such as unix:///run/sds.sock, and waits up
+// to timeout for both. The subscription lasts until ctx is done.
+func newSDSSource(ctx context.Context, addr, certName, caName string, timeout time.Duration) (_ *sdsSource, err error) {
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	s := &sdsSource{
+		client:   secretv3.NewSecretDiscoveryServiceClient(conn),
+		certName: certName,
+		caName:   caName,
+		secrets:  make(map[string]*tlsv3.Secret),
+		ready:    make(chan struct{}),
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer func() {
+		if err != nil {
+			cancel()
+		}
+	}()
+	go func() {
+		s.run(ctx)
+		conn.Close()
+	}()
+	select {
+	case <-s.ready:
+		return s, nil
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("secrets %q and %q not received from %s within %s", certName, caName, addr, timeout)
+	}
+}
+
+// run keeps a subscription open, reconnecting with backoff when the stream
+// breaks, until ctx is done.
+func (s *sdsSource) run(ctx context.Context) {
+	backoff := time.Second
+	for {
+		err := s.subscribe(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("SDS stream ended, retrying in %s: %v", backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff = min(2*backoff, 30*time.Second)
+	}
+}
+
+// subscribe requests the secrets and applies every response until the
+// stream fails, acknowledging the valid ones.
+func (s *sdsSource) subscribe(ctx context.Context) error {
+	stream, err := s.client.StreamSecrets(ctx)
+	if err != nil {
+		return err
+	}
+	req := &discoveryv3.DiscoveryRequest{
+		Node:          &corev3.Node{Id: "envoy-hck"},
+		ResourceNames: []string{s.certName, s.caName},
+		TypeUrl:       secretTypeURL,
+	}
+	for {
+		if err := stream.Send(req); err != nil {
+			return err
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		req.ResponseNonce = resp.GetNonce()
+		if err := s.apply(resp); err != nil {
+			tlsReloadFailures.Inc()
+			log.Printf("Rejecting SDS update %s, keeping the current TLS material: %v", resp.GetVersionInfo(), err)
+			req.ErrorDetail = &rpcstatus.Status{Code: int32(codes.InvalidArgument), Message: err.Error()}
+			continue
+		}
+		req.VersionInfo, req.ErrorDetail = resp.GetVersionInfo(), nil
+	}
+}
+
+// apply merges the secrets of resp with those received before and, once
+// both are known, swaps in the material they make up.
+func (s *sdsSource) apply(resp *discoveryv3.DiscoveryResponse) error {
+	updated := make(map[string]*tlsv3.Secret, len(s.secrets))
+	for name, secret := range s.secrets {
+		updated[name] = secret
+	}
+	for _, res := range resp.GetResources() {
+		var secret tlsv3.Secret
+		if err := res.UnmarshalTo(&secret); err != nil {
+			return err
+		}
+		updated[secret.GetName()] = &secret
+	}
+	certSecret, caSecret := updated[s.certName], updated[s.caName]
+	if certSecret == nil || caSecret == nil {
+		s.secrets = updated
+		return nil
+	}
+	m, err := sdsMaterial(certSecret.GetTlsCertificate(), caSecret.GetValidationContext())
+	if err != nil {
+		return err
+	}
+	s.secrets = updated
+	s.Set(m)
+	tlsutil.LogLoaded("SDS version "+resp.GetVersionInfo(), m)
+	s.readyOnce.Do(func() { close(s.ready) })
+	return nil
+}
+
+// sdsMaterial builds TLS material from a certificate and a validation
+// context secret.
+func sdsMaterial(certSecret *tlsv3.TlsCertificate, caSecret *tlsv3.CertificateValidationContext) (*tlsutil.Material, error) {
+	if certSecret == nil {
+		return nil, errors.New("certificate secret has no tls_certificate")
+	}
+	if caSecret == nil {
+		return nil, errors.New("validation context secret has no validation_context")
+	}
+	certPEM, err := dataSourceBytes(certSecret.GetCertificateChain())
+	if err != nil {
+		return nil, fmt.Errorf("certificate_chain: %w", err)
+	}
+	keyPEM, err := dataSourceBytes(certSecret.GetPrivateKey())
+	if err != nil {
+		return nil, fmt.Errorf("private_key: %w", err)
+	}
+	caPEM, err := dataSourceBytes(caSecret.GetTrustedCa())
+	if err != nil {
+		return nil, fmt.Errorf("trusted_ca: %w", err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return tlsutil.NewMaterial(cert, tlsutil.ParseCertsPEM(caPEM))
+}
+
+// dataSourceBytes returns the contents of an inline or file data source.
+func dataSourceBytes(ds *corev3.DataSource) ([]byte, error) {
+	switch spec := ds.GetSpecifier().(type) {
+	case *corev3.DataSource_InlineBytes:
+		return spec.InlineBytes, nil
+	case *corev3.DataSource_InlineString:
+		return []byte(spec.InlineString), nil
+	case *corev3.DataSource_Filename:
+		return os.ReadFile(spec.Filename)
+	default:
+		return nil, errors.New("unsupported or empty data source")
+	}
+}
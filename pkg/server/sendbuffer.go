@@ -0,0 +1,150 @@
+// sendbuffer.go
+//
+// This file decouples a stream's ticks from its client. With -send-buffer,
+// StreamTime queues its messages for a sender goroutine, so a slow client
+// no longer holds up the tick loop. When the queue is full, the overflow
+// policy drops the oldest queued message, drops the new one, or ends the
+// stream.
+
+package server
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/dethi/envoy_hck/protos"
+)
+
+// sendOverflowPolicies are the -send-buffer-overflow values.
+var sendOverflowPolicies = []string{"drop-oldest", "drop-newest", "disconnect"}
+
+// errSlowConsumer ends a stream whose send buffer overflowed under the
+// disconnect policy.
+var errSlowConsumer = status.Error(codes.ResourceExhausted, "client is not reading fast enough: send buffer full")
+
+// sendQueue delivers the messages of one stream. With a depth of zero it
+// sends them synchronously, as the stream did before send buffers.
+type sendQueue struct {
+	stream pb.TimeService_StreamTimeServer
+	policy string
+	sent   atomic.Int64
+
+	// The fields below are only used with a buffer.
+	ch       chan *pb.TimeResponse
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{} // closed when the sender exits
+	err      error         // why the sender exited, set before done closes
+}
+
+// newSendQueue returns the queue of stream, buffering up to depth messages
+// and applying policy when they do not fit.
+func newSendQueue(stream pb.TimeService_StreamTimeServer, depth int, policy string) *sendQueue {
+	q := &sendQueue{stream: stream, policy: policy}
+	if depth > 0 {
+		q.ch = make(chan *pb.TimeResponse, depth)
+		q.stop = make(chan struct{})
+		q.done = make(chan struct{})
+		go q.run()
+	}
+	return q
+}
+
+func (q *sendQueue) run() {
+	defer close(q.done)
+	for {
+		// Checked first so an abort discards what is still buffered.
+		select {
+		case <-q.stop:
+			return
+		default:
+		}
+		select {
+		case resp, ok := <-q.ch:
+			if !ok {
+				return
+			}
+			if err := q.stream.Send(resp); err != nil {
+				q.err = err
+				return
+			}
+			q.sent.Add(1)
+		case <-q.stop:
+			return
+		}
+	}
+}
+
+// push sends resp or queues it. It returns the error of a synchronous
+// send, or errSlowConsumer when the buffer overflowed under the
+// disconnect policy.
+func (q *sendQueue) push(resp *pb.TimeResponse) error {
+	if q.ch == nil {
+		if err := q.stream.Send(resp); err != nil {
+			return err
+		}
+		q.sent.Add(1)
+		return nil
+	}
+	select {
+	case q.ch <- resp:
+		return nil
+	default:
+	}
+	switch q.policy {
+	case "drop-newest":
+		sendBufferDropped.WithLabelValues(q.policy).Inc()
+	case "disconnect":
+		sendBufferDropped.WithLabelValues(q.policy).Add(float64(len(q.ch) + 1))
+		log.Printf("Send buffer of %d messages full, disconnecting %s", cap(q.ch), IdentityFromContext(q.stream.Context()).Name())
+		return errSlowConsumer
+	default: // drop-oldest
+		select {
+		case <-q.ch:
+			sendBufferDropped.WithLabelValues(q.policy).Inc()
+		default:
+		}
+		// Only the sender takes from the buffer, so there is room now.
+		select {
+		case q.ch <- resp:
+		default:
+		}
+	}
+	return nil
+}
+
+// failed returns a channel closed when the sender stopped because a send
+// failed, with the error in sendErr. It is nil without a buffer.
+func (q *sendQueue) failed() <-chan struct{} { return q.done }
+
+// sendErr returns the error that stopped the sender.
+func (q *sendQueue) sendErr() error { return q.err }
+
+// flush waits for the buffered messages to be sent, unless ctx ends first,
+// and returns the error of a send that failed meanwhile.
+func (q *sendQueue) flush(ctx context.Context) error {
+	if q.ch == nil {
+		return nil
+	}
+	close(q.ch)
+	select {
+	case <-q.done:
+		return q.err
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// abort stops the sender, discarding the buffered messages. A send in
+// progress is left behind, as with -send-timeout; it returns once the
+// handler exits and gRPC cancels the stream.
+func (q *sendQueue) abort() {
+	if q.stop != nil {
+		q.stopOnce.Do(func() { close(q.stop) })
+	}
+}
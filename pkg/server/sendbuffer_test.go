@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/dethi/envoy_hck/protos"
+)
+
+// blockedStream is a StreamTime stream whose sends block until release is
+// closed, like a client that stopped reading.
+type blockedStream struct {
+	grpc.ServerStream
+	entered chan struct{} // receives a value as each send starts
+	release chan struct{}
+
+	mu   sync.Mutex
+	sent []int64 // sequences
+}
+
+func newBlockedStream() *blockedStream {
+	return &blockedStream{entered: make(chan struct{}, 16), release: make(chan struct{})}
+}
+
+func (s *blockedStream) Context() context.Context { return context.Background() }
+
+func (s *blockedStream) Send(resp *pb.TimeResponse) error {
+	s.entered <- struct{}{}
+	<-s.release
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, resp.GetSequence())
+	return nil
+}
+
+func (s *blockedStream) sequences() []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.sent)
+}
+
+func TestSendBufferOverflow(t *testing.T) {
+	for _, tc := range []struct {
+		policy      string
+		wantSent    []int64
+		wantDropped float64
+	}{
+		{"drop-oldest", []int64{1, 3, 4}, 1},
+		{"drop-newest", []int64{1, 2, 3}, 1},
+		{"disconnect", []int64{1}, 3},
+	} {
+		t.Run(tc.policy, func(t *testing.T) {
+			stream := newBlockedStream()
+			q := newSendQueue(stream, 2, tc.policy)
+			dropped := sendBufferDropped.WithLabelValues(tc.policy)
+			before := counterValue(t, dropped)
+
+			// The sender takes the first message and blocks sending it,
+			// then the next two fill the buffer.
+			if err := q.push(&pb.TimeResponse{Sequence: 1}); err != nil {
+				t.Fatal(err)
+			}
+			<-stream.entered
+			for seq := int64(2); seq <= 3; seq++ {
+				if err := q.push(&pb.TimeResponse{Sequence: seq}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := q.push(&pb.TimeResponse{Sequence: 4})
+			if tc.policy == "disconnect" {
+				if err != errSlowConsumer {
+					t.Fatalf("overflowing push: %v, want errSlowConsumer", err)
+				}
+				q.abort()
+				close(stream.release)
+				<-q.done
+			} else {
+				if err != nil {
+					t.Fatalf("overflowing push: %v", err)
+				}
+				close(stream.release)
+				if err := q.flush(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if got := stream.sequences(); !slices.Equal(got, tc.wantSent) {
+				t.Errorf("sent %v, want %v", got, tc.wantSent)
+			}
+			if got := q.sent.Load(); got != int64(len(tc.wantSent)) {
+				t.Errorf("%d messages counted as sent, want %d", got, len(tc.wantSent))
+			}
+			if got := counterValue(t, dropped) - before; got != tc.wantDropped {
+				t.Errorf("send_buffer_dropped_total went up by %g, want %g", got, tc.wantDropped)
+			}
+		})
+	}
+}
+
+func TestSendBufferUnbuffered(t *testing.T) {
+	stream := newBlockedStream()
+	close(stream.release)
+	q := newSendQueue(stream, 0, "disconnect")
+	for seq := int64(1); seq <= 3; seq++ {
+		if err := q.push(&pb.TimeResponse{Sequence: seq}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := stream.sequences(); !slices.Equal(got, []int64{1, 2, 3}) {
+		t.Errorf("sent %v, want every message in order", got)
+	}
+	if q.failed() != nil {
+		t.Error("a synchronous queue has a sender to fail")
+	}
+}
+
+func TestSendBufferDisconnectsSlowClient(t *testing.T) {
+	logs := captureLog(t)
+	s := newTestServer(t, serviceDefaults{})
+	s.sendBuffer, s.sendOverflow = 4, "disconnect"
+	srv := grpc.NewServer()
+	pb.RegisterTimeServiceServer(srv, s)
+	// The client reads nothing, so the flow control windows fill up and
+	// the buffer behind them.
+	client := pb.NewTimeServiceClient(bufconnClient(t, srv, grpc.WithInitialWindowSize(64<<10), grpc.WithInitialConnWindowSize(64<<10)))
+	stream, err := client.StreamTime(context.Background(), &pb.TimeRequest{IntervalMs: 1, PadBytes: 16 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(logs.String(), "Send buffer of 4 messages full, disconnecting") {
+		if time.Now().After(deadline) {
+			t.Fatal("slow client not disconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("stream ended with %v, want RESOURCE_EXHAUSTED", err)
+	}
+	if got := stream.Trailer().Get("x-stream-end-reason"); !slices.Equal(got, []string{"slow-consumer"}) {
+		t.Errorf("end reason %v, want slow-consumer", got)
+	}
+}
@@ -0,0 +1,58 @@
+// sendtimeout.go
+//
+// This file bounds how long a single stream send may block. A client that
+// stops reading lets flow control stall SendMsg indefinitely, pinning the
+// handler goroutine; with a send timeout the stream is aborted instead.
+
+package server
+
+import (
+	"log"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// sendTimeout returns a stream interceptor that fails any send blocking for
+// longer than d with codes.DeadlineExceeded.
+func sendTimeout(d time.Duration) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &timeoutStream{ServerStream: ss, method: info.FullMethod, timeout: d})
+	}
+}
+
+type timeoutStream struct {
+	grpc.ServerStream
+	method  string
+	timeout time.Duration
+}
+
+// SendMsg runs the send in a goroutine since ServerStream has no deadline
+// argument. On timeout the blocked send is left behind; it returns once the
+// handler exits and gRPC cancels the stream.
+func (s *timeoutStream) SendMsg(m any) error {
+	done := make(chan error, 1)
+	go func() { done <- s.ServerStream.SendMsg(m) }()
+
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		log.Printf("Send on %s to %s blocked for more than %s, aborting stream", s.method, IdentityFromContext(s.Context()).Name(), s.timeout)
+		return status.Errorf(codes.DeadlineExceeded, "send blocked for more than %s", s.timeout)
+	}
+}
+
+// sendFailed returns the status of a handler whose send of what failed
+// with err: Internal, except that a send that timed out keeps its
+// DeadlineExceeded code.
+func sendFailed(what string, err error) error {
+	if status.Code(err) == codes.DeadlineExceeded {
+		return err
+	}
+	return status.Errorf(codes.Internal, "failed to send %s: %v", what, err)
+}
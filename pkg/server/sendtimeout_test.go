@@ -0,0 +1,42 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/dethi/envoy_hck/protos"
+)
+
+func TestSendTimeoutBlockedClient(t *testing.T) {
+	logs := captureLog(t)
+	s := newTestServer(t, serviceDefaults{})
+	srv := grpc.NewServer(grpc.StreamInterceptor(sendTimeout(100 * time.Millisecond)))
+	pb.RegisterTimeServiceServer(srv, s)
+	// The client reads nothing, so the flow control windows fill up and
+	// the server's sends block.
+	client := pb.NewTimeServiceClient(bufconnClient(t, srv, grpc.WithInitialWindowSize(64<<10), grpc.WithInitialConnWindowSize(64<<10)))
+	stream, err := client.StreamTime(context.Background(), &pb.TimeRequest{IntervalMs: 1, PadBytes: 16 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(logs.String(), "blocked for more than 100ms, aborting stream") {
+		if time.Now().After(deadline) {
+			t.Fatal("blocked send not aborted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("stream ended with %v, want DEADLINE_EXCEEDED", err)
+	}
+}
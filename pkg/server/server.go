@@ -0,0 +1,1132 @@
+// server.go
+//
+// This file assembles the server from its Config: the TLS credentials, the
+// interceptor chains, a gRPC server per listener and the HTTP server, and
+// runs them until the context passed to Start is done. The binary in
+// cmd/envoy-hck is a thin wrapper around it, so integration tests can run
+// the same server in process:
+//
+//	srv, err := server.New(server.WithGRPCAddr("127.0.0.1:0"), server.WithSelfSigned(nil))
+//	...
+//	err = srv.Start(ctx)
+//	conn, err := grpc.NewClient(srv.GRPCAddr().String(), ...)
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	grpcgzip "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/reflection"
+
+	"github.com/dethi/envoy_hck/pkg/admin"
+	"github.com/dethi/envoy_hck/pkg/faults"
+	"github.com/dethi/envoy_hck/pkg/tlsutil"
+	pb "github.com/dethi/envoy_hck/protos"
+)
+
+// Option adjusts the configuration of a Server.
+type Option func(*Config)
+
+// WithConfig replaces the whole configuration with cfg. Options after it
+// adjust cfg.
+func WithConfig(cfg Config) Option {
+	return func(c *Config) { *c = cfg }
+}
+
+// WithGRPCAddr sets the address of the mTLS gRPC listener; port 0 picks a
+// free one, reported by GRPCAddr.
+func WithGRPCAddr(addr string) Option {
+	return func(c *Config) { c.GRPCAddr = addr }
+}
+
+// WithHTTPAddr sets the address of the HTTP server; port 0 picks a free
+// one, reported by HTTPAddr.
+func WithHTTPAddr(addr string) Option {
+	return func(c *Config) { c.HTTPAddr = addr }
+}
+
+// WithTLSFiles sets the server certificate, its key and the CA bundle
+// client certificates must chain to.
+func WithTLSFiles(certFile, keyFile, caFile string) Option {
+	return func(c *Config) {
+		c.CertFile, c.KeyFile, c.CAFile = certFile, keyFile, caFile
+	}
+}
+
+// WithSelfSigned serves the certificates of bundle instead of reading any
+// file. A nil bundle is generated, and its CA and client credentials are
+// printed on stdout as with -self-signed; tests build their own with
+// tlsutil.GenerateSelfSigned to dial the server with bundle.Client.
+func WithSelfSigned(bundle *tlsutil.SelfSignedBundle) Option {
+	return func(c *Config) { c.SelfSigned, c.SelfSignedBundle = true, bundle }
+}
+
+// WithFaultInjection enables the /faults endpoints and Server.Faults.
+func WithFaultInjection() Option {
+	return func(c *Config) { c.FaultInjection = true }
+}
+
+// WithChaos enables the /chaos endpoints.
+func WithChaos() Option {
+	return func(c *Config) { c.Chaos = true }
+}
+
+// WithMetrics records RPC metrics to m instead of the -metrics-backend.
+func WithMetrics(m Metrics) Option {
+	return func(c *Config) { c.Metrics = m }
+}
+
+// running is set while a Server runs. The health state, the event stream
+// and the metrics are process-wide, so two servers cannot run at once.
+var running atomic.Bool
+
+// Server is the gRPC server on every listener and the HTTP server, as
+// configured by a Config.
+type Server struct {
+	cfg Config
+
+	injector           *faults.Injector
+	grpcAddr, httpAddr net.Addr
+
+	started atomic.Bool
+	failed  chan error    // the first Serve failure, stopping the server
+	done    chan struct{} // closed once the server stopped
+	err     error         // the failure that stopped it, set before done
+}
+
+// New returns a Server configured by the defaults of every flag and opts,
+// or the list of invalid settings. The health status, instance registry,
+// event stream and metrics are process-wide, so only one Server may run
+// in a process at a time; Start fails while another one is running.
+func New(opts ...Option) (*Server, error) {
+	cfg := DefaultConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &Server{cfg: cfg, failed: make(chan error, 1), done: make(chan struct{})}, nil
+}
+
+// Start binds the listeners and starts serving, returning once they
+// accept connections. The server shuts down gracefully, as on SIGTERM,
+// when ctx is done; Wait returns once it has. A Server can only be started
+// once.
+func (s *Server) Start(ctx context.Context) error {
+	if !s.started.CompareAndSwap(false, true) {
+		return errors.New("server already started")
+	}
+	if !running.CompareAndSwap(false, true) {
+		return errors.New("another server is running in this process")
+	}
+	resetProcessState()
+	if err := s.start(ctx); err != nil {
+		running.Store(false)
+		return err
+	}
+	return nil
+}
+
+// Wait blocks until the server stopped and returns the error that stopped
+// it, nil if ctx was done.
+func (s *Server) Wait() error {
+	<-s.done
+	return s.err
+}
+
+// GRPCAddr returns the address of the first gRPC listener, once started.
+func (s *Server) GRPCAddr() net.Addr { return s.grpcAddr }
+
+// HTTPAddr returns the address of the HTTP server, once started.
+func (s *Server) HTTPAddr() net.Addr { return s.httpAddr }
+
+// Faults returns the injector of the faults the server injects, nil
+// without fault injection or before Start.
+func (s *Server) Faults() *faults.Injector { return s.injector }
+
+// stop stops the server because of err, unless it is already stopping.
+func (s *Server) stop(err error) {
+	select {
+	case s.failed <- err:
+	default:
+	}
+}
+
+// resetProcessState forgets the health state and events of a server that
+// ran before in this process.
+func resetProcessState() {
+	mu.Lock()
+	isHealthy.Store(false)
+	breakerOpen.Store(false)
+	shuttingDown.Store(false)
+	healthServices = nil
+	serviceOverride = map[string]grpc_health_v1.HealthCheckResponse_ServingStatus{}
+	published = map[string]grpc_health_v1.HealthCheckResponse_ServingStatus{}
+	mu.Unlock()
+	clusterInstances = nil
+	identityExtension = nil
+	events = &eventBus{}
+}
+
+// start builds the servers from s.cfg and starts serving. On failure it
+// closes the listeners it bound.
+func (s *Server) start(ctx context.Context) (err error) {
+	cfg := s.cfg
+	// background ends the goroutines started besides the servers, such as
+	// file watchers, schedules and signal handlers, once the servers have
+	// stopped.
+	background, stopBackground := context.WithCancel(context.Background())
+	defer func() {
+		if err != nil {
+			stopBackground()
+		}
+	}()
+	if err := grpcgzip.SetLevel(cfg.GzipLevel); err != nil {
+		return fmt.Errorf("invalid -gzip-level %d: %w", cfg.GzipLevel, err)
+	}
+	if cfg.Zstd {
+		RegisterZstd()
+	}
+
+	defaults := serviceDefaults{interval: cfg.DefaultInterval, format: cfg.DefaultFormat}
+	if cfg.DefaultTimezone != "" {
+		if defaults.location, err = time.LoadLocation(cfg.DefaultTimezone); err != nil {
+			return fmt.Errorf("invalid -default-timezone: %w", err)
+		}
+	}
+	timeServer, err := newServer(defaults)
+	if err != nil {
+		return fmt.Errorf("invalid service defaults: %w", err)
+	}
+	timeServer.replayLoop, timeServer.fixedAdvance, timeServer.canaryInterval = cfg.ReplayLoop, cfg.FixedTimeAdvance, cfg.CanaryInterval
+	timeServer.tickLabels = newIdentityLabeler(cfg.MetricsIdentityLimit)
+	var clockControl *adjustableClock
+	if cfg.ClockControl {
+		clockControl = newAdjustableClock(timeServer.clock)
+		timeServer.clock = clockControl
+	}
+	timeServer.logDroppedTicks = cfg.LogDroppedTicks
+	timeServer.sendBuffer, timeServer.sendOverflow = cfg.SendBuffer, cfg.SendBufferOverflow
+	if cfg.FixedTime != "" {
+		timeServer.fixedTime, err = time.Parse(time.RFC3339, cfg.FixedTime)
+		if err != nil {
+			return fmt.Errorf("invalid -fixed-time: %w", err)
+		}
+		log.Println("Reporting fixed time", cfg.FixedTime)
+	}
+	if cfg.ReplayFile != "" {
+		timeServer.replay, err = loadReplay(cfg.ReplayFile)
+		if err != nil {
+			return fmt.Errorf("failed to load replay file: %w", err)
+		}
+		log.Printf("Loaded %d replay timestamps from %s", len(timeServer.replay), cfg.ReplayFile)
+	}
+
+	// --- Load TLS credentials ---
+	var (
+		serverCert tls.Certificate
+		caCertPool *x509.CertPool
+		caCerts    []*x509.Certificate
+		store      *tlsutil.Store    // nil with -self-signed, unless -cert-lifetime
+		certs      *tlsutil.Reloader // set with -tls-source=file
+	)
+	if cfg.SelfSigned {
+		bundle := cfg.SelfSignedBundle
+		if bundle == nil {
+			if bundle, err = tlsutil.GenerateSelfSigned(); err != nil {
+				return fmt.Errorf("failed to generate self-signed certificates: %w", err)
+			}
+			log.Println("Using self-signed certificates; CA and client credentials follow on stdout")
+			os.Stdout.Write(bundle.CA.CertPEM)
+			os.Stdout.Write(bundle.Client.CertPEM)
+			os.Stdout.Write(bundle.Client.KeyPEM)
+		}
+		serverCert, caCertPool = bundle.Server.TLSCertificate(), bundle.Pool()
+		caCerts = []*x509.Certificate{bundle.CA.Cert}
+		if cfg.CertLifetime > 0 {
+			src, err := newShortLivedSource(background, bundle, cfg.CertLifetime, cfg.CertRenewal == "renew")
+			if err != nil {
+				return fmt.Errorf("failed to issue a short-lived certificate: %w", err)
+			}
+			store = &src.Store
+			serverCert = src.Current().Cert
+		}
+	} else {
+		switch cfg.TLSSource {
+		case "file":
+			certs, err = tlsutil.NewReloader(background, cfg.CertFile, cfg.KeyFile, cfg.CAFile)
+			if err == nil {
+				certs.OnFailure = func(error) { tlsReloadFailures.Inc() }
+				store = &certs.Store
+			}
+		case "spiffe":
+			var src *spiffeSource
+			if src, err = newSPIFFESource(background, cfg.SPIFFESocket, cfg.TLSSourceTimeout); err == nil {
+				store = &src.Store
+			}
+		case "sds":
+			var src *sdsSource
+			if src, err = newSDSSource(background, cfg.SDSAddr, cfg.SDSCertName, cfg.SDSCAName, cfg.TLSSourceTimeout); err == nil {
+				store = &src.Store
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load TLS material from %s: %w", cfg.TLSSource, err)
+		}
+		material := store.Current()
+		serverCert, caCertPool, caCerts = material.Cert, material.ClientCAs, material.Roots
+	}
+
+	if cfg.ExpectedServerName != "" {
+		if err := tlsutil.VerifyServerName(serverCert, cfg.ExpectedServerName); err != nil {
+			return fmt.Errorf("server certificate does not match -expected-server-name: %w", err)
+		}
+	}
+
+	clientAuth, err := tlsutil.ParseClientAuth(cfg.ClientAuth)
+	if err != nil {
+		return fmt.Errorf("invalid -client-auth: %w", err)
+	}
+	if clientAuth != tls.RequireAndVerifyClientCert {
+		log.Printf("Accepting clients without a verified certificate (-client-auth=%s)", cfg.ClientAuth)
+	}
+	minVersion, _ := tlsutil.ParseVersion(cfg.TLSMinVersion)
+	maxVersion, _ := tlsutil.ParseVersion(cfg.TLSMaxVersion)
+	cipherSuites, _ := tlsutil.ParseCipherSuites(cfg.TLSCipherSuites)
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    caCertPool,
+		ClientAuth:   clientAuth, // Certificates that are sent must come from our CA
+		MinVersion:   minVersion,
+		MaxVersion:   maxVersion,
+		CipherSuites: cipherSuites,
+		NextProtos:   cfg.ALPNProtocols,
+
+		SessionTicketsDisabled: !cfg.TLSSessionTickets,
+	}
+	var verifiers []tlsutil.PeerVerifier
+	if cfg.MaxVerifyDepth > 0 {
+		verifiers = append(verifiers, tlsutil.VerifyChainDepth(cfg.MaxVerifyDepth))
+	}
+	if len(cfg.ClientCertPins) > 0 {
+		pins, err := tlsutil.ParsePins(cfg.ClientCertPins)
+		if err != nil {
+			return fmt.Errorf("invalid -client-cert-pins: %w", err)
+		}
+		verifiers = append(verifiers, tlsutil.VerifyPinnedLeaf(pins))
+	}
+	if cfg.RequiredCertExtension != "" {
+		oid, err := tlsutil.ParseOID(cfg.RequiredCertExtension)
+		if err != nil {
+			return fmt.Errorf("invalid -required-cert-extension: %w", err)
+		}
+		identityExtension = oid
+		verifiers = append(verifiers, tlsutil.VerifyRequiredExtension(oid))
+	}
+	if cfg.CRLFile != "" {
+		crls, err := newCRLChecker(cfg.CRLFile)
+		if err != nil {
+			return fmt.Errorf("failed to load -crl-file: %w", err)
+		}
+		tlsutil.WatchFile(background, cfg.CRLFile, cfg.CertWatchInterval, crls.reload)
+		verifiers = append(verifiers, crls.verify)
+	}
+	if cfg.OCSPCheck != "off" {
+		verifiers = append(verifiers, newOCSPChecker(cfg.OCSPCheck == "hard").verify)
+	}
+	tlsConfig.VerifyPeerCertificate = tlsutil.AllVerifiers(verifiers...)
+	if cfg.RequireH2ALPN {
+		tlsConfig.VerifyConnection = tlsutil.RequireH2ALPN
+	}
+	if cfg.TLSKeyLogFile != "" {
+		keyLog, err := os.OpenFile(cfg.TLSKeyLogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to open TLS key log: %w", err)
+		}
+		slog.Warn("TLS KEY LOGGING IS ENABLED: anyone with the key log file can decrypt all traffic; never use this in production", "file", cfg.TLSKeyLogFile)
+		tlsConfig.KeyLogWriter = keyLog
+	}
+
+	if store != nil {
+		tlsConfig.GetConfigForClient = store.ConfigForClient(tlsConfig)
+	}
+	if cfg.OCSPStapleFile != "" {
+		stapler, err := newOCSPStapler(cfg.OCSPStapleFile)
+		if err != nil {
+			return fmt.Errorf("failed to load -ocsp-staple-file: %w", err)
+		}
+		tlsutil.WatchFile(background, cfg.OCSPStapleFile, cfg.CertWatchInterval, stapler.reload)
+		tlsConfig.GetConfigForClient = stapler.configForClient(tlsConfig, tlsConfig.GetConfigForClient)
+	}
+	if certs != nil {
+		certs.ReloadOnSIGHUP(background)
+		if cfg.CertWatchInterval > 0 {
+			certs.Watch(background, cfg.CertWatchInterval)
+		}
+	}
+
+	if cfg.TLSTicketKeyRotation > 0 {
+		tickets := newTicketKeyRotator(background, cfg.TLSTicketKeyRotation)
+		tlsConfig.GetConfigForClient = tickets.configForClient(tlsConfig, tlsConfig.GetConfigForClient)
+	}
+
+	handshakes := newHandshakeLog(cfg.LogHandshakes)
+	tlsConfig.GetConfigForClient = handshakes.configForClient(tlsConfig, tlsConfig.GetConfigForClient)
+	var injector *faults.Injector
+	if cfg.FaultInjection {
+		injector = &faults.Injector{OnInject: func(method, fault string) {
+			faultsInjected.WithLabelValues(method, fault).Inc()
+		}}
+	}
+	var chaos *chaosController
+	if cfg.Chaos {
+		chaos = &chaosController{}
+	}
+	var conns connRegistry
+	// transport wraps the credentials of every gRPC listener.
+	transport := func(creds credentials.TransportCredentials) credentials.TransportCredentials {
+		creds = goawayLogCreds{creds, &conns}
+		if injector != nil {
+			creds = injector.Creds(creds)
+		}
+		if cfg.ProxyProtocol != "off" {
+			creds = proxyProtocolCreds{creds, cfg.ProxyProtocol == "optional"}
+		}
+		return creds
+	}
+	creds := transport(handshakeTimeoutCreds{credentials.NewTLS(tlsConfig), handshakes})
+
+	// --- gRPC Server ---
+	// The listeners of -extra-listeners follow those of -grpc-addr, one
+	// per IP family network each or one for a Unix socket, also when
+	// inherited.
+	extras, _ := parseExtraListeners(cfg.ExtraListeners)
+	var extraProfiles []string
+	for _, e := range extras {
+		for range listenerNetworks(e.addr, cfg.IPFamily) {
+			extraProfiles = append(extraProfiles, e.profile)
+		}
+	}
+	extraCount := len(extraProfiles)
+	var instanceAddresses []string
+	if cfg.Instances > 1 {
+		instanceAddresses, _ = instanceAddrs(cfg.GRPCAddr, cfg.Instances, cfg.BasePort)
+	}
+	unixMode, _ := parseFileMode(cfg.UnixSocketMode)
+	var (
+		listeners []net.Listener
+		httpLis   net.Listener
+	)
+	defer func() {
+		if err != nil {
+			for _, lis := range listeners {
+				lis.Close()
+			}
+			if httpLis != nil {
+				httpLis.Close()
+			}
+		}
+	}()
+	listeners, httpLis, err = inheritedListeners()
+	if err != nil {
+		return fmt.Errorf("failed to inherit listeners: %w", err)
+	}
+	if listeners != nil {
+		log.Printf("Took over %d gRPC listener(s) and the HTTP listener from the parent process", len(listeners))
+		if len(listeners) <= extraCount {
+			return fmt.Errorf("inherited %d gRPC listeners, want more than the %d of -extra-listeners", len(listeners), extraCount)
+		}
+	} else {
+		opts := listenOptions{family: cfg.IPFamily, backlog: cfg.ListenBacklog, keepAlive: cfg.TCPKeepAlive, unixMode: unixMode}
+		if cfg.Instances > 1 {
+			for _, addr := range instanceAddresses {
+				lis, err := listenGRPC(addr, cfg.ListenerCount, opts)
+				if err != nil {
+					return fmt.Errorf("failed to listen on %s: %w", addr, err)
+				}
+				listeners = append(listeners, lis...)
+			}
+		} else {
+			lis, err := listenGRPC(cfg.GRPCAddr, cfg.ListenerCount, opts)
+			if err != nil {
+				return fmt.Errorf("failed to listen: %w", err)
+			}
+			listeners = lis
+		}
+		for _, e := range extras {
+			lis, err := listenGRPC(e.addr, 1, opts)
+			if err != nil {
+				return fmt.Errorf("failed to listen on %s: %w", e.addr, err)
+			}
+			listeners = append(listeners, lis...)
+		}
+		lis, err := ListenAddr(cfg.HTTPAddr, unixMode)
+		if err != nil {
+			return fmt.Errorf("failed to listen: %w", err)
+		}
+		httpLis = lis
+	}
+	// Set before Ready is closed, so embedders can dial once it is.
+	s.grpcAddr, s.httpAddr = listeners[0].Addr(), httpLis.Addr()
+	// profiles holds the TLS profile of every listener, mtls for those of
+	// -grpc-addr, and listenerCreds the credentials of the extra ones.
+	profiles := make([]string, len(listeners))
+	listenerCreds := make([]credentials.TransportCredentials, len(listeners))
+	mainCount := len(listeners) - extraCount
+	for i := range listeners {
+		if i < mainCount {
+			profiles[i] = "mtls"
+			continue
+		}
+		profiles[i] = extraProfiles[i-mainCount]
+		listenerCreds[i] = transport(profileCreds(profiles[i], tlsConfig, handshakes))
+	}
+	if cfg.Metrics == nil {
+		cfg.Metrics, err = newMetricsBackend(cfg.MetricsBackend)
+		if err != nil {
+			return fmt.Errorf("invalid -metrics-backend: %w", err)
+		}
+	}
+	if cfg.MetricsSnapshotFile != "" {
+		if err := restoreMetricsSnapshot(cfg.MetricsSnapshotFile); err != nil {
+			return fmt.Errorf("invalid -metrics-snapshot-file: %w", err)
+		}
+	}
+	metrics := rpcMetrics{backend: cfg.Metrics}
+	if cfg.MetricsIdentityLabel {
+		metrics.identities = newIdentityLabeler(cfg.MetricsIdentityLimit)
+	}
+	unaryInterceptors := []grpc.UnaryServerInterceptor{identityUnaryInterceptor, metrics.unaryInterceptor}
+	var streams streamRegistry
+	streamInterceptors := []grpc.StreamServerInterceptor{identityStreamInterceptor, metrics.streamInterceptor, streamWhen(exemptInfrastructure, connStreamLimit(cfg.MaxStreamsPerConn)), streams.streamInterceptor}
+	unaryInterceptors = append(unaryInterceptors, unaryWhen(exemptInfrastructure, eventsUnaryInterceptor))
+	streamInterceptors = append(streamInterceptors, streamWhen(exemptInfrastructure, eventsStreamInterceptor))
+	var tracer *tracing
+	if cfg.Tracing {
+		tracer, err = newTracing(cfg.TracingForceSample)
+		if err != nil {
+			return fmt.Errorf("failed to set up tracing: %w", err)
+		}
+		unaryInterceptors = append(unaryInterceptors, traceIdentityUnaryInterceptor)
+		streamInterceptors = append(streamInterceptors, traceIdentityStreamInterceptor)
+	}
+	if cfg.AuthzPolicy != "" {
+		authz, err := newAuthorizer(cfg.AuthzPolicy)
+		if err != nil {
+			return fmt.Errorf("failed to load -authz-policy: %w", err)
+		}
+		authz.watch(background, cfg.AuthzWatchInterval)
+		unaryInterceptors = append(unaryInterceptors, unaryWhen(exemptInfrastructure, authz.unaryInterceptor))
+		streamInterceptors = append(streamInterceptors, streamWhen(exemptInfrastructure, authz.streamInterceptor))
+	}
+	if cfg.LogPeers {
+		unaryInterceptors = append(unaryInterceptors, unaryWhen(exemptInfrastructure, peerLogUnaryInterceptor))
+		streamInterceptors = append(streamInterceptors, streamWhen(exemptInfrastructure, peerLogStreamInterceptor))
+	}
+	if cfg.RateLimit > 0 {
+		limiter := newRateLimiter(cfg.RateLimit, cfg.RateLimitBurst, cfg.RateLimitScope == "identity")
+		unaryInterceptors = append(unaryInterceptors, unaryWhen(exemptInfrastructure, limiter.unaryInterceptor))
+		streamInterceptors = append(streamInterceptors, streamWhen(exemptInfrastructure, limiter.streamInterceptor))
+	}
+	overloaded := overloadResponse{code: overloadCodes[cfg.OverloadCode], retryAfter: cfg.OverloadRetryAfter}
+	if cfg.MaxInFlight > 0 {
+		inFlight := newInFlightLimiter(cfg.MaxInFlight, overloaded)
+		unaryInterceptors = append(unaryInterceptors, unaryWhen(exemptInfrastructure, inFlight.unaryInterceptor))
+		streamInterceptors = append(streamInterceptors, streamWhen(exemptInfrastructure, inFlight.streamInterceptor))
+	}
+	if cfg.MaxGoroutines > 0 {
+		overload := &goroutineGuard{max: cfg.MaxGoroutines, response: overloaded}
+		timeServer.overload = overload
+		unaryInterceptors = append(unaryInterceptors, unaryWhen(exemptInfrastructure, overload.unaryInterceptor))
+	}
+	if injector != nil {
+		unaryInterceptors = append(unaryInterceptors, unaryWhen(exemptInfrastructure, injector.UnaryInterceptor))
+		streamInterceptors = append(streamInterceptors, streamWhen(exemptInfrastructure, injector.StreamInterceptor))
+	}
+	if chaos != nil {
+		streamInterceptors = append(streamInterceptors, streamWhen(exemptInfrastructure, chaos.streamInterceptor))
+	}
+	if cfg.ResponseCompression != "auto" || cfg.LogCompression {
+		compression := compressionPolicy{response: cfg.ResponseCompression, log: cfg.LogCompression}
+		unaryInterceptors = append(unaryInterceptors, unaryWhen(exemptInfrastructure, compression.unaryInterceptor))
+		streamInterceptors = append(streamInterceptors, streamWhen(exemptInfrastructure, compression.streamInterceptor))
+	}
+	if cfg.CanaryKey != "" {
+		key, err := newCanaryTagger(cfg.CanaryKey)
+		if err != nil {
+			return fmt.Errorf("invalid -canary-key: %w", err)
+		}
+		unaryInterceptors = append(unaryInterceptors, key.unaryInterceptor)
+		streamInterceptors = append(streamInterceptors, key.streamInterceptor)
+	}
+	if len(cfg.ResponseHeaders) > 0 {
+		md, err := parseResponseHeaders(cfg.ResponseHeaders)
+		if err != nil {
+			return fmt.Errorf("invalid -response-header: %w", err)
+		}
+		unaryInterceptors = append(unaryInterceptors, responseHeaders(md).unaryInterceptor)
+		streamInterceptors = append(streamInterceptors, responseHeaders(md).streamInterceptor)
+	}
+	if cfg.IdentityHeader != "" {
+		h, err := newIdentityHeader(cfg.IdentityHeader)
+		if err != nil {
+			return fmt.Errorf("invalid -identity-header: %w", err)
+		}
+		unaryInterceptors = append(unaryInterceptors, h.unaryInterceptor)
+		streamInterceptors = append(streamInterceptors, h.streamInterceptor)
+	}
+	if cfg.EmitTLSHeaders {
+		unaryInterceptors = append(unaryInterceptors, tlsHeadersUnaryInterceptor)
+		streamInterceptors = append(streamInterceptors, tlsHeadersStreamInterceptor)
+	}
+	if cfg.SendTimeout > 0 {
+		streamInterceptors = append(streamInterceptors, sendTimeout(cfg.SendTimeout))
+	}
+	var breaker *sendBreaker
+	if cfg.SendBreakerThreshold > 0 {
+		breaker = newSendBreaker(cfg.SendBreakerThreshold, cfg.SendBreakerWindow, cfg.SendBreakerMinSends, nil)
+		streamInterceptors = append(streamInterceptors, streamWhen(exemptInfrastructure, breaker.streamInterceptor))
+	}
+	if cfg.SlowThreshold > 0 || cfg.SlowStreamThreshold > 0 {
+		slow := slowLog{threshold: cfg.SlowThreshold, streamThreshold: cfg.SlowStreamThreshold}
+		unaryInterceptors = append(unaryInterceptors, slow.unaryInterceptor)
+		streamInterceptors = append(streamInterceptors, streamWhen(exemptInfrastructure, slow.streamInterceptor))
+	}
+	if cfg.LogRPCs {
+		unaryInterceptors = append(unaryInterceptors, unaryWhen(exemptInfrastructure, rpcLogUnaryInterceptor))
+		streamInterceptors = append(streamInterceptors, streamWhen(exemptInfrastructure, rpcLogStreamInterceptor))
+	}
+	var audit *auditLog
+	if cfg.AuditSize > 0 {
+		audit = newAuditLog(cfg.AuditSize)
+		unaryInterceptors = append(unaryInterceptors, audit.unaryInterceptor)
+		streamInterceptors = append(streamInterceptors, audit.streamInterceptor)
+	}
+	unaryInterceptors = append(unaryInterceptors, recoveryUnaryInterceptor)
+	streamInterceptors = append(streamInterceptors, recoveryStreamInterceptor)
+
+	settings := http2Settings{
+		InitialWindowSize:     int32(cfg.InitialWindowSize),
+		InitialConnWindowSize: int32(cfg.InitialConnWindowSize),
+		MaxHeaderListSize:     uint32(cfg.MaxHeaderListSize),
+	}
+	log.Printf("HTTP/2 settings: %s", settings)
+	serverOpts := []grpc.ServerOption{
+		grpc.Creds(creds), // Apply TLS credentials to the server
+		grpc.ConnectionTimeout(cfg.HandshakeTimeout),
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			MaxConnectionIdle:     cfg.MaxConnectionIdle,
+			MaxConnectionAge:      cfg.MaxConnectionAge,
+			MaxConnectionAgeGrace: cfg.MaxConnectionAgeGrace,
+			Time:                  cfg.KeepaliveTime,
+			Timeout:               cfg.KeepaliveTimeout,
+		}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             cfg.KeepaliveMinTime,
+			PermitWithoutStream: cfg.KeepalivePermitWithoutStream,
+		}),
+		grpc.StatsHandler(connTracker{verbose: cfg.LogConnections, settings: settings, conns: &conns}),
+		grpc.MaxHeaderListSize(settings.MaxHeaderListSize),
+		grpc.WriteBufferSize(cfg.WriteBufferSize),
+		grpc.ReadBufferSize(cfg.ReadBufferSize),
+		grpc.ChainUnaryInterceptor(unaryInterceptors...),
+		grpc.ChainStreamInterceptor(streamInterceptors...),
+	}
+	if cfg.MaxRecvMsgSize > 0 {
+		serverOpts = append(serverOpts, grpc.MaxRecvMsgSize(cfg.MaxRecvMsgSize))
+	}
+	if cfg.MaxSendMsgSize > 0 {
+		serverOpts = append(serverOpts, grpc.MaxSendMsgSize(cfg.MaxSendMsgSize))
+	}
+	if settings.InitialWindowSize > 0 {
+		serverOpts = append(serverOpts, grpc.InitialWindowSize(settings.InitialWindowSize))
+	}
+	if settings.InitialConnWindowSize > 0 {
+		serverOpts = append(serverOpts, grpc.InitialConnWindowSize(settings.InitialConnWindowSize))
+	}
+	if tracer != nil {
+		serverOpts = append(serverOpts, grpc.StatsHandler(tracer.statsHandler()))
+	}
+	if injector != nil {
+		// One write per frame, so GOAWAY frames can go in between,
+		// overriding -write-buffer-size.
+		serverOpts = append(serverOpts, grpc.WriteBufferSize(0))
+	}
+	if cfg.LogUnknownMethods {
+		serverOpts = append(serverOpts, grpc.UnknownServiceHandler(unknownServiceHandler))
+	}
+	// Each listener gets its own gRPC server; they share the service
+	// implementations and therefore all state.
+	var addrs []string
+	for _, lis := range listeners {
+		if addr := lis.Addr().String(); !slices.Contains(addrs, addr) {
+			addrs = append(addrs, addr)
+		}
+	}
+	info := &infoServer{
+		features:        cfg.features(),
+		listenAddresses: append(addrs, cfg.HTTPAddr),
+	}
+	healthServer := health.NewServer()
+	healthService := &healthBehavior{Server: healthServer}
+	if cfg.HealthWatchDelay > 0 || cfg.HealthWatchMaxUpdates > 0 || cfg.HealthUnknownServices != "spec" {
+		healthService.set(healthBehaviorSpec{
+			WatchDelayMs:    cfg.HealthWatchDelay.Milliseconds(),
+			WatchMaxUpdates: cfg.HealthWatchMaxUpdates,
+			UnknownServices: cfg.HealthUnknownServices,
+		})
+	}
+	var dummies []*grpc.ServiceDesc
+	for _, s := range cfg.DummyServices {
+		d, _ := parseDummyService(s)
+		if err := d.describe(); err != nil {
+			return fmt.Errorf("failed to register dummy service: %w", err)
+		}
+		dummies = append(dummies, d.serviceDesc())
+	}
+	for i, addr := range instanceAddresses {
+		clusterInstances = append(clusterInstances, newInstance(i, []string{addr}))
+	}
+	newServer := func(opts []grpc.ServerOption, inst *instance) *grpc.Server {
+		s := grpc.NewServer(opts...)
+		pb.RegisterTimeServiceServer(s, timeServer)
+		pb.RegisterDiagnosticsServer(s, newDiagnosticsServer(&timeServer.drain, cfg.RedactMetadata))
+		if inst != nil {
+			pb.RegisterServerInfoServer(s, &infoServer{
+				features:        info.features,
+				listenAddresses: append(slices.Clone(inst.addresses), cfg.HTTPAddr),
+				instanceID:      inst.id,
+			})
+		} else {
+			pb.RegisterServerInfoServer(s, info)
+		}
+		if cfg.Health && inst != nil {
+			grpc_health_v1.RegisterHealthServer(s, healthService.on(inst.hs))
+		} else if cfg.Health {
+			grpc_health_v1.RegisterHealthServer(s, healthService)
+		}
+		if cfg.Reflection {
+			reflection.Register(s)
+		}
+		for _, desc := range dummies {
+			s.RegisterService(desc, struct{}{})
+		}
+		return s
+	}
+	var servers serverGroup
+	for i, lis := range listeners {
+		opts := serverOpts
+		if listenerCreds[i] != nil {
+			opts = append(slices.Clone(serverOpts), grpc.Creds(listenerCreds[i]))
+		}
+		var inst *instance
+		if i < mainCount && clusterInstances != nil {
+			k := instanceOf(lis.Addr(), instanceAddresses)
+			if k < 0 {
+				return fmt.Errorf("listener %s belongs to none of -instances", lis.Addr())
+			}
+			inst = clusterInstances[k]
+			// First, so that even RPCs the interceptors reject name it.
+			opts = append(inst.serverOptions(), opts...)
+		}
+		servers = append(servers, newServer(opts, inst))
+	}
+	// The REST gateway calls a server of its own, in process, so its
+	// requests go through the same interceptors as those over gRPC.
+	var gateway *restGateway
+	if cfg.RESTGateway {
+		httpCodes, _ := parseStatusMap(cfg.RESTStatusMap)
+		gateway = newRESTGateway(newServer(append(slices.Clone(serverOpts), grpc.Creds(gatewayCreds{})), nil), httpCodes)
+		servers = append(servers, gateway.srv)
+	}
+	for name := range servers[0].GetServiceInfo() {
+		if exemptInfrastructure("/" + name + "/") {
+			healthServices = append(healthServices, name)
+		}
+	}
+	for _, name := range cfg.HealthServices {
+		if !slices.Contains(healthServices, name) {
+			healthServices = append(healthServices, name)
+		}
+	}
+	slices.Sort(healthServices)
+	isHealthy.Store(inheritedHealthy())
+	isLeader.Store(cfg.LeaderLockFile == "")
+	publishHealth(healthServer, "startup")
+
+	if breaker != nil {
+		breaker.onChange = func(open bool) {
+			mu.Lock()
+			defer mu.Unlock()
+			breakerOpen.Store(open)
+			publishHealth(healthServer, "circuit-breaker")
+		}
+		go breaker.run(background)
+	}
+
+	if cfg.LeaderLockFile != "" {
+		elector, err := newFileLockElector(cfg.LeaderLockFile)
+		if err != nil {
+			return fmt.Errorf("failed to set up leader election: %w", err)
+		}
+		log.Println("Standing by for leadership via lock file", cfg.LeaderLockFile)
+		go runLeaderElection(background, elector, healthServer, &timeServer.drain)
+	}
+
+	flap := &flapper{hs: healthServer}
+	if cfg.HealthFlapInterval > 0 {
+		spec := flapSpec{
+			ServingMs: cfg.HealthFlapInterval.Milliseconds(),
+			JitterMs:  cfg.HealthFlapJitter.Milliseconds(),
+			Services:  cfg.HealthFlapServices,
+		}
+		mu.Lock()
+		err := spec.validate()
+		mu.Unlock()
+		if err != nil {
+			return fmt.Errorf("invalid -health-flap-interval: %w", err)
+		}
+		flap.start(spec)
+	}
+
+	if chaos != nil && cfg.ChaosInterval > 0 {
+		actions := cfg.ChaosActions
+		if len(actions) == 0 {
+			actions = []string{"pause-accept", "hang", "pause-sends"}
+		}
+		chaos.schedule(background, cfg.ChaosInterval, actions, cfg.ChaosDuration, cfg.ChaosExitCode)
+	}
+
+	// The saved state is restored before serving, so that the first RPCs
+	// already see it.
+	httpProbes := &probes{}
+	var state *stateStore
+	if cfg.StateFile != "" {
+		state = newStateStore(cfg.StateFile, healthServer, healthService, flap, httpProbes, injector, chaos)
+		if err := state.restore(); err != nil {
+			return fmt.Errorf("invalid -state-file: %w", err)
+		}
+	}
+
+	var tcp *tcpBehavior
+	if cfg.AcceptDelay > 0 || cfg.MaxConns > 0 || cfg.TCPResetOnClose {
+		tcp = newTCPBehavior(cfg.AcceptDelay, cfg.MaxConns, cfg.MaxConnsAction, cfg.TCPResetOnClose)
+	}
+	if gateway != nil {
+		go func() {
+			if err := gateway.srv.Serve(gateway.lis); err != nil {
+				s.stop(fmt.Errorf("failed to serve the REST gateway: %w", err))
+			}
+		}()
+	}
+	for i, lis := range listeners {
+		if tcp != nil {
+			lis = tcp.listener(lis)
+		}
+		if chaos != nil {
+			lis = chaos.listener(lis)
+		}
+		go func() {
+			log.Printf("gRPC server with %s listening at %s", profiles[i], lis.Addr())
+			if err := servers[i].Serve(lis); err != nil {
+				s.stop(fmt.Errorf("failed to serve: %w", err))
+			}
+		}()
+	}
+	if cfg.StartupProbeDelay > 0 {
+		time.AfterFunc(cfg.StartupProbeDelay, httpProbes.markStarted)
+	} else {
+		httpProbes.markStarted()
+	}
+	// The listeners are already bound, so the kernel queues connections
+	// until Serve accepts them.
+	if cfg.Ready != nil {
+		close(cfg.Ready)
+	}
+
+	report := bootReport{
+		Version:   version,
+		Commit:    buildCommit(),
+		StartedAt: bootTime,
+		Config:    effectiveFlags(cfg),
+		Features:  info.features,
+		Addresses: info.listenAddresses,
+	}
+	if leaf, err := x509.ParseCertificate(serverCert.Certificate[0]); err == nil {
+		report.Certificates = append(report.Certificates, summarizeCert("server", leaf))
+	}
+	for _, ca := range caCerts {
+		report.Certificates = append(report.Certificates, summarizeCert("client-ca", ca))
+	}
+	report.log()
+	if cfg.BootReportFile != "" {
+		if err := report.write(cfg.BootReportFile); err != nil {
+			log.Printf("Failed to write boot report: %v", err)
+		}
+	}
+
+	// --- Graceful shutdown once ctx is done ---
+	// The HTTP server stops last, so the health endpoints keep answering
+	// while the gRPC connections drain.
+	httpServer := &http.Server{}
+	httpServer.RegisterOnShutdown(events.close)
+	// handedOver receives a value once an upgraded process took over the
+	// listeners.
+	handedOver := make(chan struct{}, 1)
+	go func() {
+		var err error
+		upgraded := false
+		select {
+		case <-ctx.Done():
+		case err = <-s.failed:
+			log.Printf("Stopping: %v", err)
+		case <-handedOver:
+			upgraded = true
+		}
+		flap.stop()
+		if upgraded {
+			// The replacement reports the health status now, so this
+			// process only drains its own connections.
+			gracefulStop(servers, &timeServer.drain, &streams, cfg.DrainGoawayDelay)
+		} else {
+			shutdown(servers, healthServer, &timeServer.drain, &streams, cfg.PrestopDelay, cfg.DrainGoawayDelay)
+		}
+		stopCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := httpServer.Shutdown(stopCtx); err != nil {
+			log.Printf("Failed to stop the HTTP server: %v", err)
+		}
+		if err := shutdownMetrics(stopCtx, cfg.Metrics); err != nil {
+			log.Printf("Failed to flush metrics: %v", err)
+		}
+		if tracer != nil {
+			if err := tracer.Shutdown(stopCtx); err != nil {
+				log.Printf("Failed to flush spans: %v", err)
+			}
+		}
+		cancel()
+		if cfg.MetricsSnapshotFile != "" {
+			if err := saveMetricsSnapshot(cfg.MetricsSnapshotFile); err != nil {
+				log.Printf("Failed to save metrics snapshot: %v", err)
+			}
+		}
+		stopBackground()
+		s.err = err
+		running.Store(false)
+		close(s.done)
+	}()
+
+	// --- Binary upgrade on SIGUSR2 ---
+	// The replacement accepts on the same sockets, so this process keeps
+	// reporting its health status and only drains its own connections,
+	// then stops as if ctx were done and Wait returns nil.
+	var handingOver atomic.Bool
+	installUpgradeHandler(background, func() {
+		if handingOver.Load() {
+			log.Println("Already handing over to an upgraded process, ignoring SIGUSR2")
+			return
+		}
+		proc, err := startUpgrade(listeners, httpLis, isHealthy.Load())
+		if err != nil {
+			log.Printf("Upgrade failed, continuing to serve: %v", err)
+			return
+		}
+		log.Printf("Started upgraded process %d, handing over", proc.Pid)
+		handingOver.Store(true)
+		select {
+		case handedOver <- struct{}{}:
+		default:
+		}
+	})
+
+	// --- HTTP Server for Health Toggle ---
+	mux := http.NewServeMux()
+	guard := admin.NewGuard(cfg.ToggleToken, cfg.ToggleRateLimit, time.Minute)
+	if cfg.ToggleEndpoint {
+		mux.HandleFunc("/toggle-health", guard.Wrap(state.wrap(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			if shuttingDown.Load() {
+				http.Error(w, "shutting down", http.StatusServiceUnavailable)
+				return
+			}
+			healthy := !isHealthy.Load()
+			isHealthy.Store(healthy)
+			publishHealth(healthServer, "toggle-endpoint")
+			statusString := "UNHEALTHY"
+			if healthy {
+				statusString = "HEALTHY"
+			}
+			log.Printf("Health status toggled to: %s", statusString)
+			writeHealthResult(w, r, "", "Health status is now "+statusString)
+		})))
+	}
+
+	healthControl := healthAPI{hs: healthServer}
+	mux.HandleFunc("GET /health", healthControl.list)
+	mux.HandleFunc("POST /health/{service}", guard.Wrap(state.wrap(healthControl.set)))
+	mux.HandleFunc("PUT /health", guard.Wrap(state.wrap(healthControl.put)))
+	mux.HandleFunc("PUT /health/{service}", guard.Wrap(state.wrap(healthControl.put)))
+	mux.Handle("GET /health-flap", flap)
+	mux.HandleFunc("/health-flap", guard.Wrap(state.wrap(flap.ServeHTTP)))
+	mux.HandleFunc("GET /healthz", httpProbes.serveHealthz)
+	mux.HandleFunc("GET /readyz", httpProbes.serveReadyz)
+	mux.HandleFunc("GET /startupz", httpProbes.serveStartupz)
+	mux.Handle("GET /probes", httpProbes)
+	mux.HandleFunc("/probes", guard.Wrap(state.wrap(httpProbes.ServeHTTP)))
+	if clusterInstances != nil {
+		mux.HandleFunc("GET /instances", instanceAPI{}.list)
+		mux.HandleFunc("PUT /instances/{id}/health", guard.Wrap(state.wrap(instanceAPI{}.put)))
+	}
+	mux.Handle("GET /health-behavior", healthService)
+	mux.HandleFunc("/health-behavior", guard.Wrap(state.wrap(healthService.ServeHTTP)))
+	if state != nil {
+		mux.HandleFunc("POST /state/reset", guard.Wrap(state.serveReset))
+	}
+	mux.Handle("GET /connections", &conns)
+	mux.HandleFunc("GET /connections/closed", conns.serveClosed)
+	mux.Handle("GET /config", serveConfig(report.Config))
+	if cfg.CertReloadEndpoint {
+		mux.HandleFunc("POST /reload-certs", guard.Wrap(certs.ServeHTTP))
+	}
+	if injector != nil {
+		mux.Handle("GET /faults", injector)
+		mux.HandleFunc("/faults", guard.Wrap(state.wrap(injector.ServeHTTP)))
+		mux.HandleFunc("POST /faults/goaway", guard.Wrap(injector.ServeGoaway))
+	}
+	if chaos != nil {
+		mux.Handle("GET /chaos", chaos)
+		mux.HandleFunc("/chaos", guard.Wrap(state.wrap(chaos.ServeHTTP)))
+	}
+	if clockControl != nil {
+		mux.Handle("GET /clock", clockControl)
+		mux.HandleFunc("/clock", guard.Wrap(clockControl.ServeHTTP))
+	}
+	if gateway != nil {
+		gateway.register(mux)
+	}
+	if cfg.DebugEndpoints {
+		registerPprof(mux)
+		mux.HandleFunc("GET /debug/goroutines", serveGoroutines)
+		mux.HandleFunc("GET /debug/gc", serveGC)
+		mux.HandleFunc("/debug/gc", guard.Wrap(serveGC))
+	}
+	mux.Handle("/metrics", metricsHandler())
+	mux.Handle("/streams", &streams)
+	mux.Handle("GET /handshakes", handshakes)
+	mux.Handle("GET /events", events)
+	if audit != nil {
+		mux.Handle("/audit", audit)
+	}
+	if cfg.LandingPage {
+		var endpoints []admin.Endpoint
+		if cfg.ToggleEndpoint {
+			endpoints = append(endpoints, admin.Endpoint{Pattern: "/toggle-health", Description: "flip the health status of the whole server"})
+		}
+		endpoints = append(endpoints,
+			admin.Endpoint{Pattern: "GET /health", Description: "health status of every service"},
+			admin.Endpoint{Pattern: "PUT /health", Description: "set the health status of the whole server"},
+			admin.Endpoint{Pattern: "PUT /health/{service}", Description: "set the health status of one service"},
+			admin.Endpoint{Pattern: "GET /healthz", Description: "liveness probe"},
+			admin.Endpoint{Pattern: "GET /readyz", Description: "readiness probe, following the gRPC health status (?service= for one service)"},
+			admin.Endpoint{Pattern: "GET /startupz", Description: "startup probe"},
+			admin.Endpoint{Pattern: "/probes", Description: "show (GET), set (PUT) or clear (DELETE) the probe overrides"},
+			admin.Endpoint{Pattern: "/health-flap", Description: "show (GET), set (PUT) or stop (DELETE) the health flapping schedule"},
+			admin.Endpoint{Pattern: "/health-behavior", Description: "show (GET), set (PUT) or reset (DELETE) the health Watch delays, drops and unknown-service answers"},
+			admin.Endpoint{Pattern: "GET /connections", Description: "open client connections, their identities, RPCs, bytes and GOAWAY frames"},
+			admin.Endpoint{Pattern: "GET /connections/closed", Description: "the last closed client connections and their lifetimes"},
+			admin.Endpoint{Pattern: "GET /config", Description: "effective configuration"},
+			admin.Endpoint{Pattern: "/metrics", Description: "Prometheus metrics"},
+			admin.Endpoint{Pattern: "/streams", Description: "active streams"},
+			admin.Endpoint{Pattern: "GET /handshakes", Description: "TLS handshake counts and recent failures"},
+			admin.Endpoint{Pattern: "GET /events", Description: "live connection, handshake, RPC and health events (server-sent events)"},
+		)
+		if state != nil {
+			endpoints = append(endpoints, admin.Endpoint{Pattern: "POST /state/reset", Description: "return health, faults and chaos to the startup configuration and remove the -state-file"})
+		}
+		if cfg.CertReloadEndpoint {
+			endpoints = append(endpoints, admin.Endpoint{Pattern: "POST /reload-certs", Description: "reload the TLS certificate, key and CA"})
+		}
+		if injector != nil {
+			endpoints = append(endpoints,
+				admin.Endpoint{Pattern: "/faults", Description: "show (GET), set (PUT or POST) or clear (DELETE) the injected faults"},
+				admin.Endpoint{Pattern: "POST /faults/goaway", Description: "send GOAWAY on every client connection"})
+		}
+		if clusterInstances != nil {
+			endpoints = append(endpoints,
+				admin.Endpoint{Pattern: "GET /instances", Description: "the instances of -instances and their health"},
+				admin.Endpoint{Pattern: "PUT /instances/{id}/health", Description: "take an instance out of service, or back in"})
+		}
+		if chaos != nil {
+			endpoints = append(endpoints, admin.Endpoint{Pattern: "/chaos", Description: "show (GET), run (POST) or end (DELETE) chaos actions: exit, pause-accept, hang, pause-sends"})
+		}
+		if clockControl != nil {
+			endpoints = append(endpoints, admin.Endpoint{Pattern: "/clock", Description: "show (GET), set (PUT) or reset (DELETE) the clock: frozen, offset or rate"})
+		}
+		if cfg.RESTGateway {
+			endpoints = append(endpoints,
+				admin.Endpoint{Pattern: "GET /v1/time", Description: "GetTime as JSON"},
+				admin.Endpoint{Pattern: "GET /v1/time/stream", Description: "StreamTime as server-sent events"})
+		}
+		if audit != nil {
+			endpoints = append(endpoints, admin.Endpoint{Pattern: "/audit", Description: "recently completed RPCs"})
+		}
+		if cfg.DebugEndpoints {
+			endpoints = append(endpoints,
+				admin.Endpoint{Pattern: "/debug/pprof/", Description: "Go runtime profiles"},
+				admin.Endpoint{Pattern: "GET /debug/goroutines", Description: "stacks of all goroutines"},
+				admin.Endpoint{Pattern: "/debug/gc", Description: "GC and heap statistics (GET), or force a collection (POST)"})
+		}
+		mux.Handle("GET /{$}", admin.NewLandingPage(fmt.Sprintf("envoy-hck %s (commit %s)", version, buildCommit()), servers[0].GetServiceInfo(), endpoints))
+	}
+
+	httpServer.Handler = mux
+	if cfg.HTTPToken != "" {
+		httpServer.Handler = admin.RequireToken(httpServer.Handler, cfg.HTTPToken, cfg.ToggleToken)
+	}
+	var httpServe net.Listener = httpLis
+	if cfg.HTTPTLS {
+		httpClientAuth, err := tlsutil.ParseClientAuth(cfg.HTTPClientAuth)
+		if err != nil {
+			return fmt.Errorf("invalid -http-client-auth: %w", err)
+		}
+		httpTLS := &tls.Config{
+			Certificates: []tls.Certificate{serverCert},
+			ClientCAs:    caCertPool,
+			ClientAuth:   httpClientAuth,
+		}
+		if store != nil {
+			httpTLS.GetConfigForClient = store.ConfigForClient(httpTLS)
+		}
+		// The raw listener is still the one handed over on upgrade.
+		httpServe = tls.NewListener(httpLis, httpTLS)
+		log.Println("Health toggle server with TLS listening at", httpLis.Addr())
+	} else {
+		log.Println("Health toggle server listening at", httpLis.Addr())
+	}
+	go func() {
+		if err := httpServer.Serve(httpServe); err != http.ErrServerClosed {
+			s.stop(fmt.Errorf("HTTP server failed: %w", err))
+		}
+	}()
+	s.injector = injector
+	return nil
+}
@@ -0,0 +1,778 @@
+package server_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/dethi/envoy_hck/pkg/faults"
+	"github.com/dethi/envoy_hck/pkg/server"
+	"github.com/dethi/envoy_hck/pkg/tlsutil"
+	pb "github.com/dethi/envoy_hck/protos"
+)
+
+// startEmbedded starts a server on ephemeral ports with a self-signed
+// bundle and returns it with a client of its time service.
+func startEmbedded(t *testing.T, ctx context.Context, opts ...server.Option) (*server.Server, pb.TimeServiceClient) {
+	t.Helper()
+	bundle, err := tlsutil.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts = append([]server.Option{
+		server.WithSelfSigned(bundle),
+		server.WithGRPCAddr("127.0.0.1:0"),
+		server.WithHTTPAddr("127.0.0.1:0"),
+	}, opts...)
+	srv, err := server.New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return srv, embeddedClient(t, srv.GRPCAddr(), bundle)
+}
+
+// embeddedClient returns a client of the time service at addr, dialed
+// with the client certificate of bundle.
+func embeddedClient(t *testing.T, addr net.Addr, bundle *tlsutil.SelfSignedBundle) pb.TimeServiceClient {
+	t.Helper()
+	creds := credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{bundle.Client.TLSCertificate()},
+		RootCAs:      bundle.Pool(),
+		ServerName:   "localhost",
+	})
+	conn, err := grpc.NewClient(addr.String(), grpc.WithTransportCredentials(creds))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewTimeServiceClient(conn)
+}
+
+func TestEmbeddedServer(t *testing.T) {
+	for run := range 2 {
+		ctx, cancel := context.WithCancel(context.Background())
+		srv, client := startEmbedded(t, ctx, server.WithFaultInjection())
+
+		if _, err := client.GetTime(ctx, &pb.TimeRequest{}); err != nil {
+			t.Fatalf("run %d: GetTime: %v", run, err)
+		}
+		if err := srv.Faults().Set(faults.Spec{ErrorCode: "UNAVAILABLE"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.GetTime(ctx, &pb.TimeRequest{}); status.Code(err) != codes.Unavailable {
+			t.Errorf("run %d: GetTime with a fault = %v, want UNAVAILABLE", run, err)
+		}
+		if err := srv.Start(ctx); err == nil {
+			t.Errorf("run %d: second Start succeeded", run)
+		}
+
+		cancel()
+		if err := srv.Wait(); err != nil {
+			t.Fatalf("run %d: Wait: %v", run, err)
+		}
+	}
+}
+
+func TestOneServerPerProcess(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	srv, _ := startEmbedded(t, ctx)
+	defer func() {
+		cancel()
+		srv.Wait()
+	}()
+
+	other, err := server.New(server.WithGRPCAddr("127.0.0.1:0"), server.WithHTTPAddr("127.0.0.1:0"), server.WithSelfSigned(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Start(ctx); err == nil {
+		t.Error("Start succeeded while another server is running")
+	}
+}
+
+func TestSelfSignedPrintedCredentials(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	ctx, cancel := context.WithCancel(context.Background())
+	srv, err := server.New(server.WithSelfSigned(nil), server.WithGRPCAddr("127.0.0.1:0"), server.WithHTTPAddr("127.0.0.1:0"))
+	if err == nil {
+		err = srv.Start(ctx)
+	}
+	os.Stdout = stdout
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cancel()
+		srv.Wait()
+	}()
+
+	// The CA, client certificate and client key are printed in that order.
+	printed, _ := io.ReadAll(r)
+	ca, rest := pem.Decode(printed)
+	if ca == nil {
+		t.Fatalf("no CA printed: %q", printed)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem.EncodeToMemory(ca)) {
+		t.Fatal("the printed CA does not parse")
+	}
+	clientCert, err := tls.X509KeyPair(rest, rest)
+	if err != nil {
+		t.Fatalf("the printed client credentials do not parse: %v", err)
+	}
+
+	dial := func(cfg *tls.Config) error {
+		cfg.ServerName = "localhost"
+		conn, err := grpc.NewClient(srv.GRPCAddr().String(), grpc.WithTransportCredentials(credentials.NewTLS(cfg)))
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		_, err = pb.NewTimeServiceClient(conn).GetTime(ctx, &pb.TimeRequest{})
+		return err
+	}
+	if err := dial(&tls.Config{RootCAs: roots, Certificates: []tls.Certificate{clientCert}}); err != nil {
+		t.Errorf("GetTime with the printed credentials: %v", err)
+	}
+	if err := dial(&tls.Config{RootCAs: roots}); err == nil {
+		t.Error("GetTime without a client certificate succeeded")
+	}
+	if err := dial(&tls.Config{Certificates: []tls.Certificate{clientCert}}); err == nil {
+		t.Error("GetTime without trusting the printed CA succeeded")
+	}
+}
+
+// healthStatuses returns the statuses GET /health lists, by service.
+func healthStatuses(t *testing.T, url string) map[string]string {
+	t.Helper()
+	resp, err := http.Get(url + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var statuses map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&statuses); err != nil {
+		t.Fatal(err)
+	}
+	return statuses
+}
+
+func TestToggleDuringShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	prestop := func(c *server.Config) { c.PrestopDelay = time.Second }
+	srv, _ := startEmbedded(t, ctx, prestop)
+	url := "http://" + srv.HTTPAddr().String()
+	if got := healthStatuses(t, url)[""]; got != "SERVING" {
+		t.Fatalf("before shutdown: %s, want SERVING", got)
+	}
+
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for healthStatuses(t, url)[""] != "NOT_SERVING" {
+		if time.Now().After(deadline) {
+			t.Fatal("still SERVING after shutdown started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for range 2 {
+		resp, err := http.Post(url+"/toggle-health", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("toggle during shutdown = %d, want 503", resp.StatusCode)
+		}
+		if got := healthStatuses(t, url)[""]; got != "NOT_SERVING" {
+			t.Errorf("after a toggle during shutdown: %s, want NOT_SERVING", got)
+		}
+	}
+	if err := srv.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestToggleResponseFormat(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	srv, _ := startEmbedded(t, ctx)
+	defer func() {
+		cancel()
+		srv.Wait()
+	}()
+	url := "http://" + srv.HTTPAddr().String()
+
+	toggle := func(accept string) (string, string) {
+		t.Helper()
+		req, _ := http.NewRequest("POST", url+"/toggle-health", nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("POST /toggle-health = %d %s", resp.StatusCode, body)
+		}
+		return resp.Header.Get("Content-Type"), string(body)
+	}
+
+	if _, body := toggle(""); body != "Health status is now UNHEALTHY\n" {
+		t.Errorf("text response %q, want the status line", body)
+	}
+	contentType, body := toggle("text/html, application/json;q=0.9")
+	var got map[string]string
+	if err := json.Unmarshal([]byte(body), &got); err != nil {
+		t.Fatalf("JSON response %q: %v", body, err)
+	}
+	if contentType != "application/json" || got["service"] != "" || got["status"] != "SERVING" || len(got) != 2 {
+		t.Errorf("JSON response %s %q, want the service and its new status", contentType, body)
+	}
+	contentType, body = toggle("application/json")
+	if contentType != "application/json" || body != `{"service":"","status":"NOT_SERVING"}`+"\n" {
+		t.Errorf("JSON response %s %q, want NOT_SERVING", contentType, body)
+	}
+}
+
+func TestReadyThenDial(t *testing.T) {
+	bundle, err := tlsutil.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ready := make(chan struct{})
+	srv, err := server.New(
+		server.WithSelfSigned(bundle),
+		server.WithGRPCAddr("127.0.0.1:0"),
+		server.WithHTTPAddr("127.0.0.1:0"),
+		func(c *server.Config) { c.Ready = ready },
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan error, 1)
+	go func() { started <- srv.Start(ctx) }()
+	defer func() {
+		cancel()
+		srv.Wait()
+	}()
+
+	select {
+	case <-ready:
+	case err := <-started:
+		t.Fatalf("Start returned %v before Ready was closed", err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("Ready not closed")
+	}
+	// Fail fast instead of waiting for the connection to become ready.
+	if _, err := embeddedClient(t, srv.GRPCAddr(), bundle).GetTime(ctx, &pb.TimeRequest{}, grpc.WaitForReady(false)); err != nil {
+		t.Errorf("GetTime once ready: %v", err)
+	}
+}
+
+func TestExpectedServerNameMismatch(t *testing.T) {
+	srv, err := server.New(
+		server.WithSelfSigned(nil),
+		server.WithGRPCAddr("127.0.0.1:0"),
+		server.WithHTTPAddr("127.0.0.1:0"),
+		func(c *server.Config) { c.ExpectedServerName = "time.example.com" },
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = srv.Start(context.Background())
+	if err == nil {
+		srv.Wait()
+		t.Fatal("Start succeeded with a certificate not valid for the expected name")
+	}
+	if !strings.Contains(err.Error(), "time.example.com") {
+		t.Errorf("Start: %v, want an error naming time.example.com", err)
+	}
+}
+
+func TestEmitTLSHeaders(t *testing.T) {
+	bundle, err := tlsutil.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	emit := func(c *server.Config) { c.EmitTLSHeaders = true }
+	srv, _ := startEmbedded(t, ctx, server.WithSelfSigned(bundle), emit)
+	defer func() {
+		cancel()
+		srv.Wait()
+	}()
+
+	for _, tc := range []struct {
+		name   string
+		max    uint16
+		suites []uint16
+	}{
+		{"TLS 1.3", tls.VersionTLS13, nil},
+		{"TLS 1.2", tls.VersionTLS12, []uint16{tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			creds := credentials.NewTLS(&tls.Config{
+				Certificates: []tls.Certificate{bundle.Client.TLSCertificate()},
+				RootCAs:      bundle.Pool(),
+				ServerName:   "localhost",
+				MaxVersion:   tc.max,
+				CipherSuites: tc.suites,
+			})
+			conn, err := grpc.NewClient(srv.GRPCAddr().String(), grpc.WithTransportCredentials(creds))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			var header metadata.MD
+			var p peer.Peer
+			if _, err := pb.NewTimeServiceClient(conn).GetTime(ctx, &pb.TimeRequest{}, grpc.Header(&header), grpc.Peer(&p)); err != nil {
+				t.Fatal(err)
+			}
+			state := p.AuthInfo.(credentials.TLSInfo).State
+			if got, want := header.Get("x-tls-version"), tls.VersionName(state.Version); len(got) != 1 || got[0] != want {
+				t.Errorf("x-tls-version = %v, want %s", got, want)
+			}
+			if got, want := header.Get("x-tls-cipher"), tls.CipherSuiteName(state.CipherSuite); len(got) != 1 || got[0] != want {
+				t.Errorf("x-tls-cipher = %v, want %s", got, want)
+			}
+			if tc.suites != nil && state.CipherSuite != tc.suites[0] {
+				t.Errorf("negotiated %s, want the only suite offered", tls.CipherSuiteName(state.CipherSuite))
+			}
+		})
+	}
+}
+
+// metricValue returns the value of a counter series on the /metrics
+// endpoint of srv, or 0 if it has not been incremented yet.
+func metricValue(t *testing.T, srv *server.Server, series string) int {
+	t.Helper()
+	resp, err := http.Get("http://" + srv.HTTPAddr().String() + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	for line := range strings.Lines(string(body)) {
+		if value, ok := strings.CutPrefix(line, series+" "); ok {
+			n, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return n
+		}
+	}
+	return 0
+}
+
+func TestRelaxedClientAuthWithoutCert(t *testing.T) {
+	bundle, err := tlsutil.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	relaxed := func(c *server.Config) { c.ClientAuth = "request" }
+	srv, _ := startEmbedded(t, ctx, server.WithSelfSigned(bundle), relaxed)
+	defer func() {
+		cancel()
+		srv.Wait()
+	}()
+	const series = `rpc_without_client_cert_total{method="/time.TimeService/GetTime"}`
+	before := metricValue(t, srv, series)
+	creds := credentials.NewTLS(&tls.Config{RootCAs: bundle.Pool(), ServerName: "localhost"})
+	conn, err := grpc.NewClient(srv.GRPCAddr().String(), grpc.WithTransportCredentials(creds))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	withoutCert := pb.NewTimeServiceClient(conn)
+	for range 2 {
+		if _, err := withoutCert.GetTime(ctx, &pb.TimeRequest{}); err != nil {
+			t.Fatalf("GetTime without a certificate: %v", err)
+		}
+	}
+	if _, err := embeddedClient(t, srv.GRPCAddr(), bundle).GetTime(ctx, &pb.TimeRequest{}); err != nil {
+		t.Fatalf("GetTime with a certificate: %v", err)
+	}
+	if got := metricValue(t, srv, series) - before; got != 2 {
+		t.Errorf("%s went up by %d, want 2", series, got)
+	}
+}
+
+func TestTLSKeyLogFile(t *testing.T) {
+	bundle, err := tlsutil.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "keys.log")
+	ctx, cancel := context.WithCancel(context.Background())
+	keyLog := func(c *server.Config) { c.TLSKeyLogFile = path }
+	srv, _ := startEmbedded(t, ctx, server.WithSelfSigned(bundle), keyLog)
+	defer func() {
+		cancel()
+		srv.Wait()
+	}()
+
+	var clientKeys strings.Builder
+	creds := credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{bundle.Client.TLSCertificate()},
+		RootCAs:      bundle.Pool(),
+		ServerName:   "localhost",
+		KeyLogWriter: &clientKeys,
+	})
+	conn, err := grpc.NewClient(srv.GRPCAddr().String(), grpc.WithTransportCredentials(creds))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := pb.NewTimeServiceClient(conn).GetTime(ctx, &pb.TimeRequest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o600 {
+		t.Errorf("key log mode %s, want -rw-------", fi.Mode().Perm())
+	}
+	serverKeys, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Both ends log the same secrets in NSS key log format.
+	if clientKeys.Len() == 0 || !strings.Contains(string(serverKeys), clientKeys.String()) {
+		t.Errorf("the key log %q does not hold the client's session secrets %q", serverKeys, clientKeys.String())
+	}
+	if !strings.HasPrefix(string(serverKeys), "CLIENT_HANDSHAKE_TRAFFIC_SECRET ") {
+		t.Errorf("the key log does not start with an NSS key log line: %q", serverKeys)
+	}
+}
+
+func TestReloadCertsEndpoint(t *testing.T) {
+	bundle, err := tlsutil.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.crt")
+	write := func(path string, data []byte) {
+		t.Helper()
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(certFile, bundle.Server.CertPEM)
+	write(keyFile, bundle.Server.KeyPEM)
+	write(caFile, bundle.CA.CertPEM)
+	srv, err := server.New(
+		server.WithGRPCAddr("127.0.0.1:0"),
+		server.WithHTTPAddr("127.0.0.1:0"),
+		func(c *server.Config) {
+			c.CertFile, c.KeyFile, c.CAFile = certFile, keyFile, caFile
+			c.CertReloadEndpoint = true
+			// Leave reloading to the endpoint.
+			c.CertWatchInterval = 0
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := srv.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cancel()
+		srv.Wait()
+	}()
+	// servedCert returns the certificate a new connection is served.
+	servedCert := func() *x509.Certificate {
+		t.Helper()
+		var p peer.Peer
+		if _, err := embeddedClient(t, srv.GRPCAddr(), bundle).GetTime(ctx, &pb.TimeRequest{}, grpc.Peer(&p)); err != nil {
+			t.Fatal(err)
+		}
+		return p.AuthInfo.(credentials.TLSInfo).State.PeerCertificates[0]
+	}
+	if !servedCert().Equal(bundle.Server.Cert) {
+		t.Fatal("not served the initial certificate")
+	}
+
+	renewed, err := bundle.IssueServerCert(2 * time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(certFile, renewed.CertPEM)
+	write(keyFile, renewed.KeyPEM)
+	resp, err := http.Post("http://"+srv.HTTPAddr().String()+"/reload-certs", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body map[string]string
+	json.NewDecoder(resp.Body).Decode(&body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || body["not_after"] != renewed.Cert.NotAfter.Format(time.RFC3339) {
+		t.Fatalf("POST /reload-certs = %d %v, want 200 with the new expiry", resp.StatusCode, body)
+	}
+	if !servedCert().Equal(renewed.Cert) {
+		t.Error("a new connection is not served the reloaded certificate")
+	}
+
+	write(keyFile, []byte("garbage"))
+	resp, err = http.Post("http://"+srv.HTTPAddr().String()+"/reload-certs", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("POST /reload-certs with a bad key = %d, want 500", resp.StatusCode)
+	}
+	if !servedCert().Equal(renewed.Cert) {
+		t.Error("a failed reload replaced the certificate")
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	const timeout = 200 * time.Millisecond
+	short := func(c *server.Config) { c.HandshakeTimeout = timeout }
+	srv, client := startEmbedded(t, ctx, short)
+	defer func() {
+		cancel()
+		srv.Wait()
+	}()
+	const series = "tls_handshake_timeout_total"
+	before := metricValue(t, srv, series)
+
+	// Connect and never send a ClientHello.
+	conn, err := net.Dial("tcp", srv.GRPCAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	start := time.Now()
+	conn.SetReadDeadline(start.Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("read from a stalled handshake: %v, want the server to close the connection", err)
+	}
+	if took := time.Since(start); took < timeout/2 || took > timeout+time.Second {
+		t.Errorf("connection closed after %s, want about %s", took, timeout)
+	}
+	if got := metricValue(t, srv, series) - before; got != 1 {
+		t.Errorf("%s went up by %d, want 1", series, got)
+	}
+	// Clients that complete the handshake are unaffected.
+	if _, err := client.GetTime(ctx, &pb.TimeRequest{}); err != nil {
+		t.Errorf("GetTime: %v", err)
+	}
+}
+
+// pingFlood opens an HTTP/2 connection to srv without any streams, sends
+// n pings in quick succession and reports how many were acknowledged and
+// whether the server sent GOAWAY with ENHANCE_YOUR_CALM.
+func pingFlood(t *testing.T, srv *server.Server, bundle *tlsutil.SelfSignedBundle, n int) (acks int, calm bool) {
+	t.Helper()
+	conn, err := tls.Dial("tcp", srv.GRPCAddr().String(), &tls.Config{
+		Certificates: []tls.Certificate{bundle.Client.TLSCertificate()},
+		RootCAs:      bundle.Pool(),
+		ServerName:   "localhost",
+		NextProtos:   []string{"h2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := io.WriteString(conn, http2.ClientPreface); err != nil {
+		t.Fatal(err)
+	}
+	framer := http2.NewFramer(conn, conn)
+	if err := framer.WriteSettings(); err != nil {
+		t.Fatal(err)
+	}
+	for i := range n {
+		if err := framer.WritePing(false, [8]byte{byte(i)}); err != nil {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		f, err := framer.ReadFrame()
+		if err != nil {
+			return acks, calm
+		}
+		switch f := f.(type) {
+		case *http2.PingFrame:
+			if f.IsAck() {
+				acks++
+			}
+		case *http2.GoAwayFrame:
+			calm = f.ErrCode == http2.ErrCodeEnhanceYourCalm
+		}
+	}
+}
+
+func TestPermissivePingPolicy(t *testing.T) {
+	bundle, err := tlsutil.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const pings = 10
+	for _, tc := range []struct {
+		name       string
+		minTime    time.Duration
+		permit     bool
+		wantCalmed bool
+	}{
+		{"default", 5 * time.Minute, false, true},
+		{"permissive", time.Millisecond, true, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			policy := func(c *server.Config) {
+				c.KeepaliveMinTime, c.KeepalivePermitWithoutStream = tc.minTime, tc.permit
+			}
+			srv, _ := startEmbedded(t, ctx, server.WithSelfSigned(bundle), policy)
+			defer func() {
+				cancel()
+				srv.Wait()
+			}()
+			acks, calmed := pingFlood(t, srv, bundle, pings)
+			if calmed != tc.wantCalmed {
+				t.Errorf("GOAWAY ENHANCE_YOUR_CALM sent: %t, want %t", calmed, tc.wantCalmed)
+			}
+			if !tc.wantCalmed && acks != pings {
+				t.Errorf("%d of %d pings acknowledged", acks, pings)
+			}
+		})
+	}
+}
+
+func TestLandingPage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	srv, _ := startEmbedded(t, ctx)
+	defer func() {
+		cancel()
+		srv.Wait()
+	}()
+	url := "http://" + srv.HTTPAddr().String()
+	resp, err := http.Get(url + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET / = %d", resp.StatusCode)
+	}
+	for _, want := range []string{"envoy-hck ", "time.TimeService", "StreamTime", "grpc.health.v1.Health", "/toggle-health", "/metrics", "GET /health"} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("the landing page lacks %q:\n%s", want, body)
+		}
+	}
+	// Endpoints that are off are not listed.
+	if strings.Contains(string(body), "/reload-certs") {
+		t.Error("the landing page lists /reload-certs without -cert-reload-endpoint")
+	}
+
+	resp, err = http.Get(url + "/no-such-page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET /no-such-page = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestBootReport(t *testing.T) {
+	bundle, err := tlsutil.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "boot-report.json")
+	ctx, cancel := context.WithCancel(context.Background())
+	srv, _ := startEmbedded(t, ctx, server.WithSelfSigned(bundle), func(c *server.Config) {
+		c.Reflection = false
+		c.BootReportFile = path
+	})
+	defer func() {
+		cancel()
+		srv.Wait()
+	}()
+
+	// The report is written right after the server is ready.
+	type certificate struct {
+		Role     string
+		NotAfter time.Time `json:"not_after"`
+	}
+	var report struct {
+		Certificates []certificate
+		Features     []string
+		Addresses    []string
+		Config       map[string]string
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		data, err := os.ReadFile(path)
+		if err == nil && json.Unmarshal(data, &report) == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no boot report in %s: %v", path, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	want := []certificate{
+		{"server", bundle.Server.Cert.NotAfter},
+		{"client-ca", bundle.CA.Cert.NotAfter},
+	}
+	if !slices.EqualFunc(report.Certificates, want, func(a, b certificate) bool { return a.Role == b.Role && a.NotAfter.Equal(b.NotAfter) }) {
+		t.Errorf("certificates %+v, want %+v", report.Certificates, want)
+	}
+	if wantFeatures := []string{"mtls", "health", "server-info", "diagnostics"}; !slices.Equal(report.Features, wantFeatures) {
+		t.Errorf("features %v, want %v", report.Features, wantFeatures)
+	}
+	if report.Config["reflection"] != "false" {
+		t.Errorf("config reflection=%q, want false", report.Config["reflection"])
+	}
+	if !slices.Contains(report.Addresses, srv.GRPCAddr().String()) {
+		t.Errorf("addresses %v lack the gRPC address %s", report.Addresses, srv.GRPCAddr())
+	}
+}
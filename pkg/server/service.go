@@ -0,0 +1,562 @@
+// service.go
+//
+// This file implements the TimeService RPCs.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"strconv"
+	"time"
+	_ "time/tzdata" // Resolve requested time zones without system zoneinfo
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/dethi/envoy_hck/protos"
+)
+
+// defaultInterval is the time between two ticks of a stream.
+const defaultInterval = 2 * time.Second
+
+// timeFormats maps the names accepted as TimeRequest.format to layouts.
+var timeFormats = map[string]string{
+	"rfc3339":     time.RFC3339,
+	"rfc3339nano": time.RFC3339Nano,
+	"rfc1123":     time.RFC1123,
+	"datetime":    time.DateTime,
+}
+
+// truncateUnits maps the units accepted as TimeRequest.truncate_to to
+// durations.
+var truncateUnits = map[string]time.Duration{
+	"millisecond": time.Millisecond,
+	"second":      time.Second,
+	"minute":      time.Minute,
+	"hour":        time.Hour,
+}
+
+// serviceDefaults are the behaviors of a TimeService registration for
+// requests that do not choose their own, so that differently configured
+// registrations can sit behind separate Envoy routes.
+type serviceDefaults struct {
+	interval time.Duration  // stream tick interval; defaultInterval if zero
+	format   string         // name in timeFormats; "rfc3339" if empty
+	location *time.Location // zone of local_time; none if nil
+}
+
+func newServer(defaults serviceDefaults) (*server, error) {
+	if defaults.interval < 0 {
+		return nil, fmt.Errorf("interval must not be negative, got %s", defaults.interval)
+	}
+	if defaults.interval == 0 {
+		defaults.interval = defaultInterval
+	}
+	if defaults.format == "" {
+		defaults.format = "rfc3339"
+	}
+	if _, ok := timeFormats[defaults.format]; !ok {
+		return nil, fmt.Errorf("unknown time format %q", defaults.format)
+	}
+	return &server{defaults: defaults, clock: systemClock{}}, nil
+}
+
+type server struct {
+	pb.UnimplementedTimeServiceServer
+
+	defaults serviceDefaults
+
+	// clock tells the time GetTime, GetSchedule and the streams report.
+	clock clock
+
+	// replay, when non-empty, is emitted by StreamTime one entry per tick in
+	// place of the current time. The stream ends after the last entry
+	// unless replayLoop is set.
+	replay     []time.Time
+	replayLoop bool
+
+	// fixedTime, when non-zero, replaces the current time in GetTime and
+	// StreamTime. With fixedAdvance, each stream tick moves it forward by
+	// the tick interval.
+	fixedTime    time.Time
+	fixedAdvance bool
+
+	// canaryInterval, when positive, is the initial tick interval of
+	// streams tagged as canary traffic.
+	canaryInterval time.Duration
+
+	// tickLabels bounds the identity label of dropped_ticks_total. With
+	// logDroppedTicks, every tick a stream falls behind on is also logged.
+	tickLabels      *identityLabeler
+	logDroppedTicks bool
+
+	// sendBuffer, when positive, is how many messages each stream queues
+	// for its client, applying the sendOverflow policy to those that do
+	// not fit. Zero sends synchronously from the tick loop.
+	sendBuffer   int
+	sendOverflow string
+
+	// overload refuses new streams while the process is overloaded.
+	overload *goroutineGuard
+
+	retries retryTracker
+
+	// drain ends the active streams when this instance stops being the
+	// one that should receive traffic.
+	drain drainer
+}
+
+// tickTime returns the time reported for the given tick of a stream, counted
+// from zero, that fired at time t of the clock on a stream ticking every
+// interval.
+func (s *server) tickTime(t time.Time, tick int, interval time.Duration) time.Time {
+	if s.fixedTime.IsZero() {
+		return t
+	}
+	if s.fixedAdvance {
+		return s.fixedTime.Add(time.Duration(tick) * interval)
+	}
+	return s.fixedTime
+}
+
+// requestLocation returns the time zone named in req, or the default of
+// the service if none was requested.
+func (s *server) requestLocation(req *pb.TimeRequest) (*time.Location, error) {
+	name := req.GetTimezone()
+	if name == "" {
+		return s.defaults.location, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown timezone %q", name)
+	}
+	return loc, nil
+}
+
+// requestLayout returns the layout of the format named in req, or of the
+// default format of the service if none was requested.
+func (s *server) requestLayout(req *pb.TimeRequest) (string, error) {
+	name := req.GetFormat()
+	if name == "" {
+		name = s.defaults.format
+	}
+	layout, ok := timeFormats[name]
+	if !ok {
+		return "", status.Errorf(codes.InvalidArgument, "unknown format %q", name)
+	}
+	return layout, nil
+}
+
+// requestPrecision returns the duration req asks reported times to be
+// truncated to, or zero for no truncation.
+func requestPrecision(req *pb.TimeRequest) (time.Duration, error) {
+	unit := req.GetTruncateTo()
+	if unit == "" {
+		return 0, nil
+	}
+	d, ok := truncateUnits[unit]
+	if !ok {
+		return 0, status.Errorf(codes.InvalidArgument, "unknown truncate_to unit %q, want millisecond, second, minute or hour", unit)
+	}
+	return d, nil
+}
+
+// timeResponse reports t in layout as is, or with loc set, in UTC along
+// with its local time in loc.
+func timeResponse(t time.Time, loc *time.Location, layout string) *pb.TimeResponse {
+	if loc == nil {
+		return &pb.TimeResponse{CurrentTime: t.Format(layout)}
+	}
+	return &pb.TimeResponse{
+		CurrentTime: t.UTC().Format(layout),
+		LocalTime:   t.In(loc).Format(layout),
+	}
+}
+
+// alignDelay returns how long to wait after now until the next multiple of
+// interval on the clock. The result is in (0, interval].
+func alignDelay(now time.Time, interval time.Duration) time.Duration {
+	return now.Truncate(interval).Add(interval).Sub(now)
+}
+
+// now returns the time reported by GetTime.
+func (s *server) now() time.Time {
+	return s.tickTime(s.clock.Now(), 0, s.defaults.interval)
+}
+
+// streamInterval returns the initial tick interval of a stream.
+func (s *server) streamInterval(ctx context.Context) time.Duration {
+	if s.canaryInterval > 0 && isCanary(ctx) {
+		return s.canaryInterval
+	}
+	return s.defaults.interval
+}
+
+func (s *server) GetTime(ctx context.Context, req *pb.TimeRequest) (*pb.TimeResponse, error) {
+	if hint := req.GetRetryHint(); hint.GetFailures() > 0 {
+		code, err := retriableCode(hint.GetCode())
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		key := hint.GetKey()
+		if key == "" {
+			key = IdentityFromContext(ctx).Name()
+		}
+		if attempt, fail := s.retries.fail(key, hint.GetFailures()); fail {
+			log.Printf("Failing GetTime attempt %d/%d for %q with %s", attempt, hint.GetFailures(), key, code)
+			return nil, status.Errorf(code, "simulated failure %d of %d", attempt, hint.GetFailures())
+		}
+	}
+	pad, err := padding(req.GetPadBytes())
+	if err != nil {
+		return nil, err
+	}
+	loc, err := s.requestLocation(req)
+	if err != nil {
+		return nil, err
+	}
+	layout, err := s.requestLayout(req)
+	if err != nil {
+		return nil, err
+	}
+	precision, err := requestPrecision(req)
+	if err != nil {
+		return nil, err
+	}
+	resp := timeResponse(s.now().Truncate(precision), loc, layout)
+	resp.Padding = pad
+	log.Printf("GetTime returned %s", resp.CurrentTime)
+	return resp, nil
+}
+
+// maxScheduleCount bounds the number of tick times GetSchedule returns.
+const maxScheduleCount = 1000
+
+func (s *server) GetSchedule(ctx context.Context, req *pb.ScheduleRequest) (*pb.ScheduleResponse, error) {
+	count := req.GetCount()
+	if count < 1 || count > maxScheduleCount {
+		return nil, status.Errorf(codes.InvalidArgument, "count must be between 1 and %d, got %d", maxScheduleCount, count)
+	}
+	now := s.now()
+	layout := timeFormats[s.defaults.format]
+	times := make([]string, count)
+	for i := range times {
+		times[i] = now.Add(time.Duration(i+1) * s.defaults.interval).Format(layout)
+	}
+	return &pb.ScheduleResponse{TickTimes: times}, nil
+}
+
+func (s *server) StreamTime(req *pb.TimeRequest, stream pb.TimeService_StreamTimeServer) error {
+	log.Println("StreamTime request received")
+	if err := s.overload.check(stream.Context(), pb.TimeService_StreamTime_FullMethodName); err != nil {
+		log.Printf("Refusing StreamTime: %v", err)
+		return err
+	}
+	if name := req.GetCompression(); name != "" {
+		if encoding.GetCompressor(name) == nil {
+			return status.Errorf(codes.InvalidArgument, "unknown compressor %q", name)
+		}
+		if err := grpc.SetSendCompressor(stream.Context(), name); err != nil {
+			return status.Errorf(codes.InvalidArgument, "cannot compress with %q: %v", name, err)
+		}
+		log.Printf("Compressing stream with %s", name)
+	}
+	pad, err := padding(req.GetPadBytes())
+	if err != nil {
+		return err
+	}
+	loc, err := s.requestLocation(req)
+	if err != nil {
+		return err
+	}
+	layout, err := s.requestLayout(req)
+	if err != nil {
+		return err
+	}
+	precision, err := requestPrecision(req)
+	if err != nil {
+		return err
+	}
+	sequence := req.GetResumeFromSequence()
+	if sequence < 0 {
+		return status.Errorf(codes.InvalidArgument, "resume_from_sequence must not be negative, got %d", sequence)
+	}
+	if sequence > 0 {
+		log.Printf("Resuming stream after sequence %d", sequence)
+	}
+	heartbeatInterval := time.Duration(req.GetHeartbeatIntervalMs()) * time.Millisecond
+	if heartbeatInterval < 0 {
+		return status.Errorf(codes.InvalidArgument, "heartbeat_interval_ms must not be negative, got %d", req.GetHeartbeatIntervalMs())
+	}
+	var heartbeats <-chan time.Time
+	if heartbeatInterval > 0 {
+		heartbeat := time.NewTicker(heartbeatInterval)
+		defer heartbeat.Stop()
+		heartbeats = heartbeat.C
+	}
+	interval := s.streamInterval(stream.Context())
+	if ms := req.GetIntervalMs(); ms < 0 {
+		return status.Errorf(codes.InvalidArgument, "interval_ms must not be negative, got %d", ms)
+	} else if ms > 0 {
+		interval = time.Duration(ms) * time.Millisecond
+	}
+	messageCount := req.GetMessageCount()
+	if messageCount < 0 {
+		return status.Errorf(codes.InvalidArgument, "message_count must not be negative, got %d", messageCount)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	aligning := req.GetAlignToClock()
+	if aligning {
+		ticker.Reset(alignDelay(s.now(), interval))
+	}
+
+	// Account for the stream in its trailers and a summary log line,
+	// however it ends.
+	start := time.Now()
+	var dropped int64
+	var reason string
+	out := newSendQueue(stream, s.sendBuffer, s.sendOverflow)
+	defer func() {
+		elapsed := time.Since(start)
+		sent := out.sent.Load()
+		stream.SetTrailer(metadata.Pairs(
+			"x-stream-messages", strconv.FormatInt(sent, 10),
+			"x-stream-duration-ms", strconv.FormatInt(elapsed.Milliseconds(), 10),
+			"x-stream-end-reason", reason,
+			"x-stream-dropped-ticks", strconv.FormatInt(dropped, 10),
+		))
+		id := IdentityFromContext(stream.Context())
+		slog.Info("StreamTime ended", "peer", id.Name(), "extension", id.Extension, "messages", sent, "dropped_ticks", dropped, "duration_ms", elapsed.Milliseconds(), "reason", reason)
+	}()
+	defer out.abort()
+
+	drained := s.drain.C()
+	next := 0
+	var lastTick time.Time
+	for tick := 0; ; tick++ {
+		select {
+		case <-stream.Context().Done():
+			log.Println("Client disconnected")
+			reason = "client-disconnected"
+			return nil
+		case <-drained:
+			log.Println("Draining stream")
+			reason = "draining"
+			return status.Error(codes.Unavailable, "server is draining")
+		case <-out.failed():
+			log.Printf("Error sending time: %v", out.sendErr())
+			reason = "send-failed"
+			return sendFailed("time", out.sendErr())
+		case <-heartbeats:
+			if err := out.push(&pb.TimeResponse{IsHeartbeat: true}); err == errSlowConsumer {
+				reason = "slow-consumer"
+				return err
+			} else if err != nil {
+				log.Printf("Error sending heartbeat: %v", err)
+				reason = "send-failed"
+				return sendFailed("heartbeat", err)
+			}
+		case t := <-ticker.C:
+			if aligning {
+				// The first tick landed on a boundary; keep the regular period.
+				ticker.Reset(interval)
+				aligning = false
+			} else if n := s.missedTicks(stream.Context(), lastTick, t, interval); n > 0 {
+				// The ticker drops ticks while a slow Send holds up the loop.
+				dropped += n
+			}
+			lastTick = t
+			current := s.tickTime(s.clock.Now(), tick, interval)
+			if len(s.replay) > 0 {
+				if next == len(s.replay) {
+					if !s.replayLoop {
+						log.Println("Replay sequence exhausted")
+						reason = "replay-exhausted"
+						return out.flush(stream.Context())
+					}
+					next = 0
+				}
+				current = s.replay[next]
+				next++
+			}
+			sequence++
+			resp := timeResponse(current.Truncate(precision), loc, layout)
+			resp.Padding, resp.Sequence = pad, sequence
+			if err := out.push(resp); err == errSlowConsumer {
+				reason = "slow-consumer"
+				return err
+			} else if err != nil {
+				log.Printf("Error sending time: %v", err)
+				reason = "send-failed"
+				return sendFailed("time", err)
+			}
+			log.Printf("Sent time: %s", resp.CurrentTime)
+			if messageCount > 0 && sequence-req.GetResumeFromSequence() == int64(messageCount) {
+				reason = "message-count"
+				return out.flush(stream.Context())
+			}
+		}
+	}
+}
+
+// missedTicks returns how many ticks of interval were dropped between the
+// ticks that fired at prev and t, and accounts for them against the
+// stream's client.
+func (s *server) missedTicks(ctx context.Context, prev, t time.Time, interval time.Duration) int64 {
+	if prev.IsZero() {
+		return 0
+	}
+	n := int64(t.Sub(prev).Round(interval)/interval) - 1
+	if n <= 0 {
+		return 0
+	}
+	peer := IdentityFromContext(ctx).Name()
+	droppedTicks.WithLabelValues(s.tickLabels.label(peer)).Add(float64(n))
+	if s.logDroppedTicks {
+		log.Printf("Stream to %s fell behind: dropped %d ticks", peer, n)
+	}
+	return n
+}
+
+func (s *server) ControlledTime(stream pb.TimeService_ControlledTimeServer) error {
+	log.Println("ControlledTime request received")
+	ticker := time.NewTicker(s.streamInterval(stream.Context()))
+	defer ticker.Stop()
+
+	// Receive control messages on their own goroutine so ticks keep flowing
+	// while the client is quiet.
+	intervals := make(chan time.Duration)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case intervals <- time.Duration(req.GetIntervalMs()) * time.Millisecond:
+			case <-stream.Context().Done():
+				return
+			}
+		}
+	}()
+
+	layout := timeFormats[s.defaults.format]
+	drained := s.drain.C()
+	for {
+		select {
+		case <-stream.Context().Done():
+			log.Println("Client disconnected")
+			return nil
+		case <-drained:
+			log.Println("Draining stream")
+			return status.Error(codes.Unavailable, "server is draining")
+		case err := <-recvErr:
+			if err != io.EOF {
+				return err
+			}
+			// The client is done adjusting; keep streaming at the current rate.
+			recvErr = nil
+		case d := <-intervals:
+			if d <= 0 {
+				return status.Errorf(codes.InvalidArgument, "interval_ms must be positive, got %d", d.Milliseconds())
+			}
+			ticker.Reset(d)
+			log.Printf("Stream interval changed to %s", d)
+		case <-ticker.C:
+			t := s.clock.Now()
+			if err := stream.Send(&pb.TimeResponse{CurrentTime: t.Format(layout)}); err != nil {
+				log.Printf("Error sending time: %v", err)
+				return sendFailed("time", err)
+			}
+			log.Printf("Sent time: %s", t.Format(layout))
+		}
+	}
+}
+
+// receivedReport is a TimestampReport with the time the server received it.
+type receivedReport struct {
+	report *pb.TimestampReport
+	at     time.Time
+}
+
+func (s *server) ReportTimestamps(stream pb.TimeService_ReportTimestampsServer) error {
+	log.Println("ReportTimestamps request received")
+
+	// Receive on their own goroutine so draining ends streams whose client
+	// is quiet.
+	reports := make(chan receivedReport)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case reports <- receivedReport{req, time.Now()}:
+			case <-stream.Context().Done():
+				return
+			}
+		}
+	}()
+
+	var (
+		count            int64
+		earliest, latest time.Time
+		sum, maxOffset   time.Duration
+	)
+	drained := s.drain.C()
+	for {
+		select {
+		case <-stream.Context().Done():
+			log.Println("Client disconnected")
+			return nil
+		case <-drained:
+			log.Println("Draining stream")
+			return status.Error(codes.Unavailable, "server is draining")
+		case err := <-recvErr:
+			if err != io.EOF {
+				return err
+			}
+			resp := &pb.TimestampSummary{Count: count}
+			if count > 0 {
+				layout := timeFormats[s.defaults.format]
+				resp.Earliest = earliest.UTC().Format(layout)
+				resp.Latest = latest.UTC().Format(layout)
+				resp.MeanOffsetNanos = int64(sum) / count
+				resp.MaxOffsetNanos = int64(maxOffset)
+			}
+			log.Printf("Received %d timestamp(s)", count)
+			return stream.SendAndClose(resp)
+		case r := <-reports:
+			ns := r.report.GetClientTimeUnixNano()
+			if ns <= 0 {
+				return status.Errorf(codes.InvalidArgument, "client_time_unix_nano must be positive, got %d", ns)
+			}
+			t := time.Unix(0, ns)
+			offset := r.at.Sub(t)
+			if count == 0 || t.Before(earliest) {
+				earliest = t
+			}
+			if count == 0 || t.After(latest) {
+				latest = t
+			}
+			if count == 0 || offset > maxOffset {
+				maxOffset = offset
+			}
+			sum += offset
+			count++
+		}
+	}
+}
@@ -0,0 +1,494 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/dethi/envoy_hck/protos"
+)
+
+func TestControlledTimeChangesInterval(t *testing.T) {
+	client := timeClient(t, newTestServer(t, serviceDefaults{interval: 200 * time.Millisecond}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.ControlledTime(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := stream.Send(&pb.ControlRequest{IntervalMs: 10}); err != nil {
+		t.Fatal(err)
+	}
+	// The first tick may still be on the old schedule.
+	if _, err := stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for range 5 {
+		if _, err := stream.Recv(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Errorf("5 ticks at 10ms took %s", elapsed)
+	}
+}
+
+func TestGetSchedule(t *testing.T) {
+	s := newTestServer(t, serviceDefaults{interval: 250 * time.Millisecond, format: "rfc3339nano"})
+	s.fixedTime = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	client := timeClient(t, s)
+
+	resp, err := client.GetSchedule(context.Background(), &pb.ScheduleRequest{Count: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"2024-03-01T12:00:00.25Z", "2024-03-01T12:00:00.5Z", "2024-03-01T12:00:00.75Z"}
+	if !slices.Equal(resp.GetTickTimes(), want) {
+		t.Errorf("tick times %v, want %v", resp.GetTickTimes(), want)
+	}
+	for _, count := range []int32{0, maxScheduleCount + 1} {
+		if _, err := client.GetSchedule(context.Background(), &pb.ScheduleRequest{Count: count}); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("count %d: %v, want INVALID_ARGUMENT", count, err)
+		}
+	}
+}
+
+// offsetClock is the wall clock shifted by an offset.
+type offsetClock time.Duration
+
+func (c offsetClock) Now() time.Time { return time.Now().Add(time.Duration(c)) }
+
+// fixedClock always tells the same time.
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time { return time.Time(c) }
+
+func TestAlignDelay(t *testing.T) {
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		now      time.Time
+		interval time.Duration
+		want     time.Duration
+	}{
+		{base.Add(500 * time.Millisecond), 2 * time.Second, 1500 * time.Millisecond},
+		{base.Add(1900 * time.Millisecond), 2 * time.Second, 100 * time.Millisecond},
+		{base, 2 * time.Second, 2 * time.Second},
+		{base.Add(59 * time.Second), time.Minute, time.Second},
+	} {
+		if got := alignDelay(tc.now, tc.interval); got != tc.want {
+			t.Errorf("alignDelay(%s, %s) = %s, want %s", tc.now.Format("15:04:05.000"), tc.interval, got, tc.want)
+		}
+	}
+}
+
+func TestStreamTimeAlignToClock(t *testing.T) {
+	const interval = 100 * time.Millisecond
+	s := newTestServer(t, serviceDefaults{})
+	// Shift the clock off the wall clock's boundaries: ticks must align to
+	// the clock the service reports.
+	s.clock = offsetClock(37 * time.Millisecond)
+	stream, err := timeClient(t, s).StreamTime(context.Background(), &pb.TimeRequest{
+		IntervalMs: interval.Milliseconds(), MessageCount: 3, AlignToClock: true, Format: "rfc3339nano",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		resp, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		tick, err := time.Parse(time.RFC3339Nano, resp.GetCurrentTime())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if off := tick.Sub(tick.Truncate(interval)); off > 20*time.Millisecond {
+			t.Errorf("tick %s is %s past a %s boundary", resp.GetCurrentTime(), off, interval)
+		}
+	}
+}
+
+func TestStreamTimeHeartbeats(t *testing.T) {
+	s := newTestServer(t, serviceDefaults{})
+	client := timeClient(t, s)
+	stream, err := client.StreamTime(context.Background(), &pb.TimeRequest{
+		IntervalMs: 200, HeartbeatIntervalMs: 20, MessageCount: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ticks, heartbeats int
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case resp.GetIsHeartbeat():
+			if resp.GetCurrentTime() != "" {
+				t.Errorf("heartbeat carries time %q", resp.GetCurrentTime())
+			}
+			heartbeats++
+		case resp.GetCurrentTime() == "":
+			t.Error("data tick without a time")
+		default:
+			ticks++
+		}
+	}
+	// Heartbeats do not count towards message_count.
+	if ticks != 2 {
+		t.Errorf("%d data ticks, want 2", ticks)
+	}
+	if heartbeats < 5 {
+		t.Errorf("%d heartbeats in 400ms at 20ms, want several", heartbeats)
+	}
+
+	stream, err = client.StreamTime(context.Background(), &pb.TimeRequest{HeartbeatIntervalMs: -1})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("negative heartbeat_interval_ms: %v, want INVALID_ARGUMENT", err)
+	}
+}
+
+func TestPadding(t *testing.T) {
+	s := newTestServer(t, serviceDefaults{interval: 10 * time.Millisecond})
+	client := timeClient(t, s)
+	for _, n := range []int32{0, 1000, 64 << 10, maxPadBytes} {
+		resp, err := client.GetTime(context.Background(), &pb.TimeRequest{PadBytes: n})
+		if err != nil {
+			t.Fatalf("GetTime with pad_bytes %d: %v", n, err)
+		}
+		if len(resp.GetPadding()) != int(n) {
+			t.Errorf("pad_bytes %d: %d bytes of padding", n, len(resp.GetPadding()))
+		}
+		if size := proto.Size(resp); size < int(n) || size > int(n)+100 {
+			t.Errorf("pad_bytes %d: response of %d bytes", n, size)
+		}
+	}
+
+	stream, err := client.StreamTime(context.Background(), &pb.TimeRequest{PadBytes: 4096, MessageCount: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		resp, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.GetPadding()) != 4096 {
+			t.Errorf("streamed response with %d bytes of padding, want 4096", len(resp.GetPadding()))
+		}
+	}
+
+	for _, n := range []int32{-1, maxPadBytes + 1} {
+		if _, err := client.GetTime(context.Background(), &pb.TimeRequest{PadBytes: n}); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("pad_bytes %d: %v, want INVALID_ARGUMENT", n, err)
+		}
+	}
+}
+
+func TestStreamTimeResume(t *testing.T) {
+	s := newTestServer(t, serviceDefaults{interval: 10 * time.Millisecond})
+	client := timeClient(t, s)
+	sequences := func(req *pb.TimeRequest) []int64 {
+		t.Helper()
+		stream, err := client.StreamTime(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []int64
+		for {
+			resp, err := stream.Recv()
+			if err == io.EOF {
+				return got
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, resp.GetSequence())
+		}
+	}
+
+	first := sequences(&pb.TimeRequest{MessageCount: 3})
+	if want := []int64{1, 2, 3}; !slices.Equal(first, want) {
+		t.Fatalf("first stream sequences %v, want %v", first, want)
+	}
+	// message_count counts the messages of the resumed stream alone.
+	resumed := sequences(&pb.TimeRequest{MessageCount: 2, ResumeFromSequence: first[len(first)-1]})
+	if want := []int64{4, 5}; !slices.Equal(resumed, want) {
+		t.Errorf("resumed stream sequences %v, want %v", resumed, want)
+	}
+
+	stream, err := client.StreamTime(context.Background(), &pb.TimeRequest{ResumeFromSequence: -1})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("negative resume_from_sequence: %v, want INVALID_ARGUMENT", err)
+	}
+}
+
+func TestLocalAndUTCTime(t *testing.T) {
+	s := newTestServer(t, serviceDefaults{interval: 10 * time.Millisecond})
+	client := timeClient(t, s)
+	// Kolkata keeps +05:30 all year, so the expected offset does not
+	// depend on the date.
+	check := func(resp *pb.TimeResponse) {
+		t.Helper()
+		utc, err := time.Parse(time.RFC3339, resp.GetCurrentTime())
+		if err != nil {
+			t.Fatal(err)
+		}
+		local, err := time.Parse(time.RFC3339, resp.GetLocalTime())
+		if err != nil {
+			t.Fatalf("local_time %q: %v", resp.GetLocalTime(), err)
+		}
+		if !strings.HasSuffix(resp.GetCurrentTime(), "Z") {
+			t.Errorf("current_time %s is not in UTC", resp.GetCurrentTime())
+		}
+		if _, offset := local.Zone(); offset != 5*3600+30*60 {
+			t.Errorf("local_time %s is not at +05:30", resp.GetLocalTime())
+		}
+		if !utc.Equal(local) {
+			t.Errorf("current_time %s and local_time %s are different instants", resp.GetCurrentTime(), resp.GetLocalTime())
+		}
+	}
+
+	req := &pb.TimeRequest{Timezone: "Asia/Kolkata", MessageCount: 2}
+	resp, err := client.GetTime(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(resp)
+	stream, err := client.StreamTime(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		resp, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(resp)
+	}
+
+	resp, err = client.GetTime(context.Background(), &pb.TimeRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetLocalTime() != "" {
+		t.Errorf("local_time %q without a timezone, want empty", resp.GetLocalTime())
+	}
+}
+
+func TestStreamTimeFinalStats(t *testing.T) {
+	logs := captureSlog(t)
+	s := newTestServer(t, serviceDefaults{})
+	stream, err := timeClient(t, s).StreamTime(context.Background(), &pb.TimeRequest{IntervalMs: 20, MessageCount: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for err == nil {
+		_, err = stream.Recv()
+	}
+	if err != io.EOF {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+
+	trailer := stream.Trailer()
+	for key, want := range map[string]string{"x-stream-messages": "3", "x-stream-end-reason": "message-count", "x-stream-dropped-ticks": "0"} {
+		if got := trailer.Get(key); len(got) != 1 || got[0] != want {
+			t.Errorf("%s = %v, want %s", key, got, want)
+		}
+	}
+	duration, err := strconv.Atoi(strings.Join(trailer.Get("x-stream-duration-ms"), ""))
+	if err != nil {
+		t.Fatalf("x-stream-duration-ms: %v", err)
+	}
+	// Three ticks 20ms apart take at least 60ms.
+	if duration < 60 || duration > int(elapsed.Milliseconds()) {
+		t.Errorf("x-stream-duration-ms = %d, want between 60 and %d", duration, elapsed.Milliseconds())
+	}
+
+	var summary struct {
+		Msg, Reason string
+		Messages    int64
+	}
+	for line := range strings.Lines(logs.String()) {
+		if strings.Contains(line, `"msg":"StreamTime ended"`) {
+			if err := json.Unmarshal([]byte(line), &summary); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if summary.Msg == "" {
+		t.Fatalf("no summary logged: %s", logs)
+	}
+	if summary.Messages != 3 || summary.Reason != "message-count" {
+		t.Errorf("summary %+v, want 3 messages ended by message-count", summary)
+	}
+}
+
+func TestDroppedTicksSlowConsumer(t *testing.T) {
+	logs := captureLog(t)
+	s := newTestServer(t, serviceDefaults{})
+	s.logDroppedTicks = true
+	_, withPeer := injectPeer(clientCert(t, "slow-client"))
+	stream, err := timeClient(t, s, grpc.ChainStreamInterceptor(withPeer, identityStreamInterceptor)).StreamTime(context.Background(), &pb.TimeRequest{
+		IntervalMs: 10, PadBytes: 1 << 20, MessageCount: 5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Megabyte messages fill the flow control window at once, so sends
+	// block while the client is not reading.
+	time.Sleep(300 * time.Millisecond)
+	for err == nil {
+		_, err = stream.Recv()
+	}
+	if err != io.EOF {
+		t.Fatal(err)
+	}
+
+	dropped, err := strconv.Atoi(strings.Join(stream.Trailer().Get("x-stream-dropped-ticks"), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dropped < 10 {
+		t.Errorf("%d ticks dropped while the client stalled for 300ms at 10ms, want more", dropped)
+	}
+	if !strings.Contains(logs.String(), "Stream to slow-client fell behind: dropped ") {
+		t.Errorf("no dropped ticks logged: %s", logs)
+	}
+	if !strings.Contains(scrape(t), `dropped_ticks_total{identity="slow-client"} `) {
+		t.Error(`/metrics lacks dropped_ticks_total{identity="slow-client"}`)
+	}
+}
+
+func TestServiceDefaults(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fast := timeClient(t, newTestServer(t, serviceDefaults{interval: 10 * time.Millisecond, format: "rfc3339nano", location: tokyo}))
+	slow := timeClient(t, newTestServer(t, serviceDefaults{interval: 100 * time.Millisecond, format: "datetime"}))
+	streamFor := func(client pb.TimeServiceClient, req *pb.TimeRequest) time.Duration {
+		t.Helper()
+		start := time.Now()
+		stream, err := client.StreamTime(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for err == nil {
+			_, err = stream.Recv()
+		}
+		if err != io.EOF {
+			t.Fatal(err)
+		}
+		return time.Since(start)
+	}
+
+	if took := streamFor(fast, &pb.TimeRequest{MessageCount: 3}); took > 150*time.Millisecond {
+		t.Errorf("3 ticks at the fast default took %s", took)
+	}
+	if took := streamFor(slow, &pb.TimeRequest{MessageCount: 3}); took < 300*time.Millisecond {
+		t.Errorf("3 ticks at the slow default took %s", took)
+	}
+	if took := streamFor(slow, &pb.TimeRequest{MessageCount: 3, IntervalMs: 10}); took > 150*time.Millisecond {
+		t.Errorf("3 ticks at a requested 10ms took %s on the slow registration", took)
+	}
+
+	resp, err := fast.GetTime(context.Background(), &pb.TimeRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, resp.GetCurrentTime()); err != nil || !strings.Contains(resp.GetCurrentTime(), ".") {
+		t.Errorf("fast current_time %q is not rfc3339nano", resp.GetCurrentTime())
+	}
+	if !strings.HasSuffix(resp.GetLocalTime(), "+09:00") {
+		t.Errorf("fast local_time %q is not in the default zone", resp.GetLocalTime())
+	}
+	resp, err = slow.GetTime(context.Background(), &pb.TimeRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := time.Parse(time.DateTime, resp.GetCurrentTime()); err != nil {
+		t.Errorf("slow current_time %q is not datetime", resp.GetCurrentTime())
+	}
+	if resp.GetLocalTime() != "" {
+		t.Errorf("slow local_time %q without a default zone", resp.GetLocalTime())
+	}
+	resp, err = slow.GetTime(context.Background(), &pb.TimeRequest{Format: "rfc3339", Timezone: "Asia/Tokyo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := time.Parse(time.RFC3339, resp.GetCurrentTime()); err != nil || !strings.HasSuffix(resp.GetLocalTime(), "+09:00") {
+		t.Errorf("requested format and zone not applied: %v", resp)
+	}
+
+	for _, bad := range []serviceDefaults{{interval: -time.Second}, {format: "iso"}} {
+		if _, err := newServer(bad); err == nil {
+			t.Errorf("newServer(%+v) succeeded", bad)
+		}
+	}
+}
+
+func TestTruncateTo(t *testing.T) {
+	s := newTestServer(t, serviceDefaults{interval: 10 * time.Millisecond, format: "rfc3339nano"})
+	s.clock = fixedClock(time.Date(2024, 3, 10, 14, 37, 52, 123456789, time.UTC))
+	client := timeClient(t, s)
+	for _, tc := range []struct {
+		unit, want string
+	}{
+		{"", "2024-03-10T14:37:52.123456789Z"},
+		{"millisecond", "2024-03-10T14:37:52.123Z"},
+		{"second", "2024-03-10T14:37:52Z"},
+		{"minute", "2024-03-10T14:37:00Z"},
+		{"hour", "2024-03-10T14:00:00Z"},
+	} {
+		req := &pb.TimeRequest{TruncateTo: tc.unit, MessageCount: 1}
+		resp, err := client.GetTime(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.GetCurrentTime() != tc.want {
+			t.Errorf("GetTime truncated to %q = %s, want %s", tc.unit, resp.GetCurrentTime(), tc.want)
+		}
+		stream, err := client.StreamTime(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp, err = stream.Recv(); err != nil {
+			t.Fatal(err)
+		}
+		if resp.GetCurrentTime() != tc.want {
+			t.Errorf("StreamTime truncated to %q = %s, want %s", tc.unit, resp.GetCurrentTime(), tc.want)
+		}
+	}
+
+	if _, err := client.GetTime(context.Background(), &pb.TimeRequest{TruncateTo: "day"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("truncate_to day: %v, want INVALID_ARGUMENT", err)
+	}
+}
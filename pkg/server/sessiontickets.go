@@ -0,0 +1,88 @@
+// sessiontickets.go
+//
+// This file rotates the TLS session ticket keys of the gRPC listeners on a
+// fixed interval, so Envoy's upstream session reuse can be tested across
+// key changes in minutes rather than Go's default of a new key every day.
+// Tickets stay valid for one interval after the key that sealed them was
+// replaced; older ones fall back to a full handshake. Whether a handshake
+// resumed a session is recorded with the other handshake details.
+
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"log"
+	"sync"
+	"time"
+)
+
+// ticketKeysKept is how many keys decrypt tickets: the current one, which
+// also seals new tickets, and its predecessor.
+const ticketKeysKept = 2
+
+// ticketKeyRotator holds the session ticket keys of the listeners.
+type ticketKeyRotator struct {
+	mu   sync.Mutex
+	keys [][32]byte // newest first
+}
+
+// newTicketKeyRotator returns a rotator with a fresh key that replaces it
+// every interval until ctx is done.
+func newTicketKeyRotator(ctx context.Context, interval time.Duration) *ticketKeyRotator {
+	r := &ticketKeyRotator{}
+	r.rotate()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.rotate()
+				log.Printf("Rotated TLS session ticket keys; tickets sealed more than %s ago no longer resume", interval*ticketKeysKept)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return r
+}
+
+func (r *ticketKeyRotator) rotate() {
+	var key [32]byte
+	rand.Read(key[:])
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys = append([][32]byte{key}, r.keys...)
+	if len(r.keys) > ticketKeysKept {
+		r.keys = r.keys[:ticketKeysKept]
+	}
+}
+
+func (r *ticketKeyRotator) current() [][32]byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.keys
+}
+
+// configForClient wraps a tls.Config.GetConfigForClient callback, or base
+// when there is none, to set the current keys on the configuration of
+// every handshake. Setting them on base alone would not do: a
+// configuration returned by the callback uses its own keys if it has any,
+// and the copies derived from base hold those of the time of the copy.
+func (r *ticketKeyRotator) configForClient(base *tls.Config, next func(*tls.ClientHelloInfo) (*tls.Config, error)) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		cfg := base
+		if next != nil {
+			var err error
+			if cfg, err = next(hello); err != nil {
+				return nil, err
+			}
+		}
+		cfg = cfg.Clone()
+		cfg.GetConfigForClient = nil
+		cfg.SetSessionTicketKeys(r.current())
+		return cfg, nil
+	}
+}
@@ -0,0 +1,86 @@
+// shortlived.go
+//
+// This file implements short-lived server certificates for -self-signed
+// mode. With -cert-lifetime, the server certificate expires that long
+// after it is issued, and is either renewed at half its lifetime, as SPIRE
+// agents renew SVIDs, or, with -cert-renewal lapse, served past its
+// expiry. Handshakes only check the certificate when they happen, so
+// connections established before the expiry keep working while new ones
+// fail: what Envoy runs into when an upstream's SVID lapses mid-connection.
+
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"log"
+	"time"
+
+	"github.com/dethi/envoy_hck/pkg/tlsutil"
+)
+
+// certRenewals are the values of -cert-renewal.
+var certRenewals = []string{"renew", "lapse"}
+
+// shortLivedSource is a tlsutil.Store fed by the CA of a self-signed bundle.
+type shortLivedSource struct {
+	tlsutil.Store
+
+	ctx      context.Context // ends the renewals
+	bundle   *tlsutil.SelfSignedBundle
+	lifetime time.Duration
+}
+
+// newShortLivedSource issues the first certificate, valid for lifetime,
+// and schedules its renewal or expiry until ctx is done.
+func newShortLivedSource(ctx context.Context, bundle *tlsutil.SelfSignedBundle, lifetime time.Duration, renew bool) (*shortLivedSource, error) {
+	s := &shortLivedSource{ctx: ctx, bundle: bundle, lifetime: lifetime}
+	leaf, err := s.issue()
+	if err != nil {
+		return nil, err
+	}
+	if renew {
+		s.renewIn(lifetime / 2)
+	} else {
+		t := time.AfterFunc(time.Until(leaf.NotAfter), func() {
+			log.Printf("Server certificate expired at %s and is not renewed (-cert-renewal lapse); new handshakes will fail", leaf.NotAfter.Format(time.RFC3339))
+		})
+		context.AfterFunc(ctx, func() { t.Stop() })
+	}
+	return s, nil
+}
+
+// issue makes a new certificate the current one.
+func (s *shortLivedSource) issue() (*x509.Certificate, error) {
+	server, err := s.bundle.IssueServerCert(s.lifetime)
+	if err != nil {
+		return nil, err
+	}
+	m, err := tlsutil.NewMaterial(server.TLSCertificate(), []*x509.Certificate{s.bundle.CA.Cert})
+	if err != nil {
+		return nil, err
+	}
+	s.Set(m)
+	tlsutil.LogLoaded("the short-lived certificate issuer", m)
+	return m.Leaf, nil
+}
+
+// renewIn issues the next certificate after d, then again at half its
+// lifetime, retrying sooner on failure while the current one lasts.
+func (s *shortLivedSource) renewIn(d time.Duration) {
+	go func() {
+		for {
+			select {
+			case <-time.After(d):
+			case <-s.ctx.Done():
+				return
+			}
+			d = s.lifetime / 2
+			if _, err := s.issue(); err != nil {
+				tlsReloadFailures.Inc()
+				log.Printf("Failed to renew the short-lived certificate, retrying in %s: %v", s.lifetime/10, err)
+				d = s.lifetime / 10
+			}
+		}
+	}()
+}
@@ -0,0 +1,87 @@
+// shutdown.go
+//
+// This file implements the graceful stop sequence run when the process is
+// asked to terminate.
+
+package server
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+)
+
+// stopper is the part of *grpc.Server used to stop it, also implemented
+// by serverGroup.
+type stopper interface {
+	GracefulStop()
+	Stop()
+}
+
+// serverGroup stops several gRPC servers together.
+type serverGroup []*grpc.Server
+
+func (g serverGroup) GracefulStop() {
+	var wg sync.WaitGroup
+	for _, s := range g {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.GracefulStop()
+		}()
+	}
+	wg.Wait()
+}
+
+func (g serverGroup) Stop() {
+	for _, s := range g {
+		s.Stop()
+	}
+}
+
+// shutdown runs the termination sequence in order: report NOT_SERVING so
+// Envoy's health checks take the endpoint out of rotation, keep serving
+// in-flight and new requests for preStop while that happens, then drain
+// and stop the gRPC server.
+func shutdown(s stopper, hs *health.Server, drain *drainer, streams *streamRegistry, preStop, goawayDelay time.Duration) {
+	log.Println("Reporting NOT_SERVING for shutdown")
+	mu.Lock()
+	shuttingDown.Store(true)
+	publishHealth(hs, "shutdown")
+	// Shutdown also makes the health server ignore later status changes.
+	hs.Shutdown()
+	for _, inst := range clusterInstances {
+		inst.hs.Shutdown()
+	}
+	mu.Unlock()
+	if preStop > 0 {
+		log.Printf("Waiting %s for the endpoint to leave rotation", preStop)
+		time.Sleep(preStop)
+	}
+	gracefulStop(s, drain, streams, goawayDelay)
+}
+
+// gracefulStop sends GOAWAY to every client connection, ends the active
+// streams so clients reconnect elsewhere, and waits up to delay for the
+// connections to close before closing the remaining ones forcibly. That
+// bounds the stop even when a stream is stuck, e.g. in a blocked send.
+func gracefulStop(s stopper, drain *drainer, streams *streamRegistry, delay time.Duration) {
+	log.Println("Initiating graceful stop, sending GOAWAY to clients")
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+	drain.Drain()
+
+	select {
+	case <-done:
+		log.Println("All client connections closed")
+	case <-time.After(delay):
+		log.Printf("Connections still open after %s, closing them and aborting %d stream(s)", delay, streams.count())
+		s.Stop()
+	}
+}
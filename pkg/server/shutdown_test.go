@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/dethi/envoy_hck/protos"
+)
+
+func TestGracefulStopEndsStreams(t *testing.T) {
+	s := newTestServer(t, serviceDefaults{interval: 10 * time.Millisecond})
+	srv := grpc.NewServer()
+	pb.RegisterTimeServiceServer(srv, s)
+	client := pb.NewTimeServiceClient(bufconnClient(t, srv))
+	stream, err := client.StreamTime(context.Background(), &pb.TimeRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+
+	stopped := make(chan time.Duration)
+	go func() {
+		start := time.Now()
+		var streams streamRegistry
+		gracefulStop(srv, &s.drain, &streams, 5*time.Second)
+		stopped <- time.Since(start)
+	}()
+	for err == nil {
+		_, err = stream.Recv()
+	}
+	if st := status.Convert(err); st.Code() != codes.Unavailable || st.Message() != "server is draining" {
+		t.Errorf("stream ended with %v, want UNAVAILABLE server is draining", err)
+	}
+	if got := stream.Trailer().Get("x-stream-end-reason"); len(got) != 1 || got[0] != "draining" {
+		t.Errorf("end reason %v, want draining", got)
+	}
+	if took := <-stopped; took > time.Second {
+		t.Errorf("graceful stop took %s, want the connection to close without the delay", took)
+	}
+}
+
+// recordingStopper records when the server it wraps is asked to stop.
+type recordingStopper struct {
+	*grpc.Server
+	gracefulStop chan time.Time
+}
+
+func (s recordingStopper) GracefulStop() {
+	s.gracefulStop <- time.Now()
+	s.Server.GracefulStop()
+}
+
+func TestShutdownOrder(t *testing.T) {
+	healthyProcess(t)
+	hs := health.NewServer()
+	mu.Lock()
+	publishHealth(hs, "test")
+	mu.Unlock()
+	s := newTestServer(t, serviceDefaults{interval: 10 * time.Millisecond})
+	srv := grpc.NewServer()
+	pb.RegisterTimeServiceServer(srv, s)
+	client := pb.NewTimeServiceClient(bufconnClient(t, srv))
+	stream, err := client.StreamTime(context.Background(), &pb.TimeRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const preStop = 200 * time.Millisecond
+	stopper := recordingStopper{srv, make(chan time.Time, 1)}
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		var streams streamRegistry
+		shutdown(stopper, hs, &s.drain, &streams, preStop, 5*time.Second)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(preStop / 2)
+	for overallStatus(t, hs) != grpc_health_v1.HealthCheckResponse_NOT_SERVING {
+		if time.Now().After(deadline) {
+			t.Fatal("still SERVING after shutdown started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// The endpoint keeps serving while it leaves rotation.
+	if _, err := client.GetTime(context.Background(), &pb.TimeRequest{}); err != nil {
+		t.Errorf("GetTime during the pre-stop delay: %v", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Errorf("stream during the pre-stop delay: %v", err)
+	}
+
+	if stoppedAfter := (<-stopper.gracefulStop).Sub(start); stoppedAfter < preStop {
+		t.Errorf("graceful stop after %s, before the %s pre-stop delay", stoppedAfter, preStop)
+	}
+	for err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("stream ended with %v, want UNAVAILABLE", err)
+	}
+	<-done
+	mu.Lock()
+	isHealthy.Store(true)
+	publishHealth(hs, "test")
+	mu.Unlock()
+	if got := overallStatus(t, hs); got != grpc_health_v1.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("health changed to %s after shutdown", got)
+	}
+}
+
+func TestGracefulStopAbortsStuckStream(t *testing.T) {
+	logs := captureLog(t)
+	var streams streamRegistry
+	aborted := make(chan error, 1)
+	// A handler that ignores draining and only ends when its stream is
+	// cancelled.
+	stuck := func(_ any, ss grpc.ServerStream) error {
+		<-ss.Context().Done()
+		aborted <- ss.Context().Err()
+		return ss.Context().Err()
+	}
+	srv := grpc.NewServer(grpc.UnknownServiceHandler(stuck), grpc.StreamInterceptor(streams.streamInterceptor))
+	conn := bufconnClient(t, srv)
+	stream, err := conn.NewStream(context.Background(), &grpc.StreamDesc{ServerStreams: true}, "/test.Stuck/Stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for streams.count() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("the stream never reached the handler")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	const delay = 200 * time.Millisecond
+	var drain drainer
+	start := time.Now()
+	gracefulStop(srv, &drain, &streams, delay)
+	if took := time.Since(start); took < delay || took > delay+time.Second {
+		t.Errorf("graceful stop took %s, want the %s delay", took, delay)
+	}
+	select {
+	case err := <-aborted:
+		if err != context.Canceled {
+			t.Errorf("handler context ended with %v, want canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the stuck handler was not aborted")
+	}
+	if err := stream.RecvMsg(new(pb.TimeResponse)); status.Code(err) != codes.Unavailable {
+		t.Errorf("client stream ended with %v, want UNAVAILABLE", err)
+	}
+	if !strings.Contains(logs.String(), "aborting 1 stream(s)") {
+		t.Errorf("no abort logged: %s", logs)
+	}
+}
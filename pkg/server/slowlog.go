@@ -0,0 +1,56 @@
+// slowlog.go
+//
+// This file logs RPCs that take longer than a threshold, with their method,
+// duration and peer identity, so outliers show up in the logs without
+// opening dashboards.
+
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	pb "github.com/dethi/envoy_hck/protos"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// longLivedMethods stream for as long as the client stays connected, so
+// their duration says nothing about latency.
+var longLivedMethods = map[string]bool{
+	pb.TimeService_StreamTime_FullMethodName:       true,
+	pb.TimeService_ControlledTime_FullMethodName:   true,
+	pb.TimeService_ReportTimestamps_FullMethodName: true,
+}
+
+// slowLog logs RPCs slower than threshold. Long-lived streams are only
+// logged when slower than streamThreshold, if set.
+type slowLog struct {
+	threshold       time.Duration
+	streamThreshold time.Duration
+}
+
+func (l slowLog) check(ctx context.Context, method string, start time.Time, threshold time.Duration, err error) {
+	if d := time.Since(start); threshold > 0 && d > threshold {
+		log.Printf("Slow RPC %s from %s took %s (threshold %s): %s", method, IdentityFromContext(ctx).Name(), d.Round(time.Microsecond), threshold, status.Code(err))
+	}
+}
+
+func (l slowLog) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	l.check(ctx, info.FullMethod, start, l.threshold, err)
+	return resp, err
+}
+
+func (l slowLog) streamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	threshold := l.threshold
+	if longLivedMethods[info.FullMethod] {
+		threshold = l.streamThreshold
+	}
+	start := time.Now()
+	err := handler(srv, ss)
+	l.check(ss.Context(), info.FullMethod, start, threshold, err)
+	return err
+}
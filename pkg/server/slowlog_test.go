@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+
+	pb "github.com/dethi/envoy_hck/protos"
+)
+
+func TestSlowLog(t *testing.T) {
+	l := slowLog{threshold: 20 * time.Millisecond}
+	sleep := func(d time.Duration) grpc.UnaryHandler {
+		return func(context.Context, any) (any, error) {
+			time.Sleep(d)
+			return nil, nil
+		}
+	}
+	sleepStream := func(d time.Duration) grpc.StreamHandler {
+		return func(any, grpc.ServerStream) error {
+			time.Sleep(d)
+			return nil
+		}
+	}
+	ss := &contextStream{ctx: withTestIdentity("tenant-a")}
+
+	for _, tc := range []struct {
+		name   string
+		call   func(l slowLog)
+		logged bool
+	}{
+		{"fast unary", func(l slowLog) {
+			l.unaryInterceptor(withTestIdentity("tenant-a"), nil, &grpc.UnaryServerInfo{FullMethod: "/test.Slow/Unary"}, sleep(0))
+		}, false},
+		{"slow unary", func(l slowLog) {
+			l.unaryInterceptor(withTestIdentity("tenant-a"), nil, &grpc.UnaryServerInfo{FullMethod: "/test.Slow/Unary"}, sleep(40*time.Millisecond))
+		}, true},
+		{"slow stream", func(l slowLog) {
+			l.streamInterceptor(nil, ss, &grpc.StreamServerInfo{FullMethod: "/test.Slow/Stream"}, sleepStream(40*time.Millisecond))
+		}, true},
+		{"slow StreamTime", func(l slowLog) {
+			l.streamInterceptor(nil, ss, &grpc.StreamServerInfo{FullMethod: pb.TimeService_StreamTime_FullMethodName}, sleepStream(40*time.Millisecond))
+		}, false},
+		{"slow StreamTime with a stream threshold", func(l slowLog) {
+			l.streamThreshold = 30 * time.Millisecond
+			l.streamInterceptor(nil, ss, &grpc.StreamServerInfo{FullMethod: pb.TimeService_StreamTime_FullMethodName}, sleepStream(40*time.Millisecond))
+		}, true},
+	} {
+		logs := captureLog(t)
+		tc.call(l)
+		got := logs.String()
+		if logged := strings.Contains(got, "Slow RPC"); logged != tc.logged {
+			t.Errorf("%s: logged %v, want %v: %q", tc.name, logged, tc.logged, got)
+		}
+		if tc.logged && !strings.Contains(got, "from tenant-a took") {
+			t.Errorf("%s: log lacks the peer: %q", tc.name, got)
+		}
+	}
+}
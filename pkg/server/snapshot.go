@@ -0,0 +1,146 @@
+// snapshot.go
+//
+// This file saves the counters of the registry to a file on shutdown and
+// adds them back on startup, so dashboards of short-lived demo processes do
+// not drop to zero on every restart. Gauges describe the current state of
+// the process and are not carried over.
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// snapshotCounters are the counters carried across restarts, by name.
+// Each is a prometheus.Counter or a *prometheus.CounterVec.
+var snapshotCounters = map[string]prometheus.Collector{
+	"grpc_connection_stream_rejections_total":  connectionStreamRejections,
+	"grpc_server_handled_total":                rpcHandled,
+	"rpc_without_client_cert_total":            rpcWithoutClientCert,
+	"tls_handshake_timeout_total":              tlsHandshakeTimeouts,
+	"tls_reload_failures_total":                tlsReloadFailures,
+	"tls_handshakes_total":                     tlsHandshakes,
+	"tls_session_resumptions_total":            tlsResumptions,
+	"tls_revocation_rejections_total":          revocationRejections,
+	"health_transitions_total":                 healthTransitions,
+	"dropped_ticks_total":                      droppedTicks,
+	"send_buffer_dropped_total":                sendBufferDropped,
+	"overload_rejections_total":                overloadRejections,
+	"rate_limit_rejections_total":              rateLimitRejections,
+	"in_flight_limit_rejections_total":         inFlightRejections,
+	"faults_injected_total":                    faultsInjected,
+	"chaos_actions_total":                      chaosActionsRun,
+	"cluster_instance_rpcs_total":              instanceRPCs,
+	"authz_decisions_total":                    authzDecisions,
+	"proxy_protocol_connections_total":         proxyProtocolConns,
+	"tcp_connections_rejected_total":           tcpConnsRejected,
+	"grpc_server_goaways_total":                goawaysSent,
+	"grpc_server_connections_opened_total":     connectionsOpened,
+	"grpc_server_message_bytes_received_total": messageBytesReceived,
+	"grpc_server_message_bytes_sent_total":     messageBytesSent,
+}
+
+// counterSample is one counter value in a snapshot file.
+type counterSample struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  float64           `json:"value"`
+}
+
+// saveMetricsSnapshot writes the current value of every snapshot counter to
+// path, replacing the file atomically.
+func saveMetricsSnapshot(path string) error {
+	families, err := registry.Gather()
+	if err != nil {
+		return err
+	}
+	var samples []counterSample
+	for _, mf := range families {
+		if _, ok := snapshotCounters[mf.GetName()]; !ok || mf.GetType() != dto.MetricType_COUNTER {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			s := counterSample{Name: mf.GetName(), Value: m.GetCounter().GetValue()}
+			if len(m.GetLabel()) > 0 {
+				s.Labels = make(map[string]string, len(m.GetLabel()))
+				for _, l := range m.GetLabel() {
+					s.Labels[l.GetName()] = l.GetValue()
+				}
+			}
+			samples = append(samples, s)
+		}
+	}
+	data, err := json.MarshalIndent(samples, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// writeFileAtomic replaces the file at path with data, through a temporary
+// file in the same directory, so readers never see a partial file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// restoreMetricsSnapshot adds the counter values saved in path to the
+// current counters. A missing file is not an error, and samples that no
+// longer match a counter, e.g. after its labels changed, are skipped.
+func restoreMetricsSnapshot(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var samples []counterSample
+	if err := json.Unmarshal(data, &samples); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	restored := 0
+	for _, s := range samples {
+		var c prometheus.Counter
+		switch counter := snapshotCounters[s.Name].(type) {
+		case *prometheus.CounterVec:
+			if c, err = counter.GetMetricWith(s.Labels); err != nil {
+				log.Printf("Skipping snapshot sample of %s: %v", s.Name, err)
+				continue
+			}
+		case prometheus.Counter:
+			c = counter
+		default:
+			log.Printf("Skipping snapshot sample of unknown counter %s", s.Name)
+			continue
+		}
+		if s.Value < 0 {
+			log.Printf("Skipping negative snapshot sample of %s", s.Name)
+			continue
+		}
+		c.Add(s.Value)
+		restored++
+	}
+	log.Printf("Restored %d counter samples from %s", restored, path)
+	return nil
+}
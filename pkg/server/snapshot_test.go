@@ -0,0 +1,66 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// counterValue returns the current value of c.
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestMetricsSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	labels := []string{"snapshot-test", "SERVING", "test"}
+	before := counterValue(t, healthTransitions.WithLabelValues(labels...))
+	healthTransitions.WithLabelValues(labels...).Add(3)
+	if err := saveMetricsSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"service": "snapshot-test"`) {
+		t.Fatalf("the snapshot lacks the counter: %s", data)
+	}
+
+	// Restore into fresh counters, as in a restarted process.
+	restarted := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "health_transitions_total"}, []string{"service", "status", "trigger"})
+	saved := snapshotCounters["health_transitions_total"]
+	snapshotCounters["health_transitions_total"] = restarted
+	t.Cleanup(func() { snapshotCounters["health_transitions_total"] = saved })
+	restarted.WithLabelValues(labels...).Inc()
+	if err := restoreMetricsSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	// Counters are restored additively, on top of what the new process
+	// already counted.
+	if got, want := counterValue(t, restarted.WithLabelValues(labels...)), before+3+1; got != want {
+		t.Errorf("restored counter = %g, want %g", got, want)
+	}
+
+	if err := restoreMetricsSnapshot(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Errorf("restoring a missing snapshot: %v", err)
+	}
+	stale := filepath.Join(t.TempDir(), "stale.json")
+	os.WriteFile(stale, []byte(`[{"name": "removed_total", "value": 1}, {"name": "health_transitions_total", "labels": {"old": "x"}, "value": 1}]`), 0o600)
+	if err := restoreMetricsSnapshot(stale); err != nil {
+		t.Errorf("restoring samples of unknown counters: %v", err)
+	}
+	os.WriteFile(stale, []byte("not json"), 0o600)
+	if err := restoreMetricsSnapshot(stale); err == nil {
+		t.Error("restoring a corrupt snapshot succeeded")
+	}
+}
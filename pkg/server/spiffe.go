@@ -0,0 +1,96 @@
+// spiffe.go
+//
+// This file obtains the server certificate and client trust bundle from
+// the SPIFFE Workload API, as served by the SPIRE agent, instead of files.
+// The agent pushes rotated SVIDs as they are issued and each one replaces
+// the TLS material for new handshakes.
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+
+	"github.com/dethi/envoy_hck/pkg/tlsutil"
+)
+
+// spiffeSource is a tlsutil.Store fed by the Workload API.
+type spiffeSource struct {
+	tlsutil.Store
+
+	ready     chan struct{}
+	readyOnce sync.Once
+}
+
+// newSPIFFESource connects to the Workload API at addr, or
+// $SPIFFE_ENDPOINT_SOCKET when addr is empty, and waits up to timeout for
+// the first SVID. It keeps watching until ctx is done.
+func newSPIFFESource(ctx context.Context, addr string, timeout time.Duration) (_ *spiffeSource, err error) {
+	s := &spiffeSource{ready: make(chan struct{})}
+	var opts []workloadapi.ClientOption
+	if addr != "" {
+		opts = append(opts, workloadapi.WithAddr(addr))
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer func() {
+		if err != nil {
+			cancel()
+		}
+	}()
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- workloadapi.WatchX509Context(ctx, s, opts...)
+	}()
+	select {
+	case <-s.ready:
+		return s, nil
+	case err := <-watchErr:
+		return nil, err
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("no X.509 SVID received from the Workload API within %s", timeout)
+	}
+}
+
+func (s *spiffeSource) OnX509ContextUpdate(c *workloadapi.X509Context) {
+	m, err := spiffeMaterial(c)
+	if err != nil {
+		tlsReloadFailures.Inc()
+		log.Printf("Ignoring Workload API update: %v", err)
+		return
+	}
+	s.Set(m)
+	tlsutil.LogLoaded("the SPIFFE Workload API", m)
+	s.readyOnce.Do(func() { close(s.ready) })
+}
+
+func (s *spiffeSource) OnX509ContextWatchError(err error) {
+	log.Printf("SPIFFE Workload API watch failed, keeping the current SVID: %v", err)
+}
+
+// spiffeMaterial uses the default, first, SVID of c as the server
+// certificate and trusts every bundle of c, federated ones included.
+func spiffeMaterial(c *workloadapi.X509Context) (*tlsutil.Material, error) {
+	if len(c.SVIDs) == 0 {
+		return nil, errors.New("no SVID")
+	}
+	svid := c.SVIDs[0]
+	cert := tls.Certificate{PrivateKey: svid.PrivateKey, Leaf: svid.Certificates[0]}
+	for _, c := range svid.Certificates {
+		cert.Certificate = append(cert.Certificate, c.Raw)
+	}
+	var roots []*x509.Certificate
+	if c.Bundles != nil {
+		for _, b := range c.Bundles.Bundles() {
+			roots = append(roots, b.X509Authorities()...)
+		}
+	}
+	return tlsutil.NewMaterial(cert, roots)
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+// stackdump_other.go
+//
+// SIGUSR1 does not exist here, so stack dumps cannot be triggered.
+
+package server
+
+import "context"
+
+func InstallStackDumpHandler(ctx context.Context, path string) {}
@@ -0,0 +1,32 @@
+//go:build unix
+
+// stackdump_unix.go
+//
+// This file triggers goroutine stack dumps on SIGUSR1.
+
+package server
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// InstallStackDumpHandler dumps all goroutine stacks to path (or stderr)
+// every time the process receives SIGUSR1, until ctx is done.
+func InstallStackDumpHandler(ctx context.Context, path string) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGUSR1)
+	go func() {
+		defer signal.Stop(sigCh)
+		for {
+			select {
+			case <-sigCh:
+				dumpStacks(path)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
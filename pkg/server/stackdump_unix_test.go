@@ -0,0 +1,38 @@
+//go:build unix
+
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestStackDumpOnSIGUSR1(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stacks.txt")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	InstallStackDumpHandler(ctx, path)
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		dump, _ := os.ReadFile(path)
+		if strings.Contains(string(dump), "TestStackDumpOnSIGUSR1") {
+			if !strings.HasPrefix(string(dump), "=== goroutine dump at ") {
+				t.Errorf("dump lacks its header:\n%.200s", dump)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no stack dump written after SIGUSR1, file has:\n%.200s", dump)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,245 @@
+// state.go
+//
+// This file persists the runtime state set through the admin API to the
+// -state-file, so long-running soak tests keep their configured behaviors
+// when the server is restarted or redeployed: the health status and
+// per-service overrides, the instances taken out of service, the health
+// behavior, flapping schedule and probe overrides, the injected faults and
+// the ongoing chaos pauses.
+//
+// The file is rewritten after every admin API request that may change the
+// state and read back at startup. POST /state/reset returns every behavior
+// to its startup configuration and removes the file.
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/dethi/envoy_hck/pkg/faults"
+)
+
+// runtimeState is the contents of the -state-file.
+type runtimeState struct {
+	SavedAt time.Time   `json:"saved_at,omitzero"`
+	Health  healthState `json:"health"`
+	// Faults, if any are injected, and Chaos are only saved with
+	// -fault-injection and -chaos.
+	Faults *faults.Spec `json:"faults,omitempty"`
+	Chaos  *chaosState  `json:"chaos,omitempty"`
+}
+
+// healthState is the health part of a runtimeState.
+type healthState struct {
+	// Serving is the status of the whole server as toggled, before
+	// leadership and the send circuit breaker are taken into account.
+	Serving bool `json:"serving"`
+	// Overrides are the statuses forced on services, by service.
+	Overrides             map[string]string  `json:"overrides,omitempty"`
+	InstancesOutOfService []string           `json:"instances_out_of_service,omitempty"`
+	Behavior              healthBehaviorSpec `json:"behavior"`
+	Flap                  *flapSpec          `json:"flap,omitempty"`
+	Probes                probeOverrides     `json:"probes"`
+}
+
+// stateStore saves and restores the runtime state of the server.
+type stateStore struct {
+	path     string
+	hs       *health.Server
+	behavior *healthBehavior
+	flap     *flapper
+	probes   *probes
+	faults   *faults.Injector // nil without -fault-injection
+	chaos    *chaosController // nil without -chaos
+
+	// initial is the state the server started with, before any restore.
+	initial runtimeState
+	// saveMu serializes writes of the file.
+	saveMu sync.Mutex
+}
+
+// newStateStore returns a store saving to path the state of the given
+// parts of the server, as configured at startup.
+func newStateStore(path string, hs *health.Server, behavior *healthBehavior, flap *flapper, probes *probes, faults *faults.Injector, chaos *chaosController) *stateStore {
+	s := &stateStore{path: path, hs: hs, behavior: behavior, flap: flap, probes: probes, faults: faults, chaos: chaos}
+	s.initial = s.capture()
+	return s
+}
+
+// capture returns the current state.
+func (s *stateStore) capture() runtimeState {
+	st := runtimeState{Health: healthState{
+		Behavior: s.behavior.current(),
+		Flap:     s.flap.current(),
+		Probes:   s.probes.override(),
+	}}
+	mu.Lock()
+	st.Health.Serving = isHealthy.Load()
+	for svc, status := range serviceOverride {
+		if st.Health.Overrides == nil {
+			st.Health.Overrides = map[string]string{}
+		}
+		st.Health.Overrides[svc] = status.String()
+	}
+	for _, inst := range clusterInstances {
+		if !inst.healthy {
+			st.Health.InstancesOutOfService = append(st.Health.InstancesOutOfService, inst.id)
+		}
+	}
+	mu.Unlock()
+	if s.faults != nil {
+		st.Faults = s.faults.Spec()
+	}
+	if s.chaos != nil {
+		chaos := s.chaos.state()
+		st.Chaos = &chaos
+	}
+	return st
+}
+
+// apply puts the server in state st, attributing health transitions to
+// trigger. Parts of st that no longer apply, e.g. overrides of services
+// the server no longer has, are skipped.
+func (s *stateStore) apply(st runtimeState, trigger string) {
+	mu.Lock()
+	if !shuttingDown.Load() {
+		isHealthy.Store(st.Health.Serving)
+		clear(serviceOverride)
+		for svc, name := range st.Health.Overrides {
+			status, err := parseServingStatus(name, grpc_health_v1.HealthCheckResponse_NOT_SERVING, grpc_health_v1.HealthCheckResponse_UNKNOWN)
+			switch {
+			case err != nil:
+				log.Printf("Skipping the saved override of %s: %v", svc, err)
+			case !slices.Contains(healthServices, svc):
+				log.Printf("Skipping the saved override of unknown service %q", svc)
+			default:
+				serviceOverride[svc] = status
+			}
+		}
+		for _, inst := range clusterInstances {
+			inst.healthy = !slices.Contains(st.Health.InstancesOutOfService, inst.id)
+		}
+		publishHealth(s.hs, trigger)
+	}
+	mu.Unlock()
+
+	if err := st.Health.Behavior.validate(); err != nil {
+		log.Printf("Skipping the saved health behavior: %v", err)
+	} else if st.Health.Behavior != s.behavior.current() {
+		s.behavior.set(st.Health.Behavior)
+	}
+	if st.Health.Flap == nil {
+		s.flap.stop()
+	} else {
+		spec := *st.Health.Flap
+		mu.Lock()
+		err := spec.validate()
+		mu.Unlock()
+		if err != nil {
+			log.Printf("Skipping the saved flapping schedule: %v", err)
+		} else {
+			s.flap.start(spec)
+		}
+	}
+	if err := st.Health.Probes.validate(); err != nil {
+		log.Printf("Skipping the saved probe overrides: %v", err)
+	} else if st.Health.Probes != s.probes.override() {
+		s.probes.set(st.Health.Probes)
+	}
+
+	switch {
+	case s.faults == nil:
+	case st.Faults == nil:
+		if s.faults.Spec() != nil {
+			s.faults.Clear()
+		}
+	default:
+		if err := s.faults.Set(*st.Faults); err != nil {
+			log.Printf("Skipping the saved faults: %v", err)
+		}
+	}
+	if s.chaos != nil {
+		var chaos chaosState
+		if st.Chaos != nil {
+			chaos = *st.Chaos
+		}
+		s.chaos.restore(chaos)
+	}
+}
+
+// save writes the current state to the file.
+func (s *stateStore) save() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	st := s.capture()
+	st.SavedAt = time.Now()
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, append(data, '\n'))
+}
+
+// restore applies the state saved in the file. A missing file is not an
+// error: the server keeps its startup configuration.
+func (s *stateStore) restore() error {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var st runtimeState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return fmt.Errorf("%s: %w", s.path, err)
+	}
+	s.apply(st, "state-restore")
+	log.Printf("Restored the runtime state saved at %s from %s", st.SavedAt.Format(time.RFC3339), s.path)
+	return nil
+}
+
+// wrap saves the state after h handles each request that may change it,
+// that is every request but GET and HEAD. A nil store saves nothing.
+func (s *stateStore) wrap(h http.HandlerFunc) http.HandlerFunc {
+	if s == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		h(w, r)
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			return
+		}
+		if err := s.save(); err != nil {
+			log.Printf("Failed to save the runtime state to %s: %v", s.path, err)
+		}
+	}
+}
+
+// serveReset handles POST /state/reset by returning the server to its
+// startup configuration and removing the file.
+func (s *stateStore) serveReset(w http.ResponseWriter, r *http.Request) {
+	s.apply(s.initial, "state-reset")
+	s.saveMu.Lock()
+	err := os.Remove(s.path)
+	s.saveMu.Unlock()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		writeJSONError(w, "cannot remove the state file: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Runtime state reset to the startup configuration, removed %s", s.path)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.capture())
+}
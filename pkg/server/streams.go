@@ -0,0 +1,122 @@
+// streams.go
+//
+// This file keeps a registry of the streams currently open on the server,
+// served as JSON on the HTTP server's /streams endpoint so operators can
+// see who is subscribed at a glance.
+
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// activeStream is a registry entry for one open stream.
+type activeStream struct {
+	id       uint64
+	method   string
+	identity string
+	start    time.Time
+	sent     atomic.Int64
+}
+
+// streamRegistry tracks open streams. The zero value is ready to use.
+type streamRegistry struct {
+	mu      sync.Mutex
+	nextID  uint64
+	streams map[uint64]*activeStream
+}
+
+func (r *streamRegistry) add(method, identity string) *activeStream {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.streams == nil {
+		r.streams = make(map[uint64]*activeStream)
+	}
+	r.nextID++
+	st := &activeStream{id: r.nextID, method: method, identity: identity, start: time.Now()}
+	r.streams[st.id] = st
+	return st
+}
+
+func (r *streamRegistry) remove(st *activeStream) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.streams, st.id)
+}
+
+// count returns the number of open streams.
+func (r *streamRegistry) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.streams)
+}
+
+// streamInterceptor registers every stream for the duration of its handler
+// and counts the messages sent on it.
+func (r *streamRegistry) streamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	st := r.add(info.FullMethod, IdentityFromContext(ss.Context()).Name())
+	defer r.remove(st)
+	defer func() { streamMessagesSent.WithLabelValues(info.FullMethod).Observe(float64(st.sent.Load())) }()
+	return handler(srv, &countingStream{ServerStream: ss, sent: &st.sent})
+}
+
+// streamEntry is the JSON form of an activeStream.
+type streamEntry struct {
+	ID       uint64    `json:"id"`
+	Method   string    `json:"method"`
+	Identity string    `json:"identity"`
+	Start    time.Time `json:"start"`
+	Sent     int64     `json:"sent"`
+}
+
+func (r *streamRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	entries := make([]streamEntry, 0, len(r.streams))
+	for _, st := range r.streams {
+		entries = append(entries, streamEntry{
+			ID:       st.id,
+			Method:   st.method,
+			Identity: st.identity,
+			Start:    st.start,
+			Sent:     st.sent.Load(),
+		})
+	}
+	r.mu.Unlock()
+	slices.SortFunc(entries, func(a, b streamEntry) int { return cmp.Compare(a.ID, b.ID) })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// countingStream counts the messages successfully sent on a stream and,
+// if received is set, those received.
+type countingStream struct {
+	grpc.ServerStream
+	sent, received *atomic.Int64
+}
+
+func (s *countingStream) SendMsg(m any) error {
+	if err := s.ServerStream.SendMsg(m); err != nil {
+		return err
+	}
+	s.sent.Add(1)
+	return nil
+}
+
+func (s *countingStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if s.received != nil {
+		s.received.Add(1)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+
+	pb "github.com/dethi/envoy_hck/protos"
+)
+
+// listStreams returns the streams r serves on /streams.
+func listStreams(t *testing.T, r *streamRegistry) []streamEntry {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/streams", nil))
+	var entries []streamEntry
+	if err := json.NewDecoder(rec.Body).Decode(&entries); err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestStreamsEndpoint(t *testing.T) {
+	var streams streamRegistry
+	client := timeClient(t, newTestServer(t, serviceDefaults{}), grpc.ChainStreamInterceptor(streams.streamInterceptor))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.StreamTime(ctx, &pb.TimeRequest{IntervalMs: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		if _, err := stream.Recv(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries := listStreams(t, &streams)
+	if len(entries) != 1 {
+		t.Fatalf("/streams lists %d streams, want 1", len(entries))
+	}
+	e := entries[0]
+	if e.Method != pb.TimeService_StreamTime_FullMethodName || e.Identity != "unknown" || e.Sent < 3 || time.Since(e.Start) > time.Minute {
+		t.Errorf("stream entry %+v, want StreamTime from unknown with at least 3 sent", e)
+	}
+
+	cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(listStreams(t, &streams)) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("stream still listed after it ended")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,136 @@
+// tcpbehavior.go
+//
+// This file implements TCP-level behaviors of the gRPC listeners, below
+// TLS and HTTP/2, for testing Envoy's connect timeouts, tcp_proxy and
+// upstream connection pool error handling:
+//
+//   - -accept-delay holds every accepted connection back before the
+//     handshake, like a server whose accept loop is slow; connections
+//     behind it wait in the kernel's queue meanwhile.
+//   - -max-conns caps the connections open across all gRPC listeners.
+//     Beyond it, -max-conns-action closes new connections at once (close),
+//     resets them (reset), or leaves them in the kernel's accept queue
+//     until a connection closes (queue).
+//   - -tcp-reset-on-close makes the server close connections with RST
+//     instead of FIN.
+
+package server
+
+import (
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// maxConnsActions are the values of -max-conns-action.
+var maxConnsActions = []string{"close", "reset", "queue"}
+
+// tcpBehavior applies the TCP behaviors to listeners. A tcpBehavior is
+// shared by all the listeners whose connections -max-conns caps.
+type tcpBehavior struct {
+	acceptDelay  time.Duration
+	resetOnClose bool
+	// slots holds a token per open connection when connections are
+	// capped, and is nil otherwise.
+	slots     chan struct{}
+	capAction string
+}
+
+func newTCPBehavior(acceptDelay time.Duration, maxConns int, capAction string, resetOnClose bool) *tcpBehavior {
+	b := &tcpBehavior{acceptDelay: acceptDelay, resetOnClose: resetOnClose, capAction: capAction}
+	if maxConns > 0 {
+		b.slots = make(chan struct{}, maxConns)
+	}
+	return b
+}
+
+// listener wraps lis with the behaviors.
+func (b *tcpBehavior) listener(lis net.Listener) net.Listener {
+	return &tcpBehaviorListener{Listener: lis, behavior: b, done: make(chan struct{})}
+}
+
+type tcpBehaviorListener struct {
+	net.Listener
+	behavior *tcpBehavior
+	once     sync.Once
+	done     chan struct{}
+}
+
+func (l *tcpBehaviorListener) Accept() (net.Conn, error) {
+	b := l.behavior
+	for {
+		if b.slots != nil && b.capAction == "queue" {
+			select {
+			case b.slots <- struct{}{}:
+			case <-l.done:
+				return nil, net.ErrClosed
+			}
+		}
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			if b.slots != nil && b.capAction == "queue" {
+				<-b.slots
+			}
+			return nil, err
+		}
+		if b.slots != nil && b.capAction != "queue" {
+			select {
+			case b.slots <- struct{}{}:
+			default:
+				b.reject(conn)
+				continue
+			}
+		}
+		if b.resetOnClose {
+			setLingerZero(conn)
+		}
+		if b.acceptDelay > 0 {
+			select {
+			case <-time.After(b.acceptDelay):
+			case <-l.done:
+				conn.Close()
+				return nil, net.ErrClosed
+			}
+		}
+		if b.slots == nil {
+			return conn, nil
+		}
+		return &tcpBehaviorConn{Conn: conn, slots: b.slots}, nil
+	}
+}
+
+func (l *tcpBehaviorListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// reject closes conn, over the cap, the way -max-conns-action says.
+func (b *tcpBehavior) reject(conn net.Conn) {
+	tcpConnsRejected.WithLabelValues(b.capAction).Inc()
+	log.Printf("Rejecting connection from %s with %s: %d connections open (-max-conns)", conn.RemoteAddr(), b.capAction, cap(b.slots))
+	if b.capAction == "reset" {
+		setLingerZero(conn)
+	}
+	conn.Close()
+}
+
+// setLingerZero makes closing conn send RST instead of FIN, if it is a TCP
+// connection.
+func setLingerZero(conn net.Conn) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+}
+
+// tcpBehaviorConn frees its slot under -max-conns when closed.
+type tcpBehaviorConn struct {
+	net.Conn
+	slots chan struct{}
+	once  sync.Once
+}
+
+func (c *tcpBehaviorConn) Close() error {
+	c.once.Do(func() { <-c.slots })
+	return c.Conn.Close()
+}
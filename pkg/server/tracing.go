@@ -0,0 +1,166 @@
+// tracing.go
+//
+// This file sets up OpenTelemetry tracing. A stats handler continues the
+// trace Envoy propagates in W3C traceparent or B3 (multi or single header)
+// metadata, records a server span per RPC with an event per stream
+// message, and the spans are exported over OTLP/gRPC. The exporter and
+// sampler are configured by the standard OTEL_EXPORTER_OTLP_* and
+// OTEL_TRACES_SAMPLER environment variables; with -tracing-force-sample,
+// RPCs with an x-force-sample: true metadata are sampled regardless.
+
+package server
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
+)
+
+// tracing exports the spans of the RPCs handled by the server.
+type tracing struct {
+	provider *sdktrace.TracerProvider
+}
+
+// forceSampleKey is the metadata key forcing the sampling of an RPC with
+// -tracing-force-sample.
+const forceSampleKey = "x-force-sample"
+
+// newTracing starts the OTLP span exporter.
+func newTracing(forceSample bool) (*tracing, error) {
+	exporter, err := otlptracegrpc.New(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return newTracingWith(sdktrace.WithBatcher(exporter), forceSample), nil
+}
+
+// newTracingWith returns the tracing of spans sent to processor, such as
+// sdktrace.WithBatcher.
+func newTracingWith(processor sdktrace.TracerProviderOption, forceSample bool) *tracing {
+	res := resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName("envoy-hck"),
+		semconv.ServiceVersion(version),
+	)
+	opts := []sdktrace.TracerProviderOption{processor, sdktrace.WithResource(res)}
+	if forceSample {
+		opts = append(opts, sdktrace.WithSampler(forceSampler{base: samplerFromEnv()}))
+	}
+	return &tracing{provider: sdktrace.NewTracerProvider(opts...)}
+}
+
+// forceSampler samples the RPCs with an x-force-sample: true metadata and
+// leaves the others to base.
+type forceSampler struct {
+	base sdktrace.Sampler
+}
+
+func (s forceSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	md, _ := metadata.FromIncomingContext(p.ParentContext)
+	for _, v := range md.Get(forceSampleKey) {
+		if forced, _ := strconv.ParseBool(v); forced {
+			return sdktrace.SamplingResult{
+				Decision:   sdktrace.RecordAndSample,
+				Attributes: []attribute.KeyValue{attribute.Bool("sampling.forced", true)},
+				Tracestate: trace.SpanContextFromContext(p.ParentContext).TraceState(),
+			}
+		}
+	}
+	return s.base.ShouldSample(p)
+}
+
+func (s forceSampler) Description() string {
+	return "ForceSample{" + s.base.Description() + "}"
+}
+
+// samplerFromEnv returns the sampler OTEL_TRACES_SAMPLER and
+// OTEL_TRACES_SAMPLER_ARG select, as the SDK does when no sampler is set,
+// falling back to its default on invalid values.
+func samplerFromEnv() sdktrace.Sampler {
+	ratio := func() sdktrace.Sampler {
+		r, err := strconv.ParseFloat(strings.TrimSpace(os.Getenv("OTEL_TRACES_SAMPLER_ARG")), 64)
+		if err != nil || r < 0 || r > 1 {
+			r = 1
+		}
+		return sdktrace.TraceIDRatioBased(r)
+	}
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("OTEL_TRACES_SAMPLER"))) {
+	case "always_on":
+		return sdktrace.AlwaysSample()
+	case "always_off":
+		return sdktrace.NeverSample()
+	case "traceidratio":
+		return ratio()
+	case "parentbased_always_off":
+		return sdktrace.ParentBased(sdktrace.NeverSample())
+	case "parentbased_traceidratio":
+		return sdktrace.ParentBased(ratio())
+	default:
+		return sdktrace.ParentBased(sdktrace.AlwaysSample())
+	}
+}
+
+// statsHandler returns the gRPC stats handler creating the spans. Health
+// checks and reflection, which Envoy and tooling call constantly, are not
+// traced.
+func (t *tracing) statsHandler() stats.Handler {
+	return otelgrpc.NewServerHandler(
+		otelgrpc.WithTracerProvider(t.provider),
+		otelgrpc.WithPropagators(propagation.NewCompositeTextMapPropagator(
+			propagation.TraceContext{},
+			propagation.Baggage{},
+			b3.New(),
+		)),
+		otelgrpc.WithMessageEvents(otelgrpc.ReceivedEvents, otelgrpc.SentEvents),
+		otelgrpc.WithFilter(func(info *stats.RPCTagInfo) bool {
+			return exemptInfrastructure(info.FullMethodName)
+		}),
+	)
+}
+
+// Shutdown exports the spans not exported yet.
+func (t *tracing) Shutdown(ctx context.Context) error {
+	return t.provider.Shutdown(ctx)
+}
+
+// identityAttributes returns the span attributes of the client identity.
+func identityAttributes(id Identity) []attribute.KeyValue {
+	if !id.HasCert {
+		return []attribute.KeyValue{attribute.Bool("tls.client.has_certificate", false)}
+	}
+	attrs := []attribute.KeyValue{
+		attribute.Bool("tls.client.has_certificate", true),
+		attribute.String("tls.client.subject", id.CommonName),
+	}
+	if id.SPIFFEID != "" {
+		attrs = append(attrs, attribute.String("tls.client.spiffe_id", id.SPIFFEID))
+	}
+	return attrs
+}
+
+// traceIdentityUnaryInterceptor adds the client identity to the span of
+// the RPC.
+func traceIdentityUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	trace.SpanFromContext(ctx).SetAttributes(identityAttributes(IdentityFromContext(ctx))...)
+	return handler(ctx, req)
+}
+
+// traceIdentityStreamInterceptor is the streaming counterpart of
+// traceIdentityUnaryInterceptor.
+func traceIdentityStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	trace.SpanFromContext(ss.Context()).SetAttributes(identityAttributes(IdentityFromContext(ss.Context()))...)
+	return handler(srv, ss)
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	pb "github.com/dethi/envoy_hck/protos"
+)
+
+func TestForceSample(t *testing.T) {
+	t.Setenv("OTEL_TRACES_SAMPLER", "always_off")
+	for _, tc := range []struct {
+		name        string
+		forceSample bool
+		md          metadata.MD
+		wantSpans   int
+	}{
+		{"not forced", true, nil, 0},
+		{"forced", true, metadata.Pairs(forceSampleKey, "true"), 1},
+		{"forced but disabled", false, metadata.Pairs(forceSampleKey, "true"), 0},
+		{"not true", true, metadata.Pairs(forceSampleKey, "nope"), 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			exporter := tracetest.NewInMemoryExporter()
+			tr := newTracingWith(sdktrace.WithSyncer(exporter), tc.forceSample)
+			srv := grpc.NewServer(grpc.StatsHandler(tr.statsHandler()))
+			pb.RegisterServerInfoServer(srv, &infoServer{})
+			client := pb.NewServerInfoClient(bufconnClient(t, srv))
+
+			ctx := metadata.NewOutgoingContext(context.Background(), tc.md)
+			if _, err := client.GetServerInfo(ctx, &pb.ServerInfoRequest{}); err != nil {
+				t.Fatal(err)
+			}
+			srv.Stop()
+			spans := exporter.GetSpans()
+			if len(spans) != tc.wantSpans {
+				t.Fatalf("%d spans exported, want %d", len(spans), tc.wantSpans)
+			}
+			for _, s := range spans {
+				if !s.SpanContext.IsSampled() {
+					t.Errorf("span %s is not sampled", s.Name)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+// unknown.go
+//
+// This file handles RPCs for services or methods this server does not
+// implement, logging them so that misrouted traffic from Envoy is visible.
+
+package server
+
+import (
+	"log"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// unknownServiceHandler logs the attempted method and its caller, then
+// fails the RPC with Unimplemented like the default handler would.
+func unknownServiceHandler(_ any, stream grpc.ServerStream) error {
+	method, _ := grpc.MethodFromServerStream(stream)
+	addr := "unknown"
+	if p, ok := peer.FromContext(stream.Context()); ok {
+		addr = p.Addr.String()
+	}
+	log.Printf("Unknown method %s called by %s (%s)", method, IdentityFromContext(stream.Context()).Name(), addr)
+	return status.Errorf(codes.Unimplemented, "method %s is not implemented by this server; the request may have been misrouted", method)
+}
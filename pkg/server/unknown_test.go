@@ -0,0 +1,31 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/dethi/envoy_hck/protos"
+)
+
+func TestUnknownServiceHandler(t *testing.T) {
+	logs := captureLog(t)
+	srv := grpc.NewServer(grpc.UnknownServiceHandler(unknownServiceHandler))
+	pb.RegisterServerInfoServer(srv, &infoServer{})
+	conn := bufconnClient(t, srv)
+
+	for _, method := range []string{"/bogus.Service/Method", "/timeservice.ServerInfo/Bogus"} {
+		err := conn.Invoke(context.Background(), method, &pb.ServerInfoRequest{}, &pb.ServerInfoResponse{})
+		st := status.Convert(err)
+		if st.Code() != codes.Unimplemented || !strings.Contains(st.Message(), method) || !strings.Contains(st.Message(), "misrouted") {
+			t.Errorf("%s: %v, want UNIMPLEMENTED naming the method", method, err)
+		}
+		if want := "Unknown method " + method + " called by unknown ("; !strings.Contains(logs.String(), want) {
+			t.Errorf("%s: log lacks %q:\n%s", method, want, logs)
+		}
+	}
+}
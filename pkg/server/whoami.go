@@ -0,0 +1,112 @@
+// whoami.go
+//
+// This file implements the WhoAmI RPC, which reports the client certificate
+// and TLS parameters as the server sees them, and the optional per-RPC log
+// line naming the peer. Both answer the usual question when debugging mTLS
+// origination: is Envoy forwarding the downstream certificate or presenting
+// its own?
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+
+	pb "github.com/dethi/envoy_hck/protos"
+)
+
+func (s *diagnosticsServer) WhoAmI(ctx context.Context, _ *pb.WhoAmIRequest) (*pb.WhoAmIResponse, error) {
+	resp := &pb.WhoAmIResponse{}
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return resp, nil
+	}
+	peerAddr, source := splitProxied(p.Addr)
+	resp.PeerAddress = peerAddr.String()
+	if source != nil {
+		resp.SourceAddress = source.String()
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		log.Printf("WhoAmI from %s: no TLS", resp.PeerAddress)
+		return resp, nil
+	}
+	st := info.State
+	resp.TlsVersion = tls.VersionName(st.Version)
+	resp.CipherSuite = tls.CipherSuiteName(st.CipherSuite)
+	resp.ServerName = st.ServerName
+	if len(st.PeerCertificates) == 0 {
+		log.Printf("WhoAmI from %s: no client certificate", resp.PeerAddress)
+		return resp, nil
+	}
+	leaf := st.PeerCertificates[0]
+	resp.HasCertificate = true
+	resp.Subject = leaf.Subject.String()
+	resp.Issuer = leaf.Issuer.String()
+	resp.DnsNames = leaf.DNSNames
+	resp.EmailAddresses = leaf.EmailAddresses
+	for _, ip := range leaf.IPAddresses {
+		resp.IpAddresses = append(resp.IpAddresses, ip.String())
+	}
+	for _, uri := range leaf.URIs {
+		resp.Uris = append(resp.Uris, uri.String())
+		if uri.Scheme == "spiffe" && resp.SpiffeId == "" {
+			resp.SpiffeId = uri.String()
+		}
+	}
+	resp.SerialNumber = formatSerial(leaf.SerialNumber.Bytes())
+	resp.NotBefore = leaf.NotBefore.Format(time.RFC3339)
+	resp.NotAfter = leaf.NotAfter.Format(time.RFC3339)
+	if len(st.VerifiedChains) > 0 {
+		for _, cert := range st.VerifiedChains[0] {
+			resp.Chain = append(resp.Chain, cert.Subject.String())
+		}
+	}
+	log.Printf("WhoAmI from %s: %s (serial %s, issued by %s)", resp.PeerAddress, resp.Subject, resp.SerialNumber, resp.Issuer)
+	return resp, nil
+}
+
+// formatSerial formats a certificate serial number as colon-separated hex,
+// as openssl prints it.
+func formatSerial(serial []byte) string {
+	if len(serial) == 0 {
+		return "00"
+	}
+	hex := make([]string, len(serial))
+	for i, b := range serial {
+		hex[i] = fmt.Sprintf("%02x", b)
+	}
+	return strings.Join(hex, ":")
+}
+
+// logPeer logs the peer and client certificate of an RPC.
+func logPeer(ctx context.Context, method string) {
+	var addr string
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+	}
+	id := IdentityFromContext(ctx)
+	slog.Info("RPC peer", "method", method, "peer", addr, "has_cert", id.HasCert, "identity", id.Name(), "dns_names", id.DNSNames, "uris", id.URIs)
+}
+
+// peerLogUnaryInterceptor logs the peer of every RPC.
+func peerLogUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	logPeer(ctx, info.FullMethod)
+	return handler(ctx, req)
+}
+
+// peerLogStreamInterceptor is the streaming counterpart of
+// peerLogUnaryInterceptor.
+func peerLogStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	logPeer(ss.Context(), info.FullMethod)
+	return handler(srv, ss)
+}
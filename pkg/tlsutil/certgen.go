@@ -0,0 +1,188 @@
+// certgen.go
+//
+// This file generates X.509 certificates in memory: a CA and the leaf
+// certificates it signs. It backs the -self-signed mode, which runs the
+// server without any certificate files, and the certs subcommand, which
+// writes them out.
+
+package tlsutil
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// IssuedCert is a generated certificate with its key, in both parsed and
+// PEM forms.
+type IssuedCert struct {
+	Cert    *x509.Certificate
+	Key     crypto.Signer
+	CertPEM []byte
+	KeyPEM  []byte
+}
+
+// TLSCertificate returns c in the form used by tls.Config.
+func (c *IssuedCert) TLSCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.Cert.Raw}, PrivateKey: c.Key, Leaf: c.Cert}
+}
+
+// KeyTypes are the key types GenerateKey accepts.
+var KeyTypes = []string{"ecdsa-p256", "ecdsa-p384", "rsa-2048", "rsa-3072", "rsa-4096"}
+
+// GenerateKey creates a private key of keyType, one of KeyTypes.
+func GenerateKey(keyType string) (crypto.Signer, error) {
+	switch keyType {
+	case "ecdsa-p256":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ecdsa-p384":
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "rsa-2048":
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case "rsa-3072":
+		return rsa.GenerateKey(rand.Reader, 3072)
+	case "rsa-4096":
+		return rsa.GenerateKey(rand.Reader, 4096)
+	default:
+		return nil, fmt.Errorf("unknown key type %q, want one of %s", keyType, strings.Join(KeyTypes, ", "))
+	}
+}
+
+// GenerateCert creates a certificate from template with a fresh ECDSA P-256
+// key. It is signed by parent, or self-signed if parent is nil.
+func GenerateCert(template *x509.Certificate, parent *IssuedCert) (*IssuedCert, error) {
+	key, err := GenerateKey("ecdsa-p256")
+	if err != nil {
+		return nil, err
+	}
+	return IssueCert(template, parent, key)
+}
+
+// IssueCert creates a certificate from template for key, signed by parent
+// or self-signed if parent is nil.
+func IssueCert(template *x509.Certificate, parent *IssuedCert, key crypto.Signer) (*IssuedCert, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template.SerialNumber = serial
+
+	signerCert, signerKey := template, key
+	if parent != nil {
+		signerCert, signerKey = parent.Cert, parent.Key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCert, key.Public(), signerKey)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &IssuedCert{
+		Cert:    cert,
+		Key:     key,
+		CertPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		KeyPEM:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+	}, nil
+}
+
+// CATemplate returns the template of a CA certificate valid for validity.
+func CATemplate(cn string, validity time.Duration) *x509.Certificate {
+	now := time.Now()
+	return &x509.Certificate{
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+}
+
+// LeafTemplate returns the template of a leaf certificate for usage, with
+// hosts split into DNS, IP and URI (e.g. spiffe://) SANs.
+func LeafTemplate(cn string, hosts []string, usage x509.ExtKeyUsage, validity time.Duration) *x509.Certificate {
+	now := time.Now()
+	t := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: cn},
+		NotBefore:   now.Add(-time.Minute),
+		NotAfter:    now.Add(validity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{usage},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			t.IPAddresses = append(t.IPAddresses, ip)
+		} else if u, err := url.Parse(h); err == nil && strings.Contains(h, "://") {
+			t.URIs = append(t.URIs, u)
+		} else {
+			t.DNSNames = append(t.DNSNames, h)
+		}
+	}
+	return t
+}
+
+// SelfSignedBundle is the TLS material of -self-signed mode.
+type SelfSignedBundle struct {
+	CA     *IssuedCert
+	Server *IssuedCert
+	Client *IssuedCert
+}
+
+// GenerateSelfSigned creates an ephemeral CA, a server certificate for the
+// local host names, and a client certificate, all valid for a day.
+func GenerateSelfSigned() (*SelfSignedBundle, error) {
+	const validity = 24 * time.Hour
+	ca, err := GenerateCert(CATemplate("envoy-hck self-signed CA", validity), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate CA: %w", err)
+	}
+	b := &SelfSignedBundle{CA: ca}
+	if b.Server, err = b.IssueServerCert(validity); err != nil {
+		return nil, err
+	}
+	b.Client, err = GenerateCert(LeafTemplate("envoy", nil, x509.ExtKeyUsageClientAuth, validity), ca)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate client certificate: %w", err)
+	}
+	return b, nil
+}
+
+// IssueServerCert signs a new server certificate for the local host names,
+// valid for validity.
+func (b *SelfSignedBundle) IssueServerCert(validity time.Duration) (*IssuedCert, error) {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if hostname, err := os.Hostname(); err == nil {
+		hosts = append(hosts, hostname)
+	}
+	server, err := GenerateCert(LeafTemplate("localhost", hosts, x509.ExtKeyUsageServerAuth, validity), b.CA)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate server certificate: %w", err)
+	}
+	return server, nil
+}
+
+// Pool returns a pool trusting only the bundle's CA.
+func (b *SelfSignedBundle) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(b.CA.Cert)
+	return pool
+}
@@ -0,0 +1,326 @@
+// certreload.go
+//
+// This file keeps the server certificate and client CA pool swappable at
+// runtime. Handshakes use whatever material was loaded last; a reload that
+// fails validation keeps the previous material instead of locking clients
+// out.
+//
+// Every reload trigger (file changes, SIGHUP, the HTTP endpoint) goes
+// through a single goroutine. Triggers arriving within
+// reloadCoalesceWindow of each other, as when a cert swap fires several at
+// once, are answered by one reload.
+
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Material is one consistent set of TLS files, loaded and validated.
+type Material struct {
+	Cert      tls.Certificate
+	Leaf      *x509.Certificate
+	ClientCAs *x509.CertPool
+	Roots     []*x509.Certificate // the certificates in ClientCAs
+}
+
+// NewMaterial checks that cert has a leaf and trusts roots for clients.
+func NewMaterial(cert tls.Certificate, roots []*x509.Certificate) (*Material, error) {
+	if len(cert.Certificate) == 0 {
+		return nil, errors.New("no server certificate")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(roots) == 0 {
+		return nil, errors.New("no client CA certificates")
+	}
+	pool := x509.NewCertPool()
+	for _, root := range roots {
+		pool.AddCert(root)
+	}
+	return &Material{Cert: cert, Leaf: leaf, ClientCAs: pool, Roots: roots}, nil
+}
+
+// Store holds the material TLS handshakes use. Sources of material
+// swap it atomically as they obtain new certificates.
+type Store struct {
+	current atomic.Pointer[Material]
+}
+
+// Current returns the material handshakes use now.
+func (s *Store) Current() *Material { return s.current.Load() }
+
+// Set makes handshakes use m from now on.
+func (s *Store) Set(m *Material) { s.current.Store(m) }
+
+// ConfigForClient returns a tls.Config.GetConfigForClient callback that
+// uses base with the current certificate and client CA pool.
+func (s *Store) ConfigForClient(base *tls.Config) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		m := s.current.Load()
+		cfg := base.Clone()
+		cfg.GetConfigForClient = nil
+		cfg.Certificates = []tls.Certificate{m.Cert}
+		cfg.ClientCAs = m.ClientCAs
+		return cfg, nil
+	}
+}
+
+// LogLoaded logs the certificate of m after it was loaded from source.
+func LogLoaded(source string, m *Material) {
+	log.Printf("Loaded TLS material from %s: subject %q, expires %s", source, m.Leaf.Subject.String(), m.Leaf.NotAfter.Format(time.RFC3339))
+}
+
+// reloadCoalesceWindow is how long a reload waits for further triggers to
+// fold into it.
+const reloadCoalesceWindow = 100 * time.Millisecond
+
+// reloadRequest is a reload trigger waiting for the outcome.
+type reloadRequest struct {
+	source string // what triggered the reload, for logging
+	done   chan reloadResult
+}
+
+type reloadResult struct {
+	material *Material
+	err      error
+}
+
+// Reloader loads the server key pair and CA bundle from disk and serves
+// them to TLS handshakes.
+type Reloader struct {
+	certFile, keyFile, caFile string
+
+	Store
+	requests chan reloadRequest
+	ctx      context.Context // ends run and fails further reloads
+
+	// OnFailure, if set, is called with the error of every failed reload.
+	// Set it before triggering any reload.
+	OnFailure func(error)
+}
+
+// NewReloader loads the initial material, failing if it is invalid, and
+// starts the goroutine performing reloads until ctx is done.
+func NewReloader(ctx context.Context, certFile, keyFile, caFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile, caFile: caFile, requests: make(chan reloadRequest), ctx: ctx}
+	m, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	r.current.Store(m)
+	go r.run()
+	return r, nil
+}
+
+// run performs the reloads, one at a time, each on behalf of every trigger
+// received until reloadCoalesceWindow passes without a new one.
+func (r *Reloader) run() {
+	for {
+		var batch []reloadRequest
+		select {
+		case req := <-r.requests:
+			batch = append(batch, req)
+		case <-r.ctx.Done():
+			return
+		}
+		timer := time.NewTimer(reloadCoalesceWindow)
+	collect:
+		for {
+			select {
+			case req := <-r.requests:
+				batch = append(batch, req)
+				timer.Reset(reloadCoalesceWindow)
+			case <-timer.C:
+				break collect
+			}
+		}
+		if len(batch) > 1 {
+			sources := make([]string, len(batch))
+			for i, req := range batch {
+				sources[i] = req.source
+			}
+			log.Printf("Coalescing %d TLS reload triggers into one reload: %s", len(batch), strings.Join(sources, ", "))
+		}
+		m, err := r.reload()
+		for _, req := range batch {
+			req.done <- reloadResult{m, err}
+		}
+	}
+}
+
+// Reload triggers a reload on behalf of source and waits for it,
+// returning the material loaded or the error that kept the previous one.
+func (r *Reloader) Reload(source string) (*Material, error) {
+	req := reloadRequest{source: source, done: make(chan reloadResult, 1)}
+	select {
+	case r.requests <- req:
+	case <-r.ctx.Done():
+		return nil, r.ctx.Err()
+	}
+	res := <-req.done
+	return res.material, res.err
+}
+
+func (r *Reloader) load() (*Material, error) {
+	cert, err := LoadServerKeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	caPEM, err := os.ReadFile(r.caFile)
+	if err != nil {
+		return nil, err
+	}
+	roots := ParseCertsPEM(caPEM)
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("%s contains no valid PEM certificates", r.caFile)
+	}
+	return NewMaterial(cert, roots)
+}
+
+// ReloadOnSIGHUP reloads the material whenever the process receives
+// SIGHUP, until ctx is done.
+func (r *Reloader) ReloadOnSIGHUP(ctx context.Context) {
+	onSignal(ctx, syscall.SIGHUP, func() { r.Reload("SIGHUP") })
+}
+
+// onSignal calls f every time the process receives sig, until ctx is done.
+func onSignal(ctx context.Context, sig os.Signal, f func()) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, sig)
+	go func() {
+		defer signal.Stop(sigCh)
+		for {
+			select {
+			case <-sigCh:
+				f()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// every calls f every interval until ctx is done.
+func every(ctx context.Context, interval time.Duration, f func()) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				f()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// fileStamp identifies a version of a file by its size and modification
+// time.
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// stamps returns the current stamps of the certificate, key and CA files.
+// A file that cannot be stat'ed, e.g. in the middle of a rename, gets the
+// zero stamp.
+func (r *Reloader) stamps() [3]fileStamp {
+	var stamps [3]fileStamp
+	for i, path := range []string{r.certFile, r.keyFile, r.caFile} {
+		if fi, err := os.Stat(path); err == nil {
+			stamps[i] = fileStamp{fi.Size(), fi.ModTime()}
+		}
+	}
+	return stamps
+}
+
+// Watch checks the files every interval and reloads them when any of them
+// changed, as when Envoy's control plane or cert-manager rotates them,
+// until ctx is done.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	last := r.stamps()
+	every(ctx, interval, func() {
+		if current := r.stamps(); current != last {
+			last = current
+			r.Reload("file-watch")
+		}
+	})
+}
+
+// WatchFile calls reload, with the trigger as source, on SIGHUP and, if
+// interval is positive, whenever path changed since the last check, until
+// ctx is done. It serves the files reloaded outside the TLS material, such
+// as policies and revocation lists.
+func WatchFile(ctx context.Context, path string, interval time.Duration, reload func(source string)) {
+	onSignal(ctx, syscall.SIGHUP, func() { reload("SIGHUP") })
+	if interval <= 0 {
+		return
+	}
+	stamp := func() fileStamp {
+		if fi, err := os.Stat(path); err == nil {
+			return fileStamp{fi.Size(), fi.ModTime()}
+		}
+		return fileStamp{}
+	}
+	last := stamp()
+	every(ctx, interval, func() {
+		if current := stamp(); current != last {
+			last = current
+			reload("file-watch")
+		}
+	})
+}
+
+// reload replaces the material with the files' current contents. On
+// failure the previous material stays in use. Only run calls it.
+func (r *Reloader) reload() (*Material, error) {
+	m, err := r.load()
+	if err != nil {
+		if r.OnFailure != nil {
+			r.OnFailure(err)
+		}
+		log.Printf("TLS reload failed, keeping the previous certificate and CA pool: %v", err)
+		return nil, err
+	}
+	r.current.Store(m)
+	log.Printf("Reloaded TLS material: subject %q, expires %s", m.Leaf.Subject.String(), m.Leaf.NotAfter.Format(time.RFC3339))
+	return m, nil
+}
+
+// ServeHTTP reloads the material on POST and reports the new certificate,
+// or the error that kept the previous one in place.
+func (r *Reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	m, err := r.Reload("http")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{
+		"subject":   m.Leaf.Subject.String(),
+		"not_after": m.Leaf.NotAfter.Format(time.RFC3339),
+	})
+}
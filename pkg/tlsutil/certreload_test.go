@@ -0,0 +1,126 @@
+package tlsutil
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// tlsFiles are the paths of a server key pair and CA bundle.
+type tlsFiles struct {
+	cert, key, ca string
+}
+
+// writeBundle writes the server key pair and CA of a new self-signed
+// bundle to dir and returns the bundle with their paths.
+func writeBundle(t *testing.T, dir string) (*SelfSignedBundle, tlsFiles) {
+	t.Helper()
+	b, err := GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := tlsFiles{cert: filepath.Join(dir, "server.crt"), key: filepath.Join(dir, "server.key"), ca: filepath.Join(dir, "ca.crt")}
+	writeFile(t, files.cert, b.Server.CertPEM)
+	writeFile(t, files.key, b.Server.KeyPEM)
+	writeFile(t, files.ca, b.CA.CertPEM)
+	return b, files
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestReloader(t *testing.T, files tlsFiles) *Reloader {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	r, err := NewReloader(ctx, files.cert, files.key, files.ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestReloadKeepsMaterialOnInvalidCA(t *testing.T) {
+	dir := t.TempDir()
+	first, files := writeBundle(t, dir)
+	r := newTestReloader(t, files)
+	var failures int
+	r.OnFailure = func(error) { failures++ }
+	initial := r.Current()
+
+	writeFile(t, files.ca, []byte("-----BEGIN CERTIFICATE-----\ngarbage\n-----END CERTIFICATE-----\n"))
+	if _, err := r.Reload("test"); err == nil {
+		t.Fatal("reload of a garbage CA succeeded")
+	}
+	if r.Current() != initial {
+		t.Error("a failed reload replaced the material")
+	}
+	if failures != 1 {
+		t.Errorf("OnFailure called %d times, want 1", failures)
+	}
+
+	second, err := GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, files.ca, second.CA.CertPEM)
+	m, err := r.Reload("test")
+	if err != nil {
+		t.Fatalf("reload of a good CA: %v", err)
+	}
+	if r.Current() != m || len(m.Roots) != 1 || !m.Roots[0].Equal(second.CA.Cert) {
+		t.Errorf("the reloaded CA is not the new one")
+	}
+	if m.Roots[0].Equal(first.CA.Cert) {
+		t.Errorf("the previous CA is still trusted")
+	}
+}
+
+func TestReloadCoalescesTriggers(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	_, files := writeBundle(t, t.TempDir())
+	r := newTestReloader(t, files)
+	initial := r.Current()
+
+	sources := []string{"file-watch", "SIGHUP", "http", "SIGHUP"}
+	results := make([]*Material, len(sources))
+	var wg sync.WaitGroup
+	for i, source := range sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m, err := r.Reload(source)
+			if err != nil {
+				t.Errorf("reload from %s: %v", source, err)
+			}
+			results[i] = m
+		}()
+	}
+	wg.Wait()
+
+	for i, m := range results {
+		if m == nil || m != results[0] {
+			t.Fatalf("trigger %d got material %p, want the one of trigger 0 %p", i, m, results[0])
+		}
+	}
+	if results[0] == initial || r.Current() != results[0] {
+		t.Error("the coalesced reload did not replace the material")
+	}
+	if n := strings.Count(logs.String(), "Reloaded TLS material"); n != 1 {
+		t.Errorf("%d reloads performed, want 1:\n%s", n, logs.String())
+	}
+	if !strings.Contains(logs.String(), "Coalescing 4 TLS reload triggers") {
+		t.Errorf("the coalesced reload was not logged:\n%s", logs.String())
+	}
+}
@@ -0,0 +1,399 @@
+// tls.go
+//
+// This file contains helpers for loading TLS material and verifying client
+// certificates presented to the gRPC listener.
+
+// Package tlsutil loads, generates and hot-reloads the TLS material of the
+// harness, and verifies the certificates its peers present.
+package tlsutil
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// LoadServerKeyPair loads the server certificate and private key. Unlike
+// tls.LoadX509KeyPair it names the offending file and the specific problem
+// (empty file, missing PEM block, unparseable key, key not matching the
+// certificate) so boot failures are actionable.
+func LoadServerKeyPair(certFile, keyFile string) (tls.Certificate, error) {
+	certPEM, certBlock, err := readPEMFile(certFile, "CERTIFICATE")
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPEM, keyBlock, err := readPEMFile(keyFile, "PRIVATE KEY")
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	leaf, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("%s: invalid certificate: %v", certFile, err)
+	}
+	key, err := parsePrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("%s: invalid private key: %v", keyFile, err)
+	}
+	pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(leaf.PublicKey) {
+		return tls.Certificate{}, fmt.Errorf("private key in %s does not match the certificate in %s", keyFile, certFile)
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("%s, %s: %v", certFile, keyFile, err)
+	}
+	return cert, nil
+}
+
+// VerifyServerName checks that cert is valid for name, the hostname
+// clients such as Envoy use to verify the server's SANs.
+func VerifyServerName(cert tls.Certificate, name string) error {
+	if len(cert.Certificate) == 0 {
+		return errors.New("no certificate loaded")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	return leaf.VerifyHostname(name)
+}
+
+// readPEMFile reads path and returns its contents along with the first PEM
+// block of type wantType, or of a type ending in " "+wantType such as "EC
+// PRIVATE KEY". Other blocks, like the EC PARAMETERS that openssl ecparam
+// writes before the key, are skipped as tls.X509KeyPair does.
+func readPEMFile(path, wantType string) ([]byte, *pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil, fmt.Errorf("%s is empty", path)
+	}
+	var firstType string
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == wantType || strings.HasSuffix(block.Type, " "+wantType) {
+			return data, block, nil
+		}
+		if firstType == "" {
+			firstType = block.Type
+		}
+	}
+	if firstType == "" {
+		return nil, nil, fmt.Errorf("%s: no PEM block found", path)
+	}
+	return nil, nil, fmt.Errorf("%s: found a %q PEM block, expected %s", path, firstType, wantType)
+}
+
+// parsePrivateKey parses a PKCS #1, PKCS #8 or SEC 1 encoded private key.
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, errors.New("not a PKCS #1, PKCS #8 or SEC 1 private key")
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
+
+// ParseCertsPEM returns the certificates in a PEM bundle, skipping blocks
+// that are not valid certificates.
+func ParseCertsPEM(data []byte) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+			certs = append(certs, cert)
+		}
+	}
+}
+
+// LoadCAPool reads a PEM bundle and returns a pool of the certificates it
+// contains. A file that yields no certificate is rejected rather than turned
+// into an empty pool, which would silently refuse every client.
+func LoadCAPool(path string) (*x509.CertPool, error) {
+	caCert, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("%s contains no valid PEM certificates", path)
+	}
+	return pool, nil
+}
+
+// ClientConfig builds the configuration of the built-in clients: they
+// present the certificate in certFile/keyFile and trust servers signed by
+// the CA in caFile.
+func ClientConfig(caFile, certFile, keyFile, serverName string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client cert: %w", err)
+	}
+	pool, err := LoadCAPool(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ca cert: %w", err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ServerName:   serverName,
+	}, nil
+}
+
+// PeerVerifier has the signature of tls.Config.VerifyPeerCertificate.
+type PeerVerifier func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error
+
+// AllVerifiers returns a PeerVerifier that runs each of vs in order and
+// fails with the first error. It returns nil if vs is empty, leaving the
+// standard verification untouched.
+func AllVerifiers(vs ...PeerVerifier) PeerVerifier {
+	if len(vs) == 0 {
+		return nil
+	}
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		for _, v := range vs {
+			if err := v(rawCerts, verifiedChains); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// VerifyChainDepth returns a VerifyPeerCertificate callback that accepts a
+// client only if at least one of its verified chains has no more than
+// maxDepth certificates. Clients without a certificate, allowed by
+// -client-auth=request, are left to that setting. Under the -unverified
+// modes there are no verified chains, so the depth is that of the chain
+// the client presented, counting the root it leaves out.
+func VerifyChainDepth(maxDepth int) PeerVerifier {
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return nil
+		}
+		if len(verifiedChains) == 0 {
+			if presentedDepth(rawCerts) <= maxDepth {
+				return nil
+			}
+		}
+		for _, chain := range verifiedChains {
+			if len(chain) <= maxDepth {
+				return nil
+			}
+		}
+		return fmt.Errorf("client certificate chain exceeds maximum verify depth %d", maxDepth)
+	}
+}
+
+// presentedDepth returns the length of the chain rawCerts leads to: the
+// certificates presented, plus the root that issued the last one unless
+// it is self-signed.
+func presentedDepth(rawCerts [][]byte) int {
+	last, err := x509.ParseCertificate(rawCerts[len(rawCerts)-1])
+	if err != nil || !bytes.Equal(last.RawIssuer, last.RawSubject) {
+		return len(rawCerts) + 1
+	}
+	return len(rawCerts)
+}
+
+// ParseOID parses a dotted object identifier such as 1.3.6.1.4.1.99999.1.
+func ParseOID(s string) (asn1.ObjectIdentifier, error) {
+	var oid asn1.ObjectIdentifier
+	for _, part := range strings.Split(s, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid object identifier %q", s)
+		}
+		oid = append(oid, n)
+	}
+	if len(oid) < 2 {
+		return nil, fmt.Errorf("invalid object identifier %q", s)
+	}
+	return oid, nil
+}
+
+// CertExtension returns the value of the extension oid in cert. Values
+// encoded as an ASN.1 string are returned as text and any other value as
+// hex.
+func CertExtension(cert *x509.Certificate, oid asn1.ObjectIdentifier) (string, bool) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oid) {
+			continue
+		}
+		var text string
+		if rest, err := asn1.Unmarshal(ext.Value, &text); err == nil && len(rest) == 0 {
+			return text, true
+		}
+		return hex.EncodeToString(ext.Value), true
+	}
+	return "", false
+}
+
+// VerifyRequiredExtension returns a VerifyPeerCertificate callback that
+// rejects client leaf certificates lacking the extension oid. Clients
+// without a certificate, allowed by -client-auth=request, are left to that
+// setting.
+func VerifyRequiredExtension(oid asn1.ObjectIdentifier) PeerVerifier {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return nil
+		}
+		leaf, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return err
+		}
+		if _, ok := CertExtension(leaf, oid); !ok {
+			return fmt.Errorf("client certificate lacks required extension %s", oid)
+		}
+		return nil
+	}
+}
+
+// ClientAuthModes are the -client-auth values, by policy.
+var ClientAuthModes = map[string]tls.ClientAuthType{
+	"none":               tls.NoClientCert,
+	"request":            tls.VerifyClientCertIfGiven,
+	"require":            tls.RequireAndVerifyClientCert,
+	"require-and-verify": tls.RequireAndVerifyClientCert,
+	"request-unverified": tls.RequestClientCert,
+	"require-unverified": tls.RequireAnyClientCert,
+}
+
+// ParseClientAuth maps a -client-auth value to the TLS client
+// authentication policy: "none" asks for no certificate, "require" (or
+// "require-and-verify") rejects clients without a valid certificate, and
+// "request" also accepts clients that send none. The -unverified variants
+// skip verification against the CA, to reproduce misconfigured upstreams.
+func ParseClientAuth(mode string) (tls.ClientAuthType, error) {
+	if auth, ok := ClientAuthModes[mode]; ok {
+		return auth, nil
+	}
+	return 0, fmt.Errorf("unknown mode %q, want one of %s", mode, strings.Join(ClientAuthModeNames(), ", "))
+}
+
+// ClientAuthModeNames returns the keys of ClientAuthModes, sorted.
+func ClientAuthModeNames() []string {
+	return slices.Sorted(maps.Keys(ClientAuthModes))
+}
+
+// tlsVersions are the -tls-min-version and -tls-max-version values.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseVersion maps a version such as "1.2" to its tls.Version constant.
+func ParseVersion(v string) (uint16, error) {
+	if version, ok := tlsVersions[v]; ok {
+		return version, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version %q, want 1.0, 1.1, 1.2 or 1.3", v)
+}
+
+// ParseCipherSuites resolves cipher suite names (e.g.
+// "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"), insecure ones included, to
+// their IDs, in order. TLS 1.3 suites are not configurable in Go and are
+// rejected. No names yields nil, which keeps Go's defaults.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	byName := make(map[string]*tls.CipherSuite)
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		byName[suite.Name] = suite
+	}
+	ids := make([]uint16, len(names))
+	for i, name := range names {
+		suite, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		if len(suite.SupportedVersions) == 1 && suite.SupportedVersions[0] == tls.VersionTLS13 {
+			return nil, fmt.Errorf("cipher suite %s is TLS 1.3 only; TLS 1.3 suites are not configurable", name)
+		}
+		ids[i] = suite.ID
+	}
+	return ids, nil
+}
+
+// RequireH2ALPN is a tls.Config.VerifyConnection callback that rejects, and
+// logs, any connection that did not negotiate the "h2" ALPN protocol.
+// Plain HTTPS clients and scanners are turned away during the handshake
+// instead of occupying a gRPC transport.
+func RequireH2ALPN(cs tls.ConnectionState) error {
+	if cs.NegotiatedProtocol == "h2" {
+		return nil
+	}
+	log.Printf("Rejecting TLS connection with ALPN %q (SNI %q): h2 required", cs.NegotiatedProtocol, cs.ServerName)
+	return fmt.Errorf("ALPN protocol %q is not h2", cs.NegotiatedProtocol)
+}
+
+// ParsePins parses SHA-256 certificate fingerprints written as hex, with or
+// without colon separators, into a set keyed by the lowercase hex digest.
+func ParsePins(pins []string) (map[string]bool, error) {
+	set := make(map[string]bool, len(pins))
+	for _, pin := range pins {
+		digest, err := hex.DecodeString(strings.ReplaceAll(pin, ":", ""))
+		if err != nil || len(digest) != sha256.Size {
+			return nil, fmt.Errorf("invalid SHA-256 fingerprint %q", pin)
+		}
+		set[hex.EncodeToString(digest)] = true
+	}
+	return set, nil
+}
+
+// VerifyPinnedLeaf returns a VerifyPeerCertificate callback that accepts a
+// client only if the SHA-256 fingerprint of its leaf certificate is in
+// pins. It runs after, not instead of, CA verification.
+func VerifyPinnedLeaf(pins map[string]bool) PeerVerifier {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("no client certificate to check against pins")
+		}
+		sum := sha256.Sum256(rawCerts[0])
+		fingerprint := hex.EncodeToString(sum[:])
+		if !pins[fingerprint] {
+			return fmt.Errorf("client certificate fingerprint %s is not pinned", fingerprint)
+		}
+		return nil
+	}
+}
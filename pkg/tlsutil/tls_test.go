@@ -0,0 +1,306 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// chainOf returns the raw certificates a client presents for leaf and the
+// chains verified against root with intermediates.
+func chainOf(t *testing.T, leaf *IssuedCert, root *IssuedCert, intermediates ...*IssuedCert) ([][]byte, [][]*x509.Certificate) {
+	t.Helper()
+	roots, inter := x509.NewCertPool(), x509.NewCertPool()
+	roots.AddCert(root.Cert)
+	raw := [][]byte{leaf.Cert.Raw}
+	for _, c := range intermediates {
+		inter.AddCert(c.Cert)
+		raw = append(raw, c.Cert.Raw)
+	}
+	chains, err := leaf.Cert.Verify(x509.VerifyOptions{Roots: roots, Intermediates: inter, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw, chains
+}
+
+func issue(t *testing.T, template *x509.Certificate, parent *IssuedCert) *IssuedCert {
+	t.Helper()
+	c, err := GenerateCert(template, parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestVerifyChainDepth(t *testing.T) {
+	root := issue(t, CATemplate("root", time.Hour), nil)
+	intermediate := issue(t, CATemplate("intermediate", time.Hour), root)
+	deep := issue(t, LeafTemplate("deep", nil, x509.ExtKeyUsageClientAuth, time.Hour), intermediate)
+	direct := issue(t, LeafTemplate("direct", nil, x509.ExtKeyUsageClientAuth, time.Hour), root)
+
+	raw, chains := chainOf(t, deep, root, intermediate)
+	if err := VerifyChainDepth(2)(raw, chains); err == nil {
+		t.Error("depth 2 accepted a 3-level chain")
+	}
+	if err := VerifyChainDepth(3)(raw, chains); err != nil {
+		t.Errorf("depth 3 rejected a 3-level chain: %v", err)
+	}
+	raw, chains = chainOf(t, direct, root)
+	if err := VerifyChainDepth(2)(raw, chains); err != nil {
+		t.Errorf("depth 2 rejected a 2-level chain: %v", err)
+	}
+	if err := VerifyChainDepth(2)(nil, nil); err != nil {
+		t.Errorf("depth 2 rejected a client without a certificate: %v", err)
+	}
+}
+
+func TestVerifyChainDepthPerAuthMode(t *testing.T) {
+	b, err := GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := issue(t, CATemplate("root", time.Hour), nil)
+	intermediate := issue(t, CATemplate("intermediate", time.Hour), root)
+	deep := issue(t, LeafTemplate("deep", nil, x509.ExtKeyUsageClientAuth, time.Hour), intermediate)
+	client := &tls.Config{
+		RootCAs:    b.Pool(),
+		ServerName: "localhost",
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{deep.Cert.Raw, intermediate.Cert.Raw},
+			PrivateKey:  deep.Key,
+		}},
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(root.Cert)
+
+	for mode, auth := range ClientAuthModes {
+		for _, tc := range []struct {
+			depth int
+			ok    bool
+		}{
+			// The client never sends its certificate under "none".
+			{2, mode == "none"},
+			{3, true},
+		} {
+			server := &tls.Config{
+				Certificates:          []tls.Certificate{b.Server.TLSCertificate()},
+				ClientAuth:            auth,
+				ClientCAs:             roots,
+				VerifyPeerCertificate: VerifyChainDepth(tc.depth),
+			}
+			err := handshake(t, server, client)
+			if (err == nil) != tc.ok {
+				t.Errorf("-client-auth %s, depth %d: handshake error %v, want success %v", mode, tc.depth, err, tc.ok)
+			}
+			if err != nil && !strings.Contains(err.Error(), "maximum verify depth") {
+				t.Errorf("-client-auth %s, depth %d: rejected with %v, want the depth error", mode, tc.depth, err)
+			}
+		}
+	}
+}
+
+func TestVerifyPinnedLeaf(t *testing.T) {
+	root := issue(t, CATemplate("root", time.Hour), nil)
+	pinned := issue(t, LeafTemplate("pinned", nil, x509.ExtKeyUsageClientAuth, time.Hour), root)
+	other := issue(t, LeafTemplate("other", nil, x509.ExtKeyUsageClientAuth, time.Hour), root)
+
+	sum := sha256.Sum256(pinned.Cert.Raw)
+	var colons []string
+	for _, b := range sum {
+		colons = append(colons, fmt.Sprintf("%02X", b))
+	}
+	pins, err := ParsePins([]string{strings.Join(colons, ":")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify := VerifyPinnedLeaf(pins)
+
+	if err := verify(chainOf(t, pinned, root)); err != nil {
+		t.Errorf("pinned client rejected: %v", err)
+	}
+	if err := verify(chainOf(t, other, root)); err == nil || !strings.Contains(err.Error(), "not pinned") {
+		t.Errorf("unpinned client = %v, want a not pinned error", err)
+	}
+	if _, err := ParsePins([]string{"abcd"}); err == nil {
+		t.Error("a short fingerprint was accepted")
+	}
+}
+
+func TestLoadServerKeyPair(t *testing.T) {
+	dir := t.TempDir()
+	b, files := writeBundle(t, dir)
+	if _, err := LoadServerKeyPair(files.cert, files.key); err != nil {
+		t.Fatalf("valid key pair: %v", err)
+	}
+	// openssl ecparam -genkey writes the curve parameters before the key.
+	ecKey, err := x509.MarshalECPrivateKey(b.Server.Key.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	curve, err := asn1.Marshal(asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecParams := pem.EncodeToMemory(&pem.Block{Type: "EC PARAMETERS", Bytes: curve})
+	withParams := filepath.Join(dir, "ecparam.key")
+	writeFile(t, withParams, append(ecParams, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecKey})...))
+	if _, err := LoadServerKeyPair(files.cert, withParams); err != nil {
+		t.Errorf("key after EC PARAMETERS: %v", err)
+	}
+
+	bad := filepath.Join(dir, "bad.pem")
+	for _, tc := range []struct {
+		name      string
+		cert, key string // files to load, one of them bad.pem holding data
+		data      []byte
+		want      string
+	}{
+		{"empty key", files.cert, bad, nil, "is empty"},
+		{"blank key", files.cert, bad, []byte("\n  \n"), "is empty"},
+		{"key without PEM", files.cert, bad, []byte("not a key"), "no PEM block"},
+		{"certificate as key", files.cert, bad, b.Server.CertPEM, `"CERTIFICATE" PEM block, expected PRIVATE KEY`},
+		{"EC parameters without a key", files.cert, bad, ecParams, `"EC PARAMETERS" PEM block, expected PRIVATE KEY`},
+		{"corrupt key", files.cert, bad, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("garbage")}), "invalid private key"},
+		{"corrupt certificate", bad, files.key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("garbage")}), "invalid certificate"},
+		{"mismatched key", files.cert, bad, b.Client.KeyPEM, "does not match the certificate"},
+	} {
+		writeFile(t, bad, tc.data)
+		_, err := LoadServerKeyPair(tc.cert, tc.key)
+		if err == nil || !strings.Contains(err.Error(), tc.want) || !strings.Contains(err.Error(), bad) {
+			t.Errorf("%s: %v, want an error naming %s with %q", tc.name, err, bad, tc.want)
+		}
+	}
+}
+
+// handshake runs a TLS handshake between server and client over a
+// loopback connection and returns the server's error.
+func handshake(t *testing.T, server, client *tls.Config) error {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go func() {
+		conn, err := tls.Dial("tcp", lis.Addr().String(), client)
+		if err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := lis.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return tls.Server(conn, server).Handshake()
+}
+
+func TestRequireH2ALPN(t *testing.T) {
+	b, err := GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &tls.Config{
+		Certificates:     []tls.Certificate{b.Server.TLSCertificate()},
+		NextProtos:       []string{"h2", "http/1.1"},
+		VerifyConnection: RequireH2ALPN,
+	}
+	for _, tc := range []struct {
+		protos []string
+		ok     bool
+	}{
+		{[]string{"h2"}, true},
+		{[]string{"http/1.1", "h2"}, true},
+		{[]string{"http/1.1"}, false},
+		{nil, false},
+	} {
+		err := handshake(t, server, &tls.Config{RootCAs: b.Pool(), ServerName: "localhost", NextProtos: tc.protos})
+		if (err == nil) != tc.ok {
+			t.Errorf("client ALPN %q: handshake error %v, want success %v", tc.protos, err, tc.ok)
+		}
+		if err != nil && !strings.Contains(err.Error(), "is not h2") {
+			t.Errorf("client ALPN %q rejected with %v, want the ALPN error", tc.protos, err)
+		}
+	}
+}
+
+func TestVerifyServerName(t *testing.T) {
+	b, err := GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := b.Server.TLSCertificate()
+	if err := VerifyServerName(cert, "localhost"); err != nil {
+		t.Errorf("localhost: %v", err)
+	}
+	if err := VerifyServerName(cert, "time.example.com"); err == nil {
+		t.Error("a name missing from the SANs was accepted")
+	}
+	if err := VerifyServerName(tls.Certificate{}, "localhost"); err == nil {
+		t.Error("an empty certificate was accepted")
+	}
+}
+
+func TestVerifyRequiredExtension(t *testing.T) {
+	oid, err := ParseOID("1.3.6.1.4.1.55555.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := issue(t, CATemplate("root", time.Hour), nil)
+	withExtension := func(cn string, value []byte) *IssuedCert {
+		template := LeafTemplate(cn, nil, x509.ExtKeyUsageClientAuth, time.Hour)
+		template.ExtraExtensions = []pkix.Extension{{Id: oid, Value: value}}
+		return issue(t, template, root)
+	}
+	tenantID, err := asn1.Marshal("tenant-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenant := withExtension("tenant", tenantID)
+	binary := withExtension("binary", []byte{0x04, 0x02, 0xca, 0xfe})
+	plain := issue(t, LeafTemplate("plain", nil, x509.ExtKeyUsageClientAuth, time.Hour), root)
+
+	verify := VerifyRequiredExtension(oid)
+	for _, c := range []*IssuedCert{tenant, binary} {
+		if err := verify(chainOf(t, c, root)); err != nil {
+			t.Errorf("%s: %v", c.Cert.Subject.CommonName, err)
+		}
+	}
+	if err := verify(chainOf(t, plain, root)); err == nil || !strings.Contains(err.Error(), oid.String()) {
+		t.Errorf("a certificate without the extension: %v, want an error naming %s", err, oid)
+	}
+	if err := verify(nil, nil); err != nil {
+		t.Errorf("no client certificate: %v, want it left to -client-auth", err)
+	}
+
+	for _, tc := range []struct {
+		cert *IssuedCert
+		want string
+		ok   bool
+	}{
+		{tenant, "tenant-a", true},
+		{binary, "0402cafe", true},
+		{plain, "", false},
+	} {
+		if got, ok := CertExtension(tc.cert.Cert, oid); got != tc.want || ok != tc.ok {
+			t.Errorf("CertExtension(%s) = %q, %t, want %q, %t", tc.cert.Cert.Subject.CommonName, got, ok, tc.want, tc.ok)
+		}
+	}
+
+	for _, bad := range []string{"", "1", "1.x", "1.-2"} {
+		if _, err := ParseOID(bad); err == nil {
+			t.Errorf("ParseOID(%q) succeeded", bad)
+		}
+	}
+}
@@ -25,9 +25,52 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
-// The request message containing no parameters.
+// The request message for the time RPCs.
 type TimeRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Makes GetTime fail with a retriable status before succeeding, to
+	// exercise proxy retry policies. Ignored by the streaming RPCs.
+	RetryHint *RetryHint `protobuf:"bytes,1,opt,name=retry_hint,json=retryHint,proto3" json:"retry_hint,omitempty"`
+	// Name of a registered compressor (e.g. "gzip") StreamTime should use for
+	// its responses. Empty leaves responses uncompressed.
+	Compression string `protobuf:"bytes,2,opt,name=compression,proto3" json:"compression,omitempty"`
+	// Makes StreamTime fire its ticks on wall-clock multiples of the interval
+	// (e.g. :00, :02, :04) instead of relative to the stream start, so ticks
+	// of different clients are synchronized.
+	AlignToClock bool `protobuf:"varint,3,opt,name=align_to_clock,json=alignToClock,proto3" json:"align_to_clock,omitempty"`
+	// Makes StreamTime send heartbeat messages every heartbeat_interval_ms
+	// milliseconds in between its data ticks, to keep the stream from
+	// hitting proxy idle timeouts. Zero sends no heartbeats; negative values
+	// are rejected.
+	HeartbeatIntervalMs int64 `protobuf:"varint,4,opt,name=heartbeat_interval_ms,json=heartbeatIntervalMs,proto3" json:"heartbeat_interval_ms,omitempty"`
+	// Number of filler bytes GetTime and StreamTime add to each time
+	// response, up to 4193280, to simulate larger payloads. Heartbeats are
+	// never padded.
+	PadBytes int32 `protobuf:"varint,5,opt,name=pad_bytes,json=padBytes,proto3" json:"pad_bytes,omitempty"`
+	// Sequence number of the last StreamTime response the client received
+	// before its stream broke. The new stream numbers its responses from the
+	// next value on, for continuity across reconnects; the times themselves
+	// are always live. Must not be negative.
+	ResumeFromSequence int64 `protobuf:"varint,6,opt,name=resume_from_sequence,json=resumeFromSequence,proto3" json:"resume_from_sequence,omitempty"`
+	// IANA time zone name (e.g. "Europe/Paris"). When set, GetTime and
+	// StreamTime report current_time in UTC and local_time in this zone.
+	// Empty uses the default of the service, if any.
+	Timezone string `protobuf:"bytes,7,opt,name=timezone,proto3" json:"timezone,omitempty"`
+	// Interval between StreamTime ticks, in milliseconds. Zero uses the
+	// default of the service; negative values are rejected.
+	IntervalMs int64 `protobuf:"varint,8,opt,name=interval_ms,json=intervalMs,proto3" json:"interval_ms,omitempty"`
+	// Format of the reported times: "rfc3339", "rfc3339nano", "rfc1123" or
+	// "datetime". Empty uses the default of the service.
+	Format string `protobuf:"bytes,9,opt,name=format,proto3" json:"format,omitempty"`
+	// Precision GetTime and StreamTime truncate the reported times to:
+	// "millisecond", "second", "minute" or "hour". Truncation is relative to
+	// UTC. Empty reports times untruncated.
+	TruncateTo string `protobuf:"bytes,10,opt,name=truncate_to,json=truncateTo,proto3" json:"truncate_to,omitempty"`
+	// Number of time responses after which StreamTime ends the stream with
+	// OK, e.g. 100 at interval_ms 10 with pad_bytes 65536 to push a known
+	// volume through the proxy. Heartbeats do not count. Zero streams until
+	// the client cancels; negative values are rejected.
+	MessageCount  int32 `protobuf:"varint,11,opt,name=message_count,json=messageCount,proto3" json:"message_count,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -62,17 +105,172 @@ func (*TimeRequest) Descriptor() ([]byte, []int) {
 	return file_protos_time_proto_rawDescGZIP(), []int{0}
 }
 
+func (x *TimeRequest) GetRetryHint() *RetryHint {
+	if x != nil {
+		return x.RetryHint
+	}
+	return nil
+}
+
+func (x *TimeRequest) GetCompression() string {
+	if x != nil {
+		return x.Compression
+	}
+	return ""
+}
+
+func (x *TimeRequest) GetAlignToClock() bool {
+	if x != nil {
+		return x.AlignToClock
+	}
+	return false
+}
+
+func (x *TimeRequest) GetHeartbeatIntervalMs() int64 {
+	if x != nil {
+		return x.HeartbeatIntervalMs
+	}
+	return 0
+}
+
+func (x *TimeRequest) GetPadBytes() int32 {
+	if x != nil {
+		return x.PadBytes
+	}
+	return 0
+}
+
+func (x *TimeRequest) GetResumeFromSequence() int64 {
+	if x != nil {
+		return x.ResumeFromSequence
+	}
+	return 0
+}
+
+func (x *TimeRequest) GetTimezone() string {
+	if x != nil {
+		return x.Timezone
+	}
+	return ""
+}
+
+func (x *TimeRequest) GetIntervalMs() int64 {
+	if x != nil {
+		return x.IntervalMs
+	}
+	return 0
+}
+
+func (x *TimeRequest) GetFormat() string {
+	if x != nil {
+		return x.Format
+	}
+	return ""
+}
+
+func (x *TimeRequest) GetTruncateTo() string {
+	if x != nil {
+		return x.TruncateTo
+	}
+	return ""
+}
+
+func (x *TimeRequest) GetMessageCount() int32 {
+	if x != nil {
+		return x.MessageCount
+	}
+	return 0
+}
+
+// Instructs GetTime to behave like a flaky backend that recovers.
+type RetryHint struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Number of attempts to fail before succeeding.
+	Failures int32 `protobuf:"varint,1,opt,name=failures,proto3" json:"failures,omitempty"`
+	// Status code to fail with: UNAVAILABLE (the default) or RESOURCE_EXHAUSTED.
+	Code string `protobuf:"bytes,2,opt,name=code,proto3" json:"code,omitempty"`
+	// Identifies the retried request; attempts with the same key share one
+	// failure budget. Defaults to the client certificate identity.
+	Key           string `protobuf:"bytes,3,opt,name=key,proto3" json:"key,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RetryHint) Reset() {
+	*x = RetryHint{}
+	mi := &file_protos_time_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RetryHint) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RetryHint) ProtoMessage() {}
+
+func (x *RetryHint) ProtoReflect() protoreflect.Message {
+	mi := &file_protos_time_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RetryHint.ProtoReflect.Descriptor instead.
+func (*RetryHint) Descriptor() ([]byte, []int) {
+	return file_protos_time_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *RetryHint) GetFailures() int32 {
+	if x != nil {
+		return x.Failures
+	}
+	return 0
+}
+
+func (x *RetryHint) GetCode() string {
+	if x != nil {
+		return x.Code
+	}
+	return ""
+}
+
+func (x *RetryHint) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
 // The response message containing the current time.
 type TimeResponse struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	CurrentTime   string                 `protobuf:"bytes,1,opt,name=current_time,json=currentTime,proto3" json:"current_time,omitempty"`
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	CurrentTime string                 `protobuf:"bytes,1,opt,name=current_time,json=currentTime,proto3" json:"current_time,omitempty"`
+	// Set on StreamTime heartbeats, which carry no time and only signal that
+	// the stream is alive.
+	IsHeartbeat bool `protobuf:"varint,2,opt,name=is_heartbeat,json=isHeartbeat,proto3" json:"is_heartbeat,omitempty"`
+	// Filler bytes requested through TimeRequest.pad_bytes. Meaningless.
+	Padding []byte `protobuf:"bytes,3,opt,name=padding,proto3" json:"padding,omitempty"`
+	// Position of the response in its StreamTime stream, starting at 1 or
+	// after TimeRequest.resume_from_sequence. Zero on heartbeats and unary
+	// responses.
+	Sequence int64 `protobuf:"varint,4,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	// The same instant as current_time in the local time of
+	// TimeRequest.timezone, in the same format. Empty unless a timezone
+	// applies.
+	LocalTime     string `protobuf:"bytes,5,opt,name=local_time,json=localTime,proto3" json:"local_time,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *TimeResponse) Reset() {
 	*x = TimeResponse{}
-	mi := &file_protos_time_proto_msgTypes[1]
+	mi := &file_protos_time_proto_msgTypes[2]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -84,7 +282,7 @@ func (x *TimeResponse) String() string {
 func (*TimeResponse) ProtoMessage() {}
 
 func (x *TimeResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_protos_time_proto_msgTypes[1]
+	mi := &file_protos_time_proto_msgTypes[2]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -97,7 +295,7 @@ func (x *TimeResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use TimeResponse.ProtoReflect.Descriptor instead.
 func (*TimeResponse) Descriptor() ([]byte, []int) {
-	return file_protos_time_proto_rawDescGZIP(), []int{1}
+	return file_protos_time_proto_rawDescGZIP(), []int{2}
 }
 
 func (x *TimeResponse) GetCurrentTime() string {
@@ -107,17 +305,1234 @@ func (x *TimeResponse) GetCurrentTime() string {
 	return ""
 }
 
-var File_protos_time_proto protoreflect.FileDescriptor
+func (x *TimeResponse) GetIsHeartbeat() bool {
+	if x != nil {
+		return x.IsHeartbeat
+	}
+	return false
+}
 
-const file_protos_time_proto_rawDesc = "" +
-	"\n" +
-	"\x11protos/time.proto\x12\x04time\"\r\n" +
-	"\vTimeRequest\"1\n" +
-	"\fTimeResponse\x12!\n" +
-	"\fcurrent_time\x18\x01 \x01(\tR\vcurrentTime2F\n" +
-	"\vTimeService\x127\n" +
+func (x *TimeResponse) GetPadding() []byte {
+	if x != nil {
+		return x.Padding
+	}
+	return nil
+}
+
+func (x *TimeResponse) GetSequence() int64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *TimeResponse) GetLocalTime() string {
+	if x != nil {
+		return x.LocalTime
+	}
+	return ""
+}
+
+// A control message adjusting the tick rate of a ControlledTime stream.
+type ControlRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// New interval between ticks, in milliseconds. Must be positive.
+	IntervalMs    int64 `protobuf:"varint,1,opt,name=interval_ms,json=intervalMs,proto3" json:"interval_ms,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ControlRequest) Reset() {
+	*x = ControlRequest{}
+	mi := &file_protos_time_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ControlRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ControlRequest) ProtoMessage() {}
+
+func (x *ControlRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_protos_time_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ControlRequest.ProtoReflect.Descriptor instead.
+func (*ControlRequest) Descriptor() ([]byte, []int) {
+	return file_protos_time_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ControlRequest) GetIntervalMs() int64 {
+	if x != nil {
+		return x.IntervalMs
+	}
+	return 0
+}
+
+// The request message for GetSchedule.
+type ScheduleRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Number of tick times to return, from 1 to 1000.
+	Count         int32 `protobuf:"varint,1,opt,name=count,proto3" json:"count,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ScheduleRequest) Reset() {
+	*x = ScheduleRequest{}
+	mi := &file_protos_time_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ScheduleRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScheduleRequest) ProtoMessage() {}
+
+func (x *ScheduleRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_protos_time_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScheduleRequest.ProtoReflect.Descriptor instead.
+func (*ScheduleRequest) Descriptor() ([]byte, []int) {
+	return file_protos_time_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ScheduleRequest) GetCount() int32 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+// The response message listing upcoming tick times.
+type ScheduleResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Tick times in the default format of the service, earliest first.
+	TickTimes     []string `protobuf:"bytes,1,rep,name=tick_times,json=tickTimes,proto3" json:"tick_times,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ScheduleResponse) Reset() {
+	*x = ScheduleResponse{}
+	mi := &file_protos_time_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ScheduleResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScheduleResponse) ProtoMessage() {}
+
+func (x *ScheduleResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_protos_time_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScheduleResponse.ProtoReflect.Descriptor instead.
+func (*ScheduleResponse) Descriptor() ([]byte, []int) {
+	return file_protos_time_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ScheduleResponse) GetTickTimes() []string {
+	if x != nil {
+		return x.TickTimes
+	}
+	return nil
+}
+
+// A client timestamp sent on a ReportTimestamps stream.
+type TimestampReport struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Client time in nanoseconds since the Unix epoch. Must be positive.
+	ClientTimeUnixNano int64 `protobuf:"varint,1,opt,name=client_time_unix_nano,json=clientTimeUnixNano,proto3" json:"client_time_unix_nano,omitempty"`
+	unknownFields      protoimpl.UnknownFields
+	sizeCache          protoimpl.SizeCache
+}
+
+func (x *TimestampReport) Reset() {
+	*x = TimestampReport{}
+	mi := &file_protos_time_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TimestampReport) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TimestampReport) ProtoMessage() {}
+
+func (x *TimestampReport) ProtoReflect() protoreflect.Message {
+	mi := &file_protos_time_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TimestampReport.ProtoReflect.Descriptor instead.
+func (*TimestampReport) Descriptor() ([]byte, []int) {
+	return file_protos_time_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *TimestampReport) GetClientTimeUnixNano() int64 {
+	if x != nil {
+		return x.ClientTimeUnixNano
+	}
+	return 0
+}
+
+// The response message aggregating the timestamps of a ReportTimestamps
+// stream.
+type TimestampSummary struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Number of timestamps received.
+	Count int64 `protobuf:"varint,1,opt,name=count,proto3" json:"count,omitempty"`
+	// Earliest and latest client times, in the default format of the
+	// service. Empty when no timestamp was received.
+	Earliest string `protobuf:"bytes,2,opt,name=earliest,proto3" json:"earliest,omitempty"`
+	Latest   string `protobuf:"bytes,3,opt,name=latest,proto3" json:"latest,omitempty"`
+	// Mean and maximum of the server receive time minus the client time, in
+	// nanoseconds: the one-way latency through the proxy plus the clock skew
+	// between client and server.
+	MeanOffsetNanos int64 `protobuf:"varint,4,opt,name=mean_offset_nanos,json=meanOffsetNanos,proto3" json:"mean_offset_nanos,omitempty"`
+	MaxOffsetNanos  int64 `protobuf:"varint,5,opt,name=max_offset_nanos,json=maxOffsetNanos,proto3" json:"max_offset_nanos,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *TimestampSummary) Reset() {
+	*x = TimestampSummary{}
+	mi := &file_protos_time_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TimestampSummary) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TimestampSummary) ProtoMessage() {}
+
+func (x *TimestampSummary) ProtoReflect() protoreflect.Message {
+	mi := &file_protos_time_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TimestampSummary.ProtoReflect.Descriptor instead.
+func (*TimestampSummary) Descriptor() ([]byte, []int) {
+	return file_protos_time_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *TimestampSummary) GetCount() int64 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+func (x *TimestampSummary) GetEarliest() string {
+	if x != nil {
+		return x.Earliest
+	}
+	return ""
+}
+
+func (x *TimestampSummary) GetLatest() string {
+	if x != nil {
+		return x.Latest
+	}
+	return ""
+}
+
+func (x *TimestampSummary) GetMeanOffsetNanos() int64 {
+	if x != nil {
+		return x.MeanOffsetNanos
+	}
+	return 0
+}
+
+func (x *TimestampSummary) GetMaxOffsetNanos() int64 {
+	if x != nil {
+		return x.MaxOffsetNanos
+	}
+	return 0
+}
+
+// The request message for server metadata, containing no parameters.
+type ServerInfoRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ServerInfoRequest) Reset() {
+	*x = ServerInfoRequest{}
+	mi := &file_protos_time_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ServerInfoRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ServerInfoRequest) ProtoMessage() {}
+
+func (x *ServerInfoRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_protos_time_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ServerInfoRequest.ProtoReflect.Descriptor instead.
+func (*ServerInfoRequest) Descriptor() ([]byte, []int) {
+	return file_protos_time_proto_rawDescGZIP(), []int{8}
+}
+
+// The response message describing the running server.
+type ServerInfoResponse struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Hostname string                 `protobuf:"bytes,1,opt,name=hostname,proto3" json:"hostname,omitempty"`
+	Version  string                 `protobuf:"bytes,2,opt,name=version,proto3" json:"version,omitempty"`
+	Commit   string                 `protobuf:"bytes,3,opt,name=commit,proto3" json:"commit,omitempty"`
+	// Time the process started, in RFC3339 format.
+	BootTime        string   `protobuf:"bytes,4,opt,name=boot_time,json=bootTime,proto3" json:"boot_time,omitempty"`
+	Features        []string `protobuf:"bytes,5,rep,name=features,proto3" json:"features,omitempty"`
+	ListenAddresses []string `protobuf:"bytes,6,rep,name=listen_addresses,json=listenAddresses,proto3" json:"listen_addresses,omitempty"`
+	// ID of the instance that answered, in cluster mode (-instances), where
+	// every instance has its own listeners and health. Empty otherwise.
+	InstanceId    string `protobuf:"bytes,7,opt,name=instance_id,json=instanceId,proto3" json:"instance_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ServerInfoResponse) Reset() {
+	*x = ServerInfoResponse{}
+	mi := &file_protos_time_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ServerInfoResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ServerInfoResponse) ProtoMessage() {}
+
+func (x *ServerInfoResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_protos_time_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ServerInfoResponse.ProtoReflect.Descriptor instead.
+func (*ServerInfoResponse) Descriptor() ([]byte, []int) {
+	return file_protos_time_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *ServerInfoResponse) GetHostname() string {
+	if x != nil {
+		return x.Hostname
+	}
+	return ""
+}
+
+func (x *ServerInfoResponse) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *ServerInfoResponse) GetCommit() string {
+	if x != nil {
+		return x.Commit
+	}
+	return ""
+}
+
+func (x *ServerInfoResponse) GetBootTime() string {
+	if x != nil {
+		return x.BootTime
+	}
+	return ""
+}
+
+func (x *ServerInfoResponse) GetFeatures() []string {
+	if x != nil {
+		return x.Features
+	}
+	return nil
+}
+
+func (x *ServerInfoResponse) GetListenAddresses() []string {
+	if x != nil {
+		return x.ListenAddresses
+	}
+	return nil
+}
+
+func (x *ServerInfoResponse) GetInstanceId() string {
+	if x != nil {
+		return x.InstanceId
+	}
+	return ""
+}
+
+// A ping sent by the client on a Ping stream.
+type PingRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Client-chosen sequence number, echoed back in the response.
+	Sequence uint64 `protobuf:"varint,1,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	// Client send time in nanoseconds since the Unix epoch, echoed back so the
+	// client can compute the round-trip time without keeping state.
+	ClientTimeUnixNano int64 `protobuf:"varint,2,opt,name=client_time_unix_nano,json=clientTimeUnixNano,proto3" json:"client_time_unix_nano,omitempty"`
+	unknownFields      protoimpl.UnknownFields
+	sizeCache          protoimpl.SizeCache
+}
+
+func (x *PingRequest) Reset() {
+	*x = PingRequest{}
+	mi := &file_protos_time_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PingRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PingRequest) ProtoMessage() {}
+
+func (x *PingRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_protos_time_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PingRequest.ProtoReflect.Descriptor instead.
+func (*PingRequest) Descriptor() ([]byte, []int) {
+	return file_protos_time_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *PingRequest) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *PingRequest) GetClientTimeUnixNano() int64 {
+	if x != nil {
+		return x.ClientTimeUnixNano
+	}
+	return 0
+}
+
+// The reply to a PingRequest.
+type PingResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The ping being answered.
+	Request *PingRequest `protobuf:"bytes,1,opt,name=request,proto3" json:"request,omitempty"`
+	// Time the server handled the ping, in nanoseconds since the Unix epoch.
+	ServerTimeUnixNano int64 `protobuf:"varint,2,opt,name=server_time_unix_nano,json=serverTimeUnixNano,proto3" json:"server_time_unix_nano,omitempty"`
+	unknownFields      protoimpl.UnknownFields
+	sizeCache          protoimpl.SizeCache
+}
+
+func (x *PingResponse) Reset() {
+	*x = PingResponse{}
+	mi := &file_protos_time_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PingResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PingResponse) ProtoMessage() {}
+
+func (x *PingResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_protos_time_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PingResponse.ProtoReflect.Descriptor instead.
+func (*PingResponse) Descriptor() ([]byte, []int) {
+	return file_protos_time_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *PingResponse) GetRequest() *PingRequest {
+	if x != nil {
+		return x.Request
+	}
+	return nil
+}
+
+func (x *PingResponse) GetServerTimeUnixNano() int64 {
+	if x != nil {
+		return x.ServerTimeUnixNano
+	}
+	return 0
+}
+
+type DumpMetadataRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DumpMetadataRequest) Reset() {
+	*x = DumpMetadataRequest{}
+	mi := &file_protos_time_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DumpMetadataRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DumpMetadataRequest) ProtoMessage() {}
+
+func (x *DumpMetadataRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_protos_time_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DumpMetadataRequest.ProtoReflect.Descriptor instead.
+func (*DumpMetadataRequest) Descriptor() ([]byte, []int) {
+	return file_protos_time_proto_rawDescGZIP(), []int{12}
+}
+
+// The values received for one metadata key.
+type MetadataValues struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Values in the order received. Binary (-bin) values are base64 encoded.
+	Values        []string `protobuf:"bytes,1,rep,name=values,proto3" json:"values,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MetadataValues) Reset() {
+	*x = MetadataValues{}
+	mi := &file_protos_time_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MetadataValues) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MetadataValues) ProtoMessage() {}
+
+func (x *MetadataValues) ProtoReflect() protoreflect.Message {
+	mi := &file_protos_time_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MetadataValues.ProtoReflect.Descriptor instead.
+func (*MetadataValues) Descriptor() ([]byte, []int) {
+	return file_protos_time_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *MetadataValues) GetValues() []string {
+	if x != nil {
+		return x.Values
+	}
+	return nil
+}
+
+// The request metadata as received by the server.
+type DumpMetadataResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Metadata by lowercase key. Values of sensitive keys are replaced with
+	// "[redacted]".
+	Metadata      map[string]*MetadataValues `protobuf:"bytes,1,rep,name=metadata,proto3" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DumpMetadataResponse) Reset() {
+	*x = DumpMetadataResponse{}
+	mi := &file_protos_time_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DumpMetadataResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DumpMetadataResponse) ProtoMessage() {}
+
+func (x *DumpMetadataResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_protos_time_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DumpMetadataResponse.ProtoReflect.Descriptor instead.
+func (*DumpMetadataResponse) Descriptor() ([]byte, []int) {
+	return file_protos_time_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *DumpMetadataResponse) GetMetadata() map[string]*MetadataValues {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+// Response metadata for EchoHeaders to set.
+type EchoHeadersRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Headers and trailers by key. Keys must be valid lowercase metadata
+	// keys outside the reserved grpc- prefix; binary (-bin) values are
+	// base64 encoded.
+	ResponseHeaders  map[string]*MetadataValues `protobuf:"bytes,1,rep,name=response_headers,json=responseHeaders,proto3" json:"response_headers,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	ResponseTrailers map[string]*MetadataValues `protobuf:"bytes,2,rep,name=response_trailers,json=responseTrailers,proto3" json:"response_trailers,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *EchoHeadersRequest) Reset() {
+	*x = EchoHeadersRequest{}
+	mi := &file_protos_time_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EchoHeadersRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EchoHeadersRequest) ProtoMessage() {}
+
+func (x *EchoHeadersRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_protos_time_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EchoHeadersRequest.ProtoReflect.Descriptor instead.
+func (*EchoHeadersRequest) Descriptor() ([]byte, []int) {
+	return file_protos_time_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *EchoHeadersRequest) GetResponseHeaders() map[string]*MetadataValues {
+	if x != nil {
+		return x.ResponseHeaders
+	}
+	return nil
+}
+
+func (x *EchoHeadersRequest) GetResponseTrailers() map[string]*MetadataValues {
+	if x != nil {
+		return x.ResponseTrailers
+	}
+	return nil
+}
+
+// The request metadata as received by the server.
+type EchoHeadersResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Metadata by lowercase key, redacted as by DumpMetadata.
+	Metadata      map[string]*MetadataValues `protobuf:"bytes,1,rep,name=metadata,proto3" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EchoHeadersResponse) Reset() {
+	*x = EchoHeadersResponse{}
+	mi := &file_protos_time_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EchoHeadersResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EchoHeadersResponse) ProtoMessage() {}
+
+func (x *EchoHeadersResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_protos_time_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EchoHeadersResponse.ProtoReflect.Descriptor instead.
+func (*EchoHeadersResponse) Descriptor() ([]byte, []int) {
+	return file_protos_time_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *EchoHeadersResponse) GetMetadata() map[string]*MetadataValues {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+type WhoAmIRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WhoAmIRequest) Reset() {
+	*x = WhoAmIRequest{}
+	mi := &file_protos_time_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WhoAmIRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WhoAmIRequest) ProtoMessage() {}
+
+func (x *WhoAmIRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_protos_time_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WhoAmIRequest.ProtoReflect.Descriptor instead.
+func (*WhoAmIRequest) Descriptor() ([]byte, []int) {
+	return file_protos_time_proto_rawDescGZIP(), []int{17}
+}
+
+// The client certificate and TLS parameters the server sees on the RPC's
+// connection. Behind Envoy, this is Envoy's upstream certificate unless it
+// forwards the downstream one.
+type WhoAmIResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Address of the connection's peer, Envoy or the client.
+	PeerAddress string `protobuf:"bytes,1,opt,name=peer_address,json=peerAddress,proto3" json:"peer_address,omitempty"`
+	// Whether the peer presented a client certificate. The certificate fields
+	// are empty otherwise.
+	HasCertificate bool `protobuf:"varint,2,opt,name=has_certificate,json=hasCertificate,proto3" json:"has_certificate,omitempty"`
+	// Distinguished names of the leaf certificate, in RFC 2253 form.
+	Subject string `protobuf:"bytes,3,opt,name=subject,proto3" json:"subject,omitempty"`
+	Issuer  string `protobuf:"bytes,4,opt,name=issuer,proto3" json:"issuer,omitempty"`
+	// Subject alternative names of the leaf certificate.
+	DnsNames       []string `protobuf:"bytes,5,rep,name=dns_names,json=dnsNames,proto3" json:"dns_names,omitempty"`
+	Uris           []string `protobuf:"bytes,6,rep,name=uris,proto3" json:"uris,omitempty"`
+	IpAddresses    []string `protobuf:"bytes,7,rep,name=ip_addresses,json=ipAddresses,proto3" json:"ip_addresses,omitempty"`
+	EmailAddresses []string `protobuf:"bytes,8,rep,name=email_addresses,json=emailAddresses,proto3" json:"email_addresses,omitempty"`
+	// The spiffe:// URI SAN, if any.
+	SpiffeId string `protobuf:"bytes,9,opt,name=spiffe_id,json=spiffeId,proto3" json:"spiffe_id,omitempty"`
+	// Serial number of the leaf certificate, in colon-separated hex.
+	SerialNumber string `protobuf:"bytes,10,opt,name=serial_number,json=serialNumber,proto3" json:"serial_number,omitempty"`
+	// Validity period of the leaf certificate, in RFC 3339 format.
+	NotBefore string `protobuf:"bytes,11,opt,name=not_before,json=notBefore,proto3" json:"not_before,omitempty"`
+	NotAfter  string `protobuf:"bytes,12,opt,name=not_after,json=notAfter,proto3" json:"not_after,omitempty"`
+	// Subjects of the verified chain, from the leaf to the root.
+	Chain []string `protobuf:"bytes,13,rep,name=chain,proto3" json:"chain,omitempty"`
+	// Negotiated TLS parameters, e.g. "TLS 1.3".
+	TlsVersion  string `protobuf:"bytes,14,opt,name=tls_version,json=tlsVersion,proto3" json:"tls_version,omitempty"`
+	CipherSuite string `protobuf:"bytes,15,opt,name=cipher_suite,json=cipherSuite,proto3" json:"cipher_suite,omitempty"`
+	// Server name the client asked for in its ClientHello (SNI).
+	ServerName string `protobuf:"bytes,16,opt,name=server_name,json=serverName,proto3" json:"server_name,omitempty"`
+	// Downstream address named by the PROXY protocol header of the
+	// connection, with -proxy-protocol. peer_address is then the proxy's.
+	SourceAddress string `protobuf:"bytes,17,opt,name=source_address,json=sourceAddress,proto3" json:"source_address,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WhoAmIResponse) Reset() {
+	*x = WhoAmIResponse{}
+	mi := &file_protos_time_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WhoAmIResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WhoAmIResponse) ProtoMessage() {}
+
+func (x *WhoAmIResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_protos_time_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WhoAmIResponse.ProtoReflect.Descriptor instead.
+func (*WhoAmIResponse) Descriptor() ([]byte, []int) {
+	return file_protos_time_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *WhoAmIResponse) GetPeerAddress() string {
+	if x != nil {
+		return x.PeerAddress
+	}
+	return ""
+}
+
+func (x *WhoAmIResponse) GetHasCertificate() bool {
+	if x != nil {
+		return x.HasCertificate
+	}
+	return false
+}
+
+func (x *WhoAmIResponse) GetSubject() string {
+	if x != nil {
+		return x.Subject
+	}
+	return ""
+}
+
+func (x *WhoAmIResponse) GetIssuer() string {
+	if x != nil {
+		return x.Issuer
+	}
+	return ""
+}
+
+func (x *WhoAmIResponse) GetDnsNames() []string {
+	if x != nil {
+		return x.DnsNames
+	}
+	return nil
+}
+
+func (x *WhoAmIResponse) GetUris() []string {
+	if x != nil {
+		return x.Uris
+	}
+	return nil
+}
+
+func (x *WhoAmIResponse) GetIpAddresses() []string {
+	if x != nil {
+		return x.IpAddresses
+	}
+	return nil
+}
+
+func (x *WhoAmIResponse) GetEmailAddresses() []string {
+	if x != nil {
+		return x.EmailAddresses
+	}
+	return nil
+}
+
+func (x *WhoAmIResponse) GetSpiffeId() string {
+	if x != nil {
+		return x.SpiffeId
+	}
+	return ""
+}
+
+func (x *WhoAmIResponse) GetSerialNumber() string {
+	if x != nil {
+		return x.SerialNumber
+	}
+	return ""
+}
+
+func (x *WhoAmIResponse) GetNotBefore() string {
+	if x != nil {
+		return x.NotBefore
+	}
+	return ""
+}
+
+func (x *WhoAmIResponse) GetNotAfter() string {
+	if x != nil {
+		return x.NotAfter
+	}
+	return ""
+}
+
+func (x *WhoAmIResponse) GetChain() []string {
+	if x != nil {
+		return x.Chain
+	}
+	return nil
+}
+
+func (x *WhoAmIResponse) GetTlsVersion() string {
+	if x != nil {
+		return x.TlsVersion
+	}
+	return ""
+}
+
+func (x *WhoAmIResponse) GetCipherSuite() string {
+	if x != nil {
+		return x.CipherSuite
+	}
+	return ""
+}
+
+func (x *WhoAmIResponse) GetServerName() string {
+	if x != nil {
+		return x.ServerName
+	}
+	return ""
+}
+
+func (x *WhoAmIResponse) GetSourceAddress() string {
+	if x != nil {
+		return x.SourceAddress
+	}
+	return ""
+}
+
+// The request message for GetBlob.
+type BlobRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Size of the payload to return, in bytes, up to 268435456 (256MiB).
+	// Payloads of more than the server's -max-send-msg-size fail with
+	// RESOURCE_EXHAUSTED.
+	Size int64 `protobuf:"varint,1,opt,name=size,proto3" json:"size,omitempty"`
+	// Fills the payload with zeros, which compress to almost nothing,
+	// instead of pseudo-random bytes.
+	Compressible  bool `protobuf:"varint,2,opt,name=compressible,proto3" json:"compressible,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BlobRequest) Reset() {
+	*x = BlobRequest{}
+	mi := &file_protos_time_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BlobRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BlobRequest) ProtoMessage() {}
+
+func (x *BlobRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_protos_time_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BlobRequest.ProtoReflect.Descriptor instead.
+func (*BlobRequest) Descriptor() ([]byte, []int) {
+	return file_protos_time_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *BlobRequest) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *BlobRequest) GetCompressible() bool {
+	if x != nil {
+		return x.Compressible
+	}
+	return false
+}
+
+// A payload of the size requested. Meaningless.
+type BlobResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Data          []byte                 `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BlobResponse) Reset() {
+	*x = BlobResponse{}
+	mi := &file_protos_time_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BlobResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BlobResponse) ProtoMessage() {}
+
+func (x *BlobResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_protos_time_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BlobResponse.ProtoReflect.Descriptor instead.
+func (*BlobResponse) Descriptor() ([]byte, []int) {
+	return file_protos_time_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *BlobResponse) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+var File_protos_time_proto protoreflect.FileDescriptor
+
+const file_protos_time_proto_rawDesc = "" +
+	"\n" +
+	"\x11protos/time.proto\x12\x04time\"\xa3\x03\n" +
+	"\vTimeRequest\x12.\n" +
+	"\n" +
+	"retry_hint\x18\x01 \x01(\v2\x0f.time.RetryHintR\tretryHint\x12 \n" +
+	"\vcompression\x18\x02 \x01(\tR\vcompression\x12$\n" +
+	"\x0ealign_to_clock\x18\x03 \x01(\bR\falignToClock\x122\n" +
+	"\x15heartbeat_interval_ms\x18\x04 \x01(\x03R\x13heartbeatIntervalMs\x12\x1b\n" +
+	"\tpad_bytes\x18\x05 \x01(\x05R\bpadBytes\x120\n" +
+	"\x14resume_from_sequence\x18\x06 \x01(\x03R\x12resumeFromSequence\x12\x1a\n" +
+	"\btimezone\x18\a \x01(\tR\btimezone\x12\x1f\n" +
+	"\vinterval_ms\x18\b \x01(\x03R\n" +
+	"intervalMs\x12\x16\n" +
+	"\x06format\x18\t \x01(\tR\x06format\x12\x1f\n" +
+	"\vtruncate_to\x18\n" +
+	" \x01(\tR\n" +
+	"truncateTo\x12#\n" +
+	"\rmessage_count\x18\v \x01(\x05R\fmessageCount\"M\n" +
+	"\tRetryHint\x12\x1a\n" +
+	"\bfailures\x18\x01 \x01(\x05R\bfailures\x12\x12\n" +
+	"\x04code\x18\x02 \x01(\tR\x04code\x12\x10\n" +
+	"\x03key\x18\x03 \x01(\tR\x03key\"\xa9\x01\n" +
+	"\fTimeResponse\x12!\n" +
+	"\fcurrent_time\x18\x01 \x01(\tR\vcurrentTime\x12!\n" +
+	"\fis_heartbeat\x18\x02 \x01(\bR\visHeartbeat\x12\x18\n" +
+	"\apadding\x18\x03 \x01(\fR\apadding\x12\x1a\n" +
+	"\bsequence\x18\x04 \x01(\x03R\bsequence\x12\x1d\n" +
+	"\n" +
+	"local_time\x18\x05 \x01(\tR\tlocalTime\"1\n" +
+	"\x0eControlRequest\x12\x1f\n" +
+	"\vinterval_ms\x18\x01 \x01(\x03R\n" +
+	"intervalMs\"'\n" +
+	"\x0fScheduleRequest\x12\x14\n" +
+	"\x05count\x18\x01 \x01(\x05R\x05count\"1\n" +
+	"\x10ScheduleResponse\x12\x1d\n" +
+	"\n" +
+	"tick_times\x18\x01 \x03(\tR\ttickTimes\"D\n" +
+	"\x0fTimestampReport\x121\n" +
+	"\x15client_time_unix_nano\x18\x01 \x01(\x03R\x12clientTimeUnixNano\"\xb2\x01\n" +
+	"\x10TimestampSummary\x12\x14\n" +
+	"\x05count\x18\x01 \x01(\x03R\x05count\x12\x1a\n" +
+	"\bearliest\x18\x02 \x01(\tR\bearliest\x12\x16\n" +
+	"\x06latest\x18\x03 \x01(\tR\x06latest\x12*\n" +
+	"\x11mean_offset_nanos\x18\x04 \x01(\x03R\x0fmeanOffsetNanos\x12(\n" +
+	"\x10max_offset_nanos\x18\x05 \x01(\x03R\x0emaxOffsetNanos\"\x13\n" +
+	"\x11ServerInfoRequest\"\xe7\x01\n" +
+	"\x12ServerInfoResponse\x12\x1a\n" +
+	"\bhostname\x18\x01 \x01(\tR\bhostname\x12\x18\n" +
+	"\aversion\x18\x02 \x01(\tR\aversion\x12\x16\n" +
+	"\x06commit\x18\x03 \x01(\tR\x06commit\x12\x1b\n" +
+	"\tboot_time\x18\x04 \x01(\tR\bbootTime\x12\x1a\n" +
+	"\bfeatures\x18\x05 \x03(\tR\bfeatures\x12)\n" +
+	"\x10listen_addresses\x18\x06 \x03(\tR\x0flistenAddresses\x12\x1f\n" +
+	"\vinstance_id\x18\a \x01(\tR\n" +
+	"instanceId\"\\\n" +
+	"\vPingRequest\x12\x1a\n" +
+	"\bsequence\x18\x01 \x01(\x04R\bsequence\x121\n" +
+	"\x15client_time_unix_nano\x18\x02 \x01(\x03R\x12clientTimeUnixNano\"n\n" +
+	"\fPingResponse\x12+\n" +
+	"\arequest\x18\x01 \x01(\v2\x11.time.PingRequestR\arequest\x121\n" +
+	"\x15server_time_unix_nano\x18\x02 \x01(\x03R\x12serverTimeUnixNano\"\x15\n" +
+	"\x13DumpMetadataRequest\"(\n" +
+	"\x0eMetadataValues\x12\x16\n" +
+	"\x06values\x18\x01 \x03(\tR\x06values\"\xaf\x01\n" +
+	"\x14DumpMetadataResponse\x12D\n" +
+	"\bmetadata\x18\x01 \x03(\v2(.time.DumpMetadataResponse.MetadataEntryR\bmetadata\x1aQ\n" +
+	"\rMetadataEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12*\n" +
+	"\x05value\x18\x02 \x01(\v2\x14.time.MetadataValuesR\x05value:\x028\x01\"\x80\x03\n" +
+	"\x12EchoHeadersRequest\x12X\n" +
+	"\x10response_headers\x18\x01 \x03(\v2-.time.EchoHeadersRequest.ResponseHeadersEntryR\x0fresponseHeaders\x12[\n" +
+	"\x11response_trailers\x18\x02 \x03(\v2..time.EchoHeadersRequest.ResponseTrailersEntryR\x10responseTrailers\x1aX\n" +
+	"\x14ResponseHeadersEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12*\n" +
+	"\x05value\x18\x02 \x01(\v2\x14.time.MetadataValuesR\x05value:\x028\x01\x1aY\n" +
+	"\x15ResponseTrailersEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12*\n" +
+	"\x05value\x18\x02 \x01(\v2\x14.time.MetadataValuesR\x05value:\x028\x01\"\xad\x01\n" +
+	"\x13EchoHeadersResponse\x12C\n" +
+	"\bmetadata\x18\x01 \x03(\v2'.time.EchoHeadersResponse.MetadataEntryR\bmetadata\x1aQ\n" +
+	"\rMetadataEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12*\n" +
+	"\x05value\x18\x02 \x01(\v2\x14.time.MetadataValuesR\x05value:\x028\x01\"\x0f\n" +
+	"\rWhoAmIRequest\"\xab\x04\n" +
+	"\x0eWhoAmIResponse\x12!\n" +
+	"\fpeer_address\x18\x01 \x01(\tR\vpeerAddress\x12'\n" +
+	"\x0fhas_certificate\x18\x02 \x01(\bR\x0ehasCertificate\x12\x18\n" +
+	"\asubject\x18\x03 \x01(\tR\asubject\x12\x16\n" +
+	"\x06issuer\x18\x04 \x01(\tR\x06issuer\x12\x1b\n" +
+	"\tdns_names\x18\x05 \x03(\tR\bdnsNames\x12\x12\n" +
+	"\x04uris\x18\x06 \x03(\tR\x04uris\x12!\n" +
+	"\fip_addresses\x18\a \x03(\tR\vipAddresses\x12'\n" +
+	"\x0femail_addresses\x18\b \x03(\tR\x0eemailAddresses\x12\x1b\n" +
+	"\tspiffe_id\x18\t \x01(\tR\bspiffeId\x12#\n" +
+	"\rserial_number\x18\n" +
+	" \x01(\tR\fserialNumber\x12\x1d\n" +
+	"\n" +
+	"not_before\x18\v \x01(\tR\tnotBefore\x12\x1b\n" +
+	"\tnot_after\x18\f \x01(\tR\bnotAfter\x12\x14\n" +
+	"\x05chain\x18\r \x03(\tR\x05chain\x12\x1f\n" +
+	"\vtls_version\x18\x0e \x01(\tR\n" +
+	"tlsVersion\x12!\n" +
+	"\fcipher_suite\x18\x0f \x01(\tR\vcipherSuite\x12\x1f\n" +
+	"\vserver_name\x18\x10 \x01(\tR\n" +
+	"serverName\x12%\n" +
+	"\x0esource_address\x18\x11 \x01(\tR\rsourceAddress\"E\n" +
+	"\vBlobRequest\x12\x12\n" +
+	"\x04size\x18\x01 \x01(\x03R\x04size\x12\"\n" +
+	"\fcompressible\x18\x02 \x01(\bR\fcompressible\"\"\n" +
+	"\fBlobResponse\x12\x12\n" +
+	"\x04data\x18\x01 \x01(\fR\x04data2\xc3\x02\n" +
+	"\vTimeService\x122\n" +
+	"\aGetTime\x12\x11.time.TimeRequest\x1a\x12.time.TimeResponse\"\x00\x12>\n" +
+	"\vGetSchedule\x12\x15.time.ScheduleRequest\x1a\x16.time.ScheduleResponse\"\x00\x127\n" +
+	"\n" +
+	"StreamTime\x12\x11.time.TimeRequest\x1a\x12.time.TimeResponse\"\x000\x01\x12@\n" +
+	"\x0eControlledTime\x12\x14.time.ControlRequest\x1a\x12.time.TimeResponse\"\x00(\x010\x01\x12E\n" +
+	"\x10ReportTimestamps\x12\x15.time.TimestampReport\x1a\x16.time.TimestampSummary\"\x00(\x012R\n" +
 	"\n" +
-	"StreamTime\x12\x11.time.TimeRequest\x1a\x12.time.TimeResponse\"\x000\x01B\x1aZ\x18your_project_name/protosb\x06proto3"
+	"ServerInfo\x12D\n" +
+	"\rGetServerInfo\x12\x17.time.ServerInfoRequest\x1a\x18.time.ServerInfoResponse\"\x002\xbc\x02\n" +
+	"\vDiagnostics\x123\n" +
+	"\x04Ping\x12\x11.time.PingRequest\x1a\x12.time.PingResponse\"\x00(\x010\x01\x12G\n" +
+	"\fDumpMetadata\x12\x19.time.DumpMetadataRequest\x1a\x1a.time.DumpMetadataResponse\"\x00\x12D\n" +
+	"\vEchoHeaders\x12\x18.time.EchoHeadersRequest\x1a\x19.time.EchoHeadersResponse\"\x00\x125\n" +
+	"\x06WhoAmI\x12\x13.time.WhoAmIRequest\x1a\x14.time.WhoAmIResponse\"\x00\x122\n" +
+	"\aGetBlob\x12\x11.time.BlobRequest\x1a\x12.time.BlobResponse\"\x00B\x1aZ\x18your_project_name/protosb\x06proto3"
 
 var (
 	file_protos_time_proto_rawDescOnce sync.Once
@@ -131,19 +1546,72 @@ func file_protos_time_proto_rawDescGZIP() []byte {
 	return file_protos_time_proto_rawDescData
 }
 
-var file_protos_time_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_protos_time_proto_msgTypes = make([]protoimpl.MessageInfo, 25)
 var file_protos_time_proto_goTypes = []any{
-	(*TimeRequest)(nil),  // 0: time.TimeRequest
-	(*TimeResponse)(nil), // 1: time.TimeResponse
+	(*TimeRequest)(nil),          // 0: time.TimeRequest
+	(*RetryHint)(nil),            // 1: time.RetryHint
+	(*TimeResponse)(nil),         // 2: time.TimeResponse
+	(*ControlRequest)(nil),       // 3: time.ControlRequest
+	(*ScheduleRequest)(nil),      // 4: time.ScheduleRequest
+	(*ScheduleResponse)(nil),     // 5: time.ScheduleResponse
+	(*TimestampReport)(nil),      // 6: time.TimestampReport
+	(*TimestampSummary)(nil),     // 7: time.TimestampSummary
+	(*ServerInfoRequest)(nil),    // 8: time.ServerInfoRequest
+	(*ServerInfoResponse)(nil),   // 9: time.ServerInfoResponse
+	(*PingRequest)(nil),          // 10: time.PingRequest
+	(*PingResponse)(nil),         // 11: time.PingResponse
+	(*DumpMetadataRequest)(nil),  // 12: time.DumpMetadataRequest
+	(*MetadataValues)(nil),       // 13: time.MetadataValues
+	(*DumpMetadataResponse)(nil), // 14: time.DumpMetadataResponse
+	(*EchoHeadersRequest)(nil),   // 15: time.EchoHeadersRequest
+	(*EchoHeadersResponse)(nil),  // 16: time.EchoHeadersResponse
+	(*WhoAmIRequest)(nil),        // 17: time.WhoAmIRequest
+	(*WhoAmIResponse)(nil),       // 18: time.WhoAmIResponse
+	(*BlobRequest)(nil),          // 19: time.BlobRequest
+	(*BlobResponse)(nil),         // 20: time.BlobResponse
+	nil,                          // 21: time.DumpMetadataResponse.MetadataEntry
+	nil,                          // 22: time.EchoHeadersRequest.ResponseHeadersEntry
+	nil,                          // 23: time.EchoHeadersRequest.ResponseTrailersEntry
+	nil,                          // 24: time.EchoHeadersResponse.MetadataEntry
 }
 var file_protos_time_proto_depIdxs = []int32{
-	0, // 0: time.TimeService.StreamTime:input_type -> time.TimeRequest
-	1, // 1: time.TimeService.StreamTime:output_type -> time.TimeResponse
-	1, // [1:2] is the sub-list for method output_type
-	0, // [0:1] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	1,  // 0: time.TimeRequest.retry_hint:type_name -> time.RetryHint
+	10, // 1: time.PingResponse.request:type_name -> time.PingRequest
+	21, // 2: time.DumpMetadataResponse.metadata:type_name -> time.DumpMetadataResponse.MetadataEntry
+	22, // 3: time.EchoHeadersRequest.response_headers:type_name -> time.EchoHeadersRequest.ResponseHeadersEntry
+	23, // 4: time.EchoHeadersRequest.response_trailers:type_name -> time.EchoHeadersRequest.ResponseTrailersEntry
+	24, // 5: time.EchoHeadersResponse.metadata:type_name -> time.EchoHeadersResponse.MetadataEntry
+	13, // 6: time.DumpMetadataResponse.MetadataEntry.value:type_name -> time.MetadataValues
+	13, // 7: time.EchoHeadersRequest.ResponseHeadersEntry.value:type_name -> time.MetadataValues
+	13, // 8: time.EchoHeadersRequest.ResponseTrailersEntry.value:type_name -> time.MetadataValues
+	13, // 9: time.EchoHeadersResponse.MetadataEntry.value:type_name -> time.MetadataValues
+	0,  // 10: time.TimeService.GetTime:input_type -> time.TimeRequest
+	4,  // 11: time.TimeService.GetSchedule:input_type -> time.ScheduleRequest
+	0,  // 12: time.TimeService.StreamTime:input_type -> time.TimeRequest
+	3,  // 13: time.TimeService.ControlledTime:input_type -> time.ControlRequest
+	6,  // 14: time.TimeService.ReportTimestamps:input_type -> time.TimestampReport
+	8,  // 15: time.ServerInfo.GetServerInfo:input_type -> time.ServerInfoRequest
+	10, // 16: time.Diagnostics.Ping:input_type -> time.PingRequest
+	12, // 17: time.Diagnostics.DumpMetadata:input_type -> time.DumpMetadataRequest
+	15, // 18: time.Diagnostics.EchoHeaders:input_type -> time.EchoHeadersRequest
+	17, // 19: time.Diagnostics.WhoAmI:input_type -> time.WhoAmIRequest
+	19, // 20: time.Diagnostics.GetBlob:input_type -> time.BlobRequest
+	2,  // 21: time.TimeService.GetTime:output_type -> time.TimeResponse
+	5,  // 22: time.TimeService.GetSchedule:output_type -> time.ScheduleResponse
+	2,  // 23: time.TimeService.StreamTime:output_type -> time.TimeResponse
+	2,  // 24: time.TimeService.ControlledTime:output_type -> time.TimeResponse
+	7,  // 25: time.TimeService.ReportTimestamps:output_type -> time.TimestampSummary
+	9,  // 26: time.ServerInfo.GetServerInfo:output_type -> time.ServerInfoResponse
+	11, // 27: time.Diagnostics.Ping:output_type -> time.PingResponse
+	14, // 28: time.Diagnostics.DumpMetadata:output_type -> time.DumpMetadataResponse
+	16, // 29: time.Diagnostics.EchoHeaders:output_type -> time.EchoHeadersResponse
+	18, // 30: time.Diagnostics.WhoAmI:output_type -> time.WhoAmIResponse
+	20, // 31: time.Diagnostics.GetBlob:output_type -> time.BlobResponse
+	21, // [21:32] is the sub-list for method output_type
+	10, // [10:21] is the sub-list for method input_type
+	10, // [10:10] is the sub-list for extension type_name
+	10, // [10:10] is the sub-list for extension extendee
+	0,  // [0:10] is the sub-list for field type_name
 }
 
 func init() { file_protos_time_proto_init() }
@@ -157,9 +1625,9 @@ func file_protos_time_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_protos_time_proto_rawDesc), len(file_protos_time_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   2,
+			NumMessages:   25,
 			NumExtensions: 0,
-			NumServices:   1,
+			NumServices:   3,
 		},
 		GoTypes:           file_protos_time_proto_goTypes,
 		DependencyIndexes: file_protos_time_proto_depIdxs,
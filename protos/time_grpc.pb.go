@@ -23,7 +23,11 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	TimeService_StreamTime_FullMethodName = "/time.TimeService/StreamTime"
+	TimeService_GetTime_FullMethodName          = "/time.TimeService/GetTime"
+	TimeService_GetSchedule_FullMethodName      = "/time.TimeService/GetSchedule"
+	TimeService_StreamTime_FullMethodName       = "/time.TimeService/StreamTime"
+	TimeService_ControlledTime_FullMethodName   = "/time.TimeService/ControlledTime"
+	TimeService_ReportTimestamps_FullMethodName = "/time.TimeService/ReportTimestamps"
 )
 
 // TimeServiceClient is the client API for TimeService service.
@@ -32,10 +36,29 @@ const (
 //
 // The time service definition.
 type TimeServiceClient interface {
+	// A unary RPC.
+	//
+	// Returns the current time once.
+	GetTime(ctx context.Context, in *TimeRequest, opts ...grpc.CallOption) (*TimeResponse, error)
+	// A unary RPC.
+	//
+	// Returns the times at which the next ticks of a stream started now
+	// would fire, so clients can align to them without subscribing.
+	GetSchedule(ctx context.Context, in *ScheduleRequest, opts ...grpc.CallOption) (*ScheduleResponse, error)
 	// A server-to-client streaming RPC.
 	//
 	// Obtains the current time from the server and streams it back to the client.
 	StreamTime(ctx context.Context, in *TimeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[TimeResponse], error)
+	// A bidirectional streaming RPC.
+	//
+	// Streams the current time like StreamTime, while the client may send
+	// ControlRequest messages at any point to change the tick interval.
+	ControlledTime(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[ControlRequest, TimeResponse], error)
+	// A client-to-server streaming RPC.
+	//
+	// Receives timestamps until the client closes its side of the stream,
+	// then returns a summary of them.
+	ReportTimestamps(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[TimestampReport, TimestampSummary], error)
 }
 
 type timeServiceClient struct {
@@ -46,6 +69,26 @@ func NewTimeServiceClient(cc grpc.ClientConnInterface) TimeServiceClient {
 	return &timeServiceClient{cc}
 }
 
+func (c *timeServiceClient) GetTime(ctx context.Context, in *TimeRequest, opts ...grpc.CallOption) (*TimeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TimeResponse)
+	err := c.cc.Invoke(ctx, TimeService_GetTime_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *timeServiceClient) GetSchedule(ctx context.Context, in *ScheduleRequest, opts ...grpc.CallOption) (*ScheduleResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ScheduleResponse)
+	err := c.cc.Invoke(ctx, TimeService_GetSchedule_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *timeServiceClient) StreamTime(ctx context.Context, in *TimeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[TimeResponse], error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
 	stream, err := c.cc.NewStream(ctx, &TimeService_ServiceDesc.Streams[0], TimeService_StreamTime_FullMethodName, cOpts...)
@@ -65,16 +108,61 @@ func (c *timeServiceClient) StreamTime(ctx context.Context, in *TimeRequest, opt
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type TimeService_StreamTimeClient = grpc.ServerStreamingClient[TimeResponse]
 
+func (c *timeServiceClient) ControlledTime(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[ControlRequest, TimeResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &TimeService_ServiceDesc.Streams[1], TimeService_ControlledTime_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ControlRequest, TimeResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TimeService_ControlledTimeClient = grpc.BidiStreamingClient[ControlRequest, TimeResponse]
+
+func (c *timeServiceClient) ReportTimestamps(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[TimestampReport, TimestampSummary], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &TimeService_ServiceDesc.Streams[2], TimeService_ReportTimestamps_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[TimestampReport, TimestampSummary]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TimeService_ReportTimestampsClient = grpc.ClientStreamingClient[TimestampReport, TimestampSummary]
+
 // TimeServiceServer is the server API for TimeService service.
 // All implementations must embed UnimplementedTimeServiceServer
 // for forward compatibility.
 //
 // The time service definition.
 type TimeServiceServer interface {
+	// A unary RPC.
+	//
+	// Returns the current time once.
+	GetTime(context.Context, *TimeRequest) (*TimeResponse, error)
+	// A unary RPC.
+	//
+	// Returns the times at which the next ticks of a stream started now
+	// would fire, so clients can align to them without subscribing.
+	GetSchedule(context.Context, *ScheduleRequest) (*ScheduleResponse, error)
 	// A server-to-client streaming RPC.
 	//
 	// Obtains the current time from the server and streams it back to the client.
 	StreamTime(*TimeRequest, grpc.ServerStreamingServer[TimeResponse]) error
+	// A bidirectional streaming RPC.
+	//
+	// Streams the current time like StreamTime, while the client may send
+	// ControlRequest messages at any point to change the tick interval.
+	ControlledTime(grpc.BidiStreamingServer[ControlRequest, TimeResponse]) error
+	// A client-to-server streaming RPC.
+	//
+	// Receives timestamps until the client closes its side of the stream,
+	// then returns a summary of them.
+	ReportTimestamps(grpc.ClientStreamingServer[TimestampReport, TimestampSummary]) error
 	mustEmbedUnimplementedTimeServiceServer()
 }
 
@@ -85,9 +173,21 @@ type TimeServiceServer interface {
 // pointer dereference when methods are called.
 type UnimplementedTimeServiceServer struct{}
 
+func (UnimplementedTimeServiceServer) GetTime(context.Context, *TimeRequest) (*TimeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTime not implemented")
+}
+func (UnimplementedTimeServiceServer) GetSchedule(context.Context, *ScheduleRequest) (*ScheduleResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSchedule not implemented")
+}
 func (UnimplementedTimeServiceServer) StreamTime(*TimeRequest, grpc.ServerStreamingServer[TimeResponse]) error {
 	return status.Errorf(codes.Unimplemented, "method StreamTime not implemented")
 }
+func (UnimplementedTimeServiceServer) ControlledTime(grpc.BidiStreamingServer[ControlRequest, TimeResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method ControlledTime not implemented")
+}
+func (UnimplementedTimeServiceServer) ReportTimestamps(grpc.ClientStreamingServer[TimestampReport, TimestampSummary]) error {
+	return status.Errorf(codes.Unimplemented, "method ReportTimestamps not implemented")
+}
 func (UnimplementedTimeServiceServer) mustEmbedUnimplementedTimeServiceServer() {}
 func (UnimplementedTimeServiceServer) testEmbeddedByValue()                     {}
 
@@ -109,6 +209,42 @@ func RegisterTimeServiceServer(s grpc.ServiceRegistrar, srv TimeServiceServer) {
 	s.RegisterService(&TimeService_ServiceDesc, srv)
 }
 
+func _TimeService_GetTime_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TimeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TimeServiceServer).GetTime(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TimeService_GetTime_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TimeServiceServer).GetTime(ctx, req.(*TimeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TimeService_GetSchedule_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ScheduleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TimeServiceServer).GetSchedule(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TimeService_GetSchedule_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TimeServiceServer).GetSchedule(ctx, req.(*ScheduleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _TimeService_StreamTime_Handler(srv interface{}, stream grpc.ServerStream) error {
 	m := new(TimeRequest)
 	if err := stream.RecvMsg(m); err != nil {
@@ -120,19 +256,458 @@ func _TimeService_StreamTime_Handler(srv interface{}, stream grpc.ServerStream)
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type TimeService_StreamTimeServer = grpc.ServerStreamingServer[TimeResponse]
 
+func _TimeService_ControlledTime_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(TimeServiceServer).ControlledTime(&grpc.GenericServerStream[ControlRequest, TimeResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TimeService_ControlledTimeServer = grpc.BidiStreamingServer[ControlRequest, TimeResponse]
+
+func _TimeService_ReportTimestamps_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(TimeServiceServer).ReportTimestamps(&grpc.GenericServerStream[TimestampReport, TimestampSummary]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TimeService_ReportTimestampsServer = grpc.ClientStreamingServer[TimestampReport, TimestampSummary]
+
 // TimeService_ServiceDesc is the grpc.ServiceDesc for TimeService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
 var TimeService_ServiceDesc = grpc.ServiceDesc{
 	ServiceName: "time.TimeService",
 	HandlerType: (*TimeServiceServer)(nil),
-	Methods:     []grpc.MethodDesc{},
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetTime",
+			Handler:    _TimeService_GetTime_Handler,
+		},
+		{
+			MethodName: "GetSchedule",
+			Handler:    _TimeService_GetSchedule_Handler,
+		},
+	},
 	Streams: []grpc.StreamDesc{
 		{
 			StreamName:    "StreamTime",
 			Handler:       _TimeService_StreamTime_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "ControlledTime",
+			Handler:       _TimeService_ControlledTime_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "ReportTimestamps",
+			Handler:       _TimeService_ReportTimestamps_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "protos/time.proto",
+}
+
+const (
+	ServerInfo_GetServerInfo_FullMethodName = "/time.ServerInfo/GetServerInfo"
+)
+
+// ServerInfoClient is the client API for ServerInfo service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// The server metadata service definition.
+type ServerInfoClient interface {
+	// Returns build and runtime details about the server instance.
+	GetServerInfo(ctx context.Context, in *ServerInfoRequest, opts ...grpc.CallOption) (*ServerInfoResponse, error)
+}
+
+type serverInfoClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewServerInfoClient(cc grpc.ClientConnInterface) ServerInfoClient {
+	return &serverInfoClient{cc}
+}
+
+func (c *serverInfoClient) GetServerInfo(ctx context.Context, in *ServerInfoRequest, opts ...grpc.CallOption) (*ServerInfoResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ServerInfoResponse)
+	err := c.cc.Invoke(ctx, ServerInfo_GetServerInfo_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ServerInfoServer is the server API for ServerInfo service.
+// All implementations must embed UnimplementedServerInfoServer
+// for forward compatibility.
+//
+// The server metadata service definition.
+type ServerInfoServer interface {
+	// Returns build and runtime details about the server instance.
+	GetServerInfo(context.Context, *ServerInfoRequest) (*ServerInfoResponse, error)
+	mustEmbedUnimplementedServerInfoServer()
+}
+
+// UnimplementedServerInfoServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedServerInfoServer struct{}
+
+func (UnimplementedServerInfoServer) GetServerInfo(context.Context, *ServerInfoRequest) (*ServerInfoResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetServerInfo not implemented")
+}
+func (UnimplementedServerInfoServer) mustEmbedUnimplementedServerInfoServer() {}
+func (UnimplementedServerInfoServer) testEmbeddedByValue()                    {}
+
+// UnsafeServerInfoServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ServerInfoServer will
+// result in compilation errors.
+type UnsafeServerInfoServer interface {
+	mustEmbedUnimplementedServerInfoServer()
+}
+
+func RegisterServerInfoServer(s grpc.ServiceRegistrar, srv ServerInfoServer) {
+	// If the following call pancis, it indicates UnimplementedServerInfoServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&ServerInfo_ServiceDesc, srv)
+}
+
+func _ServerInfo_GetServerInfo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ServerInfoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServerInfoServer).GetServerInfo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ServerInfo_GetServerInfo_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServerInfoServer).GetServerInfo(ctx, req.(*ServerInfoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// ServerInfo_ServiceDesc is the grpc.ServiceDesc for ServerInfo service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ServerInfo_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "time.ServerInfo",
+	HandlerType: (*ServerInfoServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetServerInfo",
+			Handler:    _ServerInfo_GetServerInfo_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "protos/time.proto",
+}
+
+const (
+	Diagnostics_Ping_FullMethodName         = "/time.Diagnostics/Ping"
+	Diagnostics_DumpMetadata_FullMethodName = "/time.Diagnostics/DumpMetadata"
+	Diagnostics_EchoHeaders_FullMethodName  = "/time.Diagnostics/EchoHeaders"
+	Diagnostics_WhoAmI_FullMethodName       = "/time.Diagnostics/WhoAmI"
+	Diagnostics_GetBlob_FullMethodName      = "/time.Diagnostics/GetBlob"
+)
+
+// DiagnosticsClient is the client API for Diagnostics service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// The diagnostics service definition.
+type DiagnosticsClient interface {
+	// A bidirectional streaming RPC.
+	//
+	// Answers every PingRequest with a PingResponse, for measuring the
+	// round-trip latency through the proxy.
+	Ping(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[PingRequest, PingResponse], error)
+	// A simple unary RPC.
+	//
+	// Returns the metadata the request arrived with, for checking which
+	// headers Envoy adds, removes or rewrites.
+	DumpMetadata(ctx context.Context, in *DumpMetadataRequest, opts ...grpc.CallOption) (*DumpMetadataResponse, error)
+	// A simple unary RPC.
+	//
+	// Returns the metadata the request arrived with, like DumpMetadata, and
+	// sets the response headers and trailers the request asks for, for
+	// checking what Envoy does to both directions, trailers included.
+	EchoHeaders(ctx context.Context, in *EchoHeadersRequest, opts ...grpc.CallOption) (*EchoHeadersResponse, error)
+	// A simple unary RPC.
+	//
+	// Returns the verified client certificate of the connection, for checking
+	// whether Envoy forwards the downstream certificate or presents its own.
+	WhoAmI(ctx context.Context, in *WhoAmIRequest, opts ...grpc.CallOption) (*WhoAmIResponse, error)
+	// A simple unary RPC.
+	//
+	// Returns a payload of the requested size, for reproducing Envoy's
+	// per-stream buffer limits and message size failures against an
+	// upstream whose response sizes the client controls.
+	GetBlob(ctx context.Context, in *BlobRequest, opts ...grpc.CallOption) (*BlobResponse, error)
+}
+
+type diagnosticsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewDiagnosticsClient(cc grpc.ClientConnInterface) DiagnosticsClient {
+	return &diagnosticsClient{cc}
+}
+
+func (c *diagnosticsClient) Ping(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[PingRequest, PingResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Diagnostics_ServiceDesc.Streams[0], Diagnostics_Ping_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[PingRequest, PingResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Diagnostics_PingClient = grpc.BidiStreamingClient[PingRequest, PingResponse]
+
+func (c *diagnosticsClient) DumpMetadata(ctx context.Context, in *DumpMetadataRequest, opts ...grpc.CallOption) (*DumpMetadataResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DumpMetadataResponse)
+	err := c.cc.Invoke(ctx, Diagnostics_DumpMetadata_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *diagnosticsClient) EchoHeaders(ctx context.Context, in *EchoHeadersRequest, opts ...grpc.CallOption) (*EchoHeadersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(EchoHeadersResponse)
+	err := c.cc.Invoke(ctx, Diagnostics_EchoHeaders_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *diagnosticsClient) WhoAmI(ctx context.Context, in *WhoAmIRequest, opts ...grpc.CallOption) (*WhoAmIResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(WhoAmIResponse)
+	err := c.cc.Invoke(ctx, Diagnostics_WhoAmI_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *diagnosticsClient) GetBlob(ctx context.Context, in *BlobRequest, opts ...grpc.CallOption) (*BlobResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BlobResponse)
+	err := c.cc.Invoke(ctx, Diagnostics_GetBlob_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DiagnosticsServer is the server API for Diagnostics service.
+// All implementations must embed UnimplementedDiagnosticsServer
+// for forward compatibility.
+//
+// The diagnostics service definition.
+type DiagnosticsServer interface {
+	// A bidirectional streaming RPC.
+	//
+	// Answers every PingRequest with a PingResponse, for measuring the
+	// round-trip latency through the proxy.
+	Ping(grpc.BidiStreamingServer[PingRequest, PingResponse]) error
+	// A simple unary RPC.
+	//
+	// Returns the metadata the request arrived with, for checking which
+	// headers Envoy adds, removes or rewrites.
+	DumpMetadata(context.Context, *DumpMetadataRequest) (*DumpMetadataResponse, error)
+	// A simple unary RPC.
+	//
+	// Returns the metadata the request arrived with, like DumpMetadata, and
+	// sets the response headers and trailers the request asks for, for
+	// checking what Envoy does to both directions, trailers included.
+	EchoHeaders(context.Context, *EchoHeadersRequest) (*EchoHeadersResponse, error)
+	// A simple unary RPC.
+	//
+	// Returns the verified client certificate of the connection, for checking
+	// whether Envoy forwards the downstream certificate or presents its own.
+	WhoAmI(context.Context, *WhoAmIRequest) (*WhoAmIResponse, error)
+	// A simple unary RPC.
+	//
+	// Returns a payload of the requested size, for reproducing Envoy's
+	// per-stream buffer limits and message size failures against an
+	// upstream whose response sizes the client controls.
+	GetBlob(context.Context, *BlobRequest) (*BlobResponse, error)
+	mustEmbedUnimplementedDiagnosticsServer()
+}
+
+// UnimplementedDiagnosticsServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedDiagnosticsServer struct{}
+
+func (UnimplementedDiagnosticsServer) Ping(grpc.BidiStreamingServer[PingRequest, PingResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method Ping not implemented")
+}
+func (UnimplementedDiagnosticsServer) DumpMetadata(context.Context, *DumpMetadataRequest) (*DumpMetadataResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DumpMetadata not implemented")
+}
+func (UnimplementedDiagnosticsServer) EchoHeaders(context.Context, *EchoHeadersRequest) (*EchoHeadersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method EchoHeaders not implemented")
+}
+func (UnimplementedDiagnosticsServer) WhoAmI(context.Context, *WhoAmIRequest) (*WhoAmIResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method WhoAmI not implemented")
+}
+func (UnimplementedDiagnosticsServer) GetBlob(context.Context, *BlobRequest) (*BlobResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBlob not implemented")
+}
+func (UnimplementedDiagnosticsServer) mustEmbedUnimplementedDiagnosticsServer() {}
+func (UnimplementedDiagnosticsServer) testEmbeddedByValue()                     {}
+
+// UnsafeDiagnosticsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to DiagnosticsServer will
+// result in compilation errors.
+type UnsafeDiagnosticsServer interface {
+	mustEmbedUnimplementedDiagnosticsServer()
+}
+
+func RegisterDiagnosticsServer(s grpc.ServiceRegistrar, srv DiagnosticsServer) {
+	// If the following call pancis, it indicates UnimplementedDiagnosticsServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Diagnostics_ServiceDesc, srv)
+}
+
+func _Diagnostics_Ping_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(DiagnosticsServer).Ping(&grpc.GenericServerStream[PingRequest, PingResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Diagnostics_PingServer = grpc.BidiStreamingServer[PingRequest, PingResponse]
+
+func _Diagnostics_DumpMetadata_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DumpMetadataRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DiagnosticsServer).DumpMetadata(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Diagnostics_DumpMetadata_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DiagnosticsServer).DumpMetadata(ctx, req.(*DumpMetadataRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Diagnostics_EchoHeaders_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EchoHeadersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DiagnosticsServer).EchoHeaders(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Diagnostics_EchoHeaders_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DiagnosticsServer).EchoHeaders(ctx, req.(*EchoHeadersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Diagnostics_WhoAmI_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WhoAmIRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DiagnosticsServer).WhoAmI(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Diagnostics_WhoAmI_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DiagnosticsServer).WhoAmI(ctx, req.(*WhoAmIRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Diagnostics_GetBlob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BlobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DiagnosticsServer).GetBlob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Diagnostics_GetBlob_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DiagnosticsServer).GetBlob(ctx, req.(*BlobRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Diagnostics_ServiceDesc is the grpc.ServiceDesc for Diagnostics service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Diagnostics_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "time.Diagnostics",
+	HandlerType: (*DiagnosticsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "DumpMetadata",
+			Handler:    _Diagnostics_DumpMetadata_Handler,
+		},
+		{
+			MethodName: "EchoHeaders",
+			Handler:    _Diagnostics_EchoHeaders_Handler,
+		},
+		{
+			MethodName: "WhoAmI",
+			Handler:    _Diagnostics_WhoAmI_Handler,
+		},
+		{
+			MethodName: "GetBlob",
+			Handler:    _Diagnostics_GetBlob_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Ping",
+			Handler:       _Diagnostics_Ping_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
 	Metadata: "protos/time.proto",
 }